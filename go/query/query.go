@@ -27,13 +27,24 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 
 	"go.skia.org/infra/go/util"
 )
 
+const (
+	// MAX_CACHED_REGEXPS is the number of compiled regular expressions kept
+	// by compileRegexp before the cache is emptied.
+	MAX_CACHED_REGEXPS = 1000
+)
+
 var (
 	keyRe   = regexp.MustCompile("^,([a-zA-Z0-9._\\-]+=[a-zA-Z0-9._\\-]+,)+$")
 	paramRe = regexp.MustCompile("^[a-zA-Z0-9._\\-]+$")
+
+	// regexpCache maps regular expressions to the results of compiling them.
+	regexpCache      = map[string]*compiledRegexp{}
+	regexpCacheMutex sync.Mutex
 )
 
 // ValidateKey returns true if a key is valid, i.e. if the parameter names are
@@ -121,13 +132,85 @@ func ParseKey(key string) (map[string]string, error) {
 	return ret, nil
 }
 
+// ValidateQuery returns a non-nil error if the given query can't be used as a
+// query against structured keys, i.e. if the parameter names contain invalid
+// chars, or if a value is neither a valid parameter value nor one of the
+// special forms '*', '!value', '~regex' or '!~regex' documented on Query.
+func ValidateQuery(q url.Values) error {
+	for key, values := range q {
+		if !paramRe.MatchString(key) {
+			return fmt.Errorf("Query param name contains invalid characters: %q", key)
+		}
+		if len(values) == 1 && values[0] == "*" {
+			continue
+		}
+		for _, v := range values {
+			v = strings.TrimPrefix(v, "!")
+			if strings.HasPrefix(v, "~") {
+				if len(values) != 1 {
+					return fmt.Errorf("A regexp must be the only value for param %q", key)
+				}
+				if _, err := compileRegexp(v[1:]); err != nil {
+					return err
+				}
+				continue
+			}
+			if !paramRe.MatchString(v) {
+				return fmt.Errorf("Query value for %q contains invalid characters: %q", key, v)
+			}
+		}
+	}
+	return nil
+}
+
+// MatchesValue returns true if the param value 'value' matches the query
+// values 'values' for that param, using the same rules as Query, i.e. the
+// values may be '*', a list of alternatives, a list of negated alternatives
+// '!value', a regexp '~regex', or a negated regexp '!~regex'.
+//
+// The values are parsed on every call, use New and Query.MatchesParams to
+// apply the same query to many params. Invalid regexps don't match anything.
+func MatchesValue(values []string, value string) bool {
+	p, err := newQueryParam("", values)
+	if err != nil {
+		return false
+	}
+	return p.matchesValue(value)
+}
+
+// compiledRegexp is the result of compiling a regular expression.
+type compiledRegexp struct {
+	reg *regexp.Regexp
+	err error
+}
+
+// compileRegexp compiles the given regular expression. The results are
+// cached since the same queries are applied to many keys and traces.
+func compileRegexp(expr string) (*regexp.Regexp, error) {
+	regexpCacheMutex.Lock()
+	defer regexpCacheMutex.Unlock()
+	if c, ok := regexpCache[expr]; ok {
+		return c.reg, c.err
+	}
+	if len(regexpCache) >= MAX_CACHED_REGEXPS {
+		regexpCache = map[string]*compiledRegexp{}
+	}
+	reg, err := regexp.Compile(expr)
+	if err != nil {
+		err = fmt.Errorf("Error compiling regexp %q: %s", expr, err)
+	}
+	regexpCache[expr] = &compiledRegexp{reg: reg, err: err}
+	return reg, err
+}
+
 // queryParam represents a query on a particular parameter in a key.
 type queryParam struct {
+	key         string         // The param key.
 	keyMatch    string         // The param key, including the leading "," and trailing "=".
 	keyMatchLen int            // The length of keyMatch.
 	isWildCard  bool           // True if this is a wildcard value match.
 	isRegex     bool           // True if this is a regex value match.
-	isNegative  bool           // True if this is a negative value match.
+	isNegative  bool           // True if this is a negative value or regex match.
 	values      []string       // The potential matches for the value.
 	reg         *regexp.Regexp // The regexp to match against, if a regexp search.
 }
@@ -160,6 +243,12 @@ type queryParam struct {
 //
 //		q := New(url.Values{"arch": []string{"~^x"}})
 //
+// A regular expression can be negated by preceeding it with an '!'. I.e. this
+// will match all keys that have a parameter named 'name' that doesn't begin
+// with 'desk_':
+//
+//		q := New(url.Values{"name": []string{"!~^desk_"}})
+//
 //
 // Here is more complex example that matches all tests that have the 'name'
 // parameter with a value of 'desk_nytimes.skp', a 'config' param that does not
@@ -186,52 +275,64 @@ func New(q url.Values) (*Query, error) {
 
 	params := make([]queryParam, 0, len(q))
 	for _, key := range keys {
-		keyMatch := "," + key + "="
-		isWildCard := false
-		isRegex := false
-		isNegative := false
-		values := q[key]
-		var reg *regexp.Regexp
-		var err error
+		p, err := newQueryParam(key, q[key])
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, p)
+	}
+
+	return &Query{params: params}, nil
+}
+
+// newQueryParam parses the query values for the param named 'key'.
+func newQueryParam(key string, values []string) (queryParam, error) {
+	keyMatch := "," + key + "="
+	ret := queryParam{
+		key:         key,
+		keyMatch:    keyMatch,
+		keyMatchLen: len(keyMatch),
+		values:      values,
+	}
+	if len(values) == 1 {
 		// Is this param query a wildcard?
-		if len(q[key]) == 1 {
-			if q[key][0] == "*" {
-				isWildCard = true
-			}
-			if q[key][0][:1] == "~" {
-				isRegex = true
-				reg, err = regexp.Compile(q[key][0][1:])
-				if err != nil {
-					return nil, fmt.Errorf("Error compiling regexp %q: %s", q[key][0][1:], err)
-				}
-			}
+		if values[0] == "*" {
+			ret.isWildCard = true
+			return ret, nil
 		}
-		// Is this param query a negative match?
-		if len(q[key]) >= 1 {
-			if strings.HasPrefix(q[key][0], "!") {
-				isNegative = true
-				values = []string{}
-				for _, v := range q[key] {
-					if strings.HasPrefix(v, "!") {
-						values = append(values, v[1:])
-					} else {
-						values = append(values, v)
-					}
-				}
+		// Is this param query a, possibly negated, regexp?
+		expr := strings.TrimPrefix(values[0], "!")
+		if strings.HasPrefix(expr, "~") {
+			reg, err := compileRegexp(expr[1:])
+			if err != nil {
+				return ret, err
 			}
+			ret.isRegex = true
+			ret.isNegative = expr != values[0]
+			ret.reg = reg
+			return ret, nil
 		}
-		params = append(params, queryParam{
-			keyMatch:    keyMatch,
-			keyMatchLen: len(keyMatch),
-			isWildCard:  isWildCard,
-			isRegex:     isRegex,
-			isNegative:  isNegative,
-			values:      values,
-			reg:         reg,
-		})
 	}
+	// Is this param query a negative match?
+	if len(values) >= 1 && strings.HasPrefix(values[0], "!") {
+		ret.isNegative = true
+		ret.values = make([]string, 0, len(values))
+		for _, v := range values {
+			ret.values = append(ret.values, strings.TrimPrefix(v, "!"))
+		}
+	}
+	return ret, nil
+}
 
-	return &Query{params: params}, nil
+// matchesValue returns true if the given param value matches the query.
+func (p *queryParam) matchesValue(value string) bool {
+	if p.isWildCard {
+		return true
+	}
+	if p.isRegex {
+		return p.isNegative != p.reg.MatchString(value)
+	}
+	return p.isNegative != util.In(value, p.values)
 }
 
 // Matches returns true if the given structured key matches the query.
@@ -255,11 +356,7 @@ func (q *Query) Matches(s string) bool {
 		// Extract the value string.
 		valueIndex := strings.Index(s, ",")
 		value := s[:valueIndex]
-		if part.isRegex {
-			if !part.reg.MatchString(value) {
-				return false
-			}
-		} else if part.isNegative == util.In(value, part.values) {
+		if !part.matchesValue(value) {
 			return false
 		}
 		// Truncate to the value.
@@ -267,3 +364,16 @@ func (q *Query) Matches(s string) bool {
 	}
 	return true
 }
+
+// MatchesParams returns true if the given params match the query. It is the
+// equivalent of Matches for params that aren't serialized as a structured
+// key, e.g. the params of a tiling.Trace.
+func (q *Query) MatchesParams(params map[string]string) bool {
+	for _, part := range q.params {
+		value, ok := params[part.key]
+		if !ok || !part.matchesValue(value) {
+			return false
+		}
+	}
+	return true
+}
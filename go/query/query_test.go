@@ -163,6 +163,20 @@ func TestNew(t *testing.T) {
 	assert.Equal(t, true, q.params[1].isWildCard)
 	assert.Equal(t, false, q.params[1].isNegative)
 
+	q, err = New(url.Values{"name": []string{"!~^desk_"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(q.params))
+	assert.Equal(t, true, q.params[0].isRegex)
+	assert.Equal(t, true, q.params[0].isNegative)
+
+	q, err = New(url.Values{"config": []string{""}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(q.params))
+	assert.Equal(t, false, q.params[0].isRegex)
+
+	_, err = New(url.Values{"name": []string{"!~desk("}})
+	assert.Error(t, err)
+
 	q, err = New(url.Values{})
 	assert.NoError(t, err)
 	assert.Equal(t, 0, len(q.params))
@@ -295,6 +309,24 @@ func TestMatches(t *testing.T) {
 			matches: false,
 			reason:  "Negative, wildcard, and miss regexp",
 		},
+		{
+			key:     ",arch=x86,config=8888,debug=true,",
+			query:   url.Values{"config": []string{"!~^5"}},
+			matches: true,
+			reason:  "Negative regexp match",
+		},
+		{
+			key:     ",arch=x86,config=565,debug=true,",
+			query:   url.Values{"config": []string{"!~^5"}},
+			matches: false,
+			reason:  "Negative regexp miss",
+		},
+		{
+			key:     ",arch=x86,debug=true,",
+			query:   url.Values{"config": []string{"!~^5"}},
+			matches: false,
+			reason:  "Negative regexp requires the param",
+		},
 	}
 	for _, tc := range testCases {
 		q, err := New(tc.query)
@@ -305,6 +337,69 @@ func TestMatches(t *testing.T) {
 	}
 }
 
+func TestValidateQuery(t *testing.T) {
+	testCases := []struct {
+		query  url.Values
+		valid  bool
+		reason string
+	}{
+		{url.Values{"config": []string{"565", "8888"}}, true, "Simple"},
+		{url.Values{"config": []string{"*"}}, true, "Wildcard"},
+		{url.Values{"config": []string{"!565", "!8888"}}, true, "Negative"},
+		{url.Values{"name": []string{"~^desk_.*"}}, true, "Regexp"},
+		{url.Values{"name": []string{"!~^desk_.*"}}, true, "Negative regexp"},
+		{url.Values{}, true, "Empty"},
+		{url.Values{"config": []string{"5 65"}}, false, "Invalid value"},
+		{url.Values{"con fig": []string{"565"}}, false, "Invalid name"},
+		{url.Values{"name": []string{"~desk("}}, false, "Invalid regexp"},
+		{url.Values{"name": []string{"~^desk", "8888"}}, false, "Regexp with other values"},
+		{url.Values{"config": []string{"565", "*"}}, false, "Wildcard with other values"},
+	}
+	for _, tc := range testCases {
+		if got, want := ValidateQuery(tc.query) == nil, tc.valid; got != want {
+			t.Errorf("Failed validating %#v. Got %v Want %v. %s", tc.query, got, want, tc.reason)
+		}
+	}
+}
+
+func TestMatchesValue(t *testing.T) {
+	assert.True(t, MatchesValue([]string{"565", "8888"}, "8888"))
+	assert.False(t, MatchesValue([]string{"565", "8888"}, "gpu"))
+	assert.True(t, MatchesValue([]string{"*"}, "gpu"))
+	assert.True(t, MatchesValue([]string{"!565", "!8888"}, "gpu"))
+	assert.False(t, MatchesValue([]string{"!565", "!8888"}, "565"))
+	assert.True(t, MatchesValue([]string{"~^desk_"}, "desk_nytimes"))
+	assert.False(t, MatchesValue([]string{"~^desk_"}, "top25_desk_nytimes"))
+	assert.True(t, MatchesValue([]string{"!~^desk_"}, "top25_desk_nytimes"))
+	assert.False(t, MatchesValue([]string{"!~^desk_"}, "desk_nytimes"))
+	assert.False(t, MatchesValue([]string{"~desk("}, "desk("))
+	assert.False(t, MatchesValue([]string{}, "565"))
+}
+
+func TestMatchesParams(t *testing.T) {
+	params := map[string]string{"arch": "x86", "config": "565", "name": "desk_nytimes"}
+
+	q, err := New(url.Values{"config": []string{"565", "8888"}, "arch": []string{"*"}})
+	assert.NoError(t, err)
+	assert.True(t, q.MatchesParams(params))
+
+	q, err = New(url.Values{"config": []string{"!565"}})
+	assert.NoError(t, err)
+	assert.False(t, q.MatchesParams(params))
+
+	q, err = New(url.Values{"name": []string{"~^desk_"}, "arch": []string{"!arm"}})
+	assert.NoError(t, err)
+	assert.True(t, q.MatchesParams(params))
+
+	q, err = New(url.Values{"os": []string{"*"}})
+	assert.NoError(t, err)
+	assert.False(t, q.MatchesParams(params))
+
+	q, err = New(url.Values{})
+	assert.NoError(t, err)
+	assert.True(t, q.MatchesParams(params))
+}
+
 func TestParseKey(t *testing.T) {
 	testCases := []struct {
 		key      string
@@ -7,9 +7,8 @@ import (
 	"github.com/golang/groupcache/lru"
 )
 
-// TODO(stephana): Add the ability to purge items from the cache and
-// expunge item from the error cache. Remove DEFAULT_CACHESIZE when
-// we have a way to expung items from the cache.
+// TODO(stephana): Remove DEFAULT_CACHESIZE when we have a way to expunge
+// items from the cache based on memory usage.
 
 // DEFAULT_CACHESIZE is the maximum number of elements in the cache.
 const DEFAULT_CACHESIZE = 50000
@@ -204,6 +203,16 @@ func (m *MemReadThroughCache) Contains(id string) bool {
 	return ok
 }
 
+// Remove implements the ReadThroughCache interface.
+func (m *MemReadThroughCache) Remove(ids []string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, id := range ids {
+		m.cache.Remove(id)
+		m.errCache.Remove(id)
+	}
+}
+
 // workItem is used to control calls to workerFn when an item is not
 // in memory. The priority field defines it's position in the priority
 // queueu.
@@ -119,3 +119,38 @@ func TestReadThroughCache(t *testing.T) {
 	assert.False(t, q.Contains("some-random-never-before-seen-key"))
 	q.(*MemReadThroughCache).shutdown()
 }
+
+func TestRemove(t *testing.T) {
+	calls := map[string]int{}
+	var mutex sync.Mutex
+	worker := func(priority int64, id string) (interface{}, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		calls[id]++
+		if id == "bad" {
+			return nil, fmt.Errorf("bad item")
+		}
+		return id, nil
+	}
+	q := New(worker, 2)
+	defer q.(*MemReadThroughCache).shutdown()
+
+	assert.NoError(t, q.Warm(0, "good"))
+	_, err := q.Get(0, "bad")
+	assert.Error(t, err)
+	assert.True(t, q.Contains("good"))
+
+	// Cached items and errors are not recalculated.
+	assert.NoError(t, q.Warm(0, "good"))
+	_, err = q.Get(0, "bad")
+	assert.Error(t, err)
+	assert.Equal(t, map[string]int{"good": 1, "bad": 1}, calls)
+
+	// Removed items are recalculated on the next call.
+	q.Remove([]string{"good", "bad", "unknown"})
+	assert.False(t, q.Contains("good"))
+	assert.NoError(t, q.Warm(0, "good"))
+	_, err = q.Get(0, "bad")
+	assert.Error(t, err)
+	assert.Equal(t, map[string]int{"good": 2, "bad": 2}, calls)
+}
@@ -21,6 +21,11 @@ type ReadThroughCache interface {
 
 	// Contains returns true if the identfied item is currently cached.
 	Contains(id string) bool
+
+	// Remove removes the identified items from the cache, including cached
+	// errors, so that the next call to Get or Warm calls the worker function
+	// again. Items that are currently being calculated are not affected.
+	Remove(ids []string)
 }
 
 // WorkerFn defines the function that is called when an item is not in the
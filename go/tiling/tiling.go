@@ -5,6 +5,7 @@ import (
 	"net/url"
 	"strings"
 
+	"go.skia.org/infra/go/query"
 	"go.skia.org/infra/go/util"
 )
 
@@ -66,13 +67,17 @@ type Trace interface {
 type TraceBuilder func(n int) Trace
 
 // Matches returns true if the given Trace matches the given query.
-func Matches(tr Trace, query url.Values) bool {
-	for k, values := range query {
-		if _, ok := tr.Params()[k]; !ok || !util.In(tr.Params()[k], values) {
-			return false
-		}
+//
+// The query values support the same negation, '!value', and regexp, '~regex',
+// forms as query.Query. The query is parsed on every call, so to match many
+// Traces against the same query use query.New and query.Query.MatchesParams
+// instead. Invalid queries don't match anything.
+func Matches(tr Trace, q url.Values) bool {
+	parsed, err := query.New(q)
+	if err != nil {
+		return false
 	}
-	return true
+	return parsed.MatchesParams(tr.Params())
 }
 
 // MatchesWithIgnores returns true if the given Trace matches the given query
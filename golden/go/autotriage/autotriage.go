@@ -0,0 +1,136 @@
+// Package autotriage triages untriaged digests automatically according to the
+// fuzzy rules stored in the FuzzyRuleStore.
+package autotriage
+
+import (
+	"sort"
+
+	"github.com/skia-dev/glog"
+
+	"go.skia.org/infra/go/tiling"
+	"go.skia.org/infra/go/timer"
+	"go.skia.org/infra/golden/go/diff"
+	"go.skia.org/infra/golden/go/diffstore"
+	"go.skia.org/infra/golden/go/ignore"
+	"go.skia.org/infra/golden/go/storage"
+	"go.skia.org/infra/golden/go/types"
+)
+
+// Triage finds the untriaged digests in the given tile which are within the
+// tolerances of a matching FuzzyRule of a positive digest of the same test,
+// and triages them as positive. Each rule's changes are added to the
+// expectations separately, under the rule's own user ID, so that they can be
+// told apart (and undone) in the triage log. Returns the changes that were
+// made, keyed by rule ID.
+func Triage(storages *storage.Storage, tile *tiling.Tile) (map[int]map[string]types.TestClassification, error) {
+	defer timer.New("autotriage.Triage").Stop()
+	if storages.FuzzyRuleStore == nil {
+		return nil, nil
+	}
+	matcher, err := storages.FuzzyRuleStore.BuildFuzzyRuleMatcher()
+	if err != nil {
+		return nil, err
+	}
+	exp, err := storages.ExpectationsStore.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the untriaged digests and the rules which apply to them.
+	// candidates[testName][digest][ruleID]*FuzzyRule
+	candidates := map[string]map[string]map[int]*ignore.FuzzyRule{}
+	for _, trace := range tile.Traces {
+		gTrace := trace.(*types.GoldenTrace)
+		rules, ok := matcher(gTrace.Params_)
+		if !ok {
+			continue
+		}
+		testName := gTrace.Params_[types.PRIMARY_KEY_FIELD]
+		for _, digest := range gTrace.Values {
+			if digest == types.MISSING_DIGEST || exp.Classification(testName, digest) != types.UNTRIAGED {
+				continue
+			}
+			if _, ok := candidates[testName]; !ok {
+				candidates[testName] = map[string]map[int]*ignore.FuzzyRule{}
+			}
+			if _, ok := candidates[testName][digest]; !ok {
+				candidates[testName][digest] = map[int]*ignore.FuzzyRule{}
+			}
+			for _, r := range rules {
+				candidates[testName][digest][r.ID] = r
+			}
+		}
+	}
+
+	// Compare the candidates against the positive digests of their tests.
+	changes := map[int]map[string]types.TestClassification{}
+	rulesByID := map[int]*ignore.FuzzyRule{}
+	for testName, digests := range candidates {
+		positives := []string{}
+		for d, label := range exp.Tests[testName] {
+			if label == types.POSITIVE {
+				positives = append(positives, d)
+			}
+		}
+		if len(positives) == 0 {
+			continue
+		}
+		sort.Strings(positives)
+		for digest, rules := range digests {
+			diffs, err := storages.DiffStore.Get(digest, positives)
+			if err != nil {
+				glog.Errorf("Unable to compute diffs for %s in %s: %s", digest, testName, err)
+				continue
+			}
+			if r := firstMatch(rules, positives, diffs); r != nil {
+				if _, ok := changes[r.ID]; !ok {
+					changes[r.ID] = map[string]types.TestClassification{}
+				}
+				if _, ok := changes[r.ID][testName]; !ok {
+					changes[r.ID][testName] = types.TestClassification{}
+				}
+				changes[r.ID][testName][digest] = types.POSITIVE
+				rulesByID[r.ID] = r
+			}
+		}
+	}
+
+	for id, change := range changes {
+		if err := storages.ExpectationsStore.AddChange(change, rulesByID[id].AutoTriageUser()); err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+// firstMatch returns the rule with the lowest ID under which the untriaged
+// digest matches any of the positive digests, or nil if there is none.
+func firstMatch(rules map[int]*ignore.FuzzyRule, positives []string, diffs map[string]*diff.DiffMetrics) *ignore.FuzzyRule {
+	ids := make([]int, 0, len(rules))
+	for id := range rules {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		for _, p := range positives {
+			if rules[id].Matches(toDiffRecord(diffs[p])) {
+				return rules[id]
+			}
+		}
+	}
+	return nil
+}
+
+// toDiffRecord converts the metrics returned by a diff.DiffStore into the
+// DiffRecord that FuzzyRules are evaluated against.
+func toDiffRecord(dm *diff.DiffMetrics) *diffstore.DiffRecord {
+	if dm == nil {
+		return nil
+	}
+	return &diffstore.DiffRecord{
+		NumDiffPixels:    dm.NumDiffPixels,
+		PixelDiffPercent: dm.PixelDiffPercent,
+		MaxRGBADiffs:     dm.MaxRGBADiffs,
+		DimDiffer:        dm.DimDiffer,
+	}
+}
@@ -0,0 +1,74 @@
+package autotriage
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"go.skia.org/infra/go/tiling"
+	"go.skia.org/infra/golden/go/expstorage"
+	"go.skia.org/infra/golden/go/ignore"
+	"go.skia.org/infra/golden/go/mocks"
+	"go.skia.org/infra/golden/go/storage"
+	"go.skia.org/infra/golden/go/types"
+)
+
+func TestTriage(t *testing.T) {
+	tile := tiling.NewTile()
+	tile.Traces = map[string]tiling.Trace{
+		"gpu-foo": &types.GoldenTrace{
+			Params_: map[string]string{types.PRIMARY_KEY_FIELD: "foo", "config": "gpu"},
+			Values:  []string{"aaa", "bbb", types.MISSING_DIGEST, "ccc"},
+		},
+		"8888-foo": &types.GoldenTrace{
+			Params_: map[string]string{types.PRIMARY_KEY_FIELD: "foo", "config": "8888"},
+			Values:  []string{"aaa", "ddd", "ddd", "ddd"},
+		},
+		"gpu-bar": &types.GoldenTrace{
+			Params_: map[string]string{types.PRIMARY_KEY_FIELD: "bar", "config": "gpu"},
+			Values:  []string{"eee", "eee", "fff", "fff"},
+		},
+	}
+
+	expStore := expstorage.NewMemExpectationsStore(nil)
+	assert.NoError(t, expStore.AddChange(map[string]types.TestClassification{
+		"foo": {"aaa": types.POSITIVE, "bbb": types.NEGATIVE},
+	}, "jon@example.com"))
+	storages := &storage.Storage{
+		DiffStore:         mocks.NewMockDiffStore(),
+		ExpectationsStore: expStore,
+	}
+
+	// Without a rule store nothing happens.
+	changes, err := Triage(storages, tile)
+	assert.NoError(t, err)
+	assert.Nil(t, changes)
+
+	// The mock diff store reports 10 differing pixels with a max delta of
+	// 5 for every pair of digests. The first rule is too strict, so the
+	// second one applies.
+	storages.FuzzyRuleStore = ignore.NewMemFuzzyRuleStore()
+	strict := ignore.NewFuzzyRule("jon@example.com", "config=gpu", 5, 5, "strict")
+	loose := ignore.NewFuzzyRule("jon@example.com", "config=gpu", 10, 5, "loose")
+	assert.NoError(t, storages.FuzzyRuleStore.Create(strict))
+	assert.NoError(t, storages.FuzzyRuleStore.Create(loose))
+	changes, err = Triage(storages, tile)
+	assert.NoError(t, err)
+
+	// Only the untriaged digest of the matching trace is triaged. "ddd" is
+	// in a trace which matches no rule, and "bar" has no positive digests.
+	assert.Equal(t, map[int]map[string]types.TestClassification{
+		loose.ID: {"foo": {"ccc": types.POSITIVE}},
+	}, changes)
+	exp, err := expStore.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, types.POSITIVE, exp.Classification("foo", "ccc"))
+	assert.Equal(t, types.NEGATIVE, exp.Classification("foo", "bbb"))
+	assert.Equal(t, types.UNTRIAGED, exp.Classification("foo", "ddd"))
+	assert.Equal(t, types.UNTRIAGED, exp.Classification("bar", "fff"))
+
+	// Nothing is left to triage.
+	changes, err = Triage(storages, tile)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(changes))
+}
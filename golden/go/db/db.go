@@ -169,6 +169,110 @@ var migrationSteps = []database.MigrationStep{
 		},
 	},
 
+	// Add a table to store fuzzy triage rules.
+	// version 11
+	{
+		MySQLUp: []string{
+			`CREATE TABLE fuzzyrule (
+				id               INT        NOT NULL AUTO_INCREMENT PRIMARY KEY,
+				userid           TEXT       NOT NULL,
+				updated_by       TEXT       NOT NULL,
+				query            TEXT       NOT NULL,
+				max_diff_pixels  INT        NOT NULL,
+				max_rgba_delta   INT        NOT NULL,
+				note             TEXT       NOT NULL
+			)`,
+		},
+		MySQLDown: []string{
+			`DROP TABLE IF EXISTS fuzzyrule`,
+		},
+	},
+
+	// Add a table to store the expectations of code review issues.
+	// version 12
+	{
+		MySQLUp: []string{
+			`CREATE TABLE exp_issue (
+				issue         VARCHAR(64)   NOT NULL,
+				name          VARCHAR(255)  NOT NULL,
+				digest        VARCHAR(255)  NOT NULL,
+				label         VARCHAR(255)  NOT NULL,
+				userid        VARCHAR(255)  NOT NULL,
+				ts            BIGINT        NOT NULL,
+				PRIMARY KEY (issue, name, digest)
+			)`,
+		},
+		MySQLDown: []string{
+			`DROP TABLE IF EXISTS exp_issue`,
+		},
+	},
+
+	// Add an owner to ignore rules and keep an audit history of all changes
+	// to ignore rules.
+	// version 13
+	{
+		MySQLUp: []string{
+			`ALTER TABLE ignorerule ADD owner TEXT NOT NULL`,
+			`UPDATE ignorerule SET owner = userid`,
+			`CREATE TABLE ignorerule_history (
+				id            INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+				rule_id       INT          NOT NULL,
+				action        VARCHAR(16)  NOT NULL,
+				userid        TEXT         NOT NULL,
+				ts            BIGINT       NOT NULL,
+				owner         TEXT         NOT NULL,
+				expires       BIGINT       NOT NULL,
+				query         TEXT         NOT NULL,
+				note          TEXT         NOT NULL,
+				INDEX ignorerule_history_rule_id_idx (rule_id)
+			)`,
+		},
+		MySQLDown: []string{
+			`DROP TABLE IF EXISTS ignorerule_history`,
+			`ALTER TABLE ignorerule DROP owner`,
+		},
+	},
+
+	// Add tables to store saved searches and the untriaged digests that have
+	// been reported for each of them.
+	// version 14
+	{
+		MySQLUp: []string{
+			`CREATE TABLE savedsearch (
+				id            INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+				name          TEXT         NOT NULL,
+				owner         VARCHAR(255) NOT NULL,
+				query         TEXT         NOT NULL,
+				created       BIGINT       NOT NULL,
+				updated       BIGINT       NOT NULL,
+				last_run      BIGINT       NOT NULL,
+				INDEX savedsearch_owner_idx (owner)
+			)`,
+			`CREATE TABLE savedsearch_seen (
+				search_id     INT          NOT NULL,
+				name          VARCHAR(255) NOT NULL,
+				digest        VARCHAR(255) NOT NULL,
+				PRIMARY KEY (search_id, name, digest)
+			)`,
+		},
+		MySQLDown: []string{
+			`DROP TABLE IF EXISTS savedsearch_seen`,
+			`DROP TABLE IF EXISTS savedsearch`,
+		},
+	},
+
+	// Record for which expiration time the owner of an ignore rule has been
+	// warned that the rule is about to expire.
+	// version 15
+	{
+		MySQLUp: []string{
+			`ALTER TABLE ignorerule ADD expiry_warned BIGINT NOT NULL DEFAULT 0`,
+		},
+		MySQLDown: []string{
+			`ALTER TABLE ignorerule DROP expiry_warned`,
+		},
+	},
+
 	// Use this is a template for more migration steps.
 	// version x
 	// {
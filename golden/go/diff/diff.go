@@ -64,6 +64,9 @@ type DiffMetrics struct {
 	MaxRGBADiffs []int
 	// True if the dimensions of the compared images are different.
 	DimDiffer bool
+	// Diffs contains the values of the diff metrics registered in the
+	// diffstore package, keyed by metric id.
+	Diffs map[string]float32 `json:",omitempty"`
 }
 
 // Diff error to indicate different error conditions during diffing.
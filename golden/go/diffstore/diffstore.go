@@ -64,8 +64,9 @@ const (
 
 // MemDiffStore implements the diff.DiffStore interface.
 type MemDiffStore struct {
-	// localDiffDir is the directory where diff images are written to.
-	localDiffDir string
+	// diskCache stores images and diff images on local disk and limits the
+	// amount of disk space they use.
+	diskCache *diskCache
 
 	// diffMetricsCache caches and calculates diff metrics and images.
 	diffMetricsCache rtcache.ReadThroughCache
@@ -83,22 +84,31 @@ type MemDiffStore struct {
 	wg sync.WaitGroup
 }
 
-// New returns a new instance of MemDiffStore.
-func New(client *http.Client, baseDir, gsBucketName, gsImageBaseDir string) (*MemDiffStore, error) {
-	// Set up image retrieval, caching and serving.
-	imgDir := fileutil.Must(fileutil.EnsureDirExists(filepath.Join(baseDir, DEFAULT_IMG_DIR_NAME)))
-	imgLoader, err := newImgLoader(client, imgDir, gsBucketName, gsImageBaseDir)
-	if err != err {
-		return nil, err
-	}
-
+// New returns a new instance of MemDiffStore. The images and diff images
+// stored below baseDir use at most maxDiskBytes of disk space. If
+// maxDiskBytes is <= 0 the disk usage is not limited.
+func New(client *http.Client, baseDir, gsBucketName, gsImageBaseDir string, maxDiskBytes int64) (*MemDiffStore, error) {
+	baseDir = fileutil.Must(fileutil.EnsureDirExists(baseDir))
 	metricsDB, err := bolt.Open(filepath.Join(baseDir, METRICSDB_NAME), 0600, nil)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to open metricsDB: %s", err)
 	}
 
+	// Set up the disk cache for images and diff images.
+	diskCache, err := newDiskCache(baseDir, []string{DEFAULT_IMG_DIR_NAME, DEFAULT_DIFFIMG_DIR_NAME}, maxDiskBytes, metricsDB)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create disk cache: %s", err)
+	}
+	diskCache.startFlusher(DISKCACHE_FLUSH_INTERVAL)
+
+	// Set up image retrieval, caching and serving.
+	imgLoader, err := newImgLoader(client, diskCache, gsBucketName, gsImageBaseDir)
+	if err != nil {
+		return nil, err
+	}
+
 	ret := &MemDiffStore{
-		localDiffDir:     fileutil.Must(fileutil.EnsureDirExists(filepath.Join(baseDir, DEFAULT_DIFFIMG_DIR_NAME))),
+		diskCache:        diskCache,
 		imgLoader:        imgLoader,
 		metricsDB:        metricsDB,
 		diffMetricsCodec: util.JSONCodec(&DiffRecord{}),
@@ -160,9 +170,13 @@ func (d *MemDiffStore) Get(priority int64, mainDigest string, rightDigests []str
 					glog.Errorf("Unable to calculate diff for %s. Got error: %s", id, err)
 					return
 				}
+				dr := ret.(*DiffRecord)
+				if len(dr.MissingMetrics()) > 0 {
+					d.backfillAsync(id)
+				}
 				mutex.Lock()
 				defer mutex.Unlock()
-				diffMap[right] = ret.(*DiffRecord)
+				diffMap[right] = dr
 			}(right)
 		}
 	}
@@ -170,6 +184,20 @@ func (d *MemDiffStore) Get(priority int64, mainDigest string, rightDigests []str
 	return diffMap, nil
 }
 
+// backfillAsync evicts the given diff from the cache and recalculates it with
+// idle priority, so that metrics that were registered after the diff was
+// calculated are added to it. Until then Get returns the incomplete record.
+func (d *MemDiffStore) backfillAsync(id string) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.diffMetricsCache.Remove([]string{id})
+		if err := d.diffMetricsCache.Warm(PRIORITY_IDLE, id); err != nil {
+			glog.Errorf("Unable to backfill diff metrics for %s. Got error: %s", id, err)
+		}
+	}()
+}
+
 // diffMetricsWorker calculates the diff if it's not in the cache.
 func (d *MemDiffStore) diffMetricsWorker(priority int64, id string) (interface{}, error) {
 	leftDigest, rightDigest := splitDigests(id)
@@ -178,6 +206,25 @@ func (d *MemDiffStore) diffMetricsWorker(priority int64, id string) (interface{}
 	if dm, err := d.loadDiffMetric(id); err != nil {
 		glog.Errorf("Error trying to load diff metric: %s", err)
 	} else if dm != nil {
+		missing := dm.MissingMetrics()
+		if len(missing) == 0 {
+			return dm, nil
+		}
+
+		// Calculate the metrics that were registered after the diff was stored.
+		imgs, err := d.imgLoader.Get(priority, []string{leftDigest, rightDigest})
+		if err != nil {
+			glog.Errorf("Unable to load images to calculate missing diff metrics for %s: %s", id, err)
+			return dm, nil
+		}
+		dm.CalcMetrics(missing, imgs[0], imgs[1])
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			if err := d.saveDiffMetric(id, dm); err != nil {
+				glog.Errorf("Error saving diff metric: %s", err)
+			}
+		}()
 		return dm, nil
 	}
 
@@ -215,7 +262,7 @@ func (d *MemDiffStore) saveDiffInfoAsync(diffID, leftDigest, rightDigest string,
 	go func() {
 		defer d.wg.Done()
 		imageFileName := getDiffImgFileName(leftDigest, rightDigest)
-		if err := saveFileRadixPath(d.localDiffDir, imageFileName, bytes.NewBuffer(imgBytes)); err != nil {
+		if err := d.diskCache.Save(DEFAULT_DIFFIMG_DIR_NAME, imageFileName, imgBytes); err != nil {
 			glog.Error(err)
 		}
 	}()
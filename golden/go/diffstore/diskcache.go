@@ -0,0 +1,371 @@
+package diffstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/skia-dev/glog"
+	"go.skia.org/infra/go/fileutil"
+	"go.skia.org/infra/go/metrics2"
+	"go.skia.org/infra/go/util"
+)
+
+const (
+	// DISKCACHE_BUCKET is the name of the bucket in the metrics DB that
+	// stores the size and last access time of the cached files.
+	DISKCACHE_BUCKET = "diskcache"
+
+	// DISKCACHE_LOW_WATER_MARK is the fraction of the maximum size the cache
+	// is shrunk to when it is full. Evicting more than strictly necessary
+	// avoids evicting files with every new file that is added.
+	DISKCACHE_LOW_WATER_MARK = 0.9
+
+	// DISKCACHE_FLUSH_INTERVAL is how often access times are written to disk.
+	DISKCACHE_FLUSH_INTERVAL = time.Minute
+)
+
+// diskCacheEntry is the bookkeeping information of a single file.
+type diskCacheEntry struct {
+	Size       int64 `json:"size"`
+	LastAccess int64 `json:"lastAccess"` // Time stamp in ms.
+}
+
+// diskCache manages the files in the radix directories below baseDir. Once
+// the total size of the files exceeds maxBytes the least recently used files
+// are removed. Sizes and access times are stored in the metrics DB so the LRU
+// order survives restarts.
+//
+// Files are identified by the directory below baseDir they belong to and
+// their file name. Since file names are derived from digests the content of
+// a file never changes, i.e. files that have been evicted can be restored
+// from their original source, e.g. Google storage.
+type diskCache struct {
+	baseDir  string
+	maxBytes int64
+	db       *bolt.DB
+
+	// entries maps the keys of all cached files, see cacheKey, to their
+	// bookkeeping info.
+	entries    map[string]*diskCacheEntry
+	totalBytes int64
+
+	// dirty contains the keys of entries whose access time has changed since
+	// the last flush.
+	dirty util.StringSet
+
+	// timeNow returns the current time in ms. Replaced in tests.
+	timeNow func() int64
+
+	sizeMetric   *metrics2.Int64Metric
+	evictedFiles *metrics2.Counter
+	evictedBytes *metrics2.Counter
+
+	mutex sync.Mutex
+}
+
+// newDiskCache creates a new diskCache for the given subdirectories of
+// baseDir. It loads the stored bookkeeping information, adds files that are
+// on disk but unknown to the cache and drops entries whose files have been
+// removed. A maxBytes value <= 0 means the size of the cache is unlimited.
+func newDiskCache(baseDir string, dirs []string, maxBytes int64, db *bolt.DB) (*diskCache, error) {
+	ret := &diskCache{
+		baseDir:      baseDir,
+		maxBytes:     maxBytes,
+		db:           db,
+		entries:      map[string]*diskCacheEntry{},
+		dirty:        util.StringSet{},
+		timeNow:      util.TimeStampMs,
+		sizeMetric:   metrics2.GetInt64Metric("gold.diskcache.size-bytes"),
+		evictedFiles: metrics2.GetCounter("gold.diskcache.evicted", map[string]string{"unit": "files"}),
+		evictedBytes: metrics2.GetCounter("gold.diskcache.evicted", map[string]string{"unit": "bytes"}),
+	}
+
+	stored, err := ret.loadEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	// Reconcile the stored entries with the files that are actually on disk.
+	removed := util.NewStringSet(keys(stored))
+	for _, dir := range dirs {
+		dirPath := filepath.Join(baseDir, dir)
+		err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && (path == dirPath) {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			key := cacheKey(dir, info.Name())
+			entry, ok := stored[key]
+			if !ok {
+				entry = &diskCacheEntry{LastAccess: info.ModTime().UnixNano() / int64(time.Millisecond)}
+				ret.dirty[key] = true
+			}
+			entry.Size = info.Size()
+			ret.entries[key] = entry
+			ret.totalBytes += entry.Size
+			delete(removed, key)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Unable to scan %s: %s", dirPath, err)
+		}
+	}
+	if err := ret.deleteEntries(removed.Keys()); err != nil {
+		return nil, err
+	}
+
+	ret.mutex.Lock()
+	defer ret.mutex.Unlock()
+	if err := ret.evict(); err != nil {
+		return nil, err
+	}
+	return ret, ret.flush()
+}
+
+// path returns the location of the given file on disk.
+func (c *diskCache) path(dir, fileName string) string {
+	return fileutil.TwoLevelRadixPath(c.baseDir, dir, fileName)
+}
+
+// Contains returns true if the given file is in the cache and marks it as
+// accessed.
+func (c *diskCache) Contains(dir, fileName string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := cacheKey(dir, fileName)
+	entry, ok := c.entries[key]
+	if ok {
+		c.touch(key, entry)
+	}
+	return ok
+}
+
+// Touch marks the given file as accessed if it is in the cache.
+func (c *diskCache) Touch(dir, fileName string) {
+	c.Contains(dir, fileName)
+}
+
+// Save writes the given file to disk and evicts the least recently used
+// files if the cache is full.
+func (c *diskCache) Save(dir, fileName string, data []byte) error {
+	if err := saveFileRadixPath(filepath.Join(c.baseDir, dir), fileName, bytes.NewBuffer(data)); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := cacheKey(dir, fileName)
+	if entry, ok := c.entries[key]; ok {
+		c.totalBytes -= entry.Size
+	}
+	entry := &diskCacheEntry{Size: int64(len(data))}
+	c.entries[key] = entry
+	c.totalBytes += entry.Size
+	c.touch(key, entry)
+	return c.evict()
+}
+
+// Remove drops the given file from the cache. It is used when a file that
+// should be in the cache cannot be read.
+func (c *diskCache) Remove(dir, fileName string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := cacheKey(dir, fileName)
+	if entry, ok := c.entries[key]; ok {
+		c.totalBytes -= entry.Size
+		delete(c.entries, key)
+		delete(c.dirty, key)
+	}
+	if err := os.Remove(c.path(dir, fileName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	c.sizeMetric.Update(c.totalBytes)
+	return c.deleteEntries([]string{key})
+}
+
+// Size returns the total number of bytes in the cache.
+func (c *diskCache) Size() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.totalBytes
+}
+
+// Flush writes the access times that have changed to disk.
+func (c *diskCache) Flush() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.flush()
+}
+
+// startFlusher periodically writes changed access times to disk.
+func (c *diskCache) startFlusher(interval time.Duration) {
+	go func() {
+		for _ = range time.Tick(interval) {
+			if err := c.Flush(); err != nil {
+				glog.Errorf("Unable to flush disk cache access times: %s", err)
+			}
+		}
+	}()
+}
+
+// touch updates the access time of the given entry. Assumes the mutex is held.
+func (c *diskCache) touch(key string, entry *diskCacheEntry) {
+	entry.LastAccess = c.timeNow()
+	c.dirty[key] = true
+}
+
+// evict removes the least recently used files until the cache is below the
+// low water mark. Assumes the mutex is held.
+func (c *diskCache) evict() error {
+	defer func() { c.sizeMetric.Update(c.totalBytes) }()
+	if (c.maxBytes <= 0) || (c.totalBytes <= c.maxBytes) {
+		return nil
+	}
+
+	byAccess := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		byAccess = append(byAccess, key)
+	}
+	sort.Sort(&keysByAccess{keys: byAccess, entries: c.entries})
+
+	target := int64(float64(c.maxBytes) * DISKCACHE_LOW_WATER_MARK)
+	evicted := []string{}
+	for _, key := range byAccess {
+		if c.totalBytes <= target {
+			break
+		}
+		dir, fileName := splitCacheKey(key)
+		if err := os.Remove(c.path(dir, fileName)); err != nil && !os.IsNotExist(err) {
+			glog.Errorf("Unable to evict %s: %s", key, err)
+			continue
+		}
+		size := c.entries[key].Size
+		c.totalBytes -= size
+		delete(c.entries, key)
+		delete(c.dirty, key)
+		evicted = append(evicted, key)
+		c.evictedFiles.Inc(1)
+		c.evictedBytes.Inc(size)
+	}
+	glog.Infof("Evicted %d files from the disk cache. %d bytes remaining.", len(evicted), c.totalBytes)
+	return c.deleteEntries(evicted)
+}
+
+// flush writes the dirty entries to the metrics DB. Assumes the mutex is held.
+func (c *diskCache) flush() error {
+	if len(c.dirty) == 0 {
+		return nil
+	}
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(DISKCACHE_BUCKET))
+		if err != nil {
+			return err
+		}
+		for key := range c.dirty {
+			jsonData, err := json.Marshal(c.entries[key])
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(key), jsonData); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.dirty = util.StringSet{}
+	return nil
+}
+
+// loadEntries reads all entries from the metrics DB.
+func (c *diskCache) loadEntries() (map[string]*diskCacheEntry, error) {
+	ret := map[string]*diskCacheEntry{}
+	err := c.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(DISKCACHE_BUCKET))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			entry := &diskCacheEntry{}
+			if err := json.Unmarshal(v, entry); err != nil {
+				return err
+			}
+			ret[string(k)] = entry
+			return nil
+		})
+	})
+	return ret, err
+}
+
+// deleteEntries removes the given entries from the metrics DB.
+func (c *diskCache) deleteEntries(keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return c.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(DISKCACHE_BUCKET))
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := bucket.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// cacheKey returns the key of a file in the cache.
+func cacheKey(dir, fileName string) string {
+	return dir + "/" + fileName
+}
+
+// splitCacheKey is the inverse of cacheKey.
+func splitCacheKey(key string) (string, string) {
+	idx := strings.LastIndex(key, "/")
+	return key[:idx], key[idx+1:]
+}
+
+// keys returns the keys of the given map.
+func keys(m map[string]*diskCacheEntry) []string {
+	ret := make([]string, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+	return ret
+}
+
+// keysByAccess sorts cache keys by their access time, oldest first.
+type keysByAccess struct {
+	keys    []string
+	entries map[string]*diskCacheEntry
+}
+
+func (k *keysByAccess) Len() int { return len(k.keys) }
+func (k *keysByAccess) Less(i, j int) bool {
+	ai, aj := k.entries[k.keys[i]].LastAccess, k.entries[k.keys[j]].LastAccess
+	if ai == aj {
+		return k.keys[i] < k.keys[j]
+	}
+	return ai < aj
+}
+func (k *keysByAccess) Swap(i, j int) { k.keys[i], k.keys[j] = k.keys[j], k.keys[i] }
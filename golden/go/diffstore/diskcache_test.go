@@ -0,0 +1,78 @@
+package diffstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	assert "github.com/stretchr/testify/require"
+
+	"go.skia.org/infra/go/fileutil"
+	"go.skia.org/infra/go/testutils"
+)
+
+func TestDiskCache(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "diskcache")
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, baseDir)
+
+	// A file that was written before the cache existed.
+	assert.NoError(t, saveFileRadixPath(filepath.Join(baseDir, DEFAULT_IMG_DIR_NAME), "aaaa0000.png", bytes.NewBuffer(make([]byte, 10))))
+
+	dbPath := filepath.Join(baseDir, METRICSDB_NAME)
+	db, err := bolt.Open(dbPath, 0600, nil)
+	assert.NoError(t, err)
+
+	dirs := []string{DEFAULT_IMG_DIR_NAME, DEFAULT_DIFFIMG_DIR_NAME}
+	cache, err := newDiskCache(baseDir, dirs, 50, db)
+	assert.NoError(t, err)
+	now := int64(1000)
+	cache.timeNow = func() int64 {
+		now++
+		return now
+	}
+	assert.Equal(t, int64(10), cache.Size())
+	assert.True(t, cache.Contains(DEFAULT_IMG_DIR_NAME, "aaaa0000.png"))
+	assert.False(t, cache.Contains(DEFAULT_IMG_DIR_NAME, "bbbb0000.png"))
+
+	// Fill the cache.
+	assert.NoError(t, cache.Save(DEFAULT_IMG_DIR_NAME, "bbbb0000.png", make([]byte, 10)))
+	assert.NoError(t, cache.Save(DEFAULT_DIFFIMG_DIR_NAME, "cccc0000.png", make([]byte, 20)))
+	assert.Equal(t, int64(40), cache.Size())
+	assert.True(t, fileutil.FileExists(cache.path(DEFAULT_DIFFIMG_DIR_NAME, "cccc0000.png")))
+
+	// Make 'bbbb' the least recently used file and overflow the cache.
+	cache.Touch(DEFAULT_IMG_DIR_NAME, "aaaa0000.png")
+	cache.Touch(DEFAULT_DIFFIMG_DIR_NAME, "cccc0000.png")
+	assert.NoError(t, cache.Save(DEFAULT_IMG_DIR_NAME, "dddd0000.png", make([]byte, 15)))
+	assert.Equal(t, int64(45), cache.Size())
+	assert.False(t, cache.Contains(DEFAULT_IMG_DIR_NAME, "bbbb0000.png"))
+	assert.False(t, fileutil.FileExists(cache.path(DEFAULT_IMG_DIR_NAME, "bbbb0000.png")))
+	assert.True(t, cache.Contains(DEFAULT_IMG_DIR_NAME, "dddd0000.png"))
+
+	// Remove a file that was deleted behind the cache's back.
+	assert.NoError(t, os.Remove(cache.path(DEFAULT_IMG_DIR_NAME, "dddd0000.png")))
+	assert.NoError(t, cache.Remove(DEFAULT_IMG_DIR_NAME, "dddd0000.png"))
+	assert.Equal(t, int64(30), cache.Size())
+
+	// The access times survive a restart. Shrinking the cache evicts the
+	// least recently used files.
+	cache.Touch(DEFAULT_IMG_DIR_NAME, "aaaa0000.png")
+	assert.NoError(t, cache.Flush())
+	assert.NoError(t, db.Close())
+	db, err = bolt.Open(dbPath, 0600, nil)
+	assert.NoError(t, err)
+	defer testutils.AssertCloses(t, db)
+
+	cache, err = newDiskCache(baseDir, dirs, 15, db)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), cache.Size())
+	assert.True(t, cache.Contains(DEFAULT_IMG_DIR_NAME, "aaaa0000.png"))
+	assert.False(t, cache.Contains(DEFAULT_DIFFIMG_DIR_NAME, "cccc0000.png"))
+	stored, err := cache.loadEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{cacheKey(DEFAULT_IMG_DIR_NAME, "aaaa0000.png")}, keys(stored))
+}
@@ -11,7 +11,17 @@
 // - Differ:      Proactively caclculates diffs between images with the goal
 //                of not having to calculate diffs when they are requested.
 //                Results are cached in RAM and on disk.
-//                Supports multiple diff metrics.
+//                Supports multiple diff metrics, which can be added via
+//                RegisterMetric. Besides the basic metrics there are
+//                perceptual metrics: SSIM, CIE76 color difference (ΔE) and
+//                an edge-aware pixel difference. Stored diffs that lack a
+//                newly registered metric are backfilled lazily.
+//
+// - DiskCache:   Keeps track of the images and diff images on local disk and
+//                evicts the least recently used files once their total size
+//                exceeds the configured limit. Access times are stored in
+//                the metrics DB. Evicted images are downloaded again from
+//                Google storage when they are needed.
 //
 
 package diffstore
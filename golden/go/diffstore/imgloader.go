@@ -13,7 +13,7 @@ import (
 
 	"cloud.google.com/go/storage"
 	"github.com/skia-dev/glog"
-	"go.skia.org/infra/go/fileutil"
+	"go.skia.org/infra/go/metrics2"
 	"go.skia.org/infra/go/rtcache"
 	"go.skia.org/infra/go/util"
 	"golang.org/x/net/context"
@@ -33,8 +33,8 @@ type ImageLoader struct {
 	// client is the Google storage client to local content form GS.
 	storageClient *storage.Client
 
-	// localImgDir is the local directory where images should be written to.
-	localImgDir string
+	// diskCache stores the images on local disk below DEFAULT_IMG_DIR_NAME.
+	diskCache *diskCache
 
 	// gsBucketName is the GS bucket where images are stored.
 	gsBucketName string
@@ -48,11 +48,16 @@ type ImageLoader struct {
 	// keep ?
 	isMaster bool
 
+	// redownloads counts the images that had to be fetched again from GS
+	// because their file in the disk cache could not be read, e.g. because
+	// it was evicted while being loaded.
+	redownloads *metrics2.Counter
+
 	wg sync.WaitGroup
 }
 
 // Creates a new instance of ImageLoader.
-func newImgLoader(client *http.Client, imgDir, gsBucketName, gsImageBaseDir string) (*ImageLoader, error) {
+func newImgLoader(client *http.Client, diskCache *diskCache, gsBucketName, gsImageBaseDir string) (*ImageLoader, error) {
 	storageClient, err := storage.NewClient(context.Background(), option.WithHTTPClient(client))
 	if err != nil {
 		return nil, err
@@ -60,9 +65,10 @@ func newImgLoader(client *http.Client, imgDir, gsBucketName, gsImageBaseDir stri
 
 	ret := &ImageLoader{
 		storageClient:  storageClient,
-		localImgDir:    imgDir,
+		diskCache:      diskCache,
 		gsBucketName:   gsBucketName,
 		gsImageBaseDir: gsImageBaseDir,
+		redownloads:    metrics2.GetCounter("gold.diskcache.redownloads", nil),
 	}
 
 	// Set up the work queues that balance the load.
@@ -112,6 +118,9 @@ func (il *ImageLoader) Get(priority int64, digests []string) ([]*image.NRGBA, er
 	for idx, digest := range digests {
 		go func(idx int, digest string) {
 			defer wg.Done()
+			// Images that are used keep their place in the disk cache even if
+			// they are served from RAM.
+			il.diskCache.Touch(DEFAULT_IMG_DIR_NAME, getDigestImageFileName(digest))
 			img, err := il.imageCache.Get(priority, digest)
 			if err != nil {
 				errCh <- err
@@ -135,15 +144,23 @@ func (il *ImageLoader) Get(priority int64, digests []string) ([]*image.NRGBA, er
 }
 
 // imageLoadWorker implements the rtcache.ReadThroughFunc signature.
-// It loads an image file either from disk or from Google storage.
+// It loads an image file either from disk or from Google storage. Images that
+// have been evicted from the disk cache or cannot be read are downloaded again.
 func (il *ImageLoader) imageLoadWorker(priority int64, digest string) (interface{}, error) {
 	// Check if the image is in the disk cache.
 	imageFileName := getDigestImageFileName(digest)
-	imagePath := fileutil.TwoLevelRadixPath(il.localImgDir, imageFileName)
-	if fileutil.FileExists(imagePath) {
+	if il.diskCache.Contains(DEFAULT_IMG_DIR_NAME, imageFileName) {
+		imagePath := il.diskCache.path(DEFAULT_IMG_DIR_NAME, imageFileName)
 		img, err := loadImg(imagePath)
-		glog.Infof("Loaded img %s from disk", imagePath)
-		return img, err
+		if err == nil {
+			glog.Infof("Loaded img %s from disk", imagePath)
+			return img, nil
+		}
+		glog.Errorf("Unable to load img %s from disk. Downloading it again: %s", imagePath, err)
+		if err := il.diskCache.Remove(DEFAULT_IMG_DIR_NAME, imageFileName); err != nil {
+			glog.Errorf("Unable to remove %s from the disk cache: %s", imagePath, err)
+		}
+		il.redownloads.Inc(1)
 	}
 
 	// Download the image
@@ -163,7 +180,7 @@ func (il *ImageLoader) saveImgInfoAsync(imageFileName string, imgBytes []byte) {
 	il.wg.Add(1)
 	go func() {
 		defer il.wg.Done()
-		if err := saveFileRadixPath(il.localImgDir, imageFileName, bytes.NewBuffer(imgBytes)); err != nil {
+		if err := il.diskCache.Save(DEFAULT_IMG_DIR_NAME, imageFileName, imgBytes); err != nil {
 			glog.Error(err)
 		}
 	}()
@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/boltdb/bolt"
 	assert "github.com/stretchr/testify/require"
 
 	"go.skia.org/infra/go/fileutil"
@@ -53,10 +54,15 @@ func getImageLoaderAndTile(t assert.TestingT) (string, string, *tiling.Tile, *Im
 	baseDir := TEST_DATA_BASE_DIR + "-imgloader"
 	client, tile := getSetupAndTile(t, baseDir)
 
-	workingDir := filepath.Join(baseDir, "images")
+	workingDir := filepath.Join(baseDir, DEFAULT_IMG_DIR_NAME)
 	assert.Nil(t, os.Mkdir(workingDir, 0777))
 
-	imgLoader, err := newImgLoader(client, workingDir, TEST_GS_BUCKET_NAME, TEST_GS_IMAGE_DIR)
+	db, err := bolt.Open(filepath.Join(baseDir, METRICSDB_NAME), 0600, nil)
+	assert.NoError(t, err)
+	diskCache, err := newDiskCache(baseDir, []string{DEFAULT_IMG_DIR_NAME}, 0, db)
+	assert.NoError(t, err)
+
+	imgLoader, err := newImgLoader(client, diskCache, TEST_GS_BUCKET_NAME, TEST_GS_IMAGE_DIR)
 	assert.NoError(t, err)
 	return baseDir, workingDir, tile, imgLoader
 }
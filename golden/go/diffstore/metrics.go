@@ -1,8 +1,11 @@
 package diffstore
 
 import (
+	"fmt"
 	"image"
 	"math"
+	"sort"
+	"sync"
 
 	"go.skia.org/infra/golden/go/diff"
 )
@@ -10,29 +13,96 @@ import (
 const (
 	METRIC_COMBINED = "combined"
 	METRIC_PERCENT  = "percent"
+	METRIC_SSIM     = "ssim"
+	METRIC_DELTA_E  = "deltaE"
+	METRIC_EDGE     = "edge"
 )
 
-// MetricsFn is the signature a custom diff metric has to implmente.
+// MetricFn is the signature a custom diff metric has to implement. It is
+// called with the basic diff of the two images already filled in and must
+// return a value where smaller means more similar.
 type MetricFn func(*DiffRecord, *image.NRGBA, *image.NRGBA) float32
 
-// metrics contains the custom diff metrics.
-var metrics = map[string]MetricFn{
-	METRIC_COMBINED: combinedDiffMetric,
-	METRIC_PERCENT:  percentDiffMetric,
-}
+var (
+	// metrics contains the registered diff metrics.
+	metrics = map[string]MetricFn{}
+
+	// diffMetricIds contains the sorted keys of metrics.
+	diffMetricIds []string
 
-var diffMetricIds []string
+	// metricsMutex protects metrics and diffMetricIds.
+	metricsMutex sync.RWMutex
+)
 
 func init() {
-	diffMetricIds = make([]string, 0, len(metrics))
-	for k := range metrics {
-		diffMetricIds = append(diffMetricIds, k)
+	MustRegisterMetric(METRIC_COMBINED, combinedDiffMetric)
+	MustRegisterMetric(METRIC_PERCENT, percentDiffMetric)
+	MustRegisterMetric(METRIC_SSIM, ssimDiffMetric)
+	MustRegisterMetric(METRIC_DELTA_E, deltaEDiffMetric)
+	MustRegisterMetric(METRIC_EDGE, edgeDiffMetric)
+}
+
+// RegisterMetric adds a diff metric under the given id. Results for the new
+// metric are calculated for new diffs right away, diffs that have already
+// been stored are backfilled lazily by the MemDiffStore.
+func RegisterMetric(id string, fn MetricFn) error {
+	if id == "" {
+		return fmt.Errorf("Diff metrics must have a non-empty id.")
+	}
+	if fn == nil {
+		return fmt.Errorf("No function provided for diff metric %q.", id)
+	}
+
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	if _, ok := metrics[id]; ok {
+		return fmt.Errorf("Diff metric %q is already registered.", id)
 	}
+	metrics[id] = fn
+	diffMetricIds = append(diffMetricIds, id)
+	sort.Strings(diffMetricIds)
+	return nil
+}
+
+// unregisterMetric removes the diff metric with the given id. It is only
+// used by tests to undo RegisterMetric.
+func unregisterMetric(id string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	if _, ok := metrics[id]; !ok {
+		return
+	}
+	delete(metrics, id)
+	for i, metricId := range diffMetricIds {
+		if metricId == id {
+			diffMetricIds = append(diffMetricIds[:i], diffMetricIds[i+1:]...)
+			break
+		}
+	}
+}
+
+// MustRegisterMetric is like RegisterMetric but panics on error.
+func MustRegisterMetric(id string, fn MetricFn) {
+	if err := RegisterMetric(id, fn); err != nil {
+		panic(err)
+	}
+}
+
+// IsMetric returns true if a diff metric with the given id is registered.
+func IsMetric(id string) bool {
+	metricsMutex.RLock()
+	defer metricsMutex.RUnlock()
+	_, ok := metrics[id]
+	return ok
 }
 
-// GetDiffMetricIDs returns the ids of the available diff metrics.
+// GetDiffMetricIDs returns the sorted ids of the available diff metrics.
 func GetDiffMetricIDs() []string {
-	return diffMetricIds
+	metricsMutex.RLock()
+	defer metricsMutex.RUnlock()
+	ret := make([]string, len(diffMetricIds))
+	copy(ret, diffMetricIds)
+	return ret
 }
 
 // TODO(stephana): Consolidate with diff.DiffMetrics.
@@ -42,10 +112,42 @@ type DiffRecord struct {
 	MaxRGBADiffs     []int
 	DimDiffer        bool
 
-	// Diffs contains the diff metrics defined in 'metrics'.
+	// Diffs contains the values of the registered diff metrics.
 	Diffs map[string]float32
 }
 
+// MissingMetrics returns the ids of the registered metrics that have not
+// been calculated for this record.
+func (d *DiffRecord) MissingMetrics() []string {
+	ret := []string{}
+	for _, id := range GetDiffMetricIDs() {
+		if _, ok := d.Diffs[id]; !ok {
+			ret = append(ret, id)
+		}
+	}
+	return ret
+}
+
+// CalcMetrics calculates the given metrics for the two images and adds
+// them to the record. Unknown metric ids are ignored.
+func (d *DiffRecord) CalcMetrics(ids []string, leftImg *image.NRGBA, rightImg *image.NRGBA) {
+	if d.Diffs == nil {
+		d.Diffs = make(map[string]float32, len(ids))
+	}
+	metricsMutex.RLock()
+	fns := make(map[string]MetricFn, len(ids))
+	for _, id := range ids {
+		if fn, ok := metrics[id]; ok {
+			fns[id] = fn
+		}
+	}
+	metricsMutex.RUnlock()
+
+	for id, fn := range fns {
+		d.Diffs[id] = fn(d, leftImg, rightImg)
+	}
+}
+
 // CalcDiff calculates the basic difference and then then custom diff metrics.
 func CalcDiff(leftImg *image.NRGBA, rightImg *image.NRGBA) (*DiffRecord, *image.NRGBA) {
 	basicDiff, diffImg := diff.Diff(leftImg, rightImg)
@@ -55,35 +157,33 @@ func CalcDiff(leftImg *image.NRGBA, rightImg *image.NRGBA) (*DiffRecord, *image.
 		MaxRGBADiffs:     basicDiff.MaxRGBADiffs,
 		DimDiffer:        basicDiff.DimDiffer,
 	}
-
-	// Calcluate the metrics.
-	diffs := make(map[string]float32, len(diffMetricIds))
-	for _, id := range diffMetricIds {
-		diffs[id] = metrics[id](ret, leftImg, rightImg)
-	}
-	ret.Diffs = diffs
+	ret.CalcMetrics(GetDiffMetricIDs(), leftImg, rightImg)
 	return ret, diffImg
 }
 
-// combinedDiffMetric returns a value in [0, 1] that represents how large
-// the diff is between two images. Implements the MetricFn signature.
-func combinedDiffMetric(basic *DiffRecord, one *image.NRGBA, two *image.NRGBA) float32 {
-	//
-	// pixelDiffPercent float32, maxRGBA []int) float32 {
-	if len(basic.MaxRGBADiffs) == 0 {
+// CombinedDiffMetric returns a value in [0, 1] that represents how large
+// the diff is between two images, based on the percentage of differing pixels
+// and the maximum difference of each channel.
+func CombinedDiffMetric(pixelDiffPercent float32, maxRGBA []int) float32 {
+	if len(maxRGBA) == 0 {
 		return 1.0
 	}
 	// Turn maxRGBA into a percent by taking the root mean square difference from
 	// [0, 0, 0, 0].
 	sum := 0.0
-	for _, c := range basic.MaxRGBADiffs {
+	for _, c := range maxRGBA {
 		sum += float64(c) * float64(c)
 	}
-	normalizedRGBA := math.Sqrt(sum/float64(len(basic.MaxRGBADiffs))) / 255.0
+	normalizedRGBA := math.Sqrt(sum/float64(len(maxRGBA))) / 255.0
 	// We take the sqrt of (pixelDiffPercent * normalizedRGBA) to straigten out
 	// the curve, i.e. think about what a plot of x^2 would look like in the
 	// range [0, 1].
-	return float32(math.Sqrt(float64(basic.PixelDiffPercent) * normalizedRGBA))
+	return float32(math.Sqrt(float64(pixelDiffPercent) * normalizedRGBA))
+}
+
+// combinedDiffMetric implements the MetricFn signature for CombinedDiffMetric.
+func combinedDiffMetric(basic *DiffRecord, one *image.NRGBA, two *image.NRGBA) float32 {
+	return CombinedDiffMetric(basic.PixelDiffPercent, basic.MaxRGBADiffs)
 }
 
 // percentDiffMetric returns pixel percent as the metric. Implements the MetricFn signature.
@@ -0,0 +1,89 @@
+package diffstore
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestRegisterMetric(t *testing.T) {
+	assert.Equal(t, []string{METRIC_COMBINED, METRIC_DELTA_E, METRIC_EDGE, METRIC_PERCENT, METRIC_SSIM}, GetDiffMetricIDs())
+
+	assert.Error(t, RegisterMetric("", percentDiffMetric))
+	assert.Error(t, RegisterMetric("test-nil", nil))
+	assert.Error(t, RegisterMetric(METRIC_COMBINED, percentDiffMetric))
+
+	numDiffPixels := func(basic *DiffRecord, one *image.NRGBA, two *image.NRGBA) float32 {
+		return float32(basic.NumDiffPixels)
+	}
+	dr := &DiffRecord{NumDiffPixels: 3, Diffs: map[string]float32{}}
+	for _, id := range GetDiffMetricIDs() {
+		dr.Diffs[id] = 0
+	}
+	assert.Equal(t, []string{}, dr.MissingMetrics())
+
+	assert.NoError(t, RegisterMetric("test-num-pixels", numDiffPixels))
+	defer unregisterMetric("test-num-pixels")
+	assert.True(t, IsMetric("test-num-pixels"))
+	assert.False(t, IsMetric("test-unknown"))
+	assert.Equal(t, []string{"test-num-pixels"}, dr.MissingMetrics())
+
+	dr.CalcMetrics(dr.MissingMetrics(), nil, nil)
+	assert.Equal(t, float32(3), dr.Diffs["test-num-pixels"])
+	assert.Equal(t, []string{}, dr.MissingMetrics())
+}
+
+func TestPerceptualMetrics(t *testing.T) {
+	white := color.NRGBA{255, 255, 255, 255}
+	black := color.NRGBA{0, 0, 0, 255}
+
+	// A black square on a white background.
+	square := func(offset int) *image.NRGBA {
+		img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 16; x++ {
+				img.SetNRGBA(x, y, white)
+				if x >= 4+offset && x < 12+offset && y >= 4 && y < 12 {
+					img.SetNRGBA(x, y, black)
+				}
+			}
+		}
+		return img
+	}
+
+	// Identical images.
+	dr, _ := CalcDiff(square(0), square(0))
+	assert.Equal(t, float32(0), dr.Diffs[METRIC_SSIM])
+	assert.Equal(t, float32(0), dr.Diffs[METRIC_DELTA_E])
+	assert.Equal(t, float32(0), dr.Diffs[METRIC_EDGE])
+
+	// Moving the square by one pixel only changes pixels on its edges.
+	dr, _ = CalcDiff(square(0), square(1))
+	assert.Equal(t, 16, dr.NumDiffPixels)
+	assert.Equal(t, float32(0), dr.Diffs[METRIC_EDGE])
+	assert.True(t, dr.Diffs[METRIC_SSIM] > 0 && dr.Diffs[METRIC_SSIM] <= 1)
+	// 16 of 256 pixels change from white to black, i.e. by ΔE 100.
+	assert.InDelta(t, 100.0*16/256, dr.Diffs[METRIC_DELTA_E], 0.1)
+
+	// A pixel that changes inside a flat area is not on an edge.
+	speck := square(0)
+	speck.SetNRGBA(1, 1, color.NRGBA{250, 250, 250, 255})
+	dr, _ = CalcDiff(square(0), speck)
+	assert.InDelta(t, 100.0/256, dr.Diffs[METRIC_EDGE], 0.0001)
+	assert.True(t, dr.Diffs[METRIC_DELTA_E] < 0.1)
+
+	// Images of different sizes are maximally different.
+	dr, _ = CalcDiff(square(0), image.NewNRGBA(image.Rect(0, 0, 8, 8)))
+	assert.True(t, dr.DimDiffer)
+	assert.Equal(t, float32(1), dr.Diffs[METRIC_SSIM])
+	assert.Equal(t, float32(100), dr.Diffs[METRIC_EDGE])
+}
+
+func TestCombinedDiffMetric(t *testing.T) {
+	assert.InDelta(t, 1.0, CombinedDiffMetric(0.0, []int{}), 0.000001)
+	assert.InDelta(t, 1.0, CombinedDiffMetric(1.0, []int{255, 255, 255, 255}), 0.000001)
+	assert.InDelta(t, math.Sqrt(0.5), CombinedDiffMetric(0.5, []int{255, 255, 255, 255}), 0.000001)
+}
@@ -0,0 +1,217 @@
+package diffstore
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	// SSIM_WINDOW is the side length of the square windows over which the
+	// structural similarity is calculated.
+	SSIM_WINDOW = 8
+
+	// EDGE_THRESHOLD is the gradient magnitude of the luminance above which
+	// a pixel is considered to lie on an edge.
+	EDGE_THRESHOLD = 128.0
+)
+
+// Stabilizing constants of the SSIM formula for a dynamic range of 255.
+var (
+	ssimC1 = math.Pow(0.01*255, 2)
+	ssimC2 = math.Pow(0.03*255, 2)
+)
+
+// ssimDiffMetric returns 1 - SSIM of the luminance of the two images, i.e. a
+// value in [0, 1] where 0 means the images are structurally identical.
+// SSIM is averaged over non-overlapping windows of SSIM_WINDOW pixels.
+// Implements the MetricFn signature.
+func ssimDiffMetric(basic *DiffRecord, one *image.NRGBA, two *image.NRGBA) float32 {
+	if basic.DimDiffer {
+		return 1.0
+	}
+	if basic.NumDiffPixels == 0 {
+		return 0.0
+	}
+	lumOne, lumTwo := luminance(one), luminance(two)
+	w, h := one.Bounds().Dx(), one.Bounds().Dy()
+
+	total := 0.0
+	nWindows := 0
+	for y := 0; y < h; y += SSIM_WINDOW {
+		for x := 0; x < w; x += SSIM_WINDOW {
+			total += windowSSIM(lumOne, lumTwo, w, x, y, min(x+SSIM_WINDOW, w), min(y+SSIM_WINDOW, h))
+			nWindows++
+		}
+	}
+	ret := 1.0 - total/float64(nWindows)
+	return float32(math.Max(0.0, math.Min(1.0, ret)))
+}
+
+// windowSSIM calculates the SSIM of the window [x0, x1) x [y0, y1) of two
+// luminance images with the given stride.
+func windowSSIM(one, two []float64, stride, x0, y0, x1, y1 int) float64 {
+	n := float64((x1 - x0) * (y1 - y0))
+	var sumOne, sumTwo float64
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			sumOne += one[y*stride+x]
+			sumTwo += two[y*stride+x]
+		}
+	}
+	meanOne, meanTwo := sumOne/n, sumTwo/n
+
+	var varOne, varTwo, coVar float64
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			dOne := one[y*stride+x] - meanOne
+			dTwo := two[y*stride+x] - meanTwo
+			varOne += dOne * dOne
+			varTwo += dTwo * dTwo
+			coVar += dOne * dTwo
+		}
+	}
+	varOne, varTwo, coVar = varOne/n, varTwo/n, coVar/n
+
+	return ((2*meanOne*meanTwo + ssimC1) * (2*coVar + ssimC2)) /
+		((meanOne*meanOne + meanTwo*meanTwo + ssimC1) * (varOne + varTwo + ssimC2))
+}
+
+// deltaEDiffMetric returns the mean CIE76 color difference (ΔE*ab) of all
+// pixels of the two images after compositing them onto a white background.
+// A value below ~2.3 is generally not noticeable by a human observer.
+// Returns math.MaxFloat32 if the dimensions differ. Implements the MetricFn
+// signature.
+func deltaEDiffMetric(basic *DiffRecord, one *image.NRGBA, two *image.NRGBA) float32 {
+	if basic.DimDiffer {
+		return math.MaxFloat32
+	}
+	if basic.NumDiffPixels == 0 {
+		return 0.0
+	}
+	total := 0.0
+	nPixels := len(one.Pix) / 4
+	for i := 0; i < len(one.Pix); i += 4 {
+		if one.Pix[i] == two.Pix[i] && one.Pix[i+1] == two.Pix[i+1] && one.Pix[i+2] == two.Pix[i+2] && one.Pix[i+3] == two.Pix[i+3] {
+			continue
+		}
+		l1, a1, b1 := toLab(one.Pix[i : i+4])
+		l2, a2, b2 := toLab(two.Pix[i : i+4])
+		total += math.Sqrt((l1-l2)*(l1-l2) + (a1-a2)*(a1-a2) + (b1-b2)*(b1-b2))
+	}
+	return float32(total / float64(nPixels))
+}
+
+// edgeDiffMetric returns the percentage of pixels that differ and do not lie
+// on an edge in either image. Differences along edges, e.g. due to
+// anti-aliasing, are thereby ignored. Returns 100 if the dimensions differ.
+// Implements the MetricFn signature.
+func edgeDiffMetric(basic *DiffRecord, one *image.NRGBA, two *image.NRGBA) float32 {
+	if basic.DimDiffer {
+		return 100.0
+	}
+	if basic.NumDiffPixels == 0 {
+		return 0.0
+	}
+	w, h := one.Bounds().Dx(), one.Bounds().Dy()
+	edgesOne := edges(luminance(one), w, h)
+	edgesTwo := edges(luminance(two), w, h)
+
+	count := 0
+	for i := 0; i < w*h; i++ {
+		if edgesOne[i] || edgesTwo[i] {
+			continue
+		}
+		p := i * 4
+		if one.Pix[p] != two.Pix[p] || one.Pix[p+1] != two.Pix[p+1] || one.Pix[p+2] != two.Pix[p+2] || one.Pix[p+3] != two.Pix[p+3] {
+			count++
+		}
+	}
+	return float32(count) * 100 / float32(w*h)
+}
+
+// luminance returns the luma of every pixel of the image, with alpha
+// composited onto a white background, in row major order.
+func luminance(img *image.NRGBA) []float64 {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	ret := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := img.PixOffset(x+img.Rect.Min.X, y+img.Rect.Min.Y)
+			r, g, b := onWhite(img.Pix[p : p+4])
+			ret[y*w+x] = 0.299*r + 0.587*g + 0.114*b
+		}
+	}
+	return ret
+}
+
+// edges returns for every pixel of the given luminance image whether the
+// magnitude of its Sobel gradient exceeds EDGE_THRESHOLD. Pixels on the border
+// of the image are compared against their clamped neighbors.
+func edges(lum []float64, w, h int) []bool {
+	at := func(x, y int) float64 {
+		return lum[clamp(y, 0, h-1)*w+clamp(x, 0, w-1)]
+	}
+	ret := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+			ret[y*w+x] = math.Sqrt(gx*gx+gy*gy) > EDGE_THRESHOLD
+		}
+	}
+	return ret
+}
+
+// onWhite composites a non-premultiplied RGBA pixel onto a white background.
+func onWhite(pix []uint8) (float64, float64, float64) {
+	alpha := float64(pix[3]) / 255.0
+	bg := 255.0 * (1.0 - alpha)
+	return float64(pix[0])*alpha + bg, float64(pix[1])*alpha + bg, float64(pix[2])*alpha + bg
+}
+
+// toLab converts a non-premultiplied sRGB pixel, composited onto white, into
+// the CIE L*a*b* color space using the D65 white point.
+func toLab(pix []uint8) (float64, float64, float64) {
+	r, g, b := onWhite(pix)
+	r, g, b = linearize(r/255.0), linearize(g/255.0), linearize(b/255.0)
+
+	x := (0.4124*r + 0.3576*g + 0.1805*b) / 0.95047
+	y := 0.2126*r + 0.7152*g + 0.0722*b
+	z := (0.0193*r + 0.1192*g + 0.9505*b) / 1.08883
+
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// linearize converts an sRGB channel value in [0, 1] into linear RGB.
+func linearize(c float64) float64 {
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+// labF is the non-linear function used in the XYZ to L*a*b* conversion.
+func labF(t float64) float64 {
+	if t > 216.0/24389.0 {
+		return math.Cbrt(t)
+	}
+	return (24389.0/27.0*t + 16) / 116
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/skia-dev/glog"
 	"go.skia.org/infra/golden/go/diff"
+	"go.skia.org/infra/golden/go/diffstore"
 	"go.skia.org/infra/golden/go/expstorage"
 	"go.skia.org/infra/golden/go/tally"
 	"go.skia.org/infra/golden/go/types"
@@ -31,6 +32,13 @@ func newClosest() *Closest {
 //
 // If no digest of type 'label' is found then Closest.Digest is the empty string.
 func ClosestDigest(test string, digest string, exp *expstorage.Expectations, tallies tally.Tally, diffStore diff.DiffStore, label types.Label) *Closest {
+	return ClosestDigestByMetric(test, digest, exp, tallies, diffStore, label, diffstore.METRIC_COMBINED)
+}
+
+// ClosestDigestByMetric is like ClosestDigest but measures the distance
+// between digests with the given diff metric, see diffstore.RegisterMetric.
+// Digests for which the metric has not been calculated are not considered.
+func ClosestDigestByMetric(test string, digest string, exp *expstorage.Expectations, tallies tally.Tally, diffStore diff.DiffStore, label types.Label, metric string) *Closest {
 	ret := newClosest()
 	unavailableDigests := diffStore.UnavailableDigests()
 
@@ -54,7 +62,7 @@ func ClosestDigest(test string, digest string, exp *expstorage.Expectations, tal
 		return ret
 	} else {
 		for digest, diff := range diffMetrics {
-			if delta := combinedDiffMetric(diff.PixelDiffPercent, diff.MaxRGBADiffs); delta < ret.Diff {
+			if delta, ok := MetricValue(diff, metric); ok && delta < ret.Diff {
 				ret.Digest = digest
 				ret.Diff = delta
 				ret.DiffPixels = diff.PixelDiffPercent
@@ -69,27 +77,24 @@ func ClosestDigest(test string, digest string, exp *expstorage.Expectations, tal
 // given diff.DiffMetrics. The Digest field will be left empty.
 func ClosestFromDiffMetrics(diff *diff.DiffMetrics) *Closest {
 	return &Closest{
-		Diff:       combinedDiffMetric(diff.PixelDiffPercent, diff.MaxRGBADiffs),
+		Diff:       diffstore.CombinedDiffMetric(diff.PixelDiffPercent, diff.MaxRGBADiffs),
 		DiffPixels: diff.PixelDiffPercent,
 		MaxRGBA:    diff.MaxRGBADiffs,
 	}
 }
 
-// combinedDiffMetric returns a value in [0, 1] that represents how large
-// the diff is between two images.
-func combinedDiffMetric(pixelDiffPercent float32, maxRGBA []int) float32 {
-	if len(maxRGBA) == 0 {
-		return 1.0
+// MetricValue returns the value of the given diff metric for the diff. The
+// basic metrics are derived from the diff if they have not been stored with
+// it. Returns false if the value is not available.
+func MetricValue(dm *diff.DiffMetrics, metric string) (float32, bool) {
+	if v, ok := dm.Diffs[metric]; ok {
+		return v, true
 	}
-	// Turn maxRGBA into a percent by taking the root mean square difference from
-	// [0, 0, 0, 0].
-	sum := 0.0
-	for _, c := range maxRGBA {
-		sum += float64(c) * float64(c)
+	switch metric {
+	case diffstore.METRIC_COMBINED:
+		return diffstore.CombinedDiffMetric(dm.PixelDiffPercent, dm.MaxRGBADiffs), true
+	case diffstore.METRIC_PERCENT:
+		return dm.PixelDiffPercent, true
 	}
-	normalizedRGBA := math.Sqrt(sum/float64(len(maxRGBA))) / 255.0
-	// We take the sqrt of (pixelDiffPercent * normalizedRGBA) to straigten out
-	// the curve, i.e. think about what a plot of x^2 would look like in the
-	// range [0, 1].
-	return float32(math.Sqrt(float64(pixelDiffPercent) * normalizedRGBA))
+	return 0, false
 }
@@ -6,6 +6,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"go.skia.org/infra/golden/go/diff"
+	"go.skia.org/infra/golden/go/diffstore"
 	"go.skia.org/infra/golden/go/expstorage"
 	"go.skia.org/infra/golden/go/tally"
 	"go.skia.org/infra/golden/go/types"
@@ -18,7 +19,8 @@ func (m MockDiffStore) UnavailableDigests() map[string]*diff.DigestFailure
 func (m MockDiffStore) PurgeDigests(digests []string, purgeGS bool) error        { return nil }
 func (m MockDiffStore) SetDigestSets(namedDigestSets map[string]map[string]bool) {}
 
-// Get always finds that digest "eee" is closest to dMain.
+// Get always finds that digest "eee" is closest to dMain, except for the
+// SSIM metric for which "aaa" is closest.
 func (m MockDiffStore) Get(dMain string, dRest []string) (map[string]*diff.DiffMetrics, error) {
 	result := map[string]*diff.DiffMetrics{}
 	for i, d := range dRest {
@@ -26,9 +28,14 @@ func (m MockDiffStore) Get(dMain string, dRest []string) (map[string]*diff.DiffM
 		if d == "eee" {
 			diffPercent = 0.1
 		}
+		ssim := float32(0.5)
+		if d == "aaa" {
+			ssim = 0.2
+		}
 		result[d] = &diff.DiffMetrics{
 			PixelDiffPercent: diffPercent,
 			MaxRGBADiffs:     []int{5, 3, 4, 0},
+			Diffs:            map[string]float32{diffstore.METRIC_SSIM: ssim},
 		}
 	}
 	return result, nil
@@ -75,8 +82,31 @@ func TestClosestDigest(t *testing.T) {
 	assert.Equal(t, []int{5, 3, 4, 0}, c.MaxRGBA)
 }
 
-func TestCombinedDiffMetric(t *testing.T) {
-	assert.InDelta(t, 1.0, combinedDiffMetric(0.0, []int{}), 0.000001)
-	assert.InDelta(t, 1.0, combinedDiffMetric(1.0, []int{255, 255, 255, 255}), 0.000001)
-	assert.InDelta(t, math.Sqrt(0.5), combinedDiffMetric(0.5, []int{255, 255, 255, 255}), 0.000001)
+func TestClosestDigestByMetric(t *testing.T) {
+	diffStore := MockDiffStore{}
+	exp := &expstorage.Expectations{
+		Tests: map[string]types.TestClassification{
+			"foo": map[string]types.Label{
+				"aaa": types.POSITIVE,
+				"eee": types.POSITIVE,
+			},
+		},
+	}
+	tallies := tally.Tally{
+		"aaa": 2,
+		"eee": 2,
+	}
+
+	c := ClosestDigestByMetric("foo", "fff", exp, tallies, diffStore, types.POSITIVE, diffstore.METRIC_PERCENT)
+	assert.Equal(t, "eee", c.Digest)
+	assert.InDelta(t, 0.1, float64(c.Diff), 0.000001)
+
+	// "aaa" is closer according to the stored SSIM values.
+	c = ClosestDigestByMetric("foo", "fff", exp, tallies, diffStore, types.POSITIVE, diffstore.METRIC_SSIM)
+	assert.Equal(t, "aaa", c.Digest)
+	assert.InDelta(t, 0.2, float64(c.Diff), 0.000001)
+
+	// No values are available for an unknown metric.
+	c = ClosestDigestByMetric("foo", "fff", exp, tallies, diffStore, types.POSITIVE, "unknown")
+	assert.Equal(t, "", c.Digest)
 }
@@ -0,0 +1,51 @@
+// exp_export writes the expectations and the triage log of a Gold instance
+// to a file. The output can be loaded into another instance via exp_import.
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/skia-dev/glog"
+
+	"go.skia.org/infra/go/common"
+	"go.skia.org/infra/go/database"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/db"
+	"go.skia.org/infra/golden/go/expstorage"
+)
+
+var (
+	outputFile = flag.String("output_file", "expectations.jsonl", "Path of the export file. Use '-' to write to stdout.")
+)
+
+func main() {
+	defer common.LogPanic()
+	dbConf := database.ConfigFromFlags(db.PROD_DB_HOST, db.PROD_DB_PORT, database.USER_ROOT, db.PROD_DB_NAME, db.MigrationSteps())
+	common.Init()
+
+	vdb, err := dbConf.NewVersionedDB()
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if !vdb.IsLatestVersion() {
+		glog.Fatal("Wrong DB version. Please updated to latest version.")
+	}
+
+	data, err := expstorage.Export(expstorage.NewSQLExpectationStore(vdb))
+	if err != nil {
+		glog.Fatalf("Export failed: %s", err)
+	}
+
+	out := os.Stdout
+	if *outputFile != "-" {
+		if out, err = os.Create(*outputFile); err != nil {
+			glog.Fatalf("Unable to create %s: %s", *outputFile, err)
+		}
+		defer util.Close(out)
+	}
+	if err := expstorage.WriteExport(out, data); err != nil {
+		glog.Fatalf("Unable to write export: %s", err)
+	}
+	glog.Infof("Exported expectations for %d tests and %d triage log entries.", len(data.Expectations.Tests), len(data.TriageLog))
+}
@@ -0,0 +1,93 @@
+// exp_import loads a file written by exp_export into a Gold instance. The
+// triage log is replayed with the original users and time stamps, afterwards
+// the exported expectations that are missing are added. Expectations that are
+// not in the file are only removed if --replace is given.
+//
+// Run with --dry_run first to see what would change.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/skia-dev/glog"
+
+	"go.skia.org/infra/go/common"
+	"go.skia.org/infra/go/database"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/db"
+	"go.skia.org/infra/golden/go/expstorage"
+)
+
+var (
+	inputFile = flag.String("input_file", "expectations.jsonl", "Path of the file written by exp_export.")
+	dryRun    = flag.Bool("dry_run", false, "Print the changes without modifying the database.")
+	replace   = flag.Bool("replace", false, "Remove the expectations that are not in the input file, so the expectations match it exactly.")
+	userId    = flag.String("user", "exp_import", "User that is recorded for changes that are not part of the imported triage log.")
+)
+
+func main() {
+	defer common.LogPanic()
+	dbConf := database.ConfigFromFlags(db.PROD_DB_HOST, db.PROD_DB_PORT, database.USER_ROOT, db.PROD_DB_NAME, db.MigrationSteps())
+	common.Init()
+
+	f, err := os.Open(*inputFile)
+	if err != nil {
+		glog.Fatalf("Unable to open %s: %s", *inputFile, err)
+	}
+	defer util.Close(f)
+	data, err := expstorage.ReadExport(f)
+	if err != nil {
+		glog.Fatalf("Unable to read %s: %s", *inputFile, err)
+	}
+
+	vdb, err := dbConf.NewVersionedDB()
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if !vdb.IsLatestVersion() {
+		glog.Fatal("Wrong DB version. Please updated to latest version.")
+	}
+
+	store := expstorage.NewSQLExpectationStore(vdb).(expstorage.TimeStampedExpectationsStore)
+	result, err := expstorage.Import(store, data, *userId, *replace, *dryRun)
+	if err != nil {
+		glog.Fatalf("Import failed: %s", err)
+	}
+	printResult(result)
+}
+
+// printResult writes a human readable summary of the import to stdout.
+func printResult(result *expstorage.ImportResult) {
+	verb := "Replayed"
+	if *dryRun {
+		verb = "Would replay"
+		fmt.Println("Dry run. The database has not been changed.")
+	}
+	fmt.Printf("%s %d triage log entries, %d already exist.\n", verb, result.Replayed, result.Skipped)
+	fmt.Println("Changes to the expectations after replaying the triage log:")
+
+	testNames := make([]string, 0, len(result.Added.Tests))
+	for testName := range result.Added.Tests {
+		testNames = append(testNames, testName)
+	}
+	sort.Strings(testNames)
+	for _, testName := range testNames {
+		for digest, label := range result.Added.Tests[testName] {
+			fmt.Printf("  + %s %s %s\n", testName, digest, label.String())
+		}
+	}
+
+	testNames = make([]string, 0, len(result.Removed))
+	for testName := range result.Removed {
+		testNames = append(testNames, testName)
+	}
+	sort.Strings(testNames)
+	for _, testName := range testNames {
+		for _, digest := range result.Removed[testName] {
+			fmt.Printf("  - %s %s\n", testName, digest)
+		}
+	}
+}
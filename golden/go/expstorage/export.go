@@ -0,0 +1,262 @@
+package expstorage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/types"
+)
+
+const (
+	// EXPORT_FORMAT is stored in the header of every export file.
+	EXPORT_FORMAT = "gold-expectations"
+
+	// EXPORT_VERSION is the current version of the export format. It has to be
+	// incremented whenever the format changes in an incompatible way.
+	EXPORT_VERSION = 1
+
+	// exportPageSize is the number of triage log entries fetched at once.
+	exportPageSize = 500
+)
+
+// ExportData is the content of an export file. It contains the expectations
+// and the triage log of an instance of Gold.
+type ExportData struct {
+	// Expectations at the time of the export.
+	Expectations *Expectations
+
+	// TriageLog contains all changes to the expectations, oldest first.
+	TriageLog []*TriageLogEntry
+}
+
+// The export format is JSONL: The first line is an exportHeader and every
+// following line is an exportRecord. Labels are stored as strings so the
+// files do not depend on the numeric values of types.Label.
+type exportHeader struct {
+	Format   string `json:"format"`
+	Version  int    `json:"version"`
+	Exported int64  `json:"exported"`
+}
+
+type exportRecord struct {
+	Expectations map[string]map[string]string `json:"expectations,omitempty"`
+	Change       *TriageLogEntry              `json:"change,omitempty"`
+}
+
+// Export retrieves the current expectations and the entire triage log from the
+// given store.
+func Export(store ExpectationsStore) (*ExportData, error) {
+	exp, err := store.Get()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve expectations: %s", err)
+	}
+
+	triageLog, err := FetchTriageLog(store)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExportData{
+		Expectations: exp,
+		TriageLog:    triageLog,
+	}, nil
+}
+
+// FetchTriageLog pages through the triage log of the given store and returns
+// all entries including their details, oldest first.
+func FetchTriageLog(store ExpectationsStore) ([]*TriageLogEntry, error) {
+	ret := []*TriageLogEntry{}
+	for {
+		entries, total, err := store.QueryLog(len(ret), exportPageSize, true)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to retrieve triage log: %s", err)
+		}
+		ret = append(ret, entries...)
+		if (len(entries) == 0) || (len(ret) >= total) {
+			break
+		}
+	}
+
+	// QueryLog returns the newest entries first.
+	for i, j := 0, len(ret)-1; i < j; i, j = i+1, j-1 {
+		ret[i], ret[j] = ret[j], ret[i]
+	}
+	return ret, nil
+}
+
+// WriteExport writes the given data in the export format to w.
+func WriteExport(w io.Writer, data *ExportData) error {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&exportHeader{Format: EXPORT_FORMAT, Version: EXPORT_VERSION, Exported: util.TimeStampMs()}); err != nil {
+		return err
+	}
+
+	tests := make(map[string]map[string]string, len(data.Expectations.Tests))
+	for testName, digests := range data.Expectations.Tests {
+		tests[testName] = make(map[string]string, len(digests))
+		for digest, label := range digests {
+			tests[testName][digest] = label.String()
+		}
+	}
+	if err := enc.Encode(&exportRecord{Expectations: tests}); err != nil {
+		return err
+	}
+
+	for _, entry := range data.TriageLog {
+		if err := enc.Encode(&exportRecord{Change: entry}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadExport parses data that was written by WriteExport.
+func ReadExport(r io.Reader) (*ExportData, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	header := &exportHeader{}
+	if err := dec.Decode(header); err != nil {
+		return nil, fmt.Errorf("Unable to read export header: %s", err)
+	}
+	if header.Format != EXPORT_FORMAT {
+		return nil, fmt.Errorf("Unknown export format: %q", header.Format)
+	}
+	if header.Version != EXPORT_VERSION {
+		return nil, fmt.Errorf("Unsupported export version %d. Expected version %d.", header.Version, EXPORT_VERSION)
+	}
+
+	ret := &ExportData{
+		Expectations: NewExpectations(),
+		TriageLog:    []*TriageLogEntry{},
+	}
+	for {
+		rec := &exportRecord{}
+		if err := dec.Decode(rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Unable to read export record: %s", err)
+		}
+
+		for testName, digests := range rec.Expectations {
+			for digest, label := range digests {
+				if !types.ValidLabel(label) {
+					return nil, fmt.Errorf("Invalid label %q for %s/%s", label, testName, digest)
+				}
+				ret.Expectations.AddDigests(map[string]types.TestClassification{testName: {digest: types.LabelFromString(label)}})
+			}
+		}
+		if rec.Change != nil {
+			for _, d := range rec.Change.Details {
+				if !types.ValidLabel(d.Label) {
+					return nil, fmt.Errorf("Invalid label %q in change %d for %s/%s", d.Label, rec.Change.ID, d.TestName, d.Digest)
+				}
+			}
+			ret.TriageLog = append(ret.TriageLog, rec.Change)
+		}
+	}
+	return ret, nil
+}
+
+// TimeStampedExpectationsStore is implemented by stores that can record changes
+// with the user and time stamp of the original change, i.e. SQLExpectationsStore.
+type TimeStampedExpectationsStore interface {
+	ExpectationsStore
+
+	// AddChangeWithTimeStamp is the same as AddChange, but allows to set the
+	// time stamp of the change. See SQLExpectationsStore.
+	AddChangeWithTimeStamp(changedTests map[string]types.TestClassification, userId string, undoID int, timeStamp int64) error
+}
+
+// ImportResult summarizes the changes made (or to be made) by Import.
+type ImportResult struct {
+	// Replayed is the number of triage log entries that were added.
+	Replayed int
+
+	// Skipped is the number of triage log entries that already existed.
+	Skipped int
+
+	// Added and Removed are the changes needed after replaying the triage log
+	// to make the expectations match the imported expectations.
+	Added   *Expectations
+	Removed map[string][]string
+}
+
+// Import adds the given data to the store. First the triage log is replayed,
+// keeping the original users and time stamps. Log entries that already
+// exist in the store, i.e. with the same user and time stamp, are skipped.
+// Afterwards the imported expectations that are missing from the store are
+// added, attributed to userId. If replace is true, expectations in the store
+// that are not in the imported expectations are removed as well, otherwise
+// they are kept and ImportResult.Removed is empty.
+//
+// If dryRun is true the store is not modified, but the returned result
+// reflects the changes that would have been made.
+//
+// Note: Undo entries are imported as regular changes, since the IDs of the
+// changes they refer to are not retained.
+func Import(store TimeStampedExpectationsStore, data *ExportData, userId string, replace, dryRun bool) (*ImportResult, error) {
+	existing, err := FetchTriageLog(store)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(existing))
+	for _, entry := range existing {
+		seen[logEntryKey(entry)] = true
+	}
+
+	exp, err := store.Get()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve expectations: %s", err)
+	}
+	exp = exp.DeepCopy()
+
+	ret := &ImportResult{}
+	for _, entry := range data.TriageLog {
+		if seen[logEntryKey(entry)] {
+			ret.Skipped++
+			continue
+		}
+
+		changes := map[string]types.TestClassification{}
+		for _, d := range entry.Details {
+			if _, ok := changes[d.TestName]; !ok {
+				changes[d.TestName] = types.TestClassification{}
+			}
+			changes[d.TestName][d.Digest] = types.LabelFromString(d.Label)
+		}
+		if !dryRun {
+			if err := store.AddChangeWithTimeStamp(changes, entry.Name, 0, entry.TS); err != nil {
+				return nil, fmt.Errorf("Unable to import change by %s at %d: %s", entry.Name, entry.TS, err)
+			}
+		}
+		exp.AddDigests(changes)
+		ret.Replayed++
+	}
+
+	ret.Added, ret.Removed = exp.Delta(data.Expectations)
+	if !replace {
+		ret.Removed = map[string][]string{}
+	}
+	if dryRun {
+		return ret, nil
+	}
+
+	if len(ret.Added.Tests) > 0 {
+		if err := store.AddChange(ret.Added.Tests, userId); err != nil {
+			return nil, fmt.Errorf("Unable to add expectations: %s", err)
+		}
+	}
+	if len(ret.Removed) > 0 {
+		if err := store.RemoveChange(ret.Removed); err != nil {
+			return nil, fmt.Errorf("Unable to remove expectations: %s", err)
+		}
+	}
+	return ret, nil
+}
+
+// logEntryKey identifies a triage log entry across instances of Gold.
+func logEntryKey(entry *TriageLogEntry) string {
+	return fmt.Sprintf("%s:%d", entry.Name, entry.TS)
+}
@@ -0,0 +1,134 @@
+package expstorage
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/database/testutil"
+	"go.skia.org/infra/golden/go/db"
+	"go.skia.org/infra/golden/go/types"
+)
+
+func TestWriteReadExport(t *testing.T) {
+	data := &ExportData{
+		Expectations: &Expectations{
+			Tests: map[string]types.TestClassification{
+				"test1": {"d11": types.POSITIVE, "d12": types.NEGATIVE},
+				"test2": {"d21": types.POSITIVE},
+			},
+		},
+		TriageLog: []*TriageLogEntry{
+			{ID: 1, Name: "jon@example.com", TS: 1000, ChangeCount: 1, Details: []*TriageDetail{{TestName: "test1", Digest: "d11", Label: "positive"}}},
+			{ID: 2, Name: "jim@example.com", TS: 2000, ChangeCount: 1, Details: []*TriageDetail{{TestName: "test2", Digest: "d21", Label: "positive"}}},
+		},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteExport(&buf, data))
+	assert.Equal(t, 4, strings.Count(buf.String(), "\n"))
+
+	found, err := ReadExport(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, data, found)
+
+	// Unknown versions and invalid labels are rejected.
+	_, err = ReadExport(strings.NewReader(`{"format":"gold-expectations","version":100}`))
+	assert.Error(t, err)
+	_, err = ReadExport(strings.NewReader(`{"format":"something-else","version":1}`))
+	assert.Error(t, err)
+	_, err = ReadExport(strings.NewReader(`{"format":"gold-expectations","version":1}
+{"expectations":{"test1":{"d11":"maybe"}}}`))
+	assert.Error(t, err)
+}
+
+func TestImport(t *testing.T) {
+	testDb := testutil.SetupMySQLTestDatabase(t, db.MigrationSteps())
+	defer testDb.Close(t)
+
+	conf := testutil.LocalTestDatabaseConfig(db.MigrationSteps())
+	vdb, err := conf.NewVersionedDB()
+	assert.NoError(t, err)
+
+	store := NewSQLExpectationStore(vdb).(TimeStampedExpectationsStore)
+	assert.NoError(t, store.AddChangeWithTimeStamp(map[string]types.TestClassification{
+		"test1": {"d11": types.POSITIVE, "d12": types.NEGATIVE},
+	}, "jon@example.com", 0, 1000))
+	assert.NoError(t, store.AddChangeWithTimeStamp(map[string]types.TestClassification{
+		"test2": {"d21": types.POSITIVE},
+	}, "jim@example.com", 0, 2000))
+
+	data, err := Export(store)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(data.TriageLog))
+	assert.Equal(t, "jon@example.com", data.TriageLog[0].Name)
+	assert.Equal(t, int64(1000), data.TriageLog[0].TS)
+	assert.Equal(t, "jim@example.com", data.TriageLog[1].Name)
+
+	// Drift away from the exported state.
+	assert.NoError(t, store.RemoveChange(map[string][]string{"test1": {"d12"}}))
+	assert.NoError(t, store.AddChangeWithTimeStamp(map[string]types.TestClassification{
+		"test3": {"d31": types.NEGATIVE},
+	}, "jane@example.com", 0, 3000))
+
+	// A dry run reports the changes without applying them. Removals are
+	// only reported when replacing.
+	result, err := Import(store, data, "importer", false, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Replayed)
+	assert.Equal(t, 2, result.Skipped)
+	assert.Equal(t, map[string]types.TestClassification{"test1": {"d12": types.NEGATIVE}}, result.Added.Tests)
+	assert.Equal(t, 0, len(result.Removed))
+	result, err = Import(store, data, "importer", true, true)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"test3": {"d31"}}, result.Removed)
+
+	exp, err := store.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, types.UNTRIAGED, exp.Classification("test1", "d12"))
+	assert.Equal(t, types.NEGATIVE, exp.Classification("test3", "d31"))
+
+	// By default the import is additive.
+	_, err = Import(store, data, "importer", false, false)
+	assert.NoError(t, err)
+	exp, err = store.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, types.NEGATIVE, exp.Classification("test1", "d12"))
+	assert.Equal(t, types.NEGATIVE, exp.Classification("test3", "d31"))
+
+	// Replacing removes the expectations that are not in the import.
+	_, err = Import(store, data, "importer", true, false)
+	assert.NoError(t, err)
+	exp, err = store.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, data.Expectations.Tests, exp.Tests)
+
+	// Entries that are not in the store are replayed with their users and
+	// time stamps.
+	data.TriageLog = append(data.TriageLog, &TriageLogEntry{
+		Name:    "joe@example.com",
+		TS:      4000,
+		Details: []*TriageDetail{{TestName: "test4", Digest: "d41", Label: "positive"}},
+	})
+	data.Expectations.AddDigests(map[string]types.TestClassification{"test4": {"d41": types.POSITIVE}})
+	result, err = Import(store, data, "importer", false, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Replayed)
+	assert.Equal(t, 0, len(result.Added.Tests))
+	assert.Equal(t, 0, len(result.Removed))
+
+	log, err := FetchTriageLog(store)
+	assert.NoError(t, err)
+	found := 0
+	for _, entry := range log {
+		if entry.Name == "joe@example.com" {
+			assert.Equal(t, int64(4000), entry.TS)
+			found++
+		}
+	}
+	assert.Equal(t, 1, found)
+	exp, err = store.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, types.POSITIVE, exp.Classification("test4", "d41"))
+}
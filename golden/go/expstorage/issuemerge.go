@@ -0,0 +1,103 @@
+package expstorage
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/skia-dev/glog"
+	"go.skia.org/infra/go/metrics2"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/go/vcsinfo"
+)
+
+const (
+	// ISSUE_MERGE_LOOKBACK is how far back StartIssueMerger looks for landed
+	// issues when it starts, to catch issues that landed while it was not
+	// running.
+	ISSUE_MERGE_LOOKBACK = 14 * 24 * time.Hour
+)
+
+var (
+	// reviewURLRegex matches the footers that Rietveld ("Review-Url:" and
+	// the older "Review URL:") and Gerrit ("Reviewed-on:") add to the commit
+	// message when an issue lands. The first group is the issue id.
+	reviewURLRegex = regexp.MustCompile(`(?m)^(?:Review-Url|Review URL|Reviewed-on):[ \t]*https?://\S*/(\d+)[ \t]*\.?[ \t]*$`)
+)
+
+// IssueFromCommit returns the id of the code review issue that was landed by
+// the given commit, or false if the commit did not come from a code review.
+func IssueFromCommit(commit *vcsinfo.LongCommit) (string, bool) {
+	// Use the last match, in case the message quotes another commit.
+	matches := reviewURLRegex.FindAllStringSubmatch(commit.Body, -1)
+	if len(matches) == 0 {
+		return "", false
+	}
+	return matches[len(matches)-1][1], true
+}
+
+// MergeLandedIssues merges the expectations of the issues that were landed by
+// the given commits into the master expectations. Returns the ids of the
+// merged issues.
+func MergeLandedIssues(store IssueExpectationsStore, commits []*vcsinfo.LongCommit) ([]string, error) {
+	issues, err := store.Issues()
+	if err != nil {
+		return nil, err
+	}
+	pending := util.NewStringSet(issues)
+
+	merged := []string{}
+	for _, commit := range commits {
+		issueID, ok := IssueFromCommit(commit)
+		if !ok || !pending[issueID] {
+			continue
+		}
+		if err := store.Merge(issueID); err != nil {
+			return merged, err
+		}
+		glog.Infof("Merged expectations of issue %s landed in %s", issueID, commit.Hash)
+		delete(pending, issueID)
+		merged = append(merged, issueID)
+	}
+	return merged, nil
+}
+
+// StartIssueMerger periodically merges the expectations of issues that have
+// landed in the given repository into the master expectations. It does not
+// update the repository, this is assumed to happen elsewhere, e.g. in the
+// MasterTileBuilder that shares it.
+func StartIssueMerger(store IssueExpectationsStore, vcs vcsinfo.VCS, interval time.Duration) {
+	liveness := metrics2.NewLiveness("gold.issue-expectations-merger")
+	since := time.Now().Add(-ISSUE_MERGE_LOOKBACK)
+	oneStep := func() error {
+		commits := []*vcsinfo.LongCommit{}
+		for _, hash := range vcs.From(since) {
+			commit, err := vcs.Details(hash, false)
+			if err != nil {
+				return err
+			}
+			commits = append(commits, commit)
+		}
+		if _, err := MergeLandedIssues(store, commits); err != nil {
+			return err
+		}
+		// Look at the most recent commits again in the next step, in case
+		// more commits with the same timestamp arrive. This is harmless since
+		// merged issues are removed from the store.
+		if len(commits) > 0 {
+			since = commits[len(commits)-1].Timestamp.Add(-time.Minute)
+		}
+		return nil
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		for {
+			if err := oneStep(); err != nil {
+				glog.Errorf("Failed to merge expectations of landed issues: %s", err)
+			} else {
+				liveness.Reset()
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,182 @@
+package expstorage
+
+import (
+	"sort"
+	"sync"
+
+	"go.skia.org/infra/golden/go/types"
+)
+
+// IssueExpectationsStore stores expectations that were triaged in the context
+// of a code review issue (Rietveld or Gerrit). They overlay the expectations
+// of the master branch, but do not affect them until the issue lands and its
+// expectations are merged into the master ExpectationsStore.
+type IssueExpectationsStore interface {
+	// Get returns the master expectations overlaid with the expectations of
+	// the given issue.
+	Get(issueID string) (*Expectations, error)
+
+	// GetDelta returns only the expectations that were triaged for the given
+	// issue.
+	GetDelta(issueID string) (*Expectations, error)
+
+	// AddChange writes the given classified digests for the given issue and
+	// records the user that made the change.
+	AddChange(issueID string, changes map[string]types.TestClassification, userId string) error
+
+	// Issues returns the sorted ids of the issues that have expectations.
+	Issues() ([]string, error)
+
+	// Merge adds the expectations of the given issue to the master
+	// ExpectationsStore, attributed to the users who triaged them, and then
+	// removes them from this store.
+	Merge(issueID string) error
+
+	// Delete removes the expectations of the given issue without merging
+	// them, e.g. because the issue was abandoned.
+	Delete(issueID string) error
+}
+
+// issueChange is a single triaged digest of an issue.
+type issueChange struct {
+	label  types.Label
+	userId string
+}
+
+// overlay returns a copy of the master expectations with the delta applied.
+func overlay(master ExpectationsStore, delta *Expectations) (*Expectations, error) {
+	exp, err := master.Get()
+	if err != nil {
+		return nil, err
+	}
+	ret := exp.DeepCopy()
+	ret.AddDigests(delta.Tests)
+	return ret, nil
+}
+
+// mergeByUser adds the changes, keyed by user, to the master store. Changes
+// are added in the order of the user ids to make merges deterministic.
+func mergeByUser(master ExpectationsStore, byUser map[string]map[string]types.TestClassification) error {
+	users := make([]string, 0, len(byUser))
+	for userId := range byUser {
+		users = append(users, userId)
+	}
+	sort.Strings(users)
+	for _, userId := range users {
+		if err := master.AddChange(byUser[userId], userId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MemIssueExpectationsStore is an in-memory implementation of
+// IssueExpectationsStore for prototyping and testing.
+type MemIssueExpectationsStore struct {
+	master ExpectationsStore
+
+	// issues maps [issueID][testName][digest] to the triaged digest.
+	issues map[string]map[string]map[string]*issueChange
+
+	// Protects issues.
+	mutex sync.Mutex
+}
+
+// NewMemIssueExpectationsStore returns an in-memory IssueExpectationsStore
+// which overlays the given master store.
+func NewMemIssueExpectationsStore(master ExpectationsStore) IssueExpectationsStore {
+	return &MemIssueExpectationsStore{
+		master: master,
+		issues: map[string]map[string]map[string]*issueChange{},
+	}
+}
+
+// Get, see IssueExpectationsStore interface.
+func (m *MemIssueExpectationsStore) Get(issueID string) (*Expectations, error) {
+	delta, err := m.GetDelta(issueID)
+	if err != nil {
+		return nil, err
+	}
+	return overlay(m.master, delta)
+}
+
+// GetDelta, see IssueExpectationsStore interface.
+func (m *MemIssueExpectationsStore) GetDelta(issueID string) (*Expectations, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ret := NewExpectations()
+	for testName, digests := range m.issues[issueID] {
+		ret.Tests[testName] = make(types.TestClassification, len(digests))
+		for digest, change := range digests {
+			ret.Tests[testName][digest] = change.label
+		}
+	}
+	return ret, nil
+}
+
+// AddChange, see IssueExpectationsStore interface.
+func (m *MemIssueExpectationsStore) AddChange(issueID string, changedTests map[string]types.TestClassification, userId string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	issue, ok := m.issues[issueID]
+	if !ok {
+		issue = map[string]map[string]*issueChange{}
+		m.issues[issueID] = issue
+	}
+	for testName, digests := range changedTests {
+		if _, ok := issue[testName]; !ok {
+			issue[testName] = map[string]*issueChange{}
+		}
+		for digest, label := range digests {
+			issue[testName][digest] = &issueChange{label: label, userId: userId}
+		}
+	}
+	return nil
+}
+
+// Issues, see IssueExpectationsStore interface.
+func (m *MemIssueExpectationsStore) Issues() ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ret := make([]string, 0, len(m.issues))
+	for issueID := range m.issues {
+		ret = append(ret, issueID)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// Merge, see IssueExpectationsStore interface.
+func (m *MemIssueExpectationsStore) Merge(issueID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	byUser := map[string]map[string]types.TestClassification{}
+	for testName, digests := range m.issues[issueID] {
+		for digest, change := range digests {
+			if _, ok := byUser[change.userId]; !ok {
+				byUser[change.userId] = map[string]types.TestClassification{}
+			}
+			if _, ok := byUser[change.userId][testName]; !ok {
+				byUser[change.userId][testName] = types.TestClassification{}
+			}
+			byUser[change.userId][testName][digest] = change.label
+		}
+	}
+	if err := mergeByUser(m.master, byUser); err != nil {
+		return err
+	}
+	delete(m.issues, issueID)
+	return nil
+}
+
+// Delete, see IssueExpectationsStore interface.
+func (m *MemIssueExpectationsStore) Delete(issueID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.issues, issueID)
+	return nil
+}
@@ -0,0 +1,142 @@
+package expstorage
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/database/testutil"
+	"go.skia.org/infra/go/vcsinfo"
+	"go.skia.org/infra/golden/go/db"
+	"go.skia.org/infra/golden/go/types"
+)
+
+func TestMemIssueExpectationsStore(t *testing.T) {
+	master := NewMemExpectationsStore(nil)
+	testIssueExpectationsStore(t, NewMemIssueExpectationsStore(master), master)
+}
+
+func TestSQLIssueExpectationsStore(t *testing.T) {
+	// Set up the test database.
+	testDb := testutil.SetupMySQLTestDatabase(t, db.MigrationSteps())
+	defer testDb.Close(t)
+
+	conf := testutil.LocalTestDatabaseConfig(db.MigrationSteps())
+	vdb, err := conf.NewVersionedDB()
+	assert.NoError(t, err)
+
+	master := NewSQLExpectationStore(vdb)
+	testIssueExpectationsStore(t, NewSQLIssueExpectationsStore(vdb, master), master)
+}
+
+func testIssueExpectationsStore(t *testing.T, store IssueExpectationsStore, master ExpectationsStore) {
+	assert.NoError(t, master.AddChange(map[string]types.TestClassification{
+		"test1": {"d11": types.POSITIVE, "d12": types.NEGATIVE},
+	}, "jon@example.com"))
+
+	// Triage in two issues.
+	assert.NoError(t, store.AddChange("1234", map[string]types.TestClassification{
+		"test1": {"d12": types.POSITIVE, "d13": types.POSITIVE},
+	}, "alice@example.com"))
+	assert.NoError(t, store.AddChange("1234", map[string]types.TestClassification{
+		"test2": {"d21": types.NEGATIVE},
+	}, "bob@example.com"))
+	assert.NoError(t, store.AddChange("5678", map[string]types.TestClassification{
+		"test1": {"d11": types.NEGATIVE},
+	}, "bob@example.com"))
+
+	issues, err := store.Issues()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1234", "5678"}, issues)
+
+	delta, err := store.GetDelta("1234")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]types.TestClassification{
+		"test1": {"d12": types.POSITIVE, "d13": types.POSITIVE},
+		"test2": {"d21": types.NEGATIVE},
+	}, delta.Tests)
+
+	// The issue overlays master without changing it.
+	exp, err := store.Get("1234")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]types.TestClassification{
+		"test1": {"d11": types.POSITIVE, "d12": types.POSITIVE, "d13": types.POSITIVE},
+		"test2": {"d21": types.NEGATIVE},
+	}, exp.Tests)
+	masterExp, err := master.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]types.TestClassification{
+		"test1": {"d11": types.POSITIVE, "d12": types.NEGATIVE},
+	}, masterExp.Tests)
+
+	// An unknown issue only has the master expectations.
+	exp, err = store.Get("9999")
+	assert.NoError(t, err)
+	assert.Equal(t, masterExp.Tests, exp.Tests)
+
+	// Merging applies the issue to master and removes it.
+	assert.NoError(t, store.Merge("1234"))
+	masterExp, err = master.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]types.TestClassification{
+		"test1": {"d11": types.POSITIVE, "d12": types.POSITIVE, "d13": types.POSITIVE},
+		"test2": {"d21": types.NEGATIVE},
+	}, masterExp.Tests)
+	issues, err = store.Issues()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"5678"}, issues)
+
+	// Deleting discards the issue.
+	assert.NoError(t, store.Delete("5678"))
+	issues, err = store.Issues()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, issues)
+	masterExp, err = master.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, types.POSITIVE, masterExp.Classification("test1", "d11"))
+}
+
+func TestIssueFromCommit(t *testing.T) {
+	testCases := []struct {
+		body    string
+		issueID string
+		ok      bool
+	}{
+		{"Fix things.\n\nBUG=skia:123\n\nReview-Url: https://codereview.chromium.org/2340123002\n", "2340123002", true},
+		{"Fix things.\n\nReview URL: https://codereview.chromium.org/1234 .\n", "1234", true},
+		{"Fix things.\n\nChange-Id: I123\nReviewed-on: https://skia-review.googlesource.com/5678\nCommit-Queue: a@example.com\n", "5678", true},
+		{"Revert \"Fix things.\"\n\nReview-Url: https://codereview.chromium.org/1111\n\nReview-Url: https://codereview.chromium.org/2222\n", "2222", true},
+		{"Manual commit.\n\nSee https://codereview.chromium.org/1234 for details.\n", "", false},
+	}
+	for _, tc := range testCases {
+		issueID, ok := IssueFromCommit(&vcsinfo.LongCommit{Body: tc.body})
+		assert.Equal(t, tc.ok, ok, tc.body)
+		assert.Equal(t, tc.issueID, issueID, tc.body)
+	}
+}
+
+func TestMergeLandedIssues(t *testing.T) {
+	master := NewMemExpectationsStore(nil)
+	store := NewMemIssueExpectationsStore(master)
+	assert.NoError(t, store.AddChange("1234", map[string]types.TestClassification{
+		"test1": {"d11": types.POSITIVE},
+	}, "alice@example.com"))
+	assert.NoError(t, store.AddChange("5678", map[string]types.TestClassification{
+		"test1": {"d12": types.POSITIVE},
+	}, "alice@example.com"))
+
+	commits := []*vcsinfo.LongCommit{
+		{ShortCommit: &vcsinfo.ShortCommit{Hash: "aaa"}, Body: "Review-Url: https://codereview.chromium.org/1111"},
+		{ShortCommit: &vcsinfo.ShortCommit{Hash: "bbb"}, Body: "Reviewed-on: https://skia-review.googlesource.com/1234"},
+		{ShortCommit: &vcsinfo.ShortCommit{Hash: "ccc"}, Body: "No review."},
+	}
+	merged, err := MergeLandedIssues(store, commits)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1234"}, merged)
+
+	exp, err := master.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]types.TestClassification{"test1": {"d11": types.POSITIVE}}, exp.Tests)
+	issues, err := store.Issues()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"5678"}, issues)
+}
@@ -0,0 +1,139 @@
+package expstorage
+
+import (
+	"go.skia.org/infra/go/database"
+	"go.skia.org/infra/go/timer"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/types"
+)
+
+// SQLIssueExpectationsStore stores the expectations of issues in an SQL
+// database. Only the latest label of each digest is kept per issue.
+type SQLIssueExpectationsStore struct {
+	vdb    *database.VersionedDB
+	master ExpectationsStore
+}
+
+// NewSQLIssueExpectationsStore returns an SQL based IssueExpectationsStore
+// which overlays the given master store.
+func NewSQLIssueExpectationsStore(vdb *database.VersionedDB, master ExpectationsStore) IssueExpectationsStore {
+	return &SQLIssueExpectationsStore{
+		vdb:    vdb,
+		master: master,
+	}
+}
+
+// Get, see IssueExpectationsStore interface.
+func (s *SQLIssueExpectationsStore) Get(issueID string) (*Expectations, error) {
+	delta, err := s.GetDelta(issueID)
+	if err != nil {
+		return nil, err
+	}
+	return overlay(s.master, delta)
+}
+
+// GetDelta, see IssueExpectationsStore interface.
+func (s *SQLIssueExpectationsStore) GetDelta(issueID string) (*Expectations, error) {
+	ret := NewExpectations()
+	err := s.forEachChange(issueID, func(testName, digest string, label types.Label, userId string) {
+		if _, ok := ret.Tests[testName]; !ok {
+			ret.Tests[testName] = types.TestClassification{}
+		}
+		ret.Tests[testName][digest] = label
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// forEachChange calls fn for every triaged digest of the given issue.
+func (s *SQLIssueExpectationsStore) forEachChange(issueID string, fn func(testName, digest string, label types.Label, userId string)) error {
+	const stmt = `SELECT name, digest, label, userid FROM exp_issue WHERE issue=?`
+	rows, err := s.vdb.DB.Query(stmt, issueID)
+	if err != nil {
+		return err
+	}
+	defer util.Close(rows)
+
+	var testName, digest, label, userId string
+	for rows.Next() {
+		if err := rows.Scan(&testName, &digest, &label, &userId); err != nil {
+			return err
+		}
+		fn(testName, digest, types.LabelFromString(label), userId)
+	}
+	return nil
+}
+
+// AddChange, see IssueExpectationsStore interface.
+func (s *SQLIssueExpectationsStore) AddChange(issueID string, changedTests map[string]types.TestClassification, userId string) (retErr error) {
+	defer timer.New("adding issue exp change").Stop()
+
+	const stmt = `INSERT INTO exp_issue (issue, name, digest, label, userid, ts)
+	              VALUES (?, ?, ?, ?, ?, ?)
+	              ON DUPLICATE KEY UPDATE label=VALUES(label), userid=VALUES(userid), ts=VALUES(ts)`
+
+	tx, err := s.vdb.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { retErr = database.CommitOrRollback(tx, retErr) }()
+
+	now := util.TimeStampMs()
+	for testName, digests := range changedTests {
+		for digest, label := range digests {
+			if _, err := tx.Exec(stmt, issueID, testName, digest, label.String(), userId, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Issues, see IssueExpectationsStore interface.
+func (s *SQLIssueExpectationsStore) Issues() ([]string, error) {
+	const stmt = `SELECT DISTINCT issue FROM exp_issue ORDER BY issue`
+	rows, err := s.vdb.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Close(rows)
+
+	ret := []string{}
+	for rows.Next() {
+		var issueID string
+		if err := rows.Scan(&issueID); err != nil {
+			return nil, err
+		}
+		ret = append(ret, issueID)
+	}
+	return ret, nil
+}
+
+// Merge, see IssueExpectationsStore interface.
+func (s *SQLIssueExpectationsStore) Merge(issueID string) error {
+	byUser := map[string]map[string]types.TestClassification{}
+	err := s.forEachChange(issueID, func(testName, digest string, label types.Label, userId string) {
+		if _, ok := byUser[userId]; !ok {
+			byUser[userId] = map[string]types.TestClassification{}
+		}
+		if _, ok := byUser[userId][testName]; !ok {
+			byUser[userId][testName] = types.TestClassification{}
+		}
+		byUser[userId][testName][digest] = label
+	})
+	if err != nil {
+		return err
+	}
+	if err := mergeByUser(s.master, byUser); err != nil {
+		return err
+	}
+	return s.Delete(issueID)
+}
+
+// Delete, see IssueExpectationsStore interface.
+func (s *SQLIssueExpectationsStore) Delete(issueID string) error {
+	_, err := s.vdb.DB.Exec(`DELETE FROM exp_issue WHERE issue=?`, issueID)
+	return err
+}
@@ -26,6 +26,7 @@ import (
 	"go.skia.org/infra/go/metrics2"
 	"go.skia.org/infra/go/util"
 	"go.skia.org/infra/golden/go/diff"
+	"go.skia.org/infra/golden/go/diffstore"
 	storage "google.golang.org/api/storage/v1"
 )
 
@@ -831,6 +832,16 @@ func (fs *FileDiffStore) diff(d1, d2 string) (*diff.DiffMetrics, error) {
 	}
 	dm, resultImg := diff.Diff(img1, img2)
 
+	// Calculate the registered diff metrics.
+	dr := &diffstore.DiffRecord{
+		NumDiffPixels:    dm.NumDiffPixels,
+		PixelDiffPercent: dm.PixelDiffPercent,
+		MaxRGBADiffs:     dm.MaxRGBADiffs,
+		DimDiffer:        dm.DimDiffer,
+	}
+	dr.CalcMetrics(diffstore.GetDiffMetricIDs(), diff.GetNRGBA(img1), diff.GetNRGBA(img2))
+	dm.Diffs = dr.Diffs
+
 	baseName := getDiffBasename(d1, d2)
 
 	// Write the diff image to a temporary file.
@@ -16,6 +16,7 @@ import (
 	"go.skia.org/infra/go/testutils"
 	"go.skia.org/infra/go/util"
 	"go.skia.org/infra/golden/go/diff"
+	"go.skia.org/infra/golden/go/diffstore"
 )
 
 const (
@@ -173,10 +174,24 @@ func TestDiff(t *testing.T) {
 	if _, err := os.Stat(diffFilePath); err != nil {
 		t.Errorf("Diff file %s was not created!", diffFilePath)
 	}
+	// Assert that the registered diff metrics were calculated.
+	assert.Equal(t, len(diffstore.GetDiffMetricIDs()), len(diffMetrics.Diffs))
+	assert.InDelta(t, diffMetrics.PixelDiffPercent, diffMetrics.Diffs[diffstore.METRIC_PERCENT], 0.000001)
+	diffMetrics.Diffs = nil
 	// Assert that the DiffMetrics are as expected.
 	assert.Equal(t, relExpectedDiffMetrics1_2, diffMetrics)
 }
 
+// assertDiffMetrics compares DiffMetrics without the registered diff metrics,
+// which are only present if the metrics were calculated rather than loaded
+// from the testdata.
+func assertDiffMetrics(t *testing.T, expected, actual *diff.DiffMetrics) {
+	assert.NotNil(t, actual)
+	cp := *actual
+	cp.Diffs = nil
+	assert.Equal(t, expected, &cp)
+}
+
 func assertFileExists(filePath string, t *testing.T) {
 	if _, err := os.Stat(filePath); err != nil {
 		_, _, line, _ := runtime.Caller(1)
@@ -284,7 +299,7 @@ func TestGet_e2e(t *testing.T) {
 		t.Error("Unexpected error: ", err)
 	}
 	assert.Equal(t, 1, len(diffMetricsMap1))
-	assert.Equal(t, expectedDiffMetrics1_2, diffMetricsMap1[TEST_DIGEST2])
+	assertDiffMetrics(t, expectedDiffMetrics1_2, diffMetricsMap1[TEST_DIGEST2])
 	assert.Equal(t, int64(0), fdsEmpty.downloadSuccessCount.Get())
 	assert.Equal(t, int64(0), fdsEmpty.downloadFailureCount.Get())
 
@@ -306,7 +321,7 @@ func TestGet_e2e(t *testing.T) {
 	assertFileExists(diffFilePath, t)
 	assertFileExists(diffMetricsFilePath, t)
 	assert.Equal(t, 1, len(diffMetricsMap2))
-	assert.Equal(t, expectedDiffMetrics1_2, diffMetricsMap2[TEST_DIGEST2])
+	assertDiffMetrics(t, expectedDiffMetrics1_2, diffMetricsMap2[TEST_DIGEST2])
 	assert.Equal(t, int64(0), fds2.downloadSuccessCount.Get())
 	assert.Equal(t, int64(0), fds2.downloadFailureCount.Get())
 
@@ -331,7 +346,7 @@ func TestGet_e2e(t *testing.T) {
 	assertFileExists(diffFilePath, t)
 	assertFileExists(diffMetricsFilePath, t)
 	assert.Equal(t, 1, len(diffMetricsMap3))
-	assert.Equal(t, expectedDiffMetrics1_3, diffMetricsMap3[TEST_DIGEST3])
+	assertDiffMetrics(t, expectedDiffMetrics1_3, diffMetricsMap3[TEST_DIGEST3])
 	assert.Equal(t, int64(1), fds3.downloadSuccessCount.Get())
 	assert.Equal(t, int64(1), fds3.downloadFailureCount.Get())
 
@@ -353,8 +368,8 @@ func TestGet_e2e(t *testing.T) {
 	assertFileExists(diffFilePath, t)
 	assertFileExists(diffMetricsFilePath, t)
 	assert.Equal(t, 2, len(diffMetricsMap5))
-	assert.Equal(t, expectedDiffMetrics1_2, diffMetricsMap5[TEST_DIGEST2])
-	assert.Equal(t, expectedDiffMetrics1_3, diffMetricsMap5[TEST_DIGEST3])
+	assertDiffMetrics(t, expectedDiffMetrics1_2, diffMetricsMap5[TEST_DIGEST2])
+	assertDiffMetrics(t, expectedDiffMetrics1_3, diffMetricsMap5[TEST_DIGEST3])
 	assert.Equal(t, int64(1), fds5.downloadFailureCount.Get())
 
 	// diffFilePath, diffMetricsFilePath, and newImageFilePath will be removed
@@ -371,7 +386,7 @@ func TestReuseSameInstance(t *testing.T) {
 		t.Error("Unexpected error: ", err)
 	}
 	assert.Equal(t, 1, len(diffMetricsMap1))
-	assert.Equal(t, expectedDiffMetrics1_2, diffMetricsMap1[TEST_DIGEST2])
+	assertDiffMetrics(t, expectedDiffMetrics1_2, diffMetricsMap1[TEST_DIGEST2])
 	assert.Equal(t, int64(0), fds.downloadSuccessCount.Get())
 	assert.Equal(t, int64(0), fds.downloadFailureCount.Get())
 
@@ -0,0 +1,198 @@
+// flaky identifies traces that cycle among several digests. Those traces are
+// typically caused by non-deterministic tests and flood the list of untriaged
+// digests.
+package flaky
+
+import (
+	"sort"
+
+	"go.skia.org/infra/go/tiling"
+	"go.skia.org/infra/go/timer"
+	"go.skia.org/infra/golden/go/types"
+)
+
+const (
+	// DEFAULT_WINDOW is the default number of commits, counted from the last
+	// commit in the tile, that are considered to calculate flakiness.
+	DEFAULT_WINDOW = 20
+
+	// DEFAULT_GROUP_THRESHOLD is the minimum score a trace has to have for its
+	// digests to be grouped together for triaging.
+	DEFAULT_GROUP_THRESHOLD = 0.3
+)
+
+// TraceScore captures how flaky a single trace is.
+type TraceScore struct {
+	// Distinct is the number of distinct digests in the window.
+	Distinct int `json:"distinct"`
+
+	// Transitions is the number of times consecutive (non-missing) values
+	// in the window differ.
+	Transitions int `json:"transitions"`
+
+	// Score is the fraction of consecutive values that differ, i.e. it is in
+	// [0, 1]. A trace that changed once because of a legitimate change has a
+	// score close to zero, a trace that alternates between digests with every
+	// commit has a score of 1.
+	Score float64 `json:"score"`
+
+	// Digests contains the distinct digests in the window, sorted.
+	Digests []string `json:"digests"`
+}
+
+// Flaky calculates the flakiness of all traces in a tile. It should be
+// considered immutable once Calculate has been called.
+type Flaky struct {
+	window int
+
+	// byTrace maps trace ids to their scores.
+	byTrace map[string]*TraceScore
+
+	// byDigest maps [testName][digest] to the highest score of all traces
+	// that contain the digest within the window.
+	byDigest map[string]map[string]float64
+
+	// tracesByDigest maps [testName][digest] to the ids of the traces that
+	// contain the digest within the window.
+	tracesByDigest map[string]map[string][]string
+}
+
+// New creates a new instance of Flaky that considers the last 'window'
+// commits of a tile.
+func New(window int) *Flaky {
+	if window <= 1 {
+		window = DEFAULT_WINDOW
+	}
+	return &Flaky{
+		window:         window,
+		byTrace:        map[string]*TraceScore{},
+		byDigest:       map[string]map[string]float64{},
+		tracesByDigest: map[string]map[string][]string{},
+	}
+}
+
+// Calculate computes the flakiness scores for the given tile.
+func (f *Flaky) Calculate(tile *tiling.Tile) {
+	defer timer.New("flaky").Stop()
+
+	last := tile.LastCommitIndex()
+	first := last - f.window + 1
+	if first < 0 {
+		first = 0
+	}
+
+	byTrace := make(map[string]*TraceScore, len(tile.Traces))
+	byDigest := map[string]map[string]float64{}
+	tracesByDigest := map[string]map[string][]string{}
+	for id, tr := range tile.Traces {
+		gTrace := tr.(*types.GoldenTrace)
+		score := scoreTrace(gTrace.Values, first, last)
+		byTrace[id] = score
+
+		testName := gTrace.Params_[types.PRIMARY_KEY_FIELD]
+		if _, ok := byDigest[testName]; !ok {
+			byDigest[testName] = map[string]float64{}
+			tracesByDigest[testName] = map[string][]string{}
+		}
+		for _, digest := range score.Digests {
+			if s, ok := byDigest[testName][digest]; !ok || (score.Score > s) {
+				byDigest[testName][digest] = score.Score
+			}
+			tracesByDigest[testName][digest] = append(tracesByDigest[testName][digest], id)
+		}
+	}
+
+	f.byTrace = byTrace
+	f.byDigest = byDigest
+	f.tracesByDigest = tracesByDigest
+}
+
+// ByTrace returns the scores of all traces indexed by trace id.
+func (f *Flaky) ByTrace() map[string]*TraceScore {
+	return f.byTrace
+}
+
+// DigestScore returns the highest score of all traces of the given test
+// that contain the digest. It returns 0 for unknown digests.
+func (f *Flaky) DigestScore(testName, digest string) float64 {
+	return f.byDigest[testName][digest]
+}
+
+// Group returns the digests of all traces of the given test that contain
+// 'digest' and have a score of at least minScore. These are the digests a
+// flaky test produces interchangeably. The result is sorted and includes
+// 'digest' itself. If no trace is flaky enough only 'digest' is returned.
+func (f *Flaky) Group(testName, digest string, minScore float64) []string {
+	group := map[string]bool{digest: true}
+	for _, id := range f.tracesByDigest[testName][digest] {
+		if score := f.byTrace[id]; score.Distinct > 1 && score.Score >= minScore {
+			for _, d := range score.Digests {
+				group[d] = true
+			}
+		}
+	}
+
+	ret := make([]string, 0, len(group))
+	for d := range group {
+		ret = append(ret, d)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// GroupDigests returns a copy of tc where every digest is accompanied by the
+// digests that 'group' returns for it, see Flaky.Group. Only grouped digests
+// that 'classify' reports as untriaged are added, so digests that have
+// already been triaged keep their label. Digests that are explicitly listed
+// in tc keep the label they were given.
+func GroupDigests(tc map[string]types.TestClassification, group func(test, digest string) []string, classify func(test, digest string) types.Label) map[string]types.TestClassification {
+	ret := make(map[string]types.TestClassification, len(tc))
+	for test, digests := range tc {
+		grouped := types.TestClassification{}
+		for digest, label := range digests {
+			for _, d := range group(test, digest) {
+				if classify(test, d) == types.UNTRIAGED {
+					grouped[d] = label
+				}
+			}
+		}
+		for digest, label := range digests {
+			grouped[digest] = label
+		}
+		ret[test] = grouped
+	}
+	return ret
+}
+
+// scoreTrace calculates the score of the values in the range [first, last].
+func scoreTrace(values []string, first, last int) *TraceScore {
+	digests := map[string]bool{}
+	prev := ""
+	n := 0
+	transitions := 0
+	for i := first; i <= last && i < len(values); i++ {
+		if values[i] == types.MISSING_DIGEST {
+			continue
+		}
+		if (n > 0) && (values[i] != prev) {
+			transitions++
+		}
+		digests[values[i]] = true
+		prev = values[i]
+		n++
+	}
+
+	ret := &TraceScore{
+		Distinct:    len(digests),
+		Transitions: transitions,
+		Digests:     make([]string, 0, len(digests)),
+	}
+	if n > 1 {
+		ret.Score = float64(transitions) / float64(n-1)
+	}
+	for d := range digests {
+		ret.Digests = append(ret.Digests, d)
+	}
+	sort.Strings(ret.Digests)
+	return ret
+}
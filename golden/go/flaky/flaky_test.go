@@ -0,0 +1,87 @@
+package flaky
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/tiling"
+	"go.skia.org/infra/golden/go/types"
+)
+
+func TestFlaky(t *testing.T) {
+	tile := tiling.NewTile()
+	tile.Commits = make([]*tiling.Commit, 6)
+	for i := range tile.Commits {
+		tile.Commits[i] = &tiling.Commit{CommitTime: int64(i + 1), Hash: "h", Author: "a"}
+	}
+
+	addTrace := func(id, testName string, values ...string) {
+		trace := types.NewGoldenTrace()
+		copy(trace.Values, values)
+		trace.Params_[types.PRIMARY_KEY_FIELD] = testName
+		tile.Traces[id] = trace
+	}
+
+	// Stable trace.
+	addTrace("stable", "foo", "aaa", "aaa", "aaa", "aaa", "aaa", "aaa")
+	// A single legitimate change.
+	addTrace("changed", "foo", "aaa", "aaa", "aaa", "bbb", "bbb", "bbb")
+	// A trace that alternates between three digests, with missing values.
+	addTrace("flaky", "bar", "ccc", "ddd", types.MISSING_DIGEST, "ccc", "eee", "ddd")
+	// Only the last 4 commits count, in which this trace is stable.
+	addTrace("old", "baz", "fff", "ggg", "hhh", "hhh", "hhh", "hhh")
+
+	f := New(4)
+	f.Calculate(tile)
+	scores := f.ByTrace()
+	assert.Equal(t, 4, len(scores))
+
+	assert.Equal(t, &TraceScore{Distinct: 1, Transitions: 0, Score: 0, Digests: []string{"aaa"}}, scores["stable"])
+	assert.Equal(t, 2, scores["changed"].Distinct)
+	assert.Equal(t, 1, scores["changed"].Transitions)
+	assert.InDelta(t, 1.0/3.0, scores["changed"].Score, 0.0001)
+	assert.Equal(t, &TraceScore{Distinct: 3, Transitions: 2, Score: 1, Digests: []string{"ccc", "ddd", "eee"}}, scores["flaky"])
+	assert.Equal(t, &TraceScore{Distinct: 1, Transitions: 0, Score: 0, Digests: []string{"hhh"}}, scores["old"])
+
+	// Digests take the highest score of their traces.
+	assert.InDelta(t, 1.0/3.0, f.DigestScore("foo", "aaa"), 0.0001)
+	assert.InDelta(t, 1.0/3.0, f.DigestScore("foo", "bbb"), 0.0001)
+	assert.Equal(t, 1.0, f.DigestScore("bar", "eee"))
+	assert.Equal(t, 0.0, f.DigestScore("baz", "fff"))
+	assert.Equal(t, 0.0, f.DigestScore("unknown", "aaa"))
+
+	assert.Equal(t, []string{"ccc", "ddd", "eee"}, f.Group("bar", "ddd", DEFAULT_GROUP_THRESHOLD))
+	assert.Equal(t, []string{"aaa", "bbb"}, f.Group("foo", "aaa", DEFAULT_GROUP_THRESHOLD))
+	assert.Equal(t, []string{"aaa"}, f.Group("foo", "aaa", 0.5))
+	assert.Equal(t, []string{"xxx"}, f.Group("foo", "xxx", DEFAULT_GROUP_THRESHOLD))
+}
+
+func TestGroupDigests(t *testing.T) {
+	groups := map[string][]string{
+		"aaa": []string{"aaa", "bbb", "ccc"},
+		"ddd": []string{"ddd"},
+	}
+	group := func(test, digest string) []string {
+		return groups[digest]
+	}
+	// "bbb" has already been triaged as negative.
+	classify := func(test, digest string) types.Label {
+		if digest == "bbb" {
+			return types.NEGATIVE
+		}
+		return types.UNTRIAGED
+	}
+
+	tc := map[string]types.TestClassification{
+		"foo": types.TestClassification{"aaa": types.POSITIVE},
+		"bar": types.TestClassification{"ddd": types.NEGATIVE},
+	}
+	assert.Equal(t, map[string]types.TestClassification{
+		"foo": types.TestClassification{"aaa": types.POSITIVE, "ccc": types.POSITIVE},
+		"bar": types.TestClassification{"ddd": types.NEGATIVE},
+	}, GroupDigests(tc, group, classify))
+
+	// Explicitly listed digests keep their label, even if triaged.
+	tc["foo"]["bbb"] = types.POSITIVE
+	assert.Equal(t, types.TestClassification{"aaa": types.POSITIVE, "bbb": types.POSITIVE, "ccc": types.POSITIVE}, GroupDigests(tc, group, classify)["foo"])
+}
@@ -0,0 +1,216 @@
+package ignore
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+
+	"go.skia.org/infra/golden/go/diffstore"
+)
+
+const (
+	// AUTO_TRIAGE_USER_PREFIX is the prefix of the user ID that is recorded
+	// in the triage log when a digest is triaged by a FuzzyRule.
+	AUTO_TRIAGE_USER_PREFIX = "auto-triage:fuzzy-rule-"
+)
+
+// FuzzyRuleMatcher returns a list of rules in the FuzzyRuleStore that match
+// the given set of parameters.
+type FuzzyRuleMatcher func(map[string]string) ([]*FuzzyRule, bool)
+
+// FuzzyRuleStore stores and matches fuzzy triage rules.
+type FuzzyRuleStore interface {
+	// Create adds a new rule to the store.
+	Create(*FuzzyRule) error
+
+	// List returns all rules in the store, sorted by ID.
+	List() ([]*FuzzyRule, error)
+
+	// Update updates a FuzzyRule.
+	Update(id int, rule *FuzzyRule) error
+
+	// Delete removes a FuzzyRule from the store.
+	Delete(id int, userId string) (int, error)
+
+	// BuildFuzzyRuleMatcher returns a FuzzyRuleMatcher based on the current
+	// content of the store.
+	BuildFuzzyRuleMatcher() (FuzzyRuleMatcher, error)
+}
+
+// FuzzyRule is the GUI struct for dealing with fuzzy triage rules. An
+// untriaged digest of a trace that matches Query is automatically triaged as
+// positive if it differs from an existing positive digest of the same test in
+// at most MaxDiffPixels pixels, and no channel of any pixel differs by more
+// than MaxRGBADelta.
+type FuzzyRule struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	UpdatedBy     string `json:"updatedBy"`
+	Query         string `json:"query"`
+	MaxDiffPixels int    `json:"maxDiffPixels"`
+	MaxRGBADelta  int    `json:"maxRGBADelta"`
+	Note          string `json:"note"`
+}
+
+func NewFuzzyRule(name, queryStr string, maxDiffPixels, maxRGBADelta int, note string) *FuzzyRule {
+	return &FuzzyRule{
+		Name:          name,
+		UpdatedBy:     name,
+		Query:         queryStr,
+		MaxDiffPixels: maxDiffPixels,
+		MaxRGBADelta:  maxRGBADelta,
+		Note:          note,
+	}
+}
+
+// Validate returns an error if the rule is not well formed.
+func (r *FuzzyRule) Validate() error {
+	if r.Query == "" {
+		return fmt.Errorf("Fuzzy rules must have a query.")
+	}
+	if _, err := url.ParseQuery(r.Query); err != nil {
+		return fmt.Errorf("Invalid query %q: %s", r.Query, err)
+	}
+	if r.MaxDiffPixels < 0 {
+		return fmt.Errorf("MaxDiffPixels must not be negative; got %d", r.MaxDiffPixels)
+	}
+	if r.MaxRGBADelta < 0 || r.MaxRGBADelta > 255 {
+		return fmt.Errorf("MaxRGBADelta must be in [0, 255]; got %d", r.MaxRGBADelta)
+	}
+	return nil
+}
+
+// Matches returns true if the given diff between an untriaged and a positive
+// digest is within the tolerances of the rule.
+func (r *FuzzyRule) Matches(dr *diffstore.DiffRecord) bool {
+	if dr == nil || dr.DimDiffer || dr.NumDiffPixels > r.MaxDiffPixels {
+		return false
+	}
+	for _, d := range dr.MaxRGBADiffs {
+		if d > r.MaxRGBADelta {
+			return false
+		}
+	}
+	return true
+}
+
+// AutoTriageUser returns the user ID under which the digests triaged by this
+// rule are recorded in the triage log.
+func (r *FuzzyRule) AutoTriageUser() string {
+	return fmt.Sprintf("%s%d", AUTO_TRIAGE_USER_PREFIX, r.ID)
+}
+
+// fuzzyRuleSlice implements sort.Interface to sort FuzzyRules by ID.
+type fuzzyRuleSlice []*FuzzyRule
+
+func (f fuzzyRuleSlice) Len() int           { return len(f) }
+func (f fuzzyRuleSlice) Less(i, j int) bool { return f[i].ID < f[j].ID }
+func (f fuzzyRuleSlice) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+
+// MemFuzzyRuleStore is an in-memory implementation of FuzzyRuleStore.
+type MemFuzzyRuleStore struct {
+	rules  []*FuzzyRule
+	mutex  sync.Mutex
+	nextId int
+}
+
+func NewMemFuzzyRuleStore() FuzzyRuleStore {
+	return &MemFuzzyRuleStore{
+		rules: []*FuzzyRule{},
+	}
+}
+
+// Create, see FuzzyRuleStore interface.
+func (m *MemFuzzyRuleStore) Create(rule *FuzzyRule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	rule.ID = m.nextId
+	m.nextId++
+	m.rules = append(m.rules, rule)
+	return nil
+}
+
+// List, see FuzzyRuleStore interface.
+func (m *MemFuzzyRuleStore) List() ([]*FuzzyRule, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	result := make([]*FuzzyRule, len(m.rules))
+	copy(result, m.rules)
+	return result, nil
+}
+
+// Update, see FuzzyRuleStore interface.
+func (m *MemFuzzyRuleStore) Update(id int, updated *FuzzyRule) error {
+	if err := updated.Validate(); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i, rule := range m.rules {
+		if rule.ID == id {
+			updated.ID = id
+			m.rules[i] = updated
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Did not find a FuzzyRule with id: %d", id)
+}
+
+// Delete, see FuzzyRuleStore interface.
+func (m *MemFuzzyRuleStore) Delete(id int, userId string) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for idx, rule := range m.rules {
+		if rule.ID == id {
+			m.rules = append(m.rules[:idx], m.rules[idx+1:]...)
+			return 1, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// BuildFuzzyRuleMatcher, see FuzzyRuleStore interface.
+func (m *MemFuzzyRuleStore) BuildFuzzyRuleMatcher() (FuzzyRuleMatcher, error) {
+	return buildFuzzyRuleMatcher(m)
+}
+
+func buildFuzzyRuleMatcher(store FuzzyRuleStore) (FuzzyRuleMatcher, error) {
+	rulesList, err := store.List()
+	if err != nil {
+		return noopFuzzyRuleMatcher, err
+	}
+	sort.Sort(fuzzyRuleSlice(rulesList))
+
+	queryRules := make([]QueryRule, len(rulesList))
+	for idx, rawRule := range rulesList {
+		parsedQuery, err := url.ParseQuery(rawRule.Query)
+		if err != nil {
+			return noopFuzzyRuleMatcher, err
+		}
+		queryRules[idx] = NewQueryRule(parsedQuery)
+	}
+
+	return func(params map[string]string) ([]*FuzzyRule, bool) {
+		result := []*FuzzyRule{}
+		for ruleIdx, rule := range queryRules {
+			if rule.IsMatch(params) {
+				result = append(result, rulesList[ruleIdx])
+			}
+		}
+		return result, len(result) > 0
+	}, nil
+}
+
+func noopFuzzyRuleMatcher(p map[string]string) ([]*FuzzyRule, bool) {
+	return nil, false
+}
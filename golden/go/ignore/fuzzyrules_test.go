@@ -0,0 +1,82 @@
+package ignore
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/golden/go/diffstore"
+)
+
+func TestMemFuzzyRuleStore(t *testing.T) {
+	testFuzzyRuleStore(t, NewMemFuzzyRuleStore())
+}
+
+func testFuzzyRuleStore(t *testing.T, store FuzzyRuleStore) {
+	r1 := NewFuzzyRule("jon@example.com", "config=gpu", 10, 5, "anti-aliasing")
+	r2 := NewFuzzyRule("jim@example.com", "config=gpu&source_type=gm", 100, 2, "gradients")
+	assert.NoError(t, store.Create(r1))
+	assert.NoError(t, store.Create(r2))
+
+	// Invalid rules are rejected.
+	assert.Error(t, store.Create(NewFuzzyRule("jon@example.com", "", 10, 5, "")))
+	assert.Error(t, store.Create(NewFuzzyRule("jon@example.com", "bad=%", 10, 5, "")))
+	assert.Error(t, store.Create(NewFuzzyRule("jon@example.com", "config=gpu", -1, 5, "")))
+	assert.Error(t, store.Create(NewFuzzyRule("jon@example.com", "config=gpu", 10, 256, "")))
+
+	allRules, err := store.List()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(allRules))
+	assert.Equal(t, r1.ID, allRules[0].ID)
+	assert.Equal(t, 10, allRules[0].MaxDiffPixels)
+	assert.Equal(t, 5, allRules[0].MaxRGBADelta)
+
+	// Test the rule matcher.
+	matcher, err := store.BuildFuzzyRuleMatcher()
+	assert.NoError(t, err)
+	found, ok := matcher(map[string]string{"config": "565"})
+	assert.False(t, ok)
+	assert.Equal(t, []*FuzzyRule{}, found)
+	found, ok = matcher(map[string]string{"config": "gpu"})
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(found))
+	found, ok = matcher(map[string]string{"config": "gpu", "source_type": "gm"})
+	assert.True(t, ok)
+	assert.Equal(t, 2, len(found))
+	assert.Equal(t, r1.ID, found[0].ID)
+
+	// Update a rule.
+	updated := *allRules[1]
+	updated.MaxRGBADelta = 3
+	updated.UpdatedBy = "jane@example.com"
+	assert.NoError(t, store.Update(updated.ID, &updated))
+	allRules, err = store.List()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, allRules[1].MaxRGBADelta)
+	assert.Equal(t, "jane@example.com", allRules[1].UpdatedBy)
+	assert.Error(t, store.Update(100001, &updated))
+
+	// Delete the rules.
+	n, err := store.Delete(r1.ID, "jon@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = store.Delete(r1.ID, "jon@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	allRules, err = store.List()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(allRules))
+	assert.Equal(t, r2.ID, allRules[0].ID)
+}
+
+func TestFuzzyRuleMatches(t *testing.T) {
+	r := NewFuzzyRule("jon@example.com", "config=gpu", 10, 5, "")
+	assert.True(t, r.Matches(&diffstore.DiffRecord{NumDiffPixels: 10, MaxRGBADiffs: []int{5, 0, 3, 0}}))
+	assert.True(t, r.Matches(&diffstore.DiffRecord{NumDiffPixels: 0, MaxRGBADiffs: []int{0, 0, 0, 0}}))
+	assert.False(t, r.Matches(&diffstore.DiffRecord{NumDiffPixels: 11, MaxRGBADiffs: []int{1, 1, 1, 1}}))
+	assert.False(t, r.Matches(&diffstore.DiffRecord{NumDiffPixels: 1, MaxRGBADiffs: []int{0, 6, 0, 0}}))
+	assert.False(t, r.Matches(&diffstore.DiffRecord{NumDiffPixels: 1, MaxRGBADiffs: []int{0, 0, 0, 0}, DimDiffer: true}))
+	assert.False(t, r.Matches(nil))
+
+	r.ID = 7
+	assert.Equal(t, "auto-triage:fuzzy-rule-7", r.AutoTriageUser())
+}
@@ -36,18 +36,46 @@ type IgnoreStore interface {
 	// BuildRuleMatcher returns a RuleMatcher based on the current content
 	// of the ignore store.
 	BuildRuleMatcher() (RuleMatcher, error)
+
+	// Get returns the IgnoreRule with the given id or nil if it does not exist.
+	Get(id int) (*IgnoreRule, error)
+
+	// Extend sets the expiration time of the given rule to 'expires'. It is
+	// used to extend a rule before it runs out or to renew an expired rule.
+	Extend(id int, expires time.Time, userId string) error
+
+	// History returns the audit trail of the given rule, oldest change first.
+	History(id int) ([]*IgnoreRuleChange, error)
+
+	// SetExpiryWarned records that the owner of the given rule has been
+	// warned that the rule expires at 'expires'.
+	SetExpiryWarned(id int, expires time.Time) error
 }
 
+// Actions recorded in the history of an ignore rule.
+const (
+	ACTION_CREATE = "create"
+	ACTION_UPDATE = "update"
+	ACTION_EXTEND = "extend"
+	ACTION_DELETE = "delete"
+	ACTION_EXPIRE = "expire"
+)
+
 // IgnoreRule is the GUI struct for dealing with Ignore rules.
 type IgnoreRule struct {
 	ID             int       `json:"id"`
 	Name           string    `json:"name"`
 	UpdatedBy      string    `json:"updatedBy"`
+	Owner          string    `json:"owner"`
 	Expires        time.Time `json:"expires"`
 	Query          string    `json:"query"`
 	Note           string    `json:"note"`
 	Count          int       `json:"count"`
 	ExclusiveCount int       `json:"exclusiveCount"`
+
+	// ExpiryWarned is the expiration time the owner has been warned about,
+	// or the zero time if no warning has been sent.
+	ExpiryWarned time.Time `json:"-"`
 }
 
 // ToQuery makes a slice of url.Values from the given slice of IngoreRules.
@@ -63,10 +91,38 @@ func ToQuery(ignores []*IgnoreRule) ([]url.Values, error) {
 	return ret, nil
 }
 
+// IgnoreRuleChange is one entry in the audit history of an ignore rule. It
+// captures the state of the rule after the change was applied, or right
+// before it was deleted.
+type IgnoreRuleChange struct {
+	RuleID  int       `json:"ruleID"`
+	Action  string    `json:"action"`
+	UserID  string    `json:"userID"`
+	TS      time.Time `json:"ts"`
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+	Query   string    `json:"query"`
+	Note    string    `json:"note"`
+}
+
+func newIgnoreRuleChange(rule *IgnoreRule, action, userId string) *IgnoreRuleChange {
+	return &IgnoreRuleChange{
+		RuleID:  rule.ID,
+		Action:  action,
+		UserID:  userId,
+		TS:      time.Now(),
+		Owner:   rule.Owner,
+		Expires: rule.Expires,
+		Query:   rule.Query,
+		Note:    rule.Note,
+	}
+}
+
 func NewIgnoreRule(name string, expires time.Time, queryStr string, note string) *IgnoreRule {
 	return &IgnoreRule{
 		Name:      name,
 		UpdatedBy: name,
+		Owner:     name,
 		Expires:   expires,
 		Query:     queryStr,
 		Note:      note,
@@ -76,6 +132,7 @@ func NewIgnoreRule(name string, expires time.Time, queryStr string, note string)
 // MemIgnoreStore is an in-memory implementation of IgnoreStore.
 type MemIgnoreStore struct {
 	rules    []*IgnoreRule
+	history  []*IgnoreRuleChange
 	mutex    sync.Mutex
 	nextId   int
 	revision int64
@@ -99,6 +156,7 @@ func (m *MemIgnoreStore) Create(rule *IgnoreRule) error {
 	rule.ID = m.nextId
 	m.nextId++
 	m.rules = append(m.rules, rule)
+	m.history = append(m.history, newIgnoreRuleChange(rule, ACTION_CREATE, rule.Name))
 	m.inc()
 	return nil
 }
@@ -122,6 +180,7 @@ func (m *MemIgnoreStore) Update(id int, updated *IgnoreRule) error {
 	for i, _ := range m.rules {
 		if updated.ID == id {
 			m.rules[i] = updated
+			m.history = append(m.history, newIgnoreRuleChange(updated, ACTION_UPDATE, updated.UpdatedBy))
 			m.inc()
 			return nil
 		}
@@ -138,6 +197,7 @@ func (m *MemIgnoreStore) Delete(id int, userId string) (int, error) {
 	for idx, rule := range m.rules {
 		if rule.ID == id {
 			m.rules = append(m.rules[:idx], m.rules[idx+1:]...)
+			m.history = append(m.history, newIgnoreRuleChange(rule, ACTION_DELETE, userId))
 			m.inc()
 			return 1, nil
 		}
@@ -159,11 +219,75 @@ func (m *MemIgnoreStore) expire() {
 	for _, rule := range m.rules {
 		if rule.Expires.After(now) {
 			newrules = append(newrules, rule)
+		} else {
+			m.history = append(m.history, newIgnoreRuleChange(rule, ACTION_EXPIRE, ""))
 		}
 	}
 	m.rules = newrules
 }
 
+// Get, see IgnoreStore interface.
+func (m *MemIgnoreStore) Get(id int) (*IgnoreRule, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, rule := range m.rules {
+		if rule.ID == id {
+			return rule, nil
+		}
+	}
+	return nil, nil
+}
+
+// Extend, see IgnoreStore interface.
+func (m *MemIgnoreStore) Extend(id int, expires time.Time, userId string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i, rule := range m.rules {
+		if rule.ID == id {
+			extended := *rule
+			extended.Expires = expires
+			extended.UpdatedBy = userId
+			m.rules[i] = &extended
+			m.history = append(m.history, newIgnoreRuleChange(&extended, ACTION_EXTEND, userId))
+			m.inc()
+			return nil
+		}
+	}
+	return fmt.Errorf("Did not find an IgnoreRule with id: %d", id)
+}
+
+// SetExpiryWarned, see IgnoreStore interface.
+func (m *MemIgnoreStore) SetExpiryWarned(id int, expires time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i, rule := range m.rules {
+		if rule.ID == id {
+			warned := *rule
+			warned.ExpiryWarned = expires
+			m.rules[i] = &warned
+			return nil
+		}
+	}
+	return fmt.Errorf("Did not find an IgnoreRule with id: %d", id)
+}
+
+// History, see IgnoreStore interface.
+func (m *MemIgnoreStore) History(id int) ([]*IgnoreRuleChange, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ret := []*IgnoreRuleChange{}
+	for _, change := range m.history {
+		if change.RuleID == id {
+			ret = append(ret, change)
+		}
+	}
+	return ret, nil
+}
+
 // BuildRuleMatcher, see IgnoreStore interface.
 func (m *MemIgnoreStore) BuildRuleMatcher() (RuleMatcher, error) {
 	return buildRuleMatcher(m)
@@ -31,6 +31,14 @@ func testIgnoreStore(t *testing.T, store IgnoreStore) {
 	assert.Equal(t, 4, len(allRules))
 	assert.Equal(t, int64(4), store.Revision())
 
+	found1, err := store.Get(r1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "jon@example.com", found1.Owner)
+	assert.Equal(t, "config=gpu", found1.Query)
+	notFound, err := store.Get(100001)
+	assert.NoError(t, err)
+	assert.Nil(t, notFound)
+
 	// Test the rule matcher
 	matcher, err := store.BuildRuleMatcher()
 	assert.NoError(t, err)
@@ -108,6 +116,55 @@ func testIgnoreStore(t *testing.T, store IgnoreStore) {
 	assert.NoError(t, err)
 	assert.Equal(t, 0, len(allRules))
 	assert.Equal(t, int64(9), store.Revision())
+
+	// Extend a rule and check its history.
+	r5 := NewIgnoreRule("jon@example.com", time.Now().Add(time.Hour), "config=565", "To be extended.")
+	r5.Owner = "team@example.com"
+	assert.NoError(t, store.Create(r5))
+	newExpires := time.Now().Add(48 * time.Hour)
+	assert.NoError(t, store.Extend(r5.ID, newExpires, "jim@example.com"))
+	assert.Error(t, store.Extend(100001, newExpires, "jim@example.com"))
+	assert.Equal(t, int64(11), store.Revision())
+
+	found5, err := store.Get(r5.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, newExpires.Unix(), found5.Expires.Unix())
+	assert.Equal(t, "jim@example.com", found5.UpdatedBy)
+	assert.Equal(t, "team@example.com", found5.Owner)
+	assert.True(t, found5.ExpiryWarned.IsZero())
+
+	// Recording an expiration warning doesn't change the revision.
+	assert.NoError(t, store.SetExpiryWarned(r5.ID, newExpires))
+	assert.Error(t, store.SetExpiryWarned(100001, newExpires))
+	assert.Equal(t, int64(11), store.Revision())
+	found5, err = store.Get(r5.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, newExpires.Unix(), found5.ExpiryWarned.Unix())
+
+	delCount, err = store.Delete(r5.ID, "jane@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, delCount)
+
+	history, err := store.History(r5.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(history))
+	assert.Equal(t, ACTION_CREATE, history[0].Action)
+	assert.Equal(t, "jon@example.com", history[0].UserID)
+	assert.Equal(t, r5.Expires.Unix(), history[0].Expires.Unix())
+	assert.Equal(t, ACTION_EXTEND, history[1].Action)
+	assert.Equal(t, "jim@example.com", history[1].UserID)
+	assert.Equal(t, newExpires.Unix(), history[1].Expires.Unix())
+	assert.Equal(t, ACTION_DELETE, history[2].Action)
+	assert.Equal(t, "jane@example.com", history[2].UserID)
+	for _, change := range history {
+		assert.Equal(t, r5.ID, change.RuleID)
+		assert.Equal(t, "team@example.com", change.Owner)
+		assert.Equal(t, "config=565", change.Query)
+	}
+
+	history, err = store.History(100001)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(history))
 }
 
 func TestToQuery(t *testing.T) {
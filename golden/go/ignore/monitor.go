@@ -1,10 +1,13 @@
 package ignore
 
 import (
+	"bytes"
 	"fmt"
+	"html/template"
 	"time"
 
 	"go.skia.org/infra/go/metrics2"
+	"go.skia.org/infra/go/util"
 
 	"github.com/skia-dev/glog"
 )
@@ -48,3 +51,116 @@ func Init(store IgnoreStore) error {
 
 	return nil
 }
+
+const (
+	// EXPIRY_EMAIL_SENDER is the display name used for expiration warnings.
+	EXPIRY_EMAIL_SENDER = "Gold"
+)
+
+// Emailer sends email messages. It is implemented by email.GMail.
+type Emailer interface {
+	Send(senderDisplayName string, to []string, subject string, body string) error
+}
+
+var expiryEmailTemplate = template.Must(template.New("expiry").Parse(`
+<p>The following ignore rule on Gold expires on {{.Expires}}:</p>
+<pre>{{.Rule.Query}}</pre>
+<p>Note: {{.Rule.Note}}</p>
+<p>Once it expires the matching traces will no longer be ignored. If the rule
+is still needed please extend it at <a href="{{.URL}}">{{.URL}}</a>.</p>
+`))
+
+// ExpiryNotifier sends an email to the owner of an ignore rule when the rule
+// is about to expire. Each rule is only announced once per expiration time,
+// i.e. extending the rule will re-arm the notification. The expiration time
+// that was announced is recorded in the IgnoreStore, so restarts don't send
+// the same warning again.
+type ExpiryNotifier struct {
+	store      IgnoreStore
+	emailer    Emailer
+	warnAhead  time.Duration
+	ignoresURL string
+}
+
+// NewExpiryNotifier creates a new ExpiryNotifier.
+//   warnAhead - how long before a rule expires the owner should be notified.
+//   ignoresURL - URL of the page where ignore rules can be extended.
+func NewExpiryNotifier(store IgnoreStore, emailer Emailer, warnAhead time.Duration, ignoresURL string) *ExpiryNotifier {
+	return &ExpiryNotifier{
+		store:      store,
+		emailer:    emailer,
+		warnAhead:  warnAhead,
+		ignoresURL: ignoresURL,
+	}
+}
+
+// Notify sends emails for all rules that expire within the warning period
+// after 'now' and have not been announced yet. It returns the number of
+// rules for which an email was sent.
+func (e *ExpiryNotifier) Notify(now time.Time) (int, error) {
+	list, err := e.store.List(false)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, rule := range list {
+		if rule.ExpiryWarned.Equal(rule.Expires) {
+			continue
+		}
+		if rule.Expires.Before(now) || rule.Expires.After(now.Add(e.warnAhead)) {
+			continue
+		}
+		if err := e.send(rule); err != nil {
+			glog.Errorf("Unable to send expiration warning for ignore rule %d: %s", rule.ID, err)
+			continue
+		}
+		sent++
+		if err := e.store.SetExpiryWarned(rule.ID, rule.Expires); err != nil {
+			glog.Errorf("Unable to record expiration warning for ignore rule %d: %s", rule.ID, err)
+		}
+	}
+	return sent, nil
+}
+
+func (e *ExpiryNotifier) send(rule *IgnoreRule) error {
+	to := []string{}
+	for _, addr := range []string{rule.Owner, rule.UpdatedBy} {
+		if addr != "" && !util.In(addr, to) {
+			to = append(to, addr)
+		}
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("Rule has no owner.")
+	}
+
+	var body bytes.Buffer
+	if err := expiryEmailTemplate.Execute(&body, struct {
+		Rule    *IgnoreRule
+		Expires string
+		URL     string
+	}{
+		Rule:    rule,
+		Expires: rule.Expires.UTC().Format(time.RFC1123),
+		URL:     e.ignoresURL,
+	}); err != nil {
+		return err
+	}
+	subject := fmt.Sprintf("Gold ignore rule %q is about to expire", rule.Query)
+	return e.emailer.Send(EXPIRY_EMAIL_SENDER, to, subject, body.String())
+}
+
+// StartExpiryNotifier checks the ignore store periodically and emails the
+// owners of ignore rules that are about to expire.
+func StartExpiryNotifier(notifier *ExpiryNotifier, interval time.Duration) {
+	liveness := metrics2.NewLiveness("gold.ignore-expiry-notifier")
+	go func() {
+		for _ = range time.Tick(interval) {
+			if _, err := notifier.Notify(time.Now()); err != nil {
+				glog.Errorf("Failed to send expiration warnings for ignore rules: %s", err)
+				continue
+			}
+			liveness.Reset()
+		}
+	}()
+}
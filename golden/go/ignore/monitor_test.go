@@ -0,0 +1,62 @@
+package ignore
+
+import (
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+type mockEmailer struct {
+	to       [][]string
+	subjects []string
+}
+
+func (m *mockEmailer) Send(senderDisplayName string, to []string, subject string, body string) error {
+	m.to = append(m.to, to)
+	m.subjects = append(m.subjects, subject)
+	return nil
+}
+
+func TestExpiryNotifier(t *testing.T) {
+	now := time.Now()
+	store := NewMemIgnoreStore()
+	soon := NewIgnoreRule("jon@example.com", now.Add(time.Hour), "config=gpu", "Expires soon.")
+	soon.Owner = "team@example.com"
+	later := NewIgnoreRule("jim@example.com", now.Add(10*24*time.Hour), "config=8888", "Expires later.")
+	assert.NoError(t, store.Create(soon))
+	assert.NoError(t, store.Create(later))
+
+	emailer := &mockEmailer{}
+	notifier := NewExpiryNotifier(store, emailer, 3*24*time.Hour, "https://example.com/ignores")
+
+	n, err := notifier.Notify(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, [][]string{{"team@example.com", "jon@example.com"}}, emailer.to)
+	assert.Contains(t, emailer.subjects[0], "config=gpu")
+
+	// Nothing changed, so no new emails are sent.
+	n, err = notifier.Notify(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// The warnings are kept in the store, so a new notifier, e.g. after a
+	// restart, doesn't send them again.
+	n, err = NewExpiryNotifier(store, emailer, 3*24*time.Hour, "https://example.com/ignores").Notify(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// Extending the rule re-arms the notification.
+	assert.NoError(t, store.Extend(soon.ID, now.Add(2*time.Hour), "jim@example.com"))
+	n, err = notifier.Notify(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"team@example.com", "jim@example.com"}, emailer.to[1])
+
+	// Both rules are within the warning period a week later.
+	n, err = notifier.Notify(now.Add(8 * 24 * time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 3, len(emailer.to))
+}
@@ -0,0 +1,100 @@
+package ignore
+
+import (
+	"fmt"
+
+	"go.skia.org/infra/go/database"
+	"go.skia.org/infra/go/util"
+)
+
+// SQLFuzzyRuleStore stores fuzzy triage rules in the same database as the
+// ignore rules.
+type SQLFuzzyRuleStore struct {
+	vdb *database.VersionedDB
+}
+
+// NewSQLFuzzyRuleStore creates a new SQL based FuzzyRuleStore.
+func NewSQLFuzzyRuleStore(vdb *database.VersionedDB) FuzzyRuleStore {
+	return &SQLFuzzyRuleStore{
+		vdb: vdb,
+	}
+}
+
+// Create, see FuzzyRuleStore interface.
+func (m *SQLFuzzyRuleStore) Create(rule *FuzzyRule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	stmt := `INSERT INTO fuzzyrule (userid, updated_by, query, max_diff_pixels, max_rgba_delta, note)
+	         VALUES(?,?,?,?,?,?)`
+
+	ret, err := m.vdb.DB.Exec(stmt, rule.Name, rule.Name, rule.Query, rule.MaxDiffPixels, rule.MaxRGBADelta, rule.Note)
+	if err != nil {
+		return err
+	}
+	createdId, err := ret.LastInsertId()
+	if err != nil {
+		return err
+	}
+	rule.ID = int(createdId)
+	return nil
+}
+
+// Update, see FuzzyRuleStore interface.
+func (m *SQLFuzzyRuleStore) Update(id int, rule *FuzzyRule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	stmt := `UPDATE fuzzyrule SET updated_by=?, query=?, max_diff_pixels=?, max_rgba_delta=?, note=? WHERE id=?`
+
+	res, err := m.vdb.DB.Exec(stmt, rule.UpdatedBy, rule.Query, rule.MaxDiffPixels, rule.MaxRGBADelta, rule.Note, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		return fmt.Errorf("Did not find a FuzzyRule with id: %d", id)
+	}
+	return nil
+}
+
+// List, see FuzzyRuleStore interface.
+func (m *SQLFuzzyRuleStore) List() ([]*FuzzyRule, error) {
+	stmt := `SELECT id, userid, updated_by, query, max_diff_pixels, max_rgba_delta, note
+	         FROM fuzzyrule
+	         ORDER BY id ASC`
+	rows, err := m.vdb.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Close(rows)
+
+	result := []*FuzzyRule{}
+	for rows.Next() {
+		target := &FuzzyRule{}
+		if err := rows.Scan(&target.ID, &target.Name, &target.UpdatedBy, &target.Query, &target.MaxDiffPixels, &target.MaxRGBADelta, &target.Note); err != nil {
+			return nil, err
+		}
+		result = append(result, target)
+	}
+	return result, nil
+}
+
+// Delete, see FuzzyRuleStore interface.
+func (m *SQLFuzzyRuleStore) Delete(id int, userId string) (int, error) {
+	stmt := "DELETE FROM fuzzyrule WHERE id=?"
+	ret, err := m.vdb.DB.Exec(stmt, id)
+	if err != nil {
+		return 0, err
+	}
+	rowsAffected, err := ret.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rowsAffected), nil
+}
+
+// BuildFuzzyRuleMatcher, see FuzzyRuleStore interface.
+func (m *SQLFuzzyRuleStore) BuildFuzzyRuleMatcher() (FuzzyRuleMatcher, error) {
+	return buildFuzzyRuleMatcher(m)
+}
@@ -1,6 +1,7 @@
 package ignore
 
 import (
+	"database/sql"
 	"fmt"
 	"net/url"
 	"sync"
@@ -44,11 +45,17 @@ func (m *SQLIgnoreStore) inc() {
 }
 
 // Create, see IgnoreStore interface.
-func (m *SQLIgnoreStore) Create(rule *IgnoreRule) error {
-	stmt := `INSERT INTO ignorerule (userid, updated_by, expires, query, note)
-	         VALUES(?,?,?,?,?)`
+func (m *SQLIgnoreStore) Create(rule *IgnoreRule) (retErr error) {
+	stmt := `INSERT INTO ignorerule (userid, updated_by, owner, expires, query, note)
+	         VALUES(?,?,?,?,?,?)`
 
-	ret, err := m.vdb.DB.Exec(stmt, rule.Name, rule.Name, rule.Expires.Unix(), rule.Query, rule.Note)
+	tx, err := m.vdb.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { retErr = database.CommitOrRollback(tx, retErr) }()
+
+	ret, err := tx.Exec(stmt, rule.Name, rule.Name, rule.Owner, rule.Expires.Unix(), rule.Query, rule.Note)
 	if err != nil {
 		return err
 	}
@@ -56,16 +63,25 @@ func (m *SQLIgnoreStore) Create(rule *IgnoreRule) error {
 	if err != nil {
 		return err
 	}
+	if err := addHistory(tx, int(createdId), ACTION_CREATE, rule.Name); err != nil {
+		return err
+	}
 	rule.ID = int(createdId)
 	m.inc()
 	return nil
 }
 
 // Update, see IgnoreStore interface.
-func (m *SQLIgnoreStore) Update(id int, rule *IgnoreRule) error {
-	stmt := `UPDATE ignorerule SET updated_by=?, expires=?, query=?, note=? WHERE id=?`
+func (m *SQLIgnoreStore) Update(id int, rule *IgnoreRule) (retErr error) {
+	stmt := `UPDATE ignorerule SET updated_by=?, owner=?, expires=?, query=?, note=? WHERE id=?`
 
-	res, err := m.vdb.DB.Exec(stmt, rule.UpdatedBy, rule.Expires.Unix(), rule.Query, rule.Note, rule.ID)
+	tx, err := m.vdb.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { retErr = database.CommitOrRollback(tx, retErr) }()
+
+	res, err := tx.Exec(stmt, rule.UpdatedBy, rule.Owner, rule.Expires.Unix(), rule.Query, rule.Note, id)
 	if err != nil {
 		return err
 	}
@@ -73,32 +89,121 @@ func (m *SQLIgnoreStore) Update(id int, rule *IgnoreRule) error {
 	if err == nil && n == 0 {
 		return fmt.Errorf("Did not find an IgnoreRule with id: %d", id)
 	}
+	if err := addHistory(tx, id, ACTION_UPDATE, rule.UpdatedBy); err != nil {
+		return err
+	}
 	m.inc()
 	return nil
 }
 
-// List, see IgnoreStore interface.
-func (m *SQLIgnoreStore) List(addCounts bool) ([]*IgnoreRule, error) {
-	stmt := `SELECT id, userid, updated_by, expires, query, note
+// Extend, see IgnoreStore interface.
+func (m *SQLIgnoreStore) Extend(id int, expires time.Time, userId string) (retErr error) {
+	stmt := `UPDATE ignorerule SET updated_by=?, expires=? WHERE id=?`
+
+	tx, err := m.vdb.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { retErr = database.CommitOrRollback(tx, retErr) }()
+
+	res, err := tx.Exec(stmt, userId, expires.Unix(), id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		return fmt.Errorf("Did not find an IgnoreRule with id: %d", id)
+	}
+	if err := addHistory(tx, id, ACTION_EXTEND, userId); err != nil {
+		return err
+	}
+	m.inc()
+	return nil
+}
+
+// Get, see IgnoreStore interface.
+func (m *SQLIgnoreStore) Get(id int) (*IgnoreRule, error) {
+	stmt := `SELECT id, userid, updated_by, owner, expires, query, note, expiry_warned
 	         FROM ignorerule
-	         ORDER BY expires ASC`
-	rows, err := m.vdb.DB.Query(stmt)
+	         WHERE id=?`
+	rows, err := m.vdb.DB.Query(stmt, id)
 	if err != nil {
 		return nil, err
 	}
 	defer util.Close(rows)
 
-	result := []*IgnoreRule{}
+	result, err := scanIgnoreRules(rows)
+	if err != nil || len(result) == 0 {
+		return nil, err
+	}
+	return result[0], nil
+}
+
+// SetExpiryWarned, see IgnoreStore interface.
+func (m *SQLIgnoreStore) SetExpiryWarned(id int, expires time.Time) error {
+	res, err := m.vdb.DB.Exec(`UPDATE ignorerule SET expiry_warned=? WHERE id=?`, expires.Unix(), id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		return fmt.Errorf("Did not find an IgnoreRule with id: %d", id)
+	}
+	return nil
+}
+
+// History, see IgnoreStore interface.
+func (m *SQLIgnoreStore) History(id int) ([]*IgnoreRuleChange, error) {
+	stmt := `SELECT rule_id, action, userid, ts, owner, expires, query, note
+	         FROM ignorerule_history
+	         WHERE rule_id=?
+	         ORDER BY id ASC`
+	rows, err := m.vdb.DB.Query(stmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Close(rows)
+
+	result := []*IgnoreRuleChange{}
 	for rows.Next() {
-		target := &IgnoreRule{}
-		var expiresTS int64
-		err := rows.Scan(&target.ID, &target.Name, &target.UpdatedBy, &expiresTS, &target.Query, &target.Note)
+		target := &IgnoreRuleChange{}
+		var ts, expiresTS int64
+		err := rows.Scan(&target.RuleID, &target.Action, &target.UserID, &ts, &target.Owner, &expiresTS, &target.Query, &target.Note)
 		if err != nil {
 			return nil, err
 		}
+		target.TS = time.Unix(ts, 0)
 		target.Expires = time.Unix(expiresTS, 0)
 		result = append(result, target)
 	}
+	return result, nil
+}
+
+// addHistory records the current state of the given rule in the history table.
+// It has to be called before a rule is deleted and after it was created or
+// changed.
+func addHistory(tx *sql.Tx, id int, action, userId string) error {
+	stmt := `INSERT INTO ignorerule_history (rule_id, action, userid, ts, owner, expires, query, note)
+	         SELECT id, ?, ?, ?, owner, expires, query, note FROM ignorerule WHERE id=?`
+	_, err := tx.Exec(stmt, action, userId, time.Now().Unix(), id)
+	return err
+}
+
+// List, see IgnoreStore interface.
+func (m *SQLIgnoreStore) List(addCounts bool) ([]*IgnoreRule, error) {
+	stmt := `SELECT id, userid, updated_by, owner, expires, query, note, expiry_warned
+	         FROM ignorerule
+	         ORDER BY expires ASC`
+	rows, err := m.vdb.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Close(rows)
+
+	result, err := scanIgnoreRules(rows)
+	if err != nil {
+		return nil, err
+	}
 
 	if addCounts {
 		if err := m.addIgnoreCounts(result); err != nil {
@@ -109,6 +214,25 @@ func (m *SQLIgnoreStore) List(addCounts bool) ([]*IgnoreRule, error) {
 	return result, nil
 }
 
+// scanIgnoreRules reads all ignore rules from the given result set.
+func scanIgnoreRules(rows *sql.Rows) ([]*IgnoreRule, error) {
+	result := []*IgnoreRule{}
+	for rows.Next() {
+		target := &IgnoreRule{}
+		var expiresTS, warnedTS int64
+		err := rows.Scan(&target.ID, &target.Name, &target.UpdatedBy, &target.Owner, &expiresTS, &target.Query, &target.Note, &warnedTS)
+		if err != nil {
+			return nil, err
+		}
+		target.Expires = time.Unix(expiresTS, 0)
+		if warnedTS != 0 {
+			target.ExpiryWarned = time.Unix(warnedTS, 0)
+		}
+		result = append(result, target)
+	}
+	return result, nil
+}
+
 // TODO(stephana): Add unit tests to addIgnoreCounts once we have a framework ready to
 // easily test against live (vs synthetic) data.
 
@@ -184,9 +308,19 @@ func (m *SQLIgnoreStore) addIgnoreCounts(rules []*IgnoreRule) error {
 }
 
 // Delete, see IgnoreStore interface.
-func (m *SQLIgnoreStore) Delete(id int, userId string) (int, error) {
+func (m *SQLIgnoreStore) Delete(id int, userId string) (n int, retErr error) {
 	stmt := "DELETE FROM ignorerule WHERE id=?"
-	ret, err := m.vdb.DB.Exec(stmt, id)
+
+	tx, err := m.vdb.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { retErr = database.CommitOrRollback(tx, retErr) }()
+
+	if err := addHistory(tx, id, ACTION_DELETE, userId); err != nil {
+		return 0, err
+	}
+	ret, err := tx.Exec(stmt, id)
 	if err != nil {
 		return 0, err
 	}
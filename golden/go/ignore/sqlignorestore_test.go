@@ -23,3 +23,17 @@ func TestSQLIgnoreStore(t *testing.T) {
 	store := NewSQLIgnoreStore(vdb, nil, nil)
 	testIgnoreStore(t, store)
 }
+
+func TestSQLFuzzyRuleStore(t *testing.T) {
+	// Set up the database. This also locks the db until this test is finished
+	// causing similar tests to wait.
+	migrationSteps := db.MigrationSteps()
+	mysqlDB := testutil.SetupMySQLTestDatabase(t, migrationSteps)
+	defer mysqlDB.Close(t)
+
+	vdb, err := testutil.LocalTestDatabaseConfig(migrationSteps).NewVersionedDB()
+	assert.NoError(t, err)
+	defer testutils.AssertCloses(t, vdb)
+
+	testFuzzyRuleStore(t, NewSQLFuzzyRuleStore(vdb))
+}
@@ -11,8 +11,10 @@ import (
 
 	"go.skia.org/infra/go/tiling"
 	"go.skia.org/infra/go/timer"
+	"go.skia.org/infra/golden/go/autotriage"
 	"go.skia.org/infra/golden/go/blame"
 	"go.skia.org/infra/golden/go/expstorage"
+	"go.skia.org/infra/golden/go/flaky"
 	"go.skia.org/infra/golden/go/paramsets"
 	"go.skia.org/infra/golden/go/pdag"
 	"go.skia.org/infra/golden/go/storage"
@@ -26,6 +28,11 @@ const (
 	// Event emitted when the indexer updates the search index.
 	// Callback argument: *SearchIndex
 	EV_INDEX_UPDATED = "indexer:index-updated"
+
+	// Event emitted when a new tile has been indexed. Unlike EV_INDEX_UPDATED
+	// it is not emitted when only the expectations changed.
+	// Callback argument: *SearchIndex
+	EV_TILE_INDEXED = "indexer:tile-indexed"
 )
 
 // SearchIndex contains everything that is necessary to search
@@ -35,6 +42,7 @@ const (
 type SearchIndex struct {
 	tilePair        *types.TilePair
 	tallies         *tally.Tallies
+	flaky           *flaky.Flaky
 	summaries       *summary.Summaries
 	paramsetSummary *paramsets.ParamSummary
 	blamer          *blame.Blamer
@@ -51,6 +59,7 @@ func newSearchIndex(storages *storage.Storage, tilePair *types.TilePair) *Search
 	return &SearchIndex{
 		tilePair:        tilePair,
 		tallies:         tally.New(),
+		flaky:           flaky.New(storages.FlakyWindow),
 		summaries:       summary.New(storages),
 		paramsetSummary: paramsets.New(),
 		blamer:          blame.New(storages),
@@ -81,17 +90,30 @@ func (idx *SearchIndex) TalliesByQuery(query url.Values, includeIgnores bool) ta
 	return idx.tallies.ByQuery(idx.GetTile(includeIgnores), query)
 }
 
+// Proxy to flaky.Flaky.ByTrace.
+func (idx *SearchIndex) FlakyByTrace() map[string]*flaky.TraceScore {
+	return idx.flaky.ByTrace()
+}
+
+// Proxy to flaky.Flaky.DigestScore.
+func (idx *SearchIndex) FlakyDigestScore(test, digest string) float64 {
+	return idx.flaky.DigestScore(test, digest)
+}
+
+// Proxy to flaky.Flaky.Group.
+func (idx *SearchIndex) FlakyGroup(test, digest string, minScore float64) []string {
+	return idx.flaky.Group(test, digest, minScore)
+}
+
 // Proxy to summary.Summary.Get.
 func (idx *SearchIndex) GetSummaries() map[string]*summary.Summary {
 	return idx.summaries.Get()
 }
 
-// Proxy to summary.CalcSummaries.
-func (idx *SearchIndex) CalcSummaries(testNames []string, query url.Values, includeIgnores, head bool) (map[string]*summary.Summary, error) {
-	if includeIgnores {
-		return idx.summaries.CalcSummaries(idx.tilePair.TileWithIgnores, testNames, query, head)
-	}
-	return idx.summaries.CalcSummaries(idx.tilePair.Tile, testNames, query, head)
+// Proxy to summary.CalcIssueSummaries. If issueID is empty only the master
+// expectations are used.
+func (idx *SearchIndex) CalcSummaries(testNames []string, query url.Values, includeIgnores, head bool, issueID string) (map[string]*summary.Summary, error) {
+	return idx.summaries.CalcIssueSummaries(idx.GetTile(includeIgnores), testNames, query, head, issueID)
 }
 
 // Proxy to paramsets.Get
@@ -115,6 +137,11 @@ type Indexer struct {
 	lastIndex  *SearchIndex
 	testNames  []string
 	mutex      sync.RWMutex
+
+	// autoTriageRunning is true while auto-triage is running, see
+	// runAutoTriage. It is protected by autoTriageMutex.
+	autoTriageRunning bool
+	autoTriageMutex   sync.Mutex
 }
 
 // New returns a new Indexer instance. It synchronously indexes the initiallly
@@ -141,8 +168,14 @@ func New(storages *storage.Storage, interval time.Duration) (*Indexer, error) {
 	// The warmer depends on tallies and summaries.
 	pdag.NewNode(runWarmer, summaryNode, tallyNode)
 
+	// Auto-triage only depends on the tile.
+	root.Child(ret.runAutoTriage)
+
+	// Flakiness only depends on the tile.
+	flakyNode := root.Child(calcFlaky)
+
 	// Set the result on the Indexer instance.
-	pdag.NewNode(ret.setIndex, summaryNode)
+	pdag.NewNode(ret.setIndex, summaryNode, flakyNode)
 
 	ret.pipeline = root
 	ret.blamerNode = blamerNode
@@ -219,7 +252,14 @@ func (ixr *Indexer) start(interval time.Duration) error {
 func (ixr *Indexer) indexTilePair(tilePair *types.TilePair) error {
 	defer timer.New("indexTilePair").Stop()
 	// Create a new index from the given tile.
-	return ixr.pipeline.Trigger(newSearchIndex(ixr.storages, tilePair))
+	if err := ixr.pipeline.Trigger(newSearchIndex(ixr.storages, tilePair)); err != nil {
+		return err
+	}
+
+	if ixr.storages.EventBus != nil {
+		ixr.storages.EventBus.Publish(EV_TILE_INDEXED, ixr.GetIndex())
+	}
+	return nil
 }
 
 // indexTest creates an updated index by indexing the given list of tests.
@@ -229,6 +269,7 @@ func (ixr *Indexer) indexTests(testNames []string) {
 	newIdx := &SearchIndex{
 		tilePair:        lastIdx.tilePair,
 		tallies:         lastIdx.tallies,
+		flaky:           lastIdx.flaky,
 		summaries:       lastIdx.summaries.Clone(),
 		paramsetSummary: lastIdx.paramsetSummary,
 		blamer:          blame.New(ixr.storages),
@@ -260,6 +301,13 @@ func calcTallies(state interface{}) error {
 	return nil
 }
 
+// calcFlaky is the pipeline function to calculate the flakiness of traces.
+func calcFlaky(state interface{}) error {
+	idx := state.(*SearchIndex)
+	idx.flaky.Calculate(idx.tilePair.TileWithIgnores)
+	return nil
+}
+
 // calcSummaries is the pipeline function to calculate the summaries.
 func calcSummaries(state interface{}) error {
 	idx := state.(*SearchIndex)
@@ -288,3 +336,51 @@ func runWarmer(state interface{}) error {
 	go idx.warmer.Run(idx.tilePair.TileWithIgnores, idx.summaries, idx.tallies)
 	return nil
 }
+
+// runAutoTriage is the pipeline function to triage digests according to the
+// fuzzy rules. It runs asynchronously since it only affects the index through
+// the resulting changes to the expectations, which trigger re-indexing of the
+// affected tests. If the previous run is still in progress this run is
+// skipped, so the same digests are never triaged twice.
+func (ixr *Indexer) runAutoTriage(state interface{}) error {
+	idx := state.(*SearchIndex)
+	if !ixr.startAutoTriage() {
+		glog.Warningf("Skipping auto-triage, the previous run is still in progress.")
+		return nil
+	}
+	go func() {
+		defer ixr.finishAutoTriage()
+		changes, err := autotriage.Triage(ixr.storages, idx.tilePair.Tile)
+		if err != nil {
+			glog.Errorf("Unable to auto-triage digests: %s", err)
+			return
+		}
+		for ruleID, change := range changes {
+			n := 0
+			for _, digests := range change {
+				n += len(digests)
+			}
+			glog.Infof("Fuzzy rule %d auto-triaged %d digests.", ruleID, n)
+		}
+	}()
+	return nil
+}
+
+// startAutoTriage returns false if auto-triage is already running, otherwise
+// it marks auto-triage as running until finishAutoTriage is called.
+func (ixr *Indexer) startAutoTriage() bool {
+	ixr.autoTriageMutex.Lock()
+	defer ixr.autoTriageMutex.Unlock()
+	if ixr.autoTriageRunning {
+		return false
+	}
+	ixr.autoTriageRunning = true
+	return true
+}
+
+// finishAutoTriage marks auto-triage as no longer running.
+func (ixr *Indexer) finishAutoTriage() {
+	ixr.autoTriageMutex.Lock()
+	defer ixr.autoTriageMutex.Unlock()
+	ixr.autoTriageRunning = false
+}
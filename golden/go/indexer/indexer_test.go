@@ -183,3 +183,14 @@ func setupStorages(t assert.TestingT) (*storage.Storage, expstorage.Expectations
 	assert.NoError(t, err)
 	return ret, expStore
 }
+
+func TestAutoTriageIsSerialized(t *testing.T) {
+	ixr := &Indexer{}
+	assert.True(t, ixr.startAutoTriage())
+
+	// A second run can't start while the first is in progress.
+	assert.False(t, ixr.startAutoTriage())
+
+	ixr.finishAutoTriage()
+	assert.True(t, ixr.startAutoTriage())
+}
@@ -0,0 +1,236 @@
+// Package savedsearch stores search queries and notifies their owners when
+// new untriaged digests show up in their results.
+package savedsearch
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"sync"
+
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/search"
+)
+
+// SavedSearch is a search query that is re-evaluated whenever a new tile is
+// indexed.
+type SavedSearch struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+
+	// Query contains the URL encoded search parameters in the same format
+	// that is accepted by the /json/search endpoint. To search over multiple
+	// corpora list all of them in the trace query, e.g.
+	// query=source_type%3Dgm%26source_type%3Dimage.
+	Query string `json:"query"`
+
+	Created int64 `json:"created"` // Time stamp in ms.
+	Updated int64 `json:"updated"` // Time stamp in ms.
+	LastRun int64 `json:"lastRun"` // Time stamp in ms, 0 if the search has not been evaluated yet.
+}
+
+// NewSavedSearch creates a new SavedSearch owned by 'owner'.
+func NewSavedSearch(name, owner, query string) *SavedSearch {
+	now := util.TimeStampMs()
+	return &SavedSearch{
+		Name:    name,
+		Owner:   owner,
+		Query:   query,
+		Created: now,
+		Updated: now,
+	}
+}
+
+// Validate returns an error if the saved search is incomplete or its query
+// cannot be parsed.
+func (s *SavedSearch) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("A saved search needs a name.")
+	}
+	if s.Owner == "" {
+		return fmt.Errorf("A saved search needs an owner.")
+	}
+	_, err := s.SearchQuery()
+	return err
+}
+
+// SearchQuery returns the query that is used to evaluate the saved search.
+// Regardless of the stored parameters only untriaged digests are returned and
+// the number of results is not limited. Since only the digests are needed to
+// find new ones, the diffs are not calculated, see search.Query.DigestsOnly.
+func (s *SavedSearch) SearchQuery() (*search.Query, error) {
+	form, err := url.ParseQuery(s.Query)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid query %q: %s", s.Query, err)
+	}
+	q := &search.Query{}
+	if err := search.ParseQuery(form, q); err != nil {
+		return nil, err
+	}
+	q.Unt = true
+	q.Pos = false
+	q.Neg = false
+	q.Limit = math.MaxInt32
+	q.DigestsOnly = true
+	q.GroupFlaky = false
+	return q, nil
+}
+
+// Store stores saved searches and the untriaged digests that have been
+// reported for each of them.
+type Store interface {
+	// Create adds a new saved search and sets its ID.
+	Create(s *SavedSearch) error
+
+	// Update changes the name and the query of an existing saved search.
+	// Since the results of the new query are unrelated to the old ones, the
+	// digests reported so far are dropped.
+	Update(s *SavedSearch) error
+
+	// Delete removes a saved search. It returns the number of deleted searches.
+	Delete(id int) (int, error)
+
+	// Get returns the saved search with the given id or nil if it does not exist.
+	Get(id int) (*SavedSearch, error)
+
+	// List returns all saved searches ordered by id.
+	List() ([]*SavedSearch, error)
+
+	// Seen returns the untriaged digests reported for the given search,
+	// keyed by test name.
+	Seen(id int) (map[string][]string, error)
+
+	// SetSeen replaces the digests reported for the given search and sets
+	// its LastRun field to 'ts'.
+	SetSeen(id int, digests map[string][]string, ts int64) error
+}
+
+// MemStore is an in-memory implementation of Store.
+type MemStore struct {
+	searches map[int]*SavedSearch
+	seen     map[int]map[string][]string
+	nextID   int
+	mutex    sync.Mutex
+}
+
+// NewMemStore creates a new instance of MemStore.
+func NewMemStore() Store {
+	return &MemStore{
+		searches: map[int]*SavedSearch{},
+		seen:     map[int]map[string][]string{},
+		nextID:   1,
+	}
+}
+
+// Create, see Store interface.
+func (m *MemStore) Create(s *SavedSearch) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	s.ID = m.nextID
+	m.nextID++
+	cp := *s
+	m.searches[s.ID] = &cp
+	return nil
+}
+
+// Update, see Store interface.
+func (m *MemStore) Update(s *SavedSearch) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	found, ok := m.searches[s.ID]
+	if !ok {
+		return fmt.Errorf("Did not find a saved search with id: %d", s.ID)
+	}
+	found.Name = s.Name
+	found.Query = s.Query
+	found.Updated = util.TimeStampMs()
+	found.LastRun = 0
+	delete(m.seen, s.ID)
+	return nil
+}
+
+// Delete, see Store interface.
+func (m *MemStore) Delete(id int) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.searches[id]; !ok {
+		return 0, nil
+	}
+	delete(m.searches, id)
+	delete(m.seen, id)
+	return 1, nil
+}
+
+// Get, see Store interface.
+func (m *MemStore) Get(id int) (*SavedSearch, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if found, ok := m.searches[id]; ok {
+		cp := *found
+		return &cp, nil
+	}
+	return nil, nil
+}
+
+// List, see Store interface.
+func (m *MemStore) List() ([]*SavedSearch, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ret := make([]*SavedSearch, 0, len(m.searches))
+	for _, s := range m.searches {
+		cp := *s
+		ret = append(ret, &cp)
+	}
+	sort.Sort(savedSearchSlice(ret))
+	return ret, nil
+}
+
+// Seen, see Store interface.
+func (m *MemStore) Seen(id int) (map[string][]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ret := map[string][]string{}
+	for testName, digests := range m.seen[id] {
+		ret[testName] = append([]string{}, digests...)
+	}
+	return ret, nil
+}
+
+// SetSeen, see Store interface.
+func (m *MemStore) SetSeen(id int, digests map[string][]string, ts int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	found, ok := m.searches[id]
+	if !ok {
+		return fmt.Errorf("Did not find a saved search with id: %d", id)
+	}
+	cp := make(map[string][]string, len(digests))
+	for testName, d := range digests {
+		cp[testName] = append([]string{}, d...)
+	}
+	m.seen[id] = cp
+	found.LastRun = ts
+	return nil
+}
+
+// savedSearchSlice is a utility type to sort saved searches by id.
+type savedSearchSlice []*SavedSearch
+
+func (s savedSearchSlice) Len() int           { return len(s) }
+func (s savedSearchSlice) Less(i, j int) bool { return s[i].ID < s[j].ID }
+func (s savedSearchSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,101 @@
+package savedsearch
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestMemStore(t *testing.T) {
+	testStore(t, NewMemStore())
+}
+
+func testStore(t *testing.T, store Store) {
+	s1 := NewSavedSearch("gm", "jon@example.com", "query=source_type%3Dgm&unt=true")
+	s2 := NewSavedSearch("all corpora", "jim@example.com", "query=source_type%3Dgm%26source_type%3Dimage")
+	assert.NoError(t, store.Create(s1))
+	assert.NoError(t, store.Create(s2))
+	assert.NotEqual(t, s1.ID, s2.ID)
+
+	// Incomplete or invalid searches are rejected.
+	assert.Error(t, store.Create(NewSavedSearch("", "jon@example.com", "")))
+	assert.Error(t, store.Create(NewSavedSearch("no owner", "", "")))
+	assert.Error(t, store.Create(NewSavedSearch("bad", "jon@example.com", "limit=abc")))
+
+	list, err := store.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []*SavedSearch{s1, s2}, list)
+
+	found, err := store.Get(s2.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, s2, found)
+	found, err = store.Get(100001)
+	assert.NoError(t, err)
+	assert.Nil(t, found)
+
+	q, err := s2.SearchQuery()
+	assert.NoError(t, err)
+	assert.True(t, q.Unt)
+	assert.False(t, q.Pos)
+	assert.True(t, q.DigestsOnly)
+	assert.Equal(t, []string{"gm", "image"}, q.Query["source_type"])
+
+	// Record the digests that have been seen.
+	seen, err := store.Seen(s1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(seen))
+	digests := map[string][]string{"test1": {"d1", "d2"}, "test2": {"d3"}}
+	assert.NoError(t, store.SetSeen(s1.ID, digests, 1000))
+	seen, err = store.Seen(s1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(seen))
+	assert.Equal(t, 2, len(seen["test1"]))
+	assert.Equal(t, []string{"d3"}, seen["test2"])
+	found, err = store.Get(s1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), found.LastRun)
+	assert.Error(t, store.SetSeen(100001, digests, 1000))
+
+	// Updating a search resets the digests that have been seen.
+	found.Name = "gm renamed"
+	found.Query = "query=source_type%3Dimage"
+	assert.NoError(t, store.Update(found))
+	found, err = store.Get(s1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "gm renamed", found.Name)
+	assert.Equal(t, "query=source_type%3Dimage", found.Query)
+	assert.Equal(t, "jon@example.com", found.Owner)
+	assert.Equal(t, int64(0), found.LastRun)
+	seen, err = store.Seen(s1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(seen))
+	assert.Error(t, store.Update(&SavedSearch{ID: 100001, Name: "x", Owner: "jon@example.com"}))
+
+	n, err := store.Delete(s1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = store.Delete(s1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	list, err = store.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []*SavedSearch{s2}, list)
+}
+
+func TestNewDigests(t *testing.T) {
+	seen := map[string][]string{
+		"test1": {"d1", "d2"},
+		"test2": {"d3"},
+	}
+	current := map[string][]string{
+		"test1": {"d5", "d2", "d4"},
+		"test2": {"d3"},
+		"test3": {"d6"},
+	}
+	assert.Equal(t, map[string][]string{
+		"test1": {"d4", "d5"},
+		"test3": {"d6"},
+	}, newDigests(seen, current))
+	assert.Equal(t, map[string][]string{}, newDigests(current, current))
+	assert.Equal(t, map[string][]string{}, newDigests(current, map[string][]string{}))
+}
@@ -0,0 +1,180 @@
+package savedsearch
+
+import (
+	"database/sql"
+	"fmt"
+
+	"go.skia.org/infra/go/database"
+	"go.skia.org/infra/go/util"
+)
+
+// SQLStore implements Store on top of a SQL database.
+type SQLStore struct {
+	vdb *database.VersionedDB
+}
+
+// NewSQLStore creates a new SQL based Store.
+func NewSQLStore(vdb *database.VersionedDB) Store {
+	return &SQLStore{
+		vdb: vdb,
+	}
+}
+
+// Create, see Store interface.
+func (s *SQLStore) Create(search *SavedSearch) error {
+	if err := search.Validate(); err != nil {
+		return err
+	}
+	const stmt = `INSERT INTO savedsearch (name, owner, query, created, updated, last_run)
+	              VALUES (?, ?, ?, ?, ?, ?)`
+	ret, err := s.vdb.DB.Exec(stmt, search.Name, search.Owner, search.Query, search.Created, search.Updated, search.LastRun)
+	if err != nil {
+		return err
+	}
+	createdID, err := ret.LastInsertId()
+	if err != nil {
+		return err
+	}
+	search.ID = int(createdID)
+	return nil
+}
+
+// Update, see Store interface.
+func (s *SQLStore) Update(search *SavedSearch) (retErr error) {
+	if err := search.Validate(); err != nil {
+		return err
+	}
+
+	tx, err := s.vdb.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { retErr = database.CommitOrRollback(tx, retErr) }()
+
+	const stmt = `UPDATE savedsearch SET name=?, query=?, updated=?, last_run=0 WHERE id=?`
+	res, err := tx.Exec(stmt, search.Name, search.Query, util.TimeStampMs(), search.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("Did not find a saved search with id: %d", search.ID)
+	}
+	_, err = tx.Exec(`DELETE FROM savedsearch_seen WHERE search_id=?`, search.ID)
+	return err
+}
+
+// Delete, see Store interface.
+func (s *SQLStore) Delete(id int) (n int, retErr error) {
+	tx, err := s.vdb.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { retErr = database.CommitOrRollback(tx, retErr) }()
+
+	if _, err := tx.Exec(`DELETE FROM savedsearch_seen WHERE search_id=?`, id); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`DELETE FROM savedsearch WHERE id=?`, id)
+	if err != nil {
+		return 0, err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rowsAffected), nil
+}
+
+// Get, see Store interface.
+func (s *SQLStore) Get(id int) (*SavedSearch, error) {
+	const stmt = `SELECT id, name, owner, query, created, updated, last_run
+	              FROM savedsearch
+	              WHERE id=?`
+	rows, err := s.vdb.DB.Query(stmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Close(rows)
+
+	ret, err := scanSavedSearches(rows)
+	if err != nil || len(ret) == 0 {
+		return nil, err
+	}
+	return ret[0], nil
+}
+
+// List, see Store interface.
+func (s *SQLStore) List() ([]*SavedSearch, error) {
+	const stmt = `SELECT id, name, owner, query, created, updated, last_run
+	              FROM savedsearch
+	              ORDER BY id ASC`
+	rows, err := s.vdb.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Close(rows)
+	return scanSavedSearches(rows)
+}
+
+// Seen, see Store interface.
+func (s *SQLStore) Seen(id int) (map[string][]string, error) {
+	const stmt = `SELECT name, digest FROM savedsearch_seen WHERE search_id=?`
+	rows, err := s.vdb.DB.Query(stmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Close(rows)
+
+	ret := map[string][]string{}
+	for rows.Next() {
+		var testName, digest string
+		if err := rows.Scan(&testName, &digest); err != nil {
+			return nil, err
+		}
+		ret[testName] = append(ret[testName], digest)
+	}
+	return ret, nil
+}
+
+// SetSeen, see Store interface.
+func (s *SQLStore) SetSeen(id int, digests map[string][]string, ts int64) (retErr error) {
+	tx, err := s.vdb.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { retErr = database.CommitOrRollback(tx, retErr) }()
+
+	res, err := tx.Exec(`UPDATE savedsearch SET last_run=? WHERE id=?`, ts, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("Did not find a saved search with id: %d", id)
+	}
+	if _, err := tx.Exec(`DELETE FROM savedsearch_seen WHERE search_id=?`, id); err != nil {
+		return err
+	}
+
+	const insertStmt = `INSERT INTO savedsearch_seen (search_id, name, digest) VALUES (?, ?, ?)`
+	for testName, d := range digests {
+		for _, digest := range d {
+			if _, err := tx.Exec(insertStmt, id, testName, digest); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scanSavedSearches reads all saved searches from the given result set.
+func scanSavedSearches(rows *sql.Rows) ([]*SavedSearch, error) {
+	ret := []*SavedSearch{}
+	for rows.Next() {
+		s := &SavedSearch{}
+		if err := rows.Scan(&s.ID, &s.Name, &s.Owner, &s.Query, &s.Created, &s.Updated, &s.LastRun); err != nil {
+			return nil, err
+		}
+		ret = append(ret, s)
+	}
+	return ret, nil
+}
@@ -0,0 +1,221 @@
+package savedsearch
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/skia-dev/glog"
+	"go.skia.org/infra/go/metrics2"
+	"go.skia.org/infra/go/timer"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/indexer"
+	"go.skia.org/infra/golden/go/search"
+	"go.skia.org/infra/golden/go/storage"
+)
+
+const (
+	// EV_SAVED_SEARCH_NEW_DIGESTS is the event that is published when a saved
+	// search returns untriaged digests that have not been reported before.
+	// The argument is an instance of *Notification.
+	EV_SAVED_SEARCH_NEW_DIGESTS = "savedsearch:new-digests"
+
+	// NOTIFICATION_EMAIL_SENDER is the display name used for notification emails.
+	NOTIFICATION_EMAIL_SENDER = "Gold"
+)
+
+// Emailer sends email messages. It is implemented by email.GMail.
+type Emailer interface {
+	Send(senderDisplayName string, to []string, subject string, body string) error
+}
+
+// Notification is sent for a saved search that returned new untriaged digests.
+type Notification struct {
+	Search *SavedSearch
+
+	// NewDigests maps test names to the untriaged digests that have not been
+	// reported for the search before.
+	NewDigests map[string][]string
+}
+
+var notificationEmailTemplate = template.Must(template.New("notification").Parse(`
+<p>Your saved search <b>{{.Search.Name}}</b> on Gold has new untriaged digests:</p>
+<ul>
+{{range .Links}}<li><a href="{{.URL}}">{{.Test}} - {{.Digest}}</a></li>
+{{end}}</ul>
+<p>See all results at <a href="{{.SearchURL}}">{{.SearchURL}}</a>.</p>
+`))
+
+// Watcher re-evaluates all saved searches whenever a new tile has been
+// indexed and notifies the owners about new untriaged digests.
+//
+// The first evaluation of a search establishes the baseline and does not
+// send a notification, otherwise creating a search would report every
+// digest that is currently untriaged.
+type Watcher struct {
+	storages *storage.Storage
+	store    Store
+	emailer  Emailer
+	siteURL  string
+
+	// mutex serializes evaluations.
+	mutex sync.Mutex
+}
+
+// NewWatcher creates a new Watcher.
+//   emailer - can be nil, in which case only events are published.
+//   siteURL - the base URL of the Gold instance, used for links in emails.
+func NewWatcher(storages *storage.Storage, store Store, emailer Emailer, siteURL string) *Watcher {
+	return &Watcher{
+		storages: storages,
+		store:    store,
+		emailer:  emailer,
+		siteURL:  siteURL,
+	}
+}
+
+// Start subscribes the watcher to the indexer so that saved searches are
+// evaluated after every new tile.
+func (w *Watcher) Start() {
+	liveness := metrics2.NewLiveness("gold.saved-search-watcher")
+	w.storages.EventBus.SubscribeAsync(indexer.EV_TILE_INDEXED, func(e interface{}) {
+		if err := w.Evaluate(e.(*indexer.SearchIndex)); err != nil {
+			glog.Errorf("Failed to evaluate saved searches: %s", err)
+			return
+		}
+		liveness.Reset()
+	})
+}
+
+// Evaluate runs all saved searches against the given index and sends
+// notifications for searches that returned new untriaged digests.
+func (w *Watcher) Evaluate(idx *indexer.SearchIndex) error {
+	defer timer.New("Evaluating saved searches").Stop()
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	searches, err := w.store.List()
+	if err != nil {
+		return err
+	}
+
+	now := util.TimeStampMs()
+	for _, s := range searches {
+		if err := w.evaluateOne(s, idx, now); err != nil {
+			glog.Errorf("Unable to evaluate saved search %d: %s", s.ID, err)
+		}
+	}
+	return nil
+}
+
+// evaluateOne runs a single saved search and records its results.
+func (w *Watcher) evaluateOne(s *SavedSearch, idx *indexer.SearchIndex, now int64) error {
+	q, err := s.SearchQuery()
+	if err != nil {
+		return err
+	}
+	resp, err := search.Search(q, w.storages, idx)
+	if err != nil {
+		return err
+	}
+
+	current := map[string][]string{}
+	for _, d := range resp.Digests {
+		current[d.Test] = append(current[d.Test], d.Digest)
+	}
+
+	seen, err := w.store.Seen(s.ID)
+	if err != nil {
+		return err
+	}
+	isBaseline := s.LastRun == 0
+	if err := w.store.SetSeen(s.ID, current, now); err != nil {
+		return err
+	}
+
+	added := newDigests(seen, current)
+	if isBaseline || len(added) == 0 {
+		return nil
+	}
+
+	n := &Notification{
+		Search:     s,
+		NewDigests: added,
+	}
+	if w.storages.EventBus != nil {
+		w.storages.EventBus.Publish(EV_SAVED_SEARCH_NEW_DIGESTS, n)
+	}
+	if w.emailer != nil {
+		return w.send(n)
+	}
+	return nil
+}
+
+// send emails the owner of the saved search about the new digests.
+func (w *Watcher) send(n *Notification) error {
+	type link struct {
+		Test   string
+		Digest string
+		URL    string
+	}
+
+	links := []*link{}
+	for _, testName := range sortedKeys(n.NewDigests) {
+		for _, digest := range n.NewDigests[testName] {
+			v := url.Values{}
+			v.Set("test", testName)
+			v.Set("digest", digest)
+			links = append(links, &link{
+				Test:   testName,
+				Digest: digest,
+				URL:    w.siteURL + "/detail?" + v.Encode(),
+			})
+		}
+	}
+
+	var body bytes.Buffer
+	if err := notificationEmailTemplate.Execute(&body, struct {
+		Search    *SavedSearch
+		Links     []*link
+		SearchURL string
+	}{
+		Search:    n.Search,
+		Links:     links,
+		SearchURL: w.siteURL + "/search?" + n.Search.Query,
+	}); err != nil {
+		return err
+	}
+	subject := fmt.Sprintf("Gold: %d new untriaged digest(s) for %q", len(links), n.Search.Name)
+	return w.emailer.Send(NOTIFICATION_EMAIL_SENDER, []string{n.Search.Owner}, subject, body.String())
+}
+
+// newDigests returns the digests in 'current' that are not in 'seen', keyed
+// by test name. The digests of each test are sorted.
+func newDigests(seen, current map[string][]string) map[string][]string {
+	ret := map[string][]string{}
+	for testName, digests := range current {
+		known := util.NewStringSet(seen[testName])
+		for _, d := range digests {
+			if !known[d] {
+				ret[testName] = append(ret[testName], d)
+			}
+		}
+		if len(ret[testName]) > 0 {
+			sort.Strings(ret[testName])
+		}
+	}
+	return ret
+}
+
+// sortedKeys returns the keys of the given map in sorted order.
+func sortedKeys(m map[string][]string) []string {
+	ret := make([]string, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
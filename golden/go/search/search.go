@@ -6,14 +6,18 @@ import (
 	"math"
 	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 
+	"go.skia.org/infra/go/query"
 	"go.skia.org/infra/go/tiling"
 	"go.skia.org/infra/go/util"
 	"go.skia.org/infra/golden/go/blame"
 	"go.skia.org/infra/golden/go/diff"
+	"go.skia.org/infra/golden/go/diffstore"
 	"go.skia.org/infra/golden/go/digesttools"
 	"go.skia.org/infra/golden/go/expstorage"
+	"go.skia.org/infra/golden/go/flaky"
 	"go.skia.org/infra/golden/go/goldingestion"
 	"go.skia.org/infra/golden/go/ignore"
 	"go.skia.org/infra/golden/go/indexer"
@@ -23,6 +27,15 @@ import (
 	"go.skia.org/infra/golden/go/types"
 )
 
+// Sort orders that Search understands.
+const (
+	// SORT_DIFF sorts by the difference to the closest triaged digest, largest first.
+	SORT_DIFF = "diff"
+
+	// SORT_FLAKY sorts by flakiness, most flaky first.
+	SORT_FLAKY = "flaky"
+)
+
 // Point is a single point. Used in Trace.
 type Point struct {
 	X int `json:"x"` // The commit index [0-49].
@@ -60,7 +73,8 @@ type DiffDigest struct {
 // Might still be useful to find diffs to closest pos for a neg, and vice-versa.
 // Will also be useful if we ever get a canonical trace or centroid.
 type Diff struct {
-	Diff float32 `json:"diff"` // The smaller of the Pos and Neg diff.
+	Diff   float32 `json:"diff"`   // The smaller of the Pos and Neg diff.
+	Metric string  `json:"metric"` // The diff metric used to calculate Diff.
 
 	// Either may be nil if there's no positive or negative to compare against.
 	Pos *DiffDigest `json:"pos"`
@@ -78,6 +92,14 @@ type Digest struct {
 	ParamSet map[string][]string `json:"paramset"`
 	Traces   *Traces             `json:"traces"`
 	Diff     *Diff               `json:"diff"`
+
+	// Flakiness is the highest flakiness score of the traces that contain the
+	// digest. See the flaky package.
+	Flakiness float64 `json:"flakiness"`
+
+	// FlakyGroup contains the digests that are produced interchangeably by
+	// the flaky traces containing this digest. Only set if requested.
+	FlakyGroup []string `json:"flakyGroup,omitempty"`
 }
 
 // CommitRange is a range of commits, starting at the git hash Begin and ending at End, inclusive.
@@ -100,8 +122,18 @@ type Query struct {
 	Issue          string
 	Patchsets      []string
 	CommitRange    CommitRange
-	Limit          int  // Only return this many items.
-	IncludeMaster  bool // Include digests from master when searching Rietveld issues.
+	Limit          int     // Only return this many items.
+	IncludeMaster  bool    // Include digests from master when searching Rietveld issues.
+	Metric         string  // The diff metric to find the closest digests and sort by. Defaults to diffstore.METRIC_COMBINED.
+	MinFlaky       float64 // Only include digests with at least this flakiness score.
+	MaxFlaky       float64 // Only include digests with at most this flakiness score. Ignored if 0 or less.
+	Sort           string  // Either SORT_DIFF (default) or SORT_FLAKY.
+	GroupFlaky     bool    // Set FlakyGroup for each digest.
+
+	// DigestsOnly skips the expensive parts of the search, i.e. the diffs and
+	// traces are not calculated and the results are not sorted. Only Test,
+	// Digest, Status and Flakiness are guaranteed to be set.
+	DigestsOnly bool
 }
 
 // SearchResponse is the standard search response. Depending on the query some fields
@@ -120,6 +152,72 @@ type IssueResponse struct {
 	QueryPatchsets []string
 }
 
+// ParseQuery sets the fields of query from the given parameters, which are
+// usually the parameters of an HTTP request. The limit, the diff metric and
+// the flakiness bounds are only changed if the parameter is present.
+func ParseQuery(form url.Values, query *Query) error {
+	// Get the limit
+	if l := form.Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil {
+			return fmt.Errorf("Unable to parse a limit of: %s", l)
+		}
+		query.Limit = limit
+	}
+
+	// Parse the query
+	var err error
+	query.Query = url.Values{}
+	if q := form.Get("query"); q != "" {
+		query.Query, err = url.ParseQuery(q)
+		if err != nil {
+			return fmt.Errorf("Unable to parse query: %s. Error: %s", q, err)
+		}
+	}
+
+	// Parse out the patchsets.
+	if temp := form.Get("patchsets"); temp != "" {
+		patchsets := strings.Split(temp, ",")
+		query.Patchsets = patchsets
+	}
+
+	query.BlameGroupID = form.Get("blame")
+	query.Pos = form.Get("pos") == "true"
+	query.Neg = form.Get("neg") == "true"
+	query.Unt = form.Get("unt") == "true"
+	query.Head = form.Get("head") == "true"
+	query.IncludeIgnores = form.Get("include") == "true"
+	query.Issue = form.Get("issue")
+	query.IncludeMaster = form.Get("master") == "true"
+
+	if fmin := form.Get("fmin"); fmin != "" {
+		if query.MinFlaky, err = strconv.ParseFloat(fmin, 64); err != nil {
+			return fmt.Errorf("Unable to parse fmin: %s", fmin)
+		}
+	}
+	if fmax := form.Get("fmax"); fmax != "" {
+		if query.MaxFlaky, err = strconv.ParseFloat(fmax, 64); err != nil {
+			return fmt.Errorf("Unable to parse fmax: %s", fmax)
+		}
+	}
+	query.GroupFlaky = form.Get("fgroup") == "true"
+	switch sortBy := form.Get("sort"); sortBy {
+	case "", SORT_DIFF, SORT_FLAKY:
+		query.Sort = sortBy
+	default:
+		return fmt.Errorf("Unknown sort order: %s", sortBy)
+	}
+
+	if metric := form.Get("metric"); metric != "" {
+		if !diffstore.IsMetric(metric) {
+			return fmt.Errorf("Unknown diff metric: %s", metric)
+		}
+		query.Metric = metric
+	}
+
+	return nil
+}
+
 // excludeClassification returns true if the given label/status for a digest
 // should be excluded based on the values in the query.
 func (q *Query) excludeClassification(cl types.Label) bool {
@@ -128,6 +226,20 @@ func (q *Query) excludeClassification(cl types.Label) bool {
 		((cl == types.UNTRIAGED) && !q.Unt)
 }
 
+// excludeFlakiness returns true if a digest with the given flakiness score
+// should be excluded based on the values in the query.
+func (q *Query) excludeFlakiness(score float64) bool {
+	return (score < q.MinFlaky) || ((q.MaxFlaky > 0) && (score > q.MaxFlaky))
+}
+
+// diffMetric returns the diff metric to use for the query.
+func (q *Query) diffMetric() string {
+	if q.Metric == "" {
+		return diffstore.METRIC_COMBINED
+	}
+	return q.Metric
+}
+
 // intermediate is the intermediate representation of the results coming from Search.
 //
 // To avoid filtering through the tile more than once we first take a pass
@@ -160,12 +272,25 @@ func (p DigestSlice) Len() int           { return len(p) }
 func (p DigestSlice) Less(i, j int) bool { return p[i].Diff.Diff > p[j].Diff.Diff }
 func (p DigestSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 
+// FlakySlice is a utility type for sorting slices of Digest by their
+// flakiness. Ties are sorted by their max diff.
+type FlakySlice []*Digest
+
+func (p FlakySlice) Len() int { return len(p) }
+func (p FlakySlice) Less(i, j int) bool {
+	if p[i].Flakiness == p[j].Flakiness {
+		return p[i].Diff.Diff > p[j].Diff.Diff
+	}
+	return p[i].Flakiness > p[j].Flakiness
+}
+func (p FlakySlice) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
 // Search returns a slice of Digests that match the input query, and the total number of Digests
 // that matched the query. It also returns a slice of Commits that were used in the calculations.
 func Search(q *Query, storages *storage.Storage, idx *indexer.SearchIndex) (*SearchResponse, error) {
 	tile := idx.GetTile(q.IncludeIgnores)
 
-	e, err := storages.ExpectationsStore.Get()
+	e, err := storages.GetExpectations(q.Issue)
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't get expectations: %s", err)
 	}
@@ -183,7 +308,22 @@ func Search(q *Query, storages *storage.Storage, idx *indexer.SearchIndex) (*Sea
 		return nil, err
 	}
 
-	sort.Sort(DigestSlice(ret))
+	if q.GroupFlaky {
+		for _, d := range ret {
+			if group := idx.FlakyGroup(d.Test, d.Digest, flaky.DEFAULT_GROUP_THRESHOLD); len(group) > 1 {
+				d.FlakyGroup = group
+			}
+		}
+	}
+
+	// Without diffs there is nothing to sort by.
+	if !q.DigestsOnly {
+		if q.Sort == SORT_FLAKY {
+			sort.Sort(FlakySlice(ret))
+		} else {
+			sort.Sort(DigestSlice(ret))
+		}
+	}
 	fullLength := len(ret)
 	if fullLength > q.Limit {
 		ret = ret[0:q.Limit]
@@ -291,12 +431,18 @@ func searchByIssue(issueID string, q *Query, exp *expstorage.Expectations, parse
 					}
 				}
 
+				flakiness := idx.FlakyDigestScore(testName, digest)
+				if q.excludeFlakiness(flakiness) {
+					continue
+				}
+
 				if cl := exp.Classification(testName, digest); !q.excludeClassification(cl) {
 					digestMap[key] = &Digest{
-						Test:     testName,
-						Digest:   digest,
-						ParamSet: util.AddParamsToParamSet(make(map[string][]string, len(params)), params),
-						Status:   cl.String(),
+						Test:      testName,
+						Digest:    digest,
+						ParamSet:  util.AddParamsToParamSet(make(map[string][]string, len(params)), params),
+						Status:    cl.String(),
+						Flakiness: flakiness,
 					}
 				}
 			}
@@ -307,13 +453,19 @@ func searchByIssue(issueID string, q *Query, exp *expstorage.Expectations, parse
 	allDigests := make([]string, len(digestMap))
 	emptyTraces := &Traces{}
 	for _, digestEntry := range digestMap {
-		digestEntry.Diff = buildDiff(digestEntry.Test, digestEntry.Digest, exp, nil, talliesByTest, storages.DiffStore, idx, q.IncludeIgnores)
+		if q.DigestsOnly {
+			ret = append(ret, digestEntry)
+			continue
+		}
+		digestEntry.Diff = buildDiff(digestEntry.Test, digestEntry.Digest, exp, nil, talliesByTest, storages.DiffStore, idx, q.IncludeIgnores, q.diffMetric())
 		digestEntry.Traces = emptyTraces
 		ret = append(ret, digestEntry)
 		allDigests = append(allDigests, digestEntry.Digest)
 	}
 
-	loadDigests(storages.DiffStore, allDigests)
+	if !q.DigestsOnly {
+		loadDigests(storages.DiffStore, allDigests)
+	}
 
 	issueResponse := &IssueResponse{
 		IssueDetails:   issue,
@@ -329,6 +481,10 @@ func searchTile(q *Query, e *expstorage.Expectations, parsedQuery url.Values, st
 
 	traceTally := idx.TalliesByTrace()
 	lastCommitIndex := tile.LastCommitIndex()
+	compiledQuery, err := query.New(parsedQuery)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid query: %s", err)
+	}
 
 	// Loop over the tile and pull out all the digests that match
 	// the query, collecting the matching traces as you go. Build
@@ -338,13 +494,13 @@ func searchTile(q *Query, e *expstorage.Expectations, parsedQuery url.Values, st
 	// map [test:digest] *intermediate
 	inter := map[string]*intermediate{}
 	for id, tr := range tile.Traces {
-		if tiling.Matches(tr, parsedQuery) {
+		if compiledQuery.MatchesParams(tr.Params()) {
 			test := tr.Params()[types.PRIMARY_KEY_FIELD]
 			// Get all the digests
 			digests := digestsFromTrace(id, tr, q.Head, lastCommitIndex, traceTally)
 			for _, digest := range digests {
 				cl := e.Classification(test, digest)
-				if q.excludeClassification(cl) {
+				if q.excludeClassification(cl) || q.excludeFlakiness(idx.FlakyDigestScore(test, digest)) {
 					continue
 				}
 
@@ -372,30 +528,42 @@ func searchTile(q *Query, e *expstorage.Expectations, parsedQuery url.Values, st
 	ret := make([]*Digest, 0, len(inter))
 	for key, i := range inter {
 		parts := strings.Split(key, ":")
-		ret = append(ret, digestFromIntermediate(parts[0], parts[1], i, e, tile, idx, storages.DiffStore, q.IncludeIgnores))
+		if q.DigestsOnly {
+			ret = append(ret, &Digest{
+				Test:      parts[0],
+				Digest:    parts[1],
+				Status:    e.Classification(parts[0], parts[1]).String(),
+				Flakiness: idx.FlakyDigestScore(parts[0], parts[1]),
+			})
+			continue
+		}
+		ret = append(ret, digestFromIntermediate(parts[0], parts[1], i, e, tile, idx, storages.DiffStore, q.IncludeIgnores, q.diffMetric()))
 	}
 	return ret, tile.Commits, nil
 }
 
-func digestFromIntermediate(test, digest string, inter *intermediate, e *expstorage.Expectations, tile *tiling.Tile, idx *indexer.SearchIndex, diffStore diff.DiffStore, includeIgnores bool) *Digest {
+func digestFromIntermediate(test, digest string, inter *intermediate, e *expstorage.Expectations, tile *tiling.Tile, idx *indexer.SearchIndex, diffStore diff.DiffStore, includeIgnores bool, metric string) *Digest {
 	traceTally := idx.TalliesByTrace()
 	ret := &Digest{
-		Test:     test,
-		Digest:   digest,
-		Status:   e.Classification(test, digest).String(),
-		ParamSet: idx.GetParamsetSummary(test, digest, includeIgnores),
-		Traces:   buildTraces(test, digest, inter.Traces, e, tile, traceTally),
-		Diff:     buildDiff(test, digest, e, tile, idx.TalliesByTest(), diffStore, idx, includeIgnores),
+		Test:      test,
+		Digest:    digest,
+		Status:    e.Classification(test, digest).String(),
+		ParamSet:  idx.GetParamsetSummary(test, digest, includeIgnores),
+		Traces:    buildTraces(test, digest, inter.Traces, e, tile, traceTally),
+		Diff:      buildDiff(test, digest, e, tile, idx.TalliesByTest(), diffStore, idx, includeIgnores, metric),
+		Flakiness: idx.FlakyDigestScore(test, digest),
 	}
 	return ret
 }
 
-// buildDiff creates a Diff for the given intermediate.
-func buildDiff(test, digest string, e *expstorage.Expectations, tile *tiling.Tile, testTally map[string]tally.Tally, diffStore diff.DiffStore, idx *indexer.SearchIndex, includeIgnores bool) *Diff {
+// buildDiff creates a Diff for the given intermediate, where the closest
+// digests are found with the given diff metric.
+func buildDiff(test, digest string, e *expstorage.Expectations, tile *tiling.Tile, testTally map[string]tally.Tally, diffStore diff.DiffStore, idx *indexer.SearchIndex, includeIgnores bool, metric string) *Diff {
 	ret := &Diff{
-		Diff: math.MaxFloat32,
-		Pos:  nil,
-		Neg:  nil,
+		Diff:   math.MaxFloat32,
+		Metric: metric,
+		Pos:    nil,
+		Neg:    nil,
 	}
 
 	if tile != nil {
@@ -408,7 +576,7 @@ func buildDiff(test, digest string, e *expstorage.Expectations, tile *tiling.Til
 	}
 
 	var diffVal float32 = 0
-	if closest := digesttools.ClosestDigest(test, digest, e, t, diffStore, types.POSITIVE); closest.Digest != "" {
+	if closest := digesttools.ClosestDigestByMetric(test, digest, e, t, diffStore, types.POSITIVE, metric); closest.Digest != "" {
 		ret.Pos = &DiffDigest{
 			Closest: closest,
 		}
@@ -416,7 +584,7 @@ func buildDiff(test, digest string, e *expstorage.Expectations, tile *tiling.Til
 		diffVal = closest.Diff
 	}
 
-	if closest := digesttools.ClosestDigest(test, digest, e, t, diffStore, types.NEGATIVE); closest.Digest != "" {
+	if closest := digesttools.ClosestDigestByMetric(test, digest, e, t, diffStore, types.NEGATIVE, metric); closest.Digest != "" {
 		ret.Neg = &DiffDigest{
 			Closest: closest,
 		}
@@ -575,15 +743,16 @@ type DigestDiff struct {
 }
 
 // CompareDigests compares two digests that were generated by the given test. It returns
-// an instance of DigestDiff.
-func CompareDigests(test, left, right string, storages *storage.Storage, idx *indexer.SearchIndex) (*DigestDiff, error) {
+// an instance of DigestDiff. If issue is not empty the digests are classified with the
+// expectations of that code review issue.
+func CompareDigests(test, left, right, issue string, storages *storage.Storage, idx *indexer.SearchIndex) (*DigestDiff, error) {
 	// Get the diff between the two digests
 	diff, err := storages.DiffStore.Get(left, []string{right})
 	if err != nil {
 		return nil, err
 	}
 
-	exp, err := storages.ExpectationsStore.Get()
+	exp, err := storages.GetExpectations(issue)
 	if err != nil {
 		return nil, err
 	}
@@ -612,10 +781,12 @@ type DigestDetails struct {
 }
 
 // GetDigestDetails returns details about a digest as an instance of DigestDetails.
-func GetDigestDetails(test, digest string, storages *storage.Storage, idx *indexer.SearchIndex) (*DigestDetails, error) {
+// If issue is not empty the digest is classified with the expectations of that
+// code review issue.
+func GetDigestDetails(test, digest, issue string, storages *storage.Storage, idx *indexer.SearchIndex) (*DigestDetails, error) {
 	tile := idx.GetTile(true)
 
-	exp, err := storages.ExpectationsStore.Get()
+	exp, err := storages.GetExpectations(issue)
 	if err != nil {
 		return nil, err
 	}
@@ -640,7 +811,7 @@ func GetDigestDetails(test, digest string, storages *storage.Storage, idx *index
 			Status:   exp.Classification(test, digest).String(),
 			ParamSet: idx.GetParamsetSummary(test, digest, true),
 			Traces:   buildTraces(test, digest, traces, exp, tile, idx.TalliesByTrace()),
-			Diff:     buildDiff(test, digest, exp, nil, idx.TalliesByTest(), storages.DiffStore, idx, true),
+			Diff:     buildDiff(test, digest, exp, nil, idx.TalliesByTest(), storages.DiffStore, idx, true, diffstore.METRIC_COMBINED),
 		},
 		Commits: tile.Commits,
 	}, nil
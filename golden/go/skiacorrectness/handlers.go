@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"sort"
@@ -16,14 +17,18 @@ import (
 	"go.skia.org/infra/go/httputils"
 	"go.skia.org/infra/go/human"
 	"go.skia.org/infra/go/login"
+	"go.skia.org/infra/go/query"
 	"go.skia.org/infra/go/tiling"
 	"go.skia.org/infra/go/timer"
 	"go.skia.org/infra/go/util"
 	"go.skia.org/infra/golden/go/blame"
 	"go.skia.org/infra/golden/go/diff"
+	"go.skia.org/infra/golden/go/diffstore"
 	"go.skia.org/infra/golden/go/expstorage"
+	"go.skia.org/infra/golden/go/flaky"
 	"go.skia.org/infra/golden/go/ignore"
 	"go.skia.org/infra/golden/go/indexer"
+	"go.skia.org/infra/golden/go/savedsearch"
 	"go.skia.org/infra/golden/go/search"
 	"go.skia.org/infra/golden/go/summary"
 	"go.skia.org/infra/golden/go/trybot"
@@ -36,6 +41,10 @@ const (
 
 	// MAX_PAGE_SIZE is the maximum page size used for pagination.
 	MAX_PAGE_SIZE = 100
+
+	// DEFAULT_IGNORE_EXTENSION is used to extend or renew an ignore rule if no
+	// duration is provided.
+	DEFAULT_IGNORE_EXTENSION = "1w"
 )
 
 // TODO(stephana): once the byBlameHandler is removed, refactor this to
@@ -155,7 +164,7 @@ func allUntriagedSummaries(idx *indexer.SearchIndex, query url.Values) (*tiling.
 	tile := idx.GetTile(true)
 
 	// Get a list of all untriaged images by test.
-	sum, err := idx.CalcSummaries([]string{}, query, false, true)
+	sum, err := idx.CalcSummaries([]string{}, query, false, true, "")
 	if err != nil {
 		return nil, nil, fmt.Errorf("Couldn't load summaries: %s", err)
 	}
@@ -273,19 +282,20 @@ func adaptIssueResponse(ir *search.IssueResponse) *IssueSearchResult {
 
 // jsonDetailsHandler returns the details about a single digest.
 func jsonDetailsHandler(w http.ResponseWriter, r *http.Request) {
-	// Extract: test, digest.
+	// Extract: test, digest, issue.
 	if err := r.ParseForm(); err != nil {
 		httputils.ReportError(w, r, err, "Failed to parse form values")
 		return
 	}
 	test := r.Form.Get("test")
 	digest := r.Form.Get("digest")
+	issue := r.Form.Get("issue")
 	if test == "" || digest == "" {
 		httputils.ReportError(w, r, fmt.Errorf("Some query parameters are missing: %q %q", test, digest), "Missing query parameters.")
 		return
 	}
 
-	ret, err := search.GetDigestDetails(test, digest, storages, ixr.GetIndex())
+	ret, err := search.GetDigestDetails(test, digest, issue, storages, ixr.GetIndex())
 	if err != nil {
 		httputils.ReportError(w, r, err, "Unable to get digest details.")
 		return
@@ -295,7 +305,7 @@ func jsonDetailsHandler(w http.ResponseWriter, r *http.Request) {
 
 // jsonDiffHandler returns difference between two digests.
 func jsonDiffHandler(w http.ResponseWriter, r *http.Request) {
-	// Extract: test, left, right where left and right are digests.
+	// Extract: test, left, right where left and right are digests, and issue.
 	if err := r.ParseForm(); err != nil {
 		httputils.ReportError(w, r, err, "Failed to parse form values")
 		return
@@ -303,12 +313,13 @@ func jsonDiffHandler(w http.ResponseWriter, r *http.Request) {
 	test := r.Form.Get("test")
 	left := r.Form.Get("left")
 	right := r.Form.Get("right")
+	issue := r.Form.Get("issue")
 	if test == "" || left == "" || right == "" {
 		httputils.ReportError(w, r, fmt.Errorf("Some query parameters are missing: %q %q %q", test, left, right), "Missing query parameters.")
 		return
 	}
 
-	ret, err := search.CompareDigests(test, left, right, storages, ixr.GetIndex())
+	ret, err := search.CompareDigests(test, left, right, issue, storages, ixr.GetIndex())
 	if err != nil {
 		httputils.ReportError(w, r, err, "Unable to compare digests")
 		return
@@ -322,6 +333,7 @@ type IgnoresRequest struct {
 	Duration string `json:"duration"`
 	Filter   string `json:"filter"`
 	Note     string `json:"note"`
+	Owner    string `json:"owner"`
 }
 
 // jsonIgnoresHandler returns the current ignore rules in JSON format.
@@ -374,6 +386,9 @@ func jsonIgnoresUpdateHandler(w http.ResponseWriter, r *http.Request) {
 		httputils.ReportError(w, r, err, "Failed to create ignore rule.")
 		return
 	}
+	if req.Owner != "" {
+		ignoreRule.Owner = req.Owner
+	}
 	ignoreRule.ID = int(id)
 
 	err = storages.IgnoreStore.Update(int(id), ignoreRule)
@@ -407,6 +422,83 @@ func jsonIgnoresDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// jsonIgnoresExtendHandler pushes the expiration of an ignore rule further
+// out by the submitted duration. Expired rules are extended from now on.
+func jsonIgnoresExtendHandler(w http.ResponseWriter, r *http.Request) {
+	changeIgnoreExpiration(w, r, false)
+}
+
+// jsonIgnoresRenewHandler sets the expiration of an ignore rule to now plus
+// the submitted duration.
+func jsonIgnoresRenewHandler(w http.ResponseWriter, r *http.Request) {
+	changeIgnoreExpiration(w, r, true)
+}
+
+// changeIgnoreExpiration implements jsonIgnoresExtendHandler and
+// jsonIgnoresRenewHandler. The request body is optional, if no duration is
+// provided DEFAULT_IGNORE_EXTENSION is used.
+func changeIgnoreExpiration(w http.ResponseWriter, r *http.Request, renew bool) {
+	user := login.LoggedInAs(r)
+	if user == "" {
+		httputils.ReportError(w, r, fmt.Errorf("Not logged in."), "You must be logged in to extend an ignore rule.")
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 0)
+	if err != nil {
+		httputils.ReportError(w, r, err, "ID must be valid integer.")
+		return
+	}
+	req := &IgnoresRequest{}
+	if err := parseJson(r, req); err != nil && err != io.EOF {
+		httputils.ReportError(w, r, err, "Failed to parse submitted data.")
+		return
+	}
+	if req.Duration == "" {
+		req.Duration = DEFAULT_IGNORE_EXTENSION
+	}
+	d, err := human.ParseDuration(req.Duration)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to parse duration")
+		return
+	}
+
+	rule, err := storages.IgnoreStore.Get(int(id))
+	if err != nil {
+		httputils.ReportError(w, r, err, "Unable to retrieve ignore rule.")
+		return
+	}
+	if rule == nil {
+		httputils.ReportError(w, r, fmt.Errorf("Unknown ignore rule: %d", id), "Unable to find ignore rule.")
+		return
+	}
+
+	start := time.Now()
+	if !renew && rule.Expires.After(start) {
+		start = rule.Expires
+	}
+	if err := storages.IgnoreStore.Extend(int(id), start.Add(d), user); err != nil {
+		httputils.ReportError(w, r, err, "Unable to extend ignore rule.")
+		return
+	}
+
+	jsonIgnoresHandler(w, r)
+}
+
+// jsonIgnoresHistoryHandler returns the audit history of an ignore rule.
+func jsonIgnoresHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 0)
+	if err != nil {
+		httputils.ReportError(w, r, err, "ID must be valid integer.")
+		return
+	}
+	history, err := storages.IgnoreStore.History(int(id))
+	if err != nil {
+		httputils.ReportError(w, r, err, "Unable to retrieve ignore rule history.")
+		return
+	}
+	sendJsonResponse(w, history)
+}
+
 // jsonIgnoresAddHandler is for adding a new ignore rule.
 func jsonIgnoresAddHandler(w http.ResponseWriter, r *http.Request) {
 	user := login.LoggedInAs(r)
@@ -433,6 +525,9 @@ func jsonIgnoresAddHandler(w http.ResponseWriter, r *http.Request) {
 		httputils.ReportError(w, r, err, "Failed to create ignore rule.")
 		return
 	}
+	if req.Owner != "" {
+		ignoreRule.Owner = req.Owner
+	}
 
 	if err = storages.IgnoreStore.Create(ignoreRule); err != nil {
 		httputils.ReportError(w, r, err, "Failed to create ignore rule.")
@@ -442,6 +537,203 @@ func jsonIgnoresAddHandler(w http.ResponseWriter, r *http.Request) {
 	jsonIgnoresHandler(w, r)
 }
 
+// SavedSearchRequest is the request structure for adding and updating
+// saved searches.
+type SavedSearchRequest struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+// jsonSavedSearchesHandler returns all saved searches in JSON format.
+func jsonSavedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	searches, err := savedSearchStore.List()
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to retrieve saved searches.")
+		return
+	}
+	sendJsonResponse(w, searches)
+}
+
+// jsonSavedSearchesAddHandler adds a new saved search that is owned by the
+// logged in user.
+func jsonSavedSearchesAddHandler(w http.ResponseWriter, r *http.Request) {
+	user := login.LoggedInAs(r)
+	if user == "" {
+		httputils.ReportError(w, r, fmt.Errorf("Not logged in."), "You must be logged in to add a saved search.")
+		return
+	}
+	req := &SavedSearchRequest{}
+	if err := parseJson(r, req); err != nil {
+		httputils.ReportError(w, r, err, "Failed to parse submitted data.")
+		return
+	}
+
+	if err := savedSearchStore.Create(savedsearch.NewSavedSearch(req.Name, user, req.Query)); err != nil {
+		httputils.ReportError(w, r, err, "Failed to create saved search.")
+		return
+	}
+	jsonSavedSearchesHandler(w, r)
+}
+
+// jsonSavedSearchesUpdateHandler changes the name and query of a saved
+// search. Only the owner can change a saved search.
+func jsonSavedSearchesUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	found, ok := getOwnSavedSearch(w, r)
+	if !ok {
+		return
+	}
+	req := &SavedSearchRequest{}
+	if err := parseJson(r, req); err != nil {
+		httputils.ReportError(w, r, err, "Failed to parse submitted data.")
+		return
+	}
+
+	found.Name = req.Name
+	found.Query = req.Query
+	if err := savedSearchStore.Update(found); err != nil {
+		httputils.ReportError(w, r, err, "Unable to update saved search.")
+		return
+	}
+	jsonSavedSearchesHandler(w, r)
+}
+
+// jsonSavedSearchesDeleteHandler deletes a saved search. Only the owner can
+// delete a saved search.
+func jsonSavedSearchesDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	found, ok := getOwnSavedSearch(w, r)
+	if !ok {
+		return
+	}
+	if _, err := savedSearchStore.Delete(found.ID); err != nil {
+		httputils.ReportError(w, r, err, "Unable to delete saved search.")
+		return
+	}
+	jsonSavedSearchesHandler(w, r)
+}
+
+// getOwnSavedSearch returns the saved search identified by the 'id' path
+// parameter if it is owned by the logged in user. Otherwise it writes an
+// error response and returns false.
+func getOwnSavedSearch(w http.ResponseWriter, r *http.Request) (*savedsearch.SavedSearch, bool) {
+	user := login.LoggedInAs(r)
+	if user == "" {
+		httputils.ReportError(w, r, fmt.Errorf("Not logged in."), "You must be logged in to change a saved search.")
+		return nil, false
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 0)
+	if err != nil {
+		httputils.ReportError(w, r, err, "ID must be valid integer.")
+		return nil, false
+	}
+	found, err := savedSearchStore.Get(int(id))
+	if err != nil {
+		httputils.ReportError(w, r, err, "Unable to retrieve saved search.")
+		return nil, false
+	}
+	if found == nil {
+		httputils.ReportError(w, r, fmt.Errorf("Unknown saved search: %d", id), "Saved search does not exist.")
+		return nil, false
+	}
+	if found.Owner != user {
+		httputils.ReportError(w, r, fmt.Errorf("%s is not the owner of saved search %d", user, id), "Only the owner can change a saved search.")
+		return nil, false
+	}
+	return found, true
+}
+
+// FuzzyRulesRequest is the request structure for adding and updating fuzzy
+// triage rules.
+type FuzzyRulesRequest struct {
+	Filter        string `json:"filter"`
+	MaxDiffPixels int    `json:"maxDiffPixels"`
+	MaxRGBADelta  int    `json:"maxRGBADelta"`
+	Note          string `json:"note"`
+}
+
+// jsonFuzzyRulesHandler returns the current fuzzy triage rules in JSON format.
+func jsonFuzzyRulesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	rules, err := storages.FuzzyRuleStore.List()
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to retrieve fuzzy rules.")
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(rules); err != nil {
+		glog.Errorf("Failed to write or encode result: %s", err)
+	}
+}
+
+// jsonFuzzyRulesAddHandler is for adding a new fuzzy triage rule.
+func jsonFuzzyRulesAddHandler(w http.ResponseWriter, r *http.Request) {
+	user := login.LoggedInAs(r)
+	if user == "" {
+		httputils.ReportError(w, r, fmt.Errorf("Not logged in."), "You must be logged in to add a fuzzy rule.")
+		return
+	}
+	req := &FuzzyRulesRequest{}
+	if err := parseJson(r, req); err != nil {
+		httputils.ReportError(w, r, err, "Failed to parse submitted data.")
+		return
+	}
+	rule := ignore.NewFuzzyRule(user, req.Filter, req.MaxDiffPixels, req.MaxRGBADelta, req.Note)
+	if err := storages.FuzzyRuleStore.Create(rule); err != nil {
+		httputils.ReportError(w, r, err, "Failed to create fuzzy rule.")
+		return
+	}
+
+	jsonFuzzyRulesHandler(w, r)
+}
+
+// jsonFuzzyRulesUpdateHandler updates an existing fuzzy triage rule.
+func jsonFuzzyRulesUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	user := login.LoggedInAs(r)
+	if user == "" {
+		httputils.ReportError(w, r, fmt.Errorf("Not logged in."), "You must be logged in to update a fuzzy rule.")
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 0)
+	if err != nil {
+		httputils.ReportError(w, r, err, "ID must be valid integer.")
+		return
+	}
+	req := &FuzzyRulesRequest{}
+	if err := parseJson(r, req); err != nil {
+		httputils.ReportError(w, r, err, "Failed to parse submitted data.")
+		return
+	}
+	rule := ignore.NewFuzzyRule(user, req.Filter, req.MaxDiffPixels, req.MaxRGBADelta, req.Note)
+	rule.ID = int(id)
+	if err := storages.FuzzyRuleStore.Update(int(id), rule); err != nil {
+		httputils.ReportError(w, r, err, "Unable to update fuzzy rule.")
+		return
+	}
+
+	jsonFuzzyRulesHandler(w, r)
+}
+
+// jsonFuzzyRulesDeleteHandler deletes an existing fuzzy triage rule.
+func jsonFuzzyRulesDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	user := login.LoggedInAs(r)
+	if user == "" {
+		httputils.ReportError(w, r, fmt.Errorf("Not logged in."), "You must be logged in to delete a fuzzy rule.")
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 0)
+	if err != nil {
+		httputils.ReportError(w, r, err, "ID must be valid integer.")
+		return
+	}
+
+	if _, err = storages.FuzzyRuleStore.Delete(int(id), user); err != nil {
+		httputils.ReportError(w, r, err, "Unable to delete fuzzy rule.")
+	} else {
+		jsonFuzzyRulesHandler(w, r)
+	}
+}
+
 // TODO(stephana): Triage by query is not used on the front-end and we should
 // see if we can remove it from jsonTriageHandler.
 
@@ -456,6 +748,11 @@ type TriageRequest struct {
 	Filter           string                       `json:"filter"`
 	Include          bool                         `json:"include"` // Include ignored digests.
 	Head             bool                         `json:"head"`    // Only include digests at head if true.
+	Issue            string                       `json:"issue"`   // If not empty, triage in the context of this code review issue.
+
+	// GroupFlaky expands the request to all digests that are produced by the
+	// same flaky traces as the listed digests, see flaky.Flaky.Group.
+	GroupFlaky bool `json:"groupFlaky"`
 }
 
 // jsonTriageHandler handles a request to change the triage status of one or more
@@ -477,11 +774,16 @@ func jsonTriageHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	glog.Infof("Triage request: %#v", req)
 
+	if req.Issue != "" && storages.IssueExpStore == nil {
+		httputils.ReportError(w, r, fmt.Errorf("No issue expectations store."), "Triaging for issues is not supported.")
+		return
+	}
+
 	var tc map[string]types.TestClassification
 
 	// Build the expectations change request from filter, query, and include.
 	if req.All {
-		exp, err := storages.ExpectationsStore.Get()
+		exp, err := storages.GetExpectations(req.Issue)
 		if err != nil {
 			httputils.ReportError(w, r, err, "Failed to load expectations.")
 			return
@@ -518,7 +820,29 @@ func jsonTriageHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if err := storages.ExpectationsStore.AddChange(tc, user); err != nil {
+	if req.GroupFlaky {
+		// Only untriaged digests are added to the group, so the expectations
+		// that are being edited decide which digests are still untriaged.
+		exp, err := storages.GetExpectations(req.Issue)
+		if err != nil {
+			httputils.ReportError(w, r, err, "Failed to load expectations.")
+			return
+		}
+		idx := ixr.GetIndex()
+		group := func(test, digest string) []string {
+			return idx.FlakyGroup(test, digest, flaky.DEFAULT_GROUP_THRESHOLD)
+		}
+		tc = flaky.GroupDigests(tc, group, exp.Classification)
+	}
+
+	// Triage for an issue only goes live once the issue lands.
+	var err error
+	if req.Issue != "" {
+		err = storages.IssueExpStore.AddChange(req.Issue, tc, user)
+	} else {
+		err = storages.ExpectationsStore.AddChange(tc, user)
+	}
+	if err != nil {
 		httputils.ReportError(w, r, err, "Failed to store the updated expectations.")
 		return
 	}
@@ -538,11 +862,15 @@ func jsonTriageHandler(w http.ResponseWriter, r *http.Request) {
 // If head is true then only return digests that appear at head.
 // If includeIgnores it true, ignored traces are included.
 func filterDigests(filter, queryString, testName string, e types.TestClassification, includeIgnores bool, head bool) ([]string, error) {
-	query, err := url.ParseQuery(queryString)
+	values, err := url.ParseQuery(queryString)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to parse Query in imgInfo: %s", err)
 	}
-	query[types.PRIMARY_KEY_FIELD] = []string{testName}
+	values[types.PRIMARY_KEY_FIELD] = []string{testName}
+	q, err := query.New(values)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Query in imgInfo: %s", err)
+	}
 
 	idx := ixr.GetIndex()
 	t := timer.New("finding digests")
@@ -551,7 +879,7 @@ func filterDigests(filter, queryString, testName string, e types.TestClassificat
 		tile := idx.GetTile(includeIgnores)
 		lastCommitIndex := tile.LastCommitIndex()
 		for _, tr := range tile.Traces {
-			if tiling.Matches(tr, query) {
+			if q.MatchesParams(tr.Params()) {
 				for i := lastCommitIndex; i >= 0; i-- {
 					if tr.IsMissing(i) {
 						continue
@@ -563,7 +891,7 @@ func filterDigests(filter, queryString, testName string, e types.TestClassificat
 			}
 		}
 	} else {
-		digests = idx.TalliesByQuery(query, includeIgnores)
+		digests = idx.TalliesByQuery(values, includeIgnores)
 	}
 	t.Stop()
 
@@ -742,7 +1070,7 @@ func jsonListTestsHandler(w http.ResponseWriter, r *http.Request) {
 	idx := ixr.GetIndex()
 	corpus, hasSourceType := query.Query[types.CORPUS_FIELD]
 	sumSlice := []*summary.Summary{}
-	if !query.IncludeIgnores && query.Head && len(query.Query) == 1 && hasSourceType {
+	if !query.IncludeIgnores && query.Head && len(query.Query) == 1 && hasSourceType && query.Issue == "" {
 		sumMap := idx.GetSummaries()
 		for _, s := range sumMap {
 			if util.In(s.Corpus, corpus) && includeSummary(s, &query) {
@@ -751,7 +1079,7 @@ func jsonListTestsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		glog.Infof("%q %q %q", r.FormValue("query"), r.FormValue("include"), r.FormValue("head"))
-		sumMap, err := idx.CalcSummaries(nil, query.Query, query.IncludeIgnores, query.Head)
+		sumMap, err := idx.CalcSummaries(nil, query.Query, query.IncludeIgnores, query.Head, query.Issue)
 		if err != nil {
 			httputils.ReportError(w, r, err, "Failed to calculate summaries.")
 			return
@@ -788,43 +1116,33 @@ func (p SummarySlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 // way to parse input parameters for search-like endpoints.
 // Remove the "Limit" field and replace with pagination.
 
-// parseQuery parses the request parameters,
+// parseQuery parses the request parameters, see search.ParseQuery.
 func parseQuery(r *http.Request, query *search.Query) error {
-	// Get the limit
-	if l := r.FormValue("limit"); l != "" {
-		limit, err := strconv.Atoi(l)
-		if err != nil {
-			return fmt.Errorf("Unable to parse a limit of: %s", l)
-		}
-		query.Limit = limit
+	if err := r.ParseForm(); err != nil {
+		return fmt.Errorf("Unable to parse request parameters: %s", err)
 	}
+	return search.ParseQuery(r.Form, query)
+}
 
-	// Parse the query
-	var err error
-	query.Query = url.Values{}
-	if q := r.FormValue("query"); q != "" {
-		query.Query, err = url.ParseQuery(q)
-		if err != nil {
-			return fmt.Errorf("Unable to parse query: %s. Error: %s", q, err)
-		}
+// jsonIssueExpectationsHandler returns the expectations that were triaged for
+// the issue given by the 'id' path variable and are not merged into master yet.
+func jsonIssueExpectationsHandler(w http.ResponseWriter, r *http.Request) {
+	if storages.IssueExpStore == nil {
+		httputils.ReportError(w, r, fmt.Errorf("No issue expectations store."), "Issue expectations are not supported.")
+		return
 	}
-
-	// Parse out the patchsets.
-	if temp := r.FormValue("patchsets"); temp != "" {
-		patchsets := strings.Split(temp, ",")
-		query.Patchsets = patchsets
+	delta, err := storages.IssueExpStore.GetDelta(mux.Vars(r)["id"])
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to retrieve the issue expectations.")
+		return
 	}
+	sendJsonResponse(w, delta)
+}
 
-	query.BlameGroupID = r.FormValue("blame")
-	query.Pos = r.FormValue("pos") == "true"
-	query.Neg = r.FormValue("neg") == "true"
-	query.Unt = r.FormValue("unt") == "true"
-	query.Head = r.FormValue("head") == "true"
-	query.IncludeIgnores = r.FormValue("include") == "true"
-	query.Issue = r.FormValue("issue")
-	query.IncludeMaster = r.FormValue("master") == "true"
-
-	return nil
+// jsonDiffMetricsHandler returns the ids of the diff metrics that can be used
+// to search and sort digests.
+func jsonDiffMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	sendJsonResponse(w, diffstore.GetDiffMetricIDs())
 }
 
 // FailureList contains the list of the digests that could not be processed
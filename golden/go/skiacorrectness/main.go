@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -17,6 +18,7 @@ import (
 	"go.skia.org/infra/go/auth"
 	"go.skia.org/infra/go/common"
 	"go.skia.org/infra/go/database"
+	"go.skia.org/infra/go/email"
 	"go.skia.org/infra/go/eventbus"
 	"go.skia.org/infra/go/fileutil"
 	"go.skia.org/infra/go/gitinfo"
@@ -35,10 +37,12 @@ import (
 	"go.skia.org/infra/golden/go/digeststore"
 	"go.skia.org/infra/golden/go/expstorage"
 	"go.skia.org/infra/golden/go/filediffstore"
+	"go.skia.org/infra/golden/go/flaky"
 	"go.skia.org/infra/golden/go/goldingestion"
 	"go.skia.org/infra/golden/go/history"
 	"go.skia.org/infra/golden/go/ignore"
 	"go.skia.org/infra/golden/go/indexer"
+	"go.skia.org/infra/golden/go/savedsearch"
 	"go.skia.org/infra/golden/go/status"
 	"go.skia.org/infra/golden/go/storage"
 	"go.skia.org/infra/golden/go/trybot"
@@ -50,7 +54,11 @@ import (
 var (
 	authWhiteList      = flag.String("auth_whitelist", login.DEFAULT_DOMAIN_WHITELIST, "White space separated list of domains and email addresses that are allowed to login.")
 	cpuProfile         = flag.Duration("cpu_profile", 0, "Duration for which to profile the CPU usage. After this duration the program writes the CPU profile and exits.")
+	emailClientId      = flag.String("email_clientid", "", "OAuth Client ID for sending email. If empty no expiration warnings for ignore rules and no saved search notifications are sent.")
+	emailClientSecret  = flag.String("email_clientsecret", "", "OAuth Client Secret for sending email.")
+	emailTokenCache    = flag.String("email_token_cache_file", "/home/perf/gmail_token.data", "Path to the file where to cache the oauth credentials for sending email.")
 	doOauth            = flag.Bool("oauth", true, "Run through the OAuth 2.0 flow on startup, otherwise use a GCE service account.")
+	flakyWindow        = flag.Int("flaky_window", flaky.DEFAULT_WINDOW, "Number of recent commits used to determine how flaky a trace is.")
 	forceLogin         = flag.Bool("force_login", false, "Force the user to be authenticated for all requests.")
 	gsBucketName       = flag.String("gs_bucket", "chromium-skia-gm", "Name of the google storage bucket that holds uploaded images.")
 	imageDir           = flag.String("image_dir", "/tmp/imagedir", "What directory to store test and diff images in.")
@@ -67,6 +75,7 @@ var (
 	resourcesDir       = flag.String("resources_dir", "", "The directory to find templates, JS, and CSS files. If blank the directory relative to the source code files will be used.")
 	rietveldURL        = flag.String("rietveld_url", "https://codereview.chromium.org/", "URL of the Rietveld instance where we retrieve CL metadata.")
 	storageDir         = flag.String("storage_dir", "/tmp/gold-storage", "Directory to store reproducible application data.")
+	ignoreWarnAhead    = flag.Duration("ignore_warn_ahead", 3*24*time.Hour, "How long before an ignore rule expires its owner is notified via email.")
 	gitRepoDir         = flag.String("git_repo_dir", "../../../skia", "Directory location for the Skia repo.")
 	gitRepoURL         = flag.String("git_repo_url", "https://skia.googlesource.com/skia", "The URL to pass to git clone for the source repository.")
 	serviceAccountFile = flag.String("service_account_file", "", "Credentials file for service account.")
@@ -108,6 +117,7 @@ var (
 	statusWatcher      *status.StatusWatcher
 	ixr                *indexer.Indexer
 	issueTracker       issues.IssueTracker
+	savedSearchStore   savedsearch.Store
 )
 
 // sendResponse wraps the data of a succesful response in a response envelope
@@ -343,6 +353,7 @@ func main() {
 		BranchTileBuilder: branchTileBuilder,
 		DigestStore:       digestStore,
 		NCommits:          *nCommits,
+		FlakyWindow:       *flakyWindow,
 		EventBus:          evt,
 		TrybotResults:     trybot.NewTrybotResults(branchTileBuilder, rietveldAPI, ingestionStore),
 		RietveldAPI:       rietveldAPI,
@@ -350,6 +361,12 @@ func main() {
 
 	// TODO(stephana): Remove this workaround to avoid circular dependencies once the 'storage' module is cleaned up.
 	storages.IgnoreStore = ignore.NewSQLIgnoreStore(vdb, storages.ExpectationsStore, storages.GetTileStreamNow(time.Minute))
+	storages.FuzzyRuleStore = ignore.NewSQLFuzzyRuleStore(vdb)
+	storages.IssueExpStore = expstorage.NewSQLIssueExpectationsStore(vdb, storages.ExpectationsStore)
+
+	// Merge the expectations of issues once they land. The repository is
+	// kept up to date by the masterTileBuilder.
+	expstorage.StartIssueMerger(storages.IssueExpStore, git, 5*time.Minute)
 
 	if err := history.Init(storages, *nTilesToBackfill); err != nil {
 		glog.Fatalf("Unable to initialize history package: %s", err)
@@ -359,6 +376,29 @@ func main() {
 		glog.Fatalf("Failed to start monitoring for expired ignore rules: %s", err)
 	}
 
+	siteURL := strings.TrimSuffix(useRedirectURL, OAUTH2_CALLBACK_PATH)
+
+	// Warn the owners of ignore rules before their rules expire. The email
+	// client is also used to notify the owners of saved searches.
+	var emailer savedsearch.Emailer
+	if *emailClientId != "" {
+		gmail, err := email.NewGMail(*emailClientId, *emailClientSecret, *emailTokenCache)
+		if err != nil {
+			glog.Fatalf("Failed to create email client: %s", err)
+		}
+		emailer = gmail
+		notifier := ignore.NewExpiryNotifier(storages.IgnoreStore, gmail, *ignoreWarnAhead, siteURL+"/ignores")
+		ignore.StartExpiryNotifier(notifier, time.Hour)
+	} else {
+		glog.Warningf("No email credentials provided. Not sending expiration warnings for ignore rules or saved search notifications.")
+	}
+
+	// Re-evaluate the saved searches whenever the indexer has processed a new
+	// tile. This needs to be set up before the indexer is created so the
+	// first tile is evaluated as well.
+	savedSearchStore = savedsearch.NewSQLStore(vdb)
+	savedsearch.NewWatcher(storages, savedSearchStore, emailer, siteURL).Start()
+
 	// Rebuild the index every two minutes.
 	ixr, err = indexer.New(storages, 2*time.Minute)
 	if err != nil {
@@ -408,12 +448,25 @@ func main() {
 	router.HandleFunc("/json/list", jsonListTestsHandler).Methods("GET")
 	router.HandleFunc("/json/paramset", jsonParamsHandler).Methods("GET")
 	router.HandleFunc("/json/search", jsonSearchHandler).Methods("GET")
+	router.HandleFunc("/json/diffmetrics", jsonDiffMetricsHandler).Methods("GET")
+	router.HandleFunc("/json/issueexp/{id}", jsonIssueExpectationsHandler).Methods("GET")
 	router.HandleFunc("/json/diff", jsonDiffHandler).Methods("GET")
 	router.HandleFunc("/json/details", jsonDetailsHandler).Methods("GET")
 	router.HandleFunc("/json/ignores", jsonIgnoresHandler).Methods("GET")
 	router.HandleFunc("/json/ignores/add/", jsonIgnoresAddHandler).Methods("POST")
 	router.HandleFunc("/json/ignores/del/{id}", jsonIgnoresDeleteHandler).Methods("POST")
 	router.HandleFunc("/json/ignores/save/{id}", jsonIgnoresUpdateHandler).Methods("POST")
+	router.HandleFunc("/json/ignores/extend/{id}", jsonIgnoresExtendHandler).Methods("POST")
+	router.HandleFunc("/json/ignores/renew/{id}", jsonIgnoresRenewHandler).Methods("POST")
+	router.HandleFunc("/json/ignores/history/{id}", jsonIgnoresHistoryHandler).Methods("GET")
+	router.HandleFunc("/json/savedsearches", jsonSavedSearchesHandler).Methods("GET")
+	router.HandleFunc("/json/savedsearches/add/", jsonSavedSearchesAddHandler).Methods("POST")
+	router.HandleFunc("/json/savedsearches/save/{id}", jsonSavedSearchesUpdateHandler).Methods("POST")
+	router.HandleFunc("/json/savedsearches/del/{id}", jsonSavedSearchesDeleteHandler).Methods("POST")
+	router.HandleFunc("/json/fuzzyrules", jsonFuzzyRulesHandler).Methods("GET")
+	router.HandleFunc("/json/fuzzyrules/add/", jsonFuzzyRulesAddHandler).Methods("POST")
+	router.HandleFunc("/json/fuzzyrules/del/{id}", jsonFuzzyRulesDeleteHandler).Methods("POST")
+	router.HandleFunc("/json/fuzzyrules/save/{id}", jsonFuzzyRulesUpdateHandler).Methods("POST")
 	router.HandleFunc("/json/triage", jsonTriageHandler).Methods("POST")
 	router.HandleFunc("/json/clusterdiff", jsonClusterDiffHandler).Methods("GET")
 	router.HandleFunc("/json/triagelog", jsonTriageLogHandler).Methods("GET")
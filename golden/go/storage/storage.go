@@ -7,6 +7,7 @@ import (
 
 	"github.com/skia-dev/glog"
 	"go.skia.org/infra/go/eventbus"
+	"go.skia.org/infra/go/query"
 	"go.skia.org/infra/go/rietveld"
 	"go.skia.org/infra/go/tiling"
 	tracedb "go.skia.org/infra/go/trace/db"
@@ -23,7 +24,9 @@ import (
 type Storage struct {
 	DiffStore         diff.DiffStore
 	ExpectationsStore expstorage.ExpectationsStore
+	IssueExpStore     expstorage.IssueExpectationsStore
 	IgnoreStore       ignore.IgnoreStore
+	FuzzyRuleStore    ignore.FuzzyRuleStore
 	MasterTileBuilder tracedb.MasterTileBuilder
 	BranchTileBuilder tracedb.BranchTileBuilder
 	DigestStore       digeststore.DigestStore
@@ -35,6 +38,10 @@ type Storage struct {
 	// 0 or smaller all commits in the last tile will be considered.
 	NCommits int
 
+	// FlakyWindow is the number of recent commits that are used to determine
+	// how flaky a trace is. If it is 1 or smaller flaky.DEFAULT_WINDOW is used.
+	FlakyWindow int
+
 	// Internal variables used to cache trimmed tiles.
 	lastTrimmedTile        *tiling.Tile
 	lastTrimmedIgnoredTile *tiling.Tile
@@ -76,6 +83,16 @@ func (s *Storage) GetTileStreamNow(interval time.Duration) <-chan *types.TilePai
 	return retCh
 }
 
+// GetExpectations returns the expectations of the given code review issue
+// overlaid on the master expectations. If issueID is empty or there is no
+// IssueExpStore the master expectations are returned.
+func (s *Storage) GetExpectations(issueID string) (*expstorage.Expectations, error) {
+	if issueID == "" || s.IssueExpStore == nil {
+		return s.ExpectationsStore.Get()
+	}
+	return s.IssueExpStore.Get(issueID)
+}
+
 // DrainChangeChannel removes everything from the channel thats currently
 // buffered or ready to be read.
 func DrainChangeChannel(ch <-chan []string) {
@@ -145,13 +162,21 @@ func FilterIgnored(inputTile *tiling.Tile, ignoreStore ignore.IgnoreStore) (*til
 	ret := inputTile.Copy()
 
 	// Then remove traces that should be ignored.
-	ignoreQueries, err := ignore.ToQuery(ignores)
+	ignoreValues, err := ignore.ToQuery(ignores)
 	if err != nil {
 		return nil, err
 	}
+	ignoreQueries := make([]*query.Query, 0, len(ignoreValues))
+	for _, v := range ignoreValues {
+		q, err := query.New(v)
+		if err != nil {
+			return nil, fmt.Errorf("Found an invalid ignore rule %v: %s", v, err)
+		}
+		ignoreQueries = append(ignoreQueries, q)
+	}
 	for id, tr := range ret.Traces {
 		for _, q := range ignoreQueries {
-			if tiling.Matches(tr, q) {
+			if q.MatchesParams(tr.Params()) {
 				delete(ret.Traces, id)
 				continue
 			}
@@ -9,6 +9,7 @@ import (
 	"sync"
 
 	"github.com/skia-dev/glog"
+	"go.skia.org/infra/go/query"
 	"go.skia.org/infra/go/tiling"
 	"go.skia.org/infra/go/timer"
 	"go.skia.org/infra/go/util"
@@ -124,18 +125,30 @@ type TraceID struct {
 //   Only consider digests at head if true.
 //
 func (s *Summaries) CalcSummaries(tile *tiling.Tile, testNames []string, query url.Values, head bool) (map[string]*Summary, error) {
+	return s.CalcIssueSummaries(tile, testNames, query, head, "")
+}
+
+// CalcIssueSummaries is like CalcSummaries, but classifies the digests with
+// the expectations of the given issue overlaid on the master expectations.
+// If issueID is empty only the master expectations are used.
+func (s *Summaries) CalcIssueSummaries(tile *tiling.Tile, testNames []string, values url.Values, head bool, issueID string) (map[string]*Summary, error) {
 	defer timer.New("CalcSummaries").Stop()
-	glog.Infof("CalcSummaries: head %v", head)
+	glog.Infof("CalcSummaries: head %v issue %q", head, issueID)
 
 	ret := map[string]*Summary{}
 
 	t := timer.New("CalcSummaries:Expectations")
-	e, err := s.storages.ExpectationsStore.Get()
+	e, err := s.storages.GetExpectations(issueID)
 	t.Stop()
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't get expectations: %s", err)
 	}
 
+	q, err := query.New(values)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid query: %s", err)
+	}
+
 	// Filter down to just the traces we are interested in, based on query.
 	filtered := map[string][]*TraceID{}
 	t = timer.New("Filter Traces")
@@ -144,7 +157,7 @@ func (s *Summaries) CalcSummaries(tile *tiling.Tile, testNames []string, query u
 		if len(testNames) > 0 && !util.In(name, testNames) {
 			continue
 		}
-		if tiling.Matches(tr, query) {
+		if q.MatchesParams(tr.Params()) {
 			if slice, ok := filtered[name]; ok {
 				filtered[name] = append(slice, &TraceID{tr: tr, id: id})
 			} else {
@@ -209,11 +222,15 @@ type DigestInfo struct {
 //
 // Note that unlike CalcSummaries the results aren't restricted by test name.
 // Also note that the result can include positive and negative digests.
-func (s *Summaries) search(tile *tiling.Tile, query string, head bool, pos bool, neg bool, unt bool) ([]DigestInfo, error) {
-	q, err := url.ParseQuery(query)
+func (s *Summaries) search(tile *tiling.Tile, queryString string, head bool, pos bool, neg bool, unt bool) ([]DigestInfo, error) {
+	values, err := url.ParseQuery(queryString)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to parse Query in Search: %s", err)
 	}
+	q, err := query.New(values)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid query in Search: %s", err)
+	}
 
 	t := timer.New("Search:Expectations")
 	e, err := s.storages.ExpectationsStore.Get()
@@ -226,7 +243,7 @@ func (s *Summaries) search(tile *tiling.Tile, query string, head bool, pos bool,
 	filtered := map[string]tiling.Trace{}
 	t = timer.New("Filter Traces")
 	for id, tr := range tile.Traces {
-		if tiling.Matches(tr, q) {
+		if q.MatchesParams(tr.Params()) {
 			filtered[id] = tr
 		}
 	}
@@ -4,6 +4,7 @@ package tally
 import (
 	"net/url"
 
+	"go.skia.org/infra/go/query"
 	"go.skia.org/infra/go/tiling"
 	"go.skia.org/infra/go/timer"
 	"go.skia.org/infra/golden/go/types"
@@ -49,10 +50,15 @@ func (t *Tallies) ByQuery(tile *tiling.Tile, query url.Values) Tally {
 }
 
 // tallyBy does the actual work of ByQuery.
-func tallyBy(tile *tiling.Tile, traceTally map[string]Tally, query url.Values) Tally {
+func tallyBy(tile *tiling.Tile, traceTally map[string]Tally, values url.Values) Tally {
 	ret := Tally{}
+	// Invalid queries don't match anything.
+	q, err := query.New(values)
+	if err != nil {
+		return ret
+	}
 	for k, tr := range tile.Traces {
-		if tiling.Matches(tr, query) {
+		if q.MatchesParams(tr.Params()) {
 			if _, ok := traceTally[k]; !ok {
 				continue
 			}
@@ -5,12 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	ttemplate "text/template"
 	"time"
 
 	"github.com/skia-dev/glog"
 	"go.skia.org/infra/go/auth"
+	"go.skia.org/infra/go/database"
 	"go.skia.org/infra/go/issues"
 	"go.skia.org/infra/go/metrics2"
+	"go.skia.org/infra/go/tiling"
 	tracedb "go.skia.org/infra/go/trace/db"
 	"go.skia.org/infra/go/util"
 	"go.skia.org/infra/perf/go/clustering"
@@ -20,7 +23,10 @@ import (
 )
 
 const (
-	CLUSTER_SIZE   = 50
+	// CLUSTER_SIZE is the default K of new alert configs.
+	CLUSTER_SIZE = 50
+
+	// CLUSTER_STDDEV is the default stddev threshold of new alert configs.
 	CLUSTER_STDDEV = 0.001
 
 	// TRACKED_ITEM_URL_TEMPLATE is used to generate the URL that is
@@ -169,6 +175,13 @@ func ListFrom(ts int64) ([]*types.ClusterSummary, error) {
 	return processRows(rows, err)
 }
 
+// listForConfig returns all clusters that have a step that occurs after the
+// given timestamp and were found by the given alert config.
+func listForConfig(ts int64, configID int64) ([]*types.ClusterSummary, error) {
+	rows, err := db.DB.Query("SELECT id, cluster FROM clusters WHERE ts>=? AND alert_config_id=? ORDER BY status DESC, ts DESC", ts, configID)
+	return processRows(rows, err)
+}
+
 // ListByStatus returns all clusters that match the given status.
 func ListByStatus(status string) ([]*types.ClusterSummary, error) {
 	rows, err := db.DB.Query("SELECT id, cluster FROM clusters WHERE status=?", status)
@@ -199,15 +212,15 @@ func Write(c *types.ClusterSummary) error {
 	}
 	if c.ID == -1 {
 		_, err := db.DB.Exec(
-			"INSERT INTO clusters (ts, hash, regression, cluster, status, message) VALUES (?, ?, ?, ?, ?, ?)",
-			c.Timestamp, c.Hash, c.StepFit.Regression, string(b), c.Status, c.Message)
+			"INSERT INTO clusters (ts, hash, regression, cluster, status, message, alert_config_id) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			c.Timestamp, c.Hash, c.StepFit.Regression, string(b), c.Status, c.Message, c.AlertConfigID)
 		if err != nil {
 			return fmt.Errorf("Failed to write to database: %s", err)
 		}
 	} else {
 		_, err := db.DB.Exec(
-			"UPDATE clusters SET ts=?, hash=?, regression=?, cluster=?, status=?, message=? WHERE id=?",
-			c.Timestamp, c.Hash, c.StepFit.Regression, string(b), c.Status, c.Message, c.ID)
+			"UPDATE clusters SET ts=?, hash=?, regression=?, cluster=?, status=?, message=?, alert_config_id=? WHERE id=?",
+			c.Timestamp, c.Hash, c.StepFit.Regression, string(b), c.Status, c.Message, c.AlertConfigID, c.ID)
 		if err != nil {
 			return fmt.Errorf("Failed to update database: %s", err)
 		}
@@ -216,7 +229,9 @@ func Write(c *types.ClusterSummary) error {
 	return nil
 }
 
-// Reset removes all non-Bug alerts from the database.
+// Reset removes all non-Bug alerts from the database. The record of which
+// regressions have been notified is kept, so clusters that are found again
+// aren't notified again.
 func Reset() error {
 	_, err := db.DB.Exec("DELETE FROM clusters WHERE status!='Bug'")
 	if err != nil {
@@ -225,8 +240,76 @@ func Reset() error {
 	return nil
 }
 
-func skpOnly(_ string, tr *types.PerfTrace) bool {
-	return tr.Params()["source_type"] == "skp" && tr.Params()["sub_result"] == "min_ms"
+// listUnnotified returns all clusters with a status of "New" that have a
+// step that occurs after the given timestamp and haven't been notified yet.
+func listUnnotified(ts int64) ([]*types.ClusterSummary, error) {
+	rows, err := db.DB.Query("SELECT id, cluster FROM clusters WHERE ts>=? AND status='New' AND notified=0", ts)
+	return processRows(rows, err)
+}
+
+// claimNotification marks the given cluster as notified. It returns false if
+// the cluster was already marked, e.g. by another instance, or if the same
+// regression, i.e. the same alert config, commit and step direction, has
+// already been notified for a cluster that was removed by Reset. Both are
+// recorded in a single transaction.
+func claimNotification(c *types.ClusterSummary) (claimed bool, retErr error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("Failed to start transaction: %s", err)
+	}
+	defer func() { retErr = database.CommitOrRollback(tx, retErr) }()
+
+	res, err := tx.Exec("UPDATE clusters SET notified=1 WHERE id=? AND notified=0", c.ID)
+	if err != nil {
+		return false, fmt.Errorf("Failed to update database: %s", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("Failed to retrieve number of claimed clusters: %s", err)
+	}
+	if n != 1 {
+		return false, nil
+	}
+	res, err = tx.Exec("INSERT IGNORE INTO notifications (alert_config_id, hash, direction) VALUES (?, ?, ?)", c.AlertConfigID, c.Hash, string(stepDirection(c)))
+	if err != nil {
+		return false, fmt.Errorf("Failed to write to database: %s", err)
+	}
+	n, err = res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("Failed to retrieve number of claimed notifications: %s", err)
+	}
+	return n == 1, nil
+}
+
+// notifyNew sends the notifications for the new clusters that have a step
+// that occurs after the given timestamp.
+//
+// Each cluster is marked as notified before its notifications are sent, so
+// a cluster is never notified twice, even if sending the notifications fails.
+func notifyNew(ts int64, notifier *Notifier) {
+	clusters, err := listUnnotified(ts)
+	if err != nil {
+		glog.Errorf("Alerting: Failed to get clusters to notify: %s", err)
+		return
+	}
+	for _, c := range clusters {
+		cfg, err := GetConfig(c.AlertConfigID)
+		if err != nil {
+			// The config has been deleted, so there's no owner to email.
+			cfg = NewConfig()
+		}
+		claimed, err := claimNotification(c)
+		if err != nil {
+			glog.Errorf("Alerting: Failed to claim cluster %d for notification: %s", c.ID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		if err := notifier.Notify(c, cfg); err != nil {
+			glog.Errorf("Alerting: Failed to notify about cluster %d: %s", c.ID, err)
+		}
+	}
 }
 
 // updateBugs will find all the bugs the reference the alerting cluster will
@@ -251,39 +334,62 @@ func updateBugs(c *types.ClusterSummary, issueTracker issues.IssueTracker) error
 	return nil
 }
 
-// singleStep does a single round of alerting.
-func singleStep(issueTracker issues.IssueTracker) {
-	clusteringLatency.Start()
-	tile := tileBuilder.GetTile()
-	summary, err := clustering.CalculateClusterSummaries(tile, CLUSTER_SIZE, CLUSTER_STDDEV, skpOnly)
+// processConfig does a round of clustering for a single alert config and
+// writes the interesting clusters, tagged with the id of the config.
+func processConfig(tile *tiling.Tile, cfg *Config) error {
+	filter, err := cfg.Filter()
 	if err != nil {
-		glog.Errorf("Alerting: Failed to calculate clusters: %s", err)
-		return
+		return err
+	}
+	summary, err := clustering.CalculateClusterSummaries(tile, cfg.K, cfg.StdDevThreshold, filter)
+	if err != nil {
+		return fmt.Errorf("Failed to calculate clusters: %s", err)
 	}
 	fresh := []*types.ClusterSummary{}
 	for _, c := range summary.Clusters {
-		if math.Abs(c.StepFit.Regression) > clustering.INTERESTING_THRESHHOLD {
+		if cfg.Interesting(c) {
 			fresh = append(fresh, c)
 		}
 	}
-	old, err := ListFrom(tile.Commits[0].CommitTime)
+	old, err := listForConfig(tile.Commits[0].CommitTime, cfg.ID)
 	if err != nil {
-		glog.Errorf("Alerting: Failed to get existing clusters: %s", err)
-		return
+		return fmt.Errorf("Failed to get existing clusters: %s", err)
 	}
-	glog.Infof("Found %d old", len(old))
-	glog.Infof("Found %d fresh", len(fresh))
+	glog.Infof("Config %d: Found %d old", cfg.ID, len(old))
+	glog.Infof("Config %d: Found %d fresh", cfg.ID, len(fresh))
 	updated := CombineClusters(fresh, old)
-	glog.Infof("Found %d to update", len(updated))
+	glog.Infof("Config %d: Found %d to update", cfg.ID, len(updated))
 
 	for _, c := range updated {
 		if c.Status == "" {
 			c.Status = "New"
 		}
+		c.AlertConfigID = cfg.ID
 		if err := Write(c); err != nil {
 			glog.Errorf("Alerting: Failed to write updated cluster: %s", err)
 		}
 	}
+	return nil
+}
+
+// singleStep does a single round of alerting. New clusters are notified if
+// notifier isn't nil.
+func singleStep(issueTracker issues.IssueTracker, notifier *Notifier) {
+	clusteringLatency.Start()
+	tile := tileBuilder.GetTile()
+	configs, err := ListConfigs()
+	if err != nil {
+		glog.Errorf("Alerting: Failed to load alert configs: %s", err)
+		return
+	}
+	for _, cfg := range configs {
+		if err := processConfig(tile, cfg); err != nil {
+			glog.Errorf("Alerting: Failed to process alert config %d: %s", cfg.ID, err)
+		}
+	}
+	if notifier != nil {
+		notifyNew(tile.Commits[0].CommitTime, notifier)
+	}
 
 	current, err := ListFrom(tile.Commits[0].CommitTime)
 	if err != nil {
@@ -330,7 +436,11 @@ func calcNewClusters() {
 }
 
 // Start kicks off a go routine the periodically refreshes the current alerting clusters.
-func Start(tb tracedb.MasterTileBuilder) {
+//
+// The owners of the alert configs are emailed about new clusters if
+// 'emailer' isn't nil, and a bug is filed for each new cluster if
+// 'bugTemplate' isn't nil, see ParseBugTemplate.
+func Start(tb tracedb.MasterTileBuilder, emailer Emailer, bugTemplate *ttemplate.Template) {
 	newClustersGauge = metrics2.GetInt64Metric("perf.clustering.untriaged", nil)
 	runsCounter = metrics2.GetCounter("perf.clustering.runs", nil)
 	clusteringLatency = metrics2.NewTimer("perf.clustering.latency", nil)
@@ -343,10 +453,14 @@ func Start(tb tracedb.MasterTileBuilder) {
 	if client != nil {
 		issueTracker = issues.NewMonorailIssueTracker(client)
 	}
+	var notifier *Notifier = nil
+	if emailer != nil || bugTemplate != nil {
+		notifier = NewNotifier(emailer, issueTracker, bugTemplate)
+	}
 
 	go func() {
 		for _ = range time.Tick(config.RECLUSTER_DURATION) {
-			singleStep(issueTracker)
+			singleStep(issueTracker, notifier)
 		}
 	}()
 }
@@ -0,0 +1,217 @@
+package alerting
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/url"
+
+	"go.skia.org/infra/go/query"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/perf/go/clustering"
+	"go.skia.org/infra/perf/go/db"
+	"go.skia.org/infra/perf/go/types"
+)
+
+// Direction is the direction of a step that a Config is interested in.
+type Direction string
+
+const (
+	// UP finds steps where the values of the traces increase, e.g. a
+	// slowdown if the traces measure time.
+	UP Direction = "UP"
+
+	// DOWN finds steps where the values of the traces decrease.
+	DOWN Direction = "DOWN"
+
+	// BOTH finds steps in either direction.
+	BOTH Direction = "BOTH"
+)
+
+// allDirections is used to validate Direction values.
+var allDirections = []string{string(UP), string(DOWN), string(BOTH)}
+
+// Config is an alert configuration. Each configuration clusters the traces
+// that match its query and reports the clusters whose step exceeds the
+// configured minimum regression.
+type Config struct {
+	// ID is the identifier in the database, -1 for configs that have not
+	// been written yet.
+	ID int64 `json:"id"`
+
+	// Query selects the traces to cluster, e.g. "source_type=skp&sub_result=min_ms".
+	Query string `json:"query"`
+
+	// K is the number of clusters to use for k-means clustering.
+	K int `json:"k"`
+
+	// StdDevThreshold is the standard deviation below which a trace is
+	// considered flat and is not normalized.
+	StdDevThreshold float64 `json:"stddev_threshold"`
+
+	// Direction selects the steps that are reported.
+	Direction Direction `json:"direction"`
+
+	// MinRegression is the minimum absolute value of StepFit.Regression of
+	// a cluster to be reported.
+	MinRegression float64 `json:"min_regression"`
+
+	// Owner is the email address of the person responsible for the alerts
+	// produced by this config.
+	Owner string `json:"owner"`
+}
+
+// NewConfig returns a new Config with default settings that matches all traces.
+func NewConfig() *Config {
+	return &Config{
+		ID:              -1,
+		Query:           "",
+		K:               CLUSTER_SIZE,
+		StdDevThreshold: CLUSTER_STDDEV,
+		Direction:       BOTH,
+		MinRegression:   clustering.INTERESTING_THRESHHOLD,
+	}
+}
+
+// Validate returns an error if the Config contains invalid values.
+func (c *Config) Validate() error {
+	q, err := url.ParseQuery(c.Query)
+	if err != nil {
+		return fmt.Errorf("Invalid query %q: %s", c.Query, err)
+	}
+	if err := query.ValidateQuery(q); err != nil {
+		return fmt.Errorf("Invalid query %q: %s", c.Query, err)
+	}
+	if c.K <= 0 {
+		return fmt.Errorf("K must be positive, got %d.", c.K)
+	}
+	if c.StdDevThreshold < 0 {
+		return fmt.Errorf("The stddev threshold must not be negative, got %f.", c.StdDevThreshold)
+	}
+	if !util.In(string(c.Direction), allDirections) {
+		return fmt.Errorf("Invalid direction %q.", c.Direction)
+	}
+	if c.MinRegression < 0 {
+		return fmt.Errorf("The minimum regression must not be negative, got %f.", c.MinRegression)
+	}
+	return nil
+}
+
+// Filter returns a clustering.Filter that selects the traces that match the
+// query of the Config.
+func (c *Config) Filter() (clustering.Filter, error) {
+	values, err := url.ParseQuery(c.Query)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid query %q: %s", c.Query, err)
+	}
+	q, err := query.New(values)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid query %q: %s", c.Query, err)
+	}
+	return func(_ string, tr *types.PerfTrace) bool {
+		return q.MatchesParams(tr.Params())
+	}, nil
+}
+
+// Interesting returns true if the given cluster should be reported.
+//
+// Note that a negative StepFit.Regression indicates a step up, see
+// types.StepFit.
+func (c *Config) Interesting(cl *types.ClusterSummary) bool {
+	return c.InterestingRegression(cl.StepFit.Regression)
+}
+
+// InterestingRegression returns true if a StepFit.Regression value 'r' is
+// large enough and in the right direction to be reported.
+func (c *Config) InterestingRegression(r float64) bool {
+	switch c.Direction {
+	case UP:
+		return r < -c.MinRegression
+	case DOWN:
+		return r > c.MinRegression
+	default:
+		return math.Abs(r) > c.MinRegression
+	}
+}
+
+// ListConfigs returns all alert configurations ordered by id.
+func ListConfigs() ([]*Config, error) {
+	rows, err := db.DB.Query("SELECT id, query, k, stddev_threshold, direction, min_regression, owner FROM alertconfig ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from database: %s", err)
+	}
+	defer util.Close(rows)
+	return scanConfigs(rows)
+}
+
+// GetConfig returns the alert configuration with the given id.
+func GetConfig(id int64) (*Config, error) {
+	rows, err := db.DB.Query("SELECT id, query, k, stddev_threshold, direction, min_regression, owner FROM alertconfig WHERE id=?", id)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from database: %s", err)
+	}
+	defer util.Close(rows)
+	configs, err := scanConfigs(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("Failed to find alert config with id: %d", id)
+	}
+	return configs[0], nil
+}
+
+// WriteConfig writes the Config to the database.
+//
+// If the ID is set to -1 then write it as a new entry and set the ID,
+// otherwise update the existing entry.
+func WriteConfig(c *Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if c.ID == -1 {
+		res, err := db.DB.Exec(
+			"INSERT INTO alertconfig (query, k, stddev_threshold, direction, min_regression, owner) VALUES (?, ?, ?, ?, ?, ?)",
+			c.Query, c.K, c.StdDevThreshold, string(c.Direction), c.MinRegression, c.Owner)
+		if err != nil {
+			return fmt.Errorf("Failed to write to database: %s", err)
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("Failed to retrieve id of new alert config: %s", err)
+		}
+		c.ID = id
+	} else {
+		_, err := db.DB.Exec(
+			"UPDATE alertconfig SET query=?, k=?, stddev_threshold=?, direction=?, min_regression=?, owner=? WHERE id=?",
+			c.Query, c.K, c.StdDevThreshold, string(c.Direction), c.MinRegression, c.Owner, c.ID)
+		if err != nil {
+			return fmt.Errorf("Failed to update database: %s", err)
+		}
+	}
+	return nil
+}
+
+// DeleteConfig removes the alert configuration with the given id. Clusters
+// found by the config are kept.
+func DeleteConfig(id int64) error {
+	if _, err := db.DB.Exec("DELETE FROM alertconfig WHERE id=?", id); err != nil {
+		return fmt.Errorf("Failed to delete from database: %s", err)
+	}
+	return nil
+}
+
+// scanConfigs reads all the rows from the alertconfig table.
+func scanConfigs(rows *sql.Rows) ([]*Config, error) {
+	ret := []*Config{}
+	for rows.Next() {
+		c := &Config{}
+		var direction string
+		if err := rows.Scan(&c.ID, &c.Query, &c.K, &c.StdDevThreshold, &direction, &c.MinRegression, &c.Owner); err != nil {
+			return nil, fmt.Errorf("Failed to read row from database: %s", err)
+		}
+		c.Direction = Direction(direction)
+		ret = append(ret, c)
+	}
+	return ret, nil
+}
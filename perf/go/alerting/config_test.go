@@ -0,0 +1,85 @@
+package alerting
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/perf/go/types"
+)
+
+func TestConfigValidate(t *testing.T) {
+	cfg := NewConfig()
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Direction = "SIDEWAYS"
+	assert.Error(t, cfg.Validate())
+
+	cfg = NewConfig()
+	cfg.K = 0
+	assert.Error(t, cfg.Validate())
+
+	cfg = NewConfig()
+	cfg.Query = "config=%gpu"
+	assert.Error(t, cfg.Validate())
+
+	cfg = NewConfig()
+	cfg.Query = "config=~gpu("
+	assert.Error(t, cfg.Validate())
+
+	cfg = NewConfig()
+	cfg.Query = "config=!8888&name=~^desk_"
+	assert.NoError(t, cfg.Validate())
+
+	cfg = NewConfig()
+	cfg.MinRegression = -1
+	assert.Error(t, cfg.Validate())
+}
+
+func TestConfigInteresting(t *testing.T) {
+	cfg := NewConfig()
+	cfg.MinRegression = 100
+
+	up := newCluster([]string{"1"}, -200, "aaa")
+	down := newCluster([]string{"2"}, 200, "bbb")
+	small := newCluster([]string{"3"}, 50, "ccc")
+
+	cfg.Direction = BOTH
+	assert.True(t, cfg.Interesting(up))
+	assert.True(t, cfg.Interesting(down))
+	assert.False(t, cfg.Interesting(small))
+
+	cfg.Direction = UP
+	assert.True(t, cfg.Interesting(up))
+	assert.False(t, cfg.Interesting(down))
+
+	cfg.Direction = DOWN
+	assert.False(t, cfg.Interesting(up))
+	assert.True(t, cfg.Interesting(down))
+}
+
+func TestConfigFilter(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Query = "source_type=skp&sub_result=min_ms&config=8888&config=gpu"
+	filter, err := cfg.Filter()
+	assert.NoError(t, err)
+
+	newTrace := func(params map[string]string) *types.PerfTrace {
+		return &types.PerfTrace{Params_: params}
+	}
+	assert.True(t, filter("a", newTrace(map[string]string{"source_type": "skp", "sub_result": "min_ms", "config": "gpu"})))
+	assert.False(t, filter("b", newTrace(map[string]string{"source_type": "skp", "sub_result": "min_ms", "config": "565"})))
+	assert.False(t, filter("c", newTrace(map[string]string{"source_type": "skp", "config": "gpu"})))
+
+	// Negated and regexp matches.
+	cfg.Query = "config=!8888&name=~^desk_"
+	filter, err = cfg.Filter()
+	assert.NoError(t, err)
+	assert.True(t, filter("e", newTrace(map[string]string{"config": "gpu", "name": "desk_nytimes"})))
+	assert.False(t, filter("f", newTrace(map[string]string{"config": "8888", "name": "desk_nytimes"})))
+	assert.False(t, filter("g", newTrace(map[string]string{"config": "gpu", "name": "top25"})))
+
+	// An empty query matches all traces.
+	filter, err = NewConfig().Filter()
+	assert.NoError(t, err)
+	assert.True(t, filter("d", newTrace(map[string]string{"source_type": "gm"})))
+}
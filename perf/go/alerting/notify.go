@@ -0,0 +1,181 @@
+package alerting
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"strings"
+	ttemplate "text/template"
+
+	"go.skia.org/infra/go/issues"
+	"go.skia.org/infra/perf/go/types"
+)
+
+const (
+	// NOTIFICATION_EMAIL_SENDER is the display name used for regression
+	// notifications.
+	NOTIFICATION_EMAIL_SENDER = "Perf"
+
+	// DEFAULT_BUG_TEMPLATE is the template used to file bugs for new
+	// clusters if no other template is given, see ParseBugTemplate.
+	//
+	// The template is expanded with a BugContext. The first line of the
+	// result is the summary of the bug and the rest is the description. The
+	// description should contain the URL, since that's how bugs are found
+	// and linked to the cluster, see updateBugs.
+	DEFAULT_BUG_TEMPLATE = `Perf regression at {{.Cluster.Hash}}
+A step {{.Direction}} was found in {{len .Cluster.Keys}} traces at commit {{.Cluster.Hash}}.
+
+Step fit: regression {{printf "%.2f" .Cluster.StepFit.Regression}}, step size {{printf "%.2f" .Cluster.StepFit.StepSize}}, least squares error {{printf "%.2f" .Cluster.StepFit.LeastSquares}}
+
+Cluster: {{.URL}}
+
+Alert config: {{.Config.Query}}
+
+Most common param values:
+{{range .Cluster.ParamSummaries}}{{range $i, $vw := .}}{{if lt $i 3}}  {{$vw.Value}}{{end}}{{end}}
+{{end}}`
+)
+
+// Emailer sends email messages. It is implemented by email.GMail.
+type Emailer interface {
+	Send(senderDisplayName string, to []string, subject string, body string) error
+}
+
+var notificationEmailTemplate = template.Must(template.New("notification").Parse(`
+<p>A step {{.Direction}} was found in {{len .Cluster.Keys}} traces at commit {{.Cluster.Hash}} by the alert config:</p>
+<pre>{{.Config.Query}}</pre>
+<p>Step fit: regression {{printf "%.2f" .Cluster.StepFit.Regression}}, step size
+{{printf "%.2f" .Cluster.StepFit.StepSize}}, least squares error
+{{printf "%.2f" .Cluster.StepFit.LeastSquares}}</p>
+<p>Most common param values:</p>
+<ul>
+{{range .Cluster.ParamSummaries}}<li>{{range $i, $vw := .}}{{if lt $i 3}}{{$vw.Value}} {{end}}{{end}}</li>
+{{end}}</ul>
+{{if .Bug}}<p>A bug has been filed for this regression.</p>{{end}}
+<p>Triage the cluster at <a href="{{.URL}}">{{.URL}}</a>.</p>
+`))
+
+// BugContext is the data that bug templates are expanded with.
+type BugContext struct {
+	// Cluster is the newly found cluster.
+	Cluster *types.ClusterSummary
+
+	// Config is the alert config that found the cluster.
+	Config *Config
+
+	// Direction is "up" or "down".
+	Direction string
+
+	// URL is the link to the page of the cluster.
+	URL string
+
+	// Bug is true if a bug was filed for the cluster.
+	Bug bool
+}
+
+// ParseBugTemplate returns the bug template read from the given file, or
+// DEFAULT_BUG_TEMPLATE if the filename is empty.
+func ParseBugTemplate(filename string) (*ttemplate.Template, error) {
+	text := DEFAULT_BUG_TEMPLATE
+	if filename != "" {
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read bug template: %s", err)
+		}
+		text = string(b)
+	}
+	t, err := ttemplate.New("bug").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse bug template: %s", err)
+	}
+	return t, nil
+}
+
+// Notifier tells the owner of an alert config about new clusters found by
+// the config, and optionally files a bug for each new cluster.
+type Notifier struct {
+	emailer      Emailer
+	issueTracker issues.IssueTracker
+	bugTemplate  *ttemplate.Template
+}
+
+// NewNotifier creates a new Notifier.
+//
+// No emails are sent if 'emailer' is nil, and no bugs are filed if either
+// 'issueTracker' or 'bugTemplate' is nil.
+func NewNotifier(emailer Emailer, issueTracker issues.IssueTracker, bugTemplate *ttemplate.Template) *Notifier {
+	return &Notifier{
+		emailer:      emailer,
+		issueTracker: issueTracker,
+		bugTemplate:  bugTemplate,
+	}
+}
+
+// Notify sends the notifications for a single new cluster 'c' found by the
+// alert config 'cfg'.
+func (n *Notifier) Notify(c *types.ClusterSummary, cfg *Config) error {
+	context := &BugContext{
+		Cluster:   c,
+		Config:    cfg,
+		Direction: strings.ToLower(string(stepDirection(c))),
+		URL:       fmt.Sprintf(TRACKED_ITEM_URL_TEMPLATE, c.ID),
+	}
+	// The owner is still emailed if filing the bug fails.
+	var bugErr error
+	if n.issueTracker != nil && n.bugTemplate != nil {
+		bugErr = n.fileBug(context)
+		context.Bug = bugErr == nil
+	}
+	if n.emailer == nil || cfg.Owner == "" {
+		return bugErr
+	}
+	var body bytes.Buffer
+	if err := notificationEmailTemplate.Execute(&body, context); err != nil {
+		return fmt.Errorf("Failed to expand email template: %s", err)
+	}
+	subject := fmt.Sprintf("Perf regression found at %s", c.Hash)
+	if err := n.emailer.Send(NOTIFICATION_EMAIL_SENDER, []string{cfg.Owner}, subject, body.String()); err != nil {
+		return fmt.Errorf("Failed to send email: %s", err)
+	}
+	return bugErr
+}
+
+// stepDirection returns the direction of the step of the cluster, either UP or
+// DOWN.
+func stepDirection(c *types.ClusterSummary) Direction {
+	// Note that a negative StepFit.Regression indicates a step up.
+	if c.StepFit.Regression < 0 {
+		return UP
+	}
+	return DOWN
+}
+
+// fileBug files a bug by expanding the bug template with the given context.
+func (n *Notifier) fileBug(context *BugContext) error {
+	var b bytes.Buffer
+	if err := n.bugTemplate.Execute(&b, context); err != nil {
+		return fmt.Errorf("Failed to expand bug template: %s", err)
+	}
+	parts := strings.SplitN(b.String(), "\n", 2)
+	issue := issues.IssueRequest{
+		Status:  "Untriaged",
+		Summary: strings.TrimSpace(parts[0]),
+		CC:      []issues.MonorailPerson{},
+		Labels:  []string{"Type-Defect"},
+	}
+	if len(parts) == 2 {
+		issue.Description = parts[1]
+	}
+	if context.Config.Owner != "" {
+		issue.CC = append(issue.CC, issues.MonorailPerson{
+			Name: context.Config.Owner,
+			Kind: "monorail#issuePerson",
+		})
+	}
+	if err := n.issueTracker.AddIssue(issue); err != nil {
+		return fmt.Errorf("Failed to file bug: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	ttemplate "text/template"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.skia.org/infra/go/database/testutil"
+	"go.skia.org/infra/go/issues"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/perf/go/db"
+	"go.skia.org/infra/perf/go/types"
+)
+
+type mockEmailer struct {
+	to     [][]string
+	bodies []string
+}
+
+func (m *mockEmailer) Send(senderDisplayName string, to []string, subject string, body string) error {
+	m.to = append(m.to, to)
+	m.bodies = append(m.bodies, body)
+	return nil
+}
+
+type mockIssueTracker struct {
+	issues []issues.IssueRequest
+	fail   bool
+}
+
+func (m *mockIssueTracker) FromQuery(q string) ([]issues.Issue, error) {
+	return nil, nil
+}
+
+func (m *mockIssueTracker) AddComment(id string, comment issues.CommentRequest) error {
+	return nil
+}
+
+func (m *mockIssueTracker) AddIssue(issue issues.IssueRequest) error {
+	if m.fail {
+		return fmt.Errorf("Failed to add issue.")
+	}
+	m.issues = append(m.issues, issue)
+	return nil
+}
+
+func newNotifyCluster() *types.ClusterSummary {
+	c := newCluster([]string{",config=565,", ",config=8888,"}, -300, "abc123")
+	c.ID = 12
+	c.ParamSummaries = [][]types.ValueWeight{
+		{{Value: "565", Weight: 19}, {Value: "8888", Weight: 19}},
+	}
+	return c
+}
+
+func TestNotifyEmailOnly(t *testing.T) {
+	emailer := &mockEmailer{}
+	cfg := NewConfig()
+	cfg.Owner = "alice@example.com"
+
+	assert.NoError(t, NewNotifier(emailer, nil, nil).Notify(newNotifyCluster(), cfg))
+	assert.Equal(t, [][]string{{"alice@example.com"}}, emailer.to)
+	assert.Contains(t, emailer.bodies[0], "https://perf.skia.org/cl/12")
+	assert.Contains(t, emailer.bodies[0], "step up")
+	assert.False(t, strings.Contains(emailer.bodies[0], "bug has been filed"))
+
+	// Configs without an owner aren't emailed.
+	assert.NoError(t, NewNotifier(emailer, nil, nil).Notify(newNotifyCluster(), NewConfig()))
+	assert.Equal(t, 1, len(emailer.to))
+}
+
+func TestNotifyFileBug(t *testing.T) {
+	emailer := &mockEmailer{}
+	tracker := &mockIssueTracker{}
+	bugTemplate, err := ParseBugTemplate("")
+	assert.NoError(t, err)
+	cfg := NewConfig()
+	cfg.Owner = "alice@example.com"
+	cfg.Query = "config=565"
+
+	assert.NoError(t, NewNotifier(emailer, tracker, bugTemplate).Notify(newNotifyCluster(), cfg))
+	assert.Equal(t, 1, len(tracker.issues))
+	issue := tracker.issues[0]
+	assert.Equal(t, "Perf regression at abc123", issue.Summary)
+	assert.Contains(t, issue.Description, "https://perf.skia.org/cl/12")
+	assert.Contains(t, issue.Description, "regression -300.00")
+	assert.Contains(t, issue.Description, "Alert config: config=565")
+	assert.Contains(t, issue.Description, "  565  8888")
+	assert.Equal(t, "alice@example.com", issue.CC[0].Name)
+	assert.Contains(t, emailer.bodies[0], "bug has been filed")
+
+	// A custom template.
+	bugTemplate, err = ttemplate.New("bug").Parse("{{.Direction}} at {{.Cluster.Hash}}")
+	assert.NoError(t, err)
+	assert.NoError(t, NewNotifier(nil, tracker, bugTemplate).Notify(newNotifyCluster(), cfg))
+	assert.Equal(t, "up at abc123", tracker.issues[1].Summary)
+	assert.Equal(t, "", tracker.issues[1].Description)
+
+	// The owner is still emailed if filing the bug fails.
+	tracker.fail = true
+	assert.Error(t, NewNotifier(emailer, tracker, bugTemplate).Notify(newNotifyCluster(), cfg))
+	assert.Equal(t, 2, len(emailer.to))
+	assert.False(t, strings.Contains(emailer.bodies[1], "bug has been filed"))
+}
+
+func TestNotifyNewAfterReset(t *testing.T) {
+	// Set up the database. This also locks the db until this test is finished
+	// causing similar tests to wait.
+	migrationSteps := db.MigrationSteps()
+	mysqlDB := testutil.SetupMySQLTestDatabase(t, migrationSteps)
+	defer mysqlDB.Close(t)
+
+	vdb, err := testutil.LocalTestDatabaseConfig(migrationSteps).NewVersionedDB()
+	assert.NoError(t, err)
+	defer testutils.AssertCloses(t, vdb)
+	db.DB = vdb.DB
+
+	cfg := NewConfig()
+	cfg.Owner = "alice@example.com"
+	cfg.Query = "config=565"
+	assert.NoError(t, WriteConfig(cfg))
+
+	// addCluster adds a new cluster like clustering does, without the side
+	// effects of Write.
+	addCluster := func(regression float64) {
+		c := newNotifyCluster()
+		c.StepFit.Regression = regression
+		c.Status = "New"
+		c.AlertConfigID = cfg.ID
+		b, err := json.Marshal(c)
+		assert.NoError(t, err)
+		_, err = db.DB.Exec(
+			"INSERT INTO clusters (ts, hash, regression, cluster, status, message, alert_config_id) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			c.Timestamp, c.Hash, c.StepFit.Regression, string(b), c.Status, c.Message, c.AlertConfigID)
+		assert.NoError(t, err)
+	}
+
+	emailer := &mockEmailer{}
+	notifier := NewNotifier(emailer, nil, nil)
+	addCluster(-300)
+	notifyNew(0, notifier)
+	assert.Equal(t, 1, len(emailer.to))
+	notifyNew(0, notifier)
+	assert.Equal(t, 1, len(emailer.to))
+
+	// The same regression found again after a reset isn't notified again.
+	assert.NoError(t, Reset())
+	addCluster(-300)
+	notifyNew(0, notifier)
+	assert.Equal(t, 1, len(emailer.to))
+
+	// A step in the other direction at the same commit is a new regression.
+	addCluster(300)
+	notifyNew(0, notifier)
+	assert.Equal(t, 2, len(emailer.to))
+	assert.Contains(t, emailer.bodies[1], "step down")
+}
@@ -31,6 +31,9 @@ const (
 	// StepFit.Regression values become interesting, i.e. they may indicate real
 	// regressions or improvements.
 	INTERESTING_THRESHHOLD = 150.0
+
+	// MIN_STEPFIT_LSE is the smallest least squares error used by StepFitAt.
+	MIN_STEPFIT_LSE = 0.001
 )
 
 // ClusterSummaries is one summary for each cluster that the k-means clustering
@@ -170,19 +173,47 @@ func getStepFit(trace []float64) *types.StepFit {
 		}
 	}
 	regression := stepSize / lse
-	status := "Uninteresting"
-	if regression > INTERESTING_THRESHHOLD {
-		status = "High"
-	} else if regression < -INTERESTING_THRESHHOLD {
-		status = "Low"
-	}
 	return &types.StepFit{
 		LeastSquares: lse,
 		StepSize:     stepSize,
 		TurningPoint: turn,
 		Regression:   regression,
-		Status:       status,
+		Status:       stepStatus(regression),
+	}
+}
+
+// StepFitAt fits a step function to the trace where the step is fixed at
+// index 'turn', i.e. trace[:turn] is fit to one level and trace[turn:] to
+// another. Unlike getStepFit it doesn't search for the best turning point.
+//
+// See types.StepFit for a description of the values being calculated. The
+// least squares error is at least MIN_STEPFIT_LSE so that perfect steps
+// don't produce an infinite Regression.
+func StepFitAt(trace []float64, turn int) *types.StepFit {
+	ret := &types.StepFit{
+		TurningPoint: turn,
+		Status:       stepStatus(0),
+	}
+	if turn <= 0 || turn >= len(trace) {
+		return ret
+	}
+	y0 := average(trace[:turn])
+	y1 := average(trace[turn:])
+	ret.StepSize = y0 - y1
+	ret.LeastSquares = math.Max(math.Sqrt(sse(trace[:turn], y0)+sse(trace[turn:], y1))/float64(len(trace)), MIN_STEPFIT_LSE)
+	ret.Regression = ret.StepSize / ret.LeastSquares
+	ret.Status = stepStatus(ret.Regression)
+	return ret
+}
+
+// stepStatus returns the StepFit.Status for the given regression value.
+func stepStatus(regression float64) string {
+	if regression > INTERESTING_THRESHHOLD {
+		return "High"
+	} else if regression < -INTERESTING_THRESHHOLD {
+		return "Low"
 	}
+	return "Uninteresting"
 }
 
 type SortableClusterable struct {
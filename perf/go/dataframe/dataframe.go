@@ -18,6 +18,15 @@ const (
 	// DEFAULT_NUM_COMMITS is the number of commits in the DataFrame returned
 	// from New().
 	DEFAULT_NUM_COMMITS = 50
+
+	// MAX_FULL_RESOLUTION_COMMITS is the largest number of commits
+	// NewFromQueryAndRange returns one column per commit for. Larger ranges
+	// have one column per day if the store has daily summaries.
+	MAX_FULL_RESOLUTION_COMMITS = 500
+
+	// DAY_DESC is the Desc of the columns of a DataFrame that has one column
+	// per day of daily summaries.
+	DAY_DESC = "day"
 )
 
 // ColumnHeader describes each column in a DataFrame.
@@ -69,14 +78,50 @@ func lastN(vcs vcsinfo.VCS) ([]*ColumnHeader, []*ptracestore.CommitID) {
 	return rangeImpl(vcs.LastNIndex(DEFAULT_NUM_COMMITS))
 }
 
+// dayRangeImpl returns the slices of ColumnHeader and ptracestore.DayID that
+// are needed by DataFrame and ptracestore.DownsampledPTraceStore,
+// respectively. There is one column for each day that has commits in the
+// given vcsinfo.IndexCommits. The ID of each column is the index of the last
+// commit of the day.
+func dayRangeImpl(resp []*vcsinfo.IndexCommit) ([]*ColumnHeader, []*ptracestore.DayID) {
+	headers := []*ColumnHeader{}
+	days := []*ptracestore.DayID{}
+	for _, r := range resp {
+		dayID := ptracestore.NewDayID("master", r.Timestamp)
+		if len(days) > 0 && days[len(days)-1].Day == dayID.Day {
+			headers[len(headers)-1].ID = fmt.Sprintf("%d", r.Index)
+			continue
+		}
+		days = append(days, dayID)
+		headers = append(headers, &ColumnHeader{
+			Source:    "master",
+			ID:        fmt.Sprintf("%d", r.Index),
+			Desc:      DAY_DESC,
+			Timestamp: dayID.Time().Unix(),
+		})
+	}
+	return headers, days
+}
+
 // getRange returns the slices of ColumnHeader and ptracestore.CommitID that are
 // needed by DataFrame and ptracestore.PTraceStore, respectively. The slices
 // are for the commits that fall in the given time range [begin, end).
-func getRange(vcs vcsinfo.VCS, begin, end time.Time) ([]*ColumnHeader, []*ptracestore.CommitID) {
+//
+// If there are more than 'maxCommits' commits in the range then the columns
+// are days instead of commits, and a slice of ptracestore.DayID is returned
+// instead of the slice of ptracestore.CommitID. A 'maxCommits' of -1 always
+// returns commits.
+func getRange(vcs vcsinfo.VCS, begin, end time.Time, maxCommits int) ([]*ColumnHeader, []*ptracestore.CommitID, []*ptracestore.DayID) {
 	if err := vcs.Update(true, false); err != nil {
 		glog.Errorf("Failed to update repo: %s", err)
 	}
-	return rangeImpl(vcs.Range(begin, end))
+	resp := vcs.Range(begin, end)
+	if maxCommits >= 0 && len(resp) > maxCommits {
+		headers, days := dayRangeImpl(resp)
+		return headers, nil, days
+	}
+	headers, commits := rangeImpl(resp)
+	return headers, commits, nil
 }
 
 func _new(colHeaders []*ColumnHeader, commitIDs []*ptracestore.CommitID, q *query.Query, store ptracestore.PTraceStore) (*DataFrame, error) {
@@ -84,6 +129,21 @@ func _new(colHeaders []*ColumnHeader, commitIDs []*ptracestore.CommitID, q *quer
 	if err != nil {
 		return nil, fmt.Errorf("DataFrame failed to query for all traces: %s", err)
 	}
+	return fromTraceSet(colHeaders, traceSet), nil
+}
+
+// _newDownsampled is the equivalent of _new for daily summaries.
+func _newDownsampled(colHeaders []*ColumnHeader, dayIDs []*ptracestore.DayID, q *query.Query, store ptracestore.DownsampledPTraceStore, stat ptracestore.Stat) (*DataFrame, error) {
+	traceSet, err := store.MatchDays(dayIDs, q, stat)
+	if err != nil {
+		return nil, fmt.Errorf("DataFrame failed to query for all daily summaries: %s", err)
+	}
+	return fromTraceSet(colHeaders, traceSet), nil
+}
+
+// fromTraceSet returns a DataFrame for the given TraceSet with a calculated
+// ParamSet.
+func fromTraceSet(colHeaders []*ColumnHeader, traceSet ptracestore.TraceSet) *DataFrame {
 	paramSet := paramtools.ParamSet{}
 	for key, _ := range traceSet {
 		paramSet.AddParamsFromKey(key)
@@ -95,7 +155,7 @@ func _new(colHeaders []*ColumnHeader, commitIDs []*ptracestore.CommitID, q *quer
 		TraceSet: traceSet,
 		Header:   colHeaders,
 		ParamSet: paramSet,
-	}, nil
+	}
 }
 
 // New returns a populated DataFrame of the last 50 commits given the 'vcs' and
@@ -108,7 +168,61 @@ func New(vcs vcsinfo.VCS, store ptracestore.PTraceStore) (*DataFrame, error) {
 // NewFromQueryAndRange returns a populated DataFrame of the traces that match
 // the given time range [begin, end) and the passed in query, or a non-nil
 // error if the traces can't be retrieved.
+//
+// If the range contains more than MAX_FULL_RESOLUTION_COMMITS commits, the
+// store is a ptracestore.DownsampledPTraceStore and all the days in the range
+// have been compacted, then the DataFrame has one column per day containing
+// the daily median of each trace.
 func NewFromQueryAndRange(vcs vcsinfo.VCS, store ptracestore.PTraceStore, begin, end time.Time, q *query.Query) (*DataFrame, error) {
-	colHeaders, commitIDs := getRange(vcs, begin, end)
+	downsampled, ok := store.(ptracestore.DownsampledPTraceStore)
+	if !ok {
+		colHeaders, commitIDs, _ := getRange(vcs, begin, end, -1)
+		return _new(colHeaders, commitIDs, q, store)
+	}
+	colHeaders, commitIDs, dayIDs := getRange(vcs, begin, end, MAX_FULL_RESOLUTION_COMMITS)
+	if dayIDs != nil {
+		compacted, err := allCompacted(downsampled, dayIDs)
+		if err != nil {
+			return nil, err
+		}
+		if compacted {
+			return _newDownsampled(colHeaders, dayIDs, q, downsampled, ptracestore.MEDIAN)
+		}
+		// Missing summaries would show up as missing values, so load the
+		// full resolution data instead.
+		colHeaders, commitIDs, _ = getRange(vcs, begin, end, -1)
+	}
 	return _new(colHeaders, commitIDs, q, store)
 }
+
+// allCompacted returns true if all the given days have been compacted.
+func allCompacted(store ptracestore.DownsampledPTraceStore, dayIDs []*ptracestore.DayID) (bool, error) {
+	for _, dayID := range dayIDs {
+		n, err := store.Compacted(dayID)
+		if err != nil {
+			return false, fmt.Errorf("Failed to check for daily summaries: %s", err)
+		}
+		if n == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// NewFullResolutionFromQueryAndRange returns a populated DataFrame of the
+// traces that match the given time range [begin, end) and the passed in
+// query, with one column per commit regardless of the number of commits in
+// the range.
+func NewFullResolutionFromQueryAndRange(vcs vcsinfo.VCS, store ptracestore.PTraceStore, begin, end time.Time, q *query.Query) (*DataFrame, error) {
+	colHeaders, commitIDs, _ := getRange(vcs, begin, end, -1)
+	return _new(colHeaders, commitIDs, q, store)
+}
+
+// NewDownsampledFromQueryAndRange returns a populated DataFrame of the daily
+// summary value 'stat' of the traces that match the given time range
+// [begin, end) and the passed in query, regardless of the number of commits
+// in the range.
+func NewDownsampledFromQueryAndRange(vcs vcsinfo.VCS, store ptracestore.DownsampledPTraceStore, begin, end time.Time, q *query.Query, stat ptracestore.Stat) (*DataFrame, error) {
+	colHeaders, _, dayIDs := getRange(vcs, begin, end, 0)
+	return _newDownsampled(colHeaders, dayIDs, q, store, stat)
+}
@@ -20,7 +20,7 @@ type mockVcs struct {
 }
 
 func (m *mockVcs) From(start time.Time) []string                     { return nil }
-func (m *mockVcs) Range(begin, end time.Time) []*vcsinfo.IndexCommit { return nil }
+func (m *mockVcs) Range(begin, end time.Time) []*vcsinfo.IndexCommit { return m.commits }
 func (m *mockVcs) Details(hash string, includeBranchInfo bool) (*vcsinfo.LongCommit, error) {
 	return nil, nil
 }
@@ -129,6 +129,56 @@ func TestRangeImpl(t *testing.T) {
 	assert.Equal(t, 0, len(pcommits))
 }
 
+func TestDayRangeImpl(t *testing.T) {
+	day := time.Date(2016, 9, 1, 0, 0, 0, 0, time.UTC)
+	resp := []*vcsinfo.IndexCommit{
+		&vcsinfo.IndexCommit{Index: 10, Timestamp: day.Add(time.Hour)},
+		&vcsinfo.IndexCommit{Index: 11, Timestamp: day.Add(2 * time.Hour)},
+		&vcsinfo.IndexCommit{Index: 12, Timestamp: day.Add(26 * time.Hour)},
+	}
+	headers, days := dayRangeImpl(resp)
+	testutils.AssertDeepEqual(t, []*ColumnHeader{
+		&ColumnHeader{
+			Source:    "master",
+			ID:        "11",
+			Desc:      "day",
+			Timestamp: day.Unix(),
+		},
+		&ColumnHeader{
+			Source:    "master",
+			ID:        "12",
+			Desc:      "day",
+			Timestamp: day.Add(24 * time.Hour).Unix(),
+		},
+	}, headers)
+	testutils.AssertDeepEqual(t, []*ptracestore.DayID{
+		&ptracestore.DayID{Day: 17045, Source: "master"},
+		&ptracestore.DayID{Day: 17046, Source: "master"},
+	}, days)
+}
+
+func TestGetRange(t *testing.T) {
+	vcs := &mockVcs{
+		commits: commits,
+	}
+	headers, pcommits, days := getRange(vcs, ts0, ts1.Add(time.Second), -1)
+	assert.Equal(t, 2, len(headers))
+	assert.Equal(t, 2, len(pcommits))
+	assert.Nil(t, days)
+
+	headers, pcommits, days = getRange(vcs, ts0, ts1.Add(time.Second), 2)
+	assert.Equal(t, 2, len(headers))
+	assert.Equal(t, 2, len(pcommits))
+	assert.Nil(t, days)
+
+	// Both commits are on the same day.
+	headers, pcommits, days = getRange(vcs, ts0, ts1.Add(time.Second), 1)
+	assert.Equal(t, 1, len(headers))
+	assert.Equal(t, "1", headers[0].ID)
+	assert.Nil(t, pcommits)
+	assert.Equal(t, 1, len(days))
+}
+
 func TestNew(t *testing.T) {
 	colHeaders := []*ColumnHeader{
 		&ColumnHeader{
@@ -191,3 +241,48 @@ func TestVCS(t *testing.T) {
 	_, err = NewFromQueryAndRange(vcs, store, ts0, ts1.Add(time.Second), &query.Query{})
 	assert.Error(t, err)
 }
+
+type mockDownsampledPTraceStore struct {
+	mockPTraceStore
+	compacted map[int]int
+}
+
+func (m mockDownsampledPTraceStore) Compact(dayID *ptracestore.DayID, commitIDs []*ptracestore.CommitID) error {
+	return nil
+}
+
+func (m mockDownsampledPTraceStore) Compacted(dayID *ptracestore.DayID) (int, error) {
+	return m.compacted[dayID.Day], nil
+}
+
+func (m mockDownsampledPTraceStore) MatchDays(dayIDs []*ptracestore.DayID, q *query.Query, stat ptracestore.Stat) (ptracestore.TraceSet, error) {
+	return ptracestore.TraceSet{",arch=x86,config=565,": ptracestore.Trace([]float32{1.5, 2.5})}, nil
+}
+
+func TestNewFromQueryAndRangeDownsampled(t *testing.T) {
+	// More than MAX_FULL_RESOLUTION_COMMITS commits spread over two days.
+	day := time.Date(2016, 9, 1, 0, 0, 0, 0, time.UTC)
+	vcs := &mockVcs{}
+	for i := 0; i <= MAX_FULL_RESOLUTION_COMMITS; i++ {
+		vcs.commits = append(vcs.commits, &vcsinfo.IndexCommit{Index: i, Timestamp: day.Add(time.Duration(i) * 5 * time.Minute)})
+	}
+	store.matchFail = false
+	downsampled := mockDownsampledPTraceStore{
+		mockPTraceStore: store,
+		compacted:       map[int]int{17045: 288},
+	}
+
+	// The second day hasn't been compacted, so the full resolution data is
+	// returned.
+	d, err := NewFromQueryAndRange(vcs, downsampled, day, day.Add(48*time.Hour), &query.Query{})
+	assert.NoError(t, err)
+	assert.Equal(t, MAX_FULL_RESOLUTION_COMMITS+1, len(d.Header))
+	assert.Equal(t, 3, len(d.TraceSet))
+
+	downsampled.compacted[17046] = 213
+	d, err = NewFromQueryAndRange(vcs, downsampled, day, day.Add(48*time.Hour), &query.Query{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(d.Header))
+	assert.Equal(t, "day", d.Header[0].Desc)
+	assert.Equal(t, 1, len(d.TraceSet))
+}
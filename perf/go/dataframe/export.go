@@ -0,0 +1,142 @@
+package dataframe
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"go.skia.org/infra/go/paramtools"
+	"go.skia.org/infra/perf/go/ptracestore"
+)
+
+// ExportFormat is a format a DataFrame can be exported in, see Export.
+type ExportFormat string
+
+const (
+	// CSV has a header row, then one row per trace. The first column is the
+	// trace id, followed by one column per param key in the ParamSet, in
+	// alphabetical order, and then one column per ColumnHeader, titled with
+	// the ColumnHeader ID, or with the date, e.g. "2016-09-01", if the column
+	// is a day of daily summaries. Missing values are empty.
+	CSV ExportFormat = "csv"
+
+	// JSON is JSON lines, i.e. one JSON object per line. The first line is
+	// an exportHeader, followed by one exportTrace per trace. Missing values
+	// are null.
+	JSON ExportFormat = "json"
+)
+
+// AllExportFormats is used to validate ExportFormat values.
+var AllExportFormats = []string{string(CSV), string(JSON)}
+
+// ContentType returns the MIME type of the format.
+func (f ExportFormat) ContentType() string {
+	if f == JSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv"
+}
+
+// exportHeader is the first line of the JSON export format.
+type exportHeader struct {
+	Header   []*ColumnHeader     `json:"header"`
+	ParamSet paramtools.ParamSet `json:"paramset"`
+}
+
+// exportTrace is a single trace in the JSON export format.
+type exportTrace struct {
+	ID     string            `json:"id"`
+	Params map[string]string `json:"params"`
+	Values []*float32        `json:"values"`
+}
+
+// Export writes the DataFrame to 'w' in the given format. The traces are
+// written in order of their trace ids, one at a time, so large DataFrames
+// can be streamed.
+func (d *DataFrame) Export(w io.Writer, format ExportFormat) error {
+	switch format {
+	case CSV:
+		return d.exportCSV(w)
+	case JSON:
+		return d.exportJSON(w)
+	default:
+		return fmt.Errorf("Unknown export format: %q", format)
+	}
+}
+
+// traceIDs returns the trace ids of the DataFrame in sorted order.
+func (d *DataFrame) traceIDs() []string {
+	ids := make([]string, 0, len(d.TraceSet))
+	for id, _ := range d.TraceSet {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// columnTitle returns the title of the CSV column for the given ColumnHeader.
+func columnTitle(h *ColumnHeader) string {
+	if h.Desc == DAY_DESC {
+		return time.Unix(h.Timestamp, 0).UTC().Format("2006-01-02")
+	}
+	return h.ID
+}
+
+func (d *DataFrame) exportCSV(w io.Writer) error {
+	keys := d.ParamSet.Keys()
+	sort.Strings(keys)
+
+	cw := csv.NewWriter(w)
+	row := make([]string, 1+len(keys)+len(d.Header))
+	row[0] = "id"
+	copy(row[1:], keys)
+	for i, h := range d.Header {
+		row[1+len(keys)+i] = columnTitle(h)
+	}
+	if err := cw.Write(row); err != nil {
+		return fmt.Errorf("Failed to write CSV header: %s", err)
+	}
+	for _, id := range d.traceIDs() {
+		params := paramtools.NewParams(id)
+		row[0] = id
+		for i, key := range keys {
+			row[1+i] = params[key]
+		}
+		tr := d.TraceSet[id]
+		for i := range d.Header {
+			if i >= len(tr) || tr[i] == ptracestore.MISSING_VALUE {
+				row[1+len(keys)+i] = ""
+			} else {
+				row[1+len(keys)+i] = fmt.Sprintf("%g", tr[i])
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return fmt.Errorf("Failed to write CSV row: %s", err)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (d *DataFrame) exportJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(exportHeader{Header: d.Header, ParamSet: d.ParamSet}); err != nil {
+		return fmt.Errorf("Failed to write JSON header: %s", err)
+	}
+	for _, id := range d.traceIDs() {
+		tr := d.TraceSet[id]
+		values := make([]*float32, len(tr))
+		for i := range tr {
+			if tr[i] != ptracestore.MISSING_VALUE {
+				values[i] = &tr[i]
+			}
+		}
+		if err := enc.Encode(exportTrace{ID: id, Params: paramtools.NewParams(id), Values: values}); err != nil {
+			return fmt.Errorf("Failed to write JSON trace: %s", err)
+		}
+	}
+	return nil
+}
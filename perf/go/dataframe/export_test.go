@@ -0,0 +1,68 @@
+package dataframe
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.skia.org/infra/go/paramtools"
+	"go.skia.org/infra/perf/go/ptracestore"
+)
+
+func exportDataFrame() *DataFrame {
+	return &DataFrame{
+		TraceSet: ptracestore.TraceSet{
+			",arch=x86,config=8888,": ptracestore.Trace([]float32{1.5, ptracestore.MISSING_VALUE}),
+			",arch=arm,config=565,":  ptracestore.Trace([]float32{2, 3.25}),
+			",config=gpu,":           ptracestore.Trace([]float32{ptracestore.MISSING_VALUE, 4}),
+		},
+		Header: []*ColumnHeader{
+			&ColumnHeader{Source: "master", ID: "10", Timestamp: 1406721642},
+			&ColumnHeader{Source: "master", ID: "11", Timestamp: 1406721715},
+		},
+		ParamSet: paramtools.ParamSet{
+			"config": []string{"565", "8888", "gpu"},
+			"arch":   []string{"arm", "x86"},
+		},
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	b := &bytes.Buffer{}
+	assert.NoError(t, exportDataFrame().Export(b, CSV))
+	assert.Equal(t, `id,arch,config,10,11
+",arch=arm,config=565,",arm,565,2,3.25
+",arch=x86,config=8888,",x86,8888,1.5,
+",config=gpu,",,gpu,,4
+`, b.String())
+}
+
+func TestExportCSVDays(t *testing.T) {
+	df := exportDataFrame()
+	df.Header = []*ColumnHeader{
+		&ColumnHeader{Source: "master", ID: "10", Desc: DAY_DESC, Timestamp: 1472688000},
+		&ColumnHeader{Source: "master", ID: "11", Desc: DAY_DESC, Timestamp: 1472774400},
+	}
+	b := &bytes.Buffer{}
+	assert.NoError(t, df.Export(b, CSV))
+	assert.Equal(t, `id,arch,config,2016-09-01,2016-09-02
+",arch=arm,config=565,",arm,565,2,3.25
+",arch=x86,config=8888,",x86,8888,1.5,
+",config=gpu,",,gpu,,4
+`, b.String())
+}
+
+func TestExportJSON(t *testing.T) {
+	b := &bytes.Buffer{}
+	assert.NoError(t, exportDataFrame().Export(b, JSON))
+	assert.Equal(t, `{"header":[{"source":"master","id":"10","desc":"","timestamp":1406721642},{"source":"master","id":"11","desc":"","timestamp":1406721715}],"paramset":{"arch":["arm","x86"],"config":["565","8888","gpu"]}}
+{"id":",arch=arm,config=565,","params":{"arch":"arm","config":"565"},"values":[2,3.25]}
+{"id":",arch=x86,config=8888,","params":{"arch":"x86","config":"8888"},"values":[1.5,null]}
+{"id":",config=gpu,","params":{"config":"gpu"},"values":[null,4]}
+`, b.String())
+}
+
+func TestExportUnknownFormat(t *testing.T) {
+	assert.Error(t, exportDataFrame().Export(&bytes.Buffer{}, ExportFormat("xml")))
+}
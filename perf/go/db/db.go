@@ -88,6 +88,97 @@ var migrationSteps = []database.MigrationStep{
 		MySQLDown: []string{},
 	},
 
+	// version 3
+	{
+		MySQLUp: []string{
+			`CREATE TABLE IF NOT EXISTS alertconfig (
+				id               INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+				query            TEXT         NOT NULL,
+				k                INT          NOT NULL,
+				stddev_threshold DOUBLE       NOT NULL,
+				direction        VARCHAR(8)   NOT NULL,
+				min_regression   DOUBLE       NOT NULL,
+				owner            TEXT         NOT NULL
+			)`,
+
+			// The default config reproduces the alerting that was done before
+			// alert configs existed.
+			`INSERT INTO alertconfig (query, k, stddev_threshold, direction, min_regression, owner)
+				VALUES ('source_type=skp&sub_result=min_ms', 50, 0.001, 'BOTH', 150, '')`,
+
+			`ALTER TABLE clusters ADD alert_config_id INT NOT NULL DEFAULT 0`,
+
+			// Clusters found before alert configs existed were found by what
+			// is now the default config.
+			`UPDATE clusters SET alert_config_id=(SELECT MIN(id) FROM alertconfig)`,
+		},
+		MySQLDown: []string{
+			`ALTER TABLE clusters DROP alert_config_id`,
+			`DROP TABLE IF EXISTS alertconfig`,
+		},
+	},
+
+	// version 4
+	{
+		MySQLUp: []string{
+			`CREATE TABLE IF NOT EXISTS regression (
+				id               INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+				source           VARCHAR(100) NOT NULL,
+				commit_offset    INT          NOT NULL,
+				ts               BIGINT       NOT NULL,
+				alert_config_id  INT          NOT NULL,
+				direction        VARCHAR(8)   NOT NULL,
+				regression       DOUBLE       NOT NULL,
+				body             MEDIUMTEXT   NOT NULL,
+				status           VARCHAR(20)  NOT NULL,
+				message          TEXT         NOT NULL,
+				triaged_by       VARCHAR(255) NOT NULL,
+				UNIQUE INDEX regression_commit (source, commit_offset, alert_config_id, direction),
+				INDEX regression_ts (ts)
+			)`,
+		},
+		MySQLDown: []string{
+			`DROP TABLE IF EXISTS regression`,
+		},
+	},
+
+	// version 5
+	{
+		MySQLUp: []string{
+			`ALTER TABLE clusters ADD notified TINYINT(1) NOT NULL DEFAULT 0`,
+
+			// Don't send notifications for clusters that were found before
+			// notifications existed.
+			`UPDATE clusters SET notified=1`,
+		},
+		MySQLDown: []string{
+			`ALTER TABLE clusters DROP notified`,
+		},
+	},
+
+	// version 6
+	{
+		MySQLUp: []string{
+			// Records the regressions that have been notified. Clusters are
+			// deleted by a reset and found again, so the notified field of a
+			// cluster alone would notify the same regression again.
+			`CREATE TABLE IF NOT EXISTS notifications (
+				alert_config_id  INT          NOT NULL,
+				hash             VARCHAR(64)  NOT NULL,
+				direction        VARCHAR(8)   NOT NULL,
+				PRIMARY KEY (alert_config_id, hash, direction)
+			)`,
+
+			// Don't notify again the regressions of clusters that have
+			// already been notified.
+			`INSERT IGNORE INTO notifications (alert_config_id, hash, direction)
+				SELECT alert_config_id, hash, IF(regression<0, 'UP', 'DOWN') FROM clusters WHERE notified=1`,
+		},
+		MySQLDown: []string{
+			`DROP TABLE IF EXISTS notifications`,
+		},
+	},
+
 	// Use this is a template for more migration steps.
 	// version x
 	// {
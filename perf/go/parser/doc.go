@@ -3,7 +3,7 @@
 //   f(g(h("foo"), i(3, "bar")))
 //
 // Note that while it does understand strings and numbers, it doesn't
-// do binary operators. We can do those via functions if needed, ala
+// do binary operators. Those are done via functions instead, ala
 // add(x, y), sub(x, y), etc.
 //
 // Caveats:
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"math"
 	"net/url"
+	"sort"
 	"strconv"
 
+	"go.skia.org/infra/go/query"
 	"go.skia.org/infra/go/tiling"
 	"go.skia.org/infra/perf/go/config"
 	"go.skia.org/infra/perf/go/types"
@@ -26,13 +28,17 @@ func (FilterFunc) Eval(ctx *Context, node *Node) ([]*types.PerfTrace, error) {
 	if node.Args[0].Typ != NodeString {
 		return nil, fmt.Errorf("filter() takes a string argument.")
 	}
-	query, err := url.ParseQuery(node.Args[0].Val)
+	values, err := url.ParseQuery(node.Args[0].Val)
 	if err != nil {
 		return nil, fmt.Errorf("filter() arg not a valid URL query parameter: %s", err)
 	}
+	q, err := query.New(values)
+	if err != nil {
+		return nil, fmt.Errorf("filter() arg not a valid query: %s", err)
+	}
 	traces := []*types.PerfTrace{}
 	for id, tr := range ctx.Tile.Traces {
-		if tiling.Matches(tr.(*types.PerfTrace), query) {
+		if q.MatchesParams(tr.Params()) {
 			cp := tr.DeepCopy()
 			cp.Params()["id"] = tiling.AsCalculatedID(id)
 			traces = append(traces, cp.(*types.PerfTrace))
@@ -391,3 +397,386 @@ func (LogFunc) Describe() string {
 }
 
 var logFunc = LogFunc{}
+
+// numArg returns the value of the i'th argument of node, which must be a
+// number.
+func numArg(node *Node, name string, i int) (float64, error) {
+	if node.Args[i].Typ != NodeNum {
+		return 0, fmt.Errorf("%s() takes a number as argument %d.", name, i+1)
+	}
+	v, err := strconv.ParseFloat(node.Args[i].Val, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s() argument %d not a valid number %s : %s", name, i+1, node.Args[i].Val, err)
+	}
+	return v, nil
+}
+
+// evalFuncAndNum evaluates functions of the form f(traces, number) and
+// returns the traces and the number.
+func evalFuncAndNum(ctx *Context, node *Node, name string) ([]*types.PerfTrace, float64, error) {
+	if len(node.Args) != 2 {
+		return nil, 0, fmt.Errorf("%s() takes two arguments.", name)
+	}
+	if node.Args[0].Typ != NodeFunc {
+		return nil, 0, fmt.Errorf("%s() takes a function as its first argument.", name)
+	}
+	v, err := numArg(node, name, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	traces, err := node.Args[0].Eval(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s() failed evaluating argument: %s", name, err)
+	}
+	return traces, v, nil
+}
+
+// rolling replaces each value of each trace with stat() applied to the
+// window of the last n values, ending at that value. MISSING_DATA_SENTINEL
+// values are left untouched.
+func rolling(ctx *Context, node *Node, name string, stat func([]float64) (float64, error)) ([]*types.PerfTrace, error) {
+	traces, size, err := evalFuncAndNum(ctx, node, name)
+	if err != nil {
+		return nil, err
+	}
+	n := int(size)
+	if n < 1 || float64(n) != size {
+		return nil, fmt.Errorf("%s() takes a positive integer as the window size, got %s.", name, node.Args[1].Val)
+	}
+	for _, tr := range traces {
+		values := make([]float64, len(tr.Values))
+		for i, v := range tr.Values {
+			values[i] = config.MISSING_DATA_SENTINEL
+			if v == config.MISSING_DATA_SENTINEL {
+				continue
+			}
+			begin := i - n + 1
+			if begin < 0 {
+				begin = 0
+			}
+			if x, err := stat(tr.Values[begin : i+1]); err == nil {
+				values[i] = x
+			}
+		}
+		tr.Values = values
+	}
+	return traces, nil
+}
+
+type RollingMeanFunc struct{}
+
+// RollingMeanFunc implements Func and replaces each value with the mean of
+// the last N values of the trace, ignoring MISSING_DATA_SENTINEL values.
+func (RollingMeanFunc) Eval(ctx *Context, node *Node) ([]*types.PerfTrace, error) {
+	return rolling(ctx, node, "rolling_mean", func(values []float64) (float64, error) {
+		mean, _, err := vec.MeanAndStdDev(values)
+		return mean, err
+	})
+}
+
+func (RollingMeanFunc) Describe() string {
+	return `rolling_mean(traces, N) replaces each point with the mean of the last N points of the trace.
+
+  Missing points are not included in the mean.`
+}
+
+var rollingMeanFunc = RollingMeanFunc{}
+
+type RollingStdDevFunc struct{}
+
+// RollingStdDevFunc implements Func and replaces each value with the
+// standard deviation of the last N values of the trace, ignoring
+// MISSING_DATA_SENTINEL values.
+func (RollingStdDevFunc) Eval(ctx *Context, node *Node) ([]*types.PerfTrace, error) {
+	return rolling(ctx, node, "rolling_stddev", func(values []float64) (float64, error) {
+		_, stddev, err := vec.MeanAndStdDev(values)
+		return stddev, err
+	})
+}
+
+func (RollingStdDevFunc) Describe() string {
+	return `rolling_stddev(traces, N) replaces each point with the standard deviation of the last N points of the trace.
+
+  Missing points are not included in the standard deviation.`
+}
+
+var rollingStdDevFunc = RollingStdDevFunc{}
+
+// fold merges all the traces into a single trace by applying f() to the
+// non-missing values at each index. If all the values at an index are
+// MISSING_DATA_SENTINEL then the result is MISSING_DATA_SENTINEL.
+func fold(ctx *Context, traces []*types.PerfTrace, f func([]float64) float64) []*types.PerfTrace {
+	if len(traces) == 0 {
+		return traces
+	}
+	ret := types.NewPerfTraceN(len(traces[0].Values))
+	ret.Params()["id"] = tiling.AsFormulaID(ctx.formula)
+	values := make([]float64, 0, len(traces))
+	for i, _ := range ret.Values {
+		values = values[:0]
+		for _, tr := range traces {
+			if v := tr.Values[i]; v != config.MISSING_DATA_SENTINEL {
+				values = append(values, v)
+			}
+		}
+		if len(values) > 0 {
+			ret.Values[i] = f(values)
+		}
+	}
+	return []*types.PerfTrace{ret}
+}
+
+// evalFunc evaluates functions of the form f(traces).
+func evalFunc(ctx *Context, node *Node, name string) ([]*types.PerfTrace, error) {
+	if len(node.Args) != 1 {
+		return nil, fmt.Errorf("%s() takes a single argument.", name)
+	}
+	if node.Args[0].Typ != NodeFunc {
+		return nil, fmt.Errorf("%s() takes a function argument.", name)
+	}
+	traces, err := node.Args[0].Eval(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s() argument failed to evaluate: %s", name, err)
+	}
+	return traces, nil
+}
+
+type MinFunc struct{}
+
+// MinFunc implements Func and merges all argument traces into a single
+// trace of the minimum value at each point.
+func (MinFunc) Eval(ctx *Context, node *Node) ([]*types.PerfTrace, error) {
+	traces, err := evalFunc(ctx, node, "min")
+	if err != nil {
+		return nil, err
+	}
+	return fold(ctx, traces, func(values []float64) float64 {
+		ret := values[0]
+		for _, v := range values[1:] {
+			ret = math.Min(ret, v)
+		}
+		return ret
+	}), nil
+}
+
+func (MinFunc) Describe() string {
+	return `min() folds all argument traces into a single trace of the minimum value at each point.`
+}
+
+var minFunc = MinFunc{}
+
+type MaxFunc struct{}
+
+// MaxFunc implements Func and merges all argument traces into a single
+// trace of the maximum value at each point.
+func (MaxFunc) Eval(ctx *Context, node *Node) ([]*types.PerfTrace, error) {
+	traces, err := evalFunc(ctx, node, "max")
+	if err != nil {
+		return nil, err
+	}
+	return fold(ctx, traces, func(values []float64) float64 {
+		ret := values[0]
+		for _, v := range values[1:] {
+			ret = math.Max(ret, v)
+		}
+		return ret
+	}), nil
+}
+
+func (MaxFunc) Describe() string {
+	return `max() folds all argument traces into a single trace of the maximum value at each point.`
+}
+
+var maxFunc = MaxFunc{}
+
+type PercentileFunc struct{}
+
+// PercentileFunc implements Func and merges all argument traces into a
+// single trace of the p'th percentile of the values at each point. Values
+// between ranks are linearly interpolated.
+func (PercentileFunc) Eval(ctx *Context, node *Node) ([]*types.PerfTrace, error) {
+	traces, p, err := evalFuncAndNum(ctx, node, "percentile")
+	if err != nil {
+		return nil, err
+	}
+	if p < 0 || p > 100 {
+		return nil, fmt.Errorf("percentile() takes a percentile between 0 and 100, got %s.", node.Args[1].Val)
+	}
+	return fold(ctx, traces, func(values []float64) float64 {
+		sort.Float64s(values)
+		rank := p / 100 * float64(len(values)-1)
+		lower := int(math.Floor(rank))
+		if lower == len(values)-1 {
+			return values[lower]
+		}
+		return values[lower] + (rank-float64(lower))*(values[lower+1]-values[lower])
+	}), nil
+}
+
+func (PercentileFunc) Describe() string {
+	return `percentile(traces, p) folds all argument traces into a single trace of the p-th percentile, 0 to 100, of the values at each point.
+
+  For example, percentile(filter("config=gpu"), 50) is the median of all the gpu traces.`
+}
+
+var percentileFunc = PercentileFunc{}
+
+// mapValues applies f() to every value in the traces that isn't
+// MISSING_DATA_SENTINEL.
+func mapValues(traces []*types.PerfTrace, f func(float64) float64) {
+	for _, tr := range traces {
+		for i, v := range tr.Values {
+			if v != config.MISSING_DATA_SENTINEL {
+				tr.Values[i] = f(v)
+			}
+		}
+	}
+}
+
+type ScaleFunc struct{}
+
+// ScaleFunc implements Func and multiplies every value of the traces by a
+// constant.
+func (ScaleFunc) Eval(ctx *Context, node *Node) ([]*types.PerfTrace, error) {
+	traces, k, err := evalFuncAndNum(ctx, node, "scale")
+	if err != nil {
+		return nil, err
+	}
+	mapValues(traces, func(v float64) float64 { return v * k })
+	return traces, nil
+}
+
+func (ScaleFunc) Describe() string {
+	return `scale(traces, k) multiplies every point of the traces by k.`
+}
+
+var scaleFunc = ScaleFunc{}
+
+type ShiftFunc struct{}
+
+// ShiftFunc implements Func and adds a constant to every value of the
+// traces.
+func (ShiftFunc) Eval(ctx *Context, node *Node) ([]*types.PerfTrace, error) {
+	traces, k, err := evalFuncAndNum(ctx, node, "shift")
+	if err != nil {
+		return nil, err
+	}
+	mapValues(traces, func(v float64) float64 { return v + k })
+	return traces, nil
+}
+
+func (ShiftFunc) Describe() string {
+	return `shift(traces, k) adds k to every point of the traces.`
+}
+
+var shiftFunc = ShiftFunc{}
+
+// ArithFunc implements Func for binary arithmetic between the results of two
+// expressions, e.g. sub(a, b).
+//
+// If both expressions return a single trace the result is a single trace.
+// If one of them returns a single trace it is combined with each of the
+// traces of the other one. If either value at a point is
+// MISSING_DATA_SENTINEL, or the result isn't a finite number, then the
+// result at that point is MISSING_DATA_SENTINEL.
+type ArithFunc struct {
+	name string
+	op   func(a, b float64) float64
+	desc string
+}
+
+func (f ArithFunc) Eval(ctx *Context, node *Node) ([]*types.PerfTrace, error) {
+	if len(node.Args) != 2 {
+		return nil, fmt.Errorf("%s() takes two arguments.", f.name)
+	}
+	if node.Args[0].Typ != NodeFunc || node.Args[1].Typ != NodeFunc {
+		return nil, fmt.Errorf("%s() takes two function arguments.", f.name)
+	}
+	tracesA, err := node.Args[0].Eval(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s() argument failed to evaluate: %s", f.name, err)
+	}
+	tracesB, err := node.Args[1].Eval(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s() argument failed to evaluate: %s", f.name, err)
+	}
+	if len(tracesA) == 0 || len(tracesB) == 0 {
+		return []*types.PerfTrace{}, nil
+	}
+	if len(tracesA) > 1 && len(tracesB) > 1 {
+		return nil, fmt.Errorf("%s() requires at least one of its arguments to be a single trace, got %d and %d.", f.name, len(tracesA), len(tracesB))
+	}
+
+	apply := func(a, b float64) float64 {
+		if a == config.MISSING_DATA_SENTINEL || b == config.MISSING_DATA_SENTINEL {
+			return config.MISSING_DATA_SENTINEL
+		}
+		ret := f.op(a, b)
+		if math.IsInf(ret, 0) || math.IsNaN(ret) {
+			return config.MISSING_DATA_SENTINEL
+		}
+		return ret
+	}
+
+	if len(tracesA) == 1 && len(tracesB) == 1 {
+		ret := types.NewPerfTraceN(len(tracesA[0].Values))
+		ret.Params()["id"] = tiling.AsFormulaID(ctx.formula)
+		for i, _ := range ret.Values {
+			ret.Values[i] = apply(tracesA[0].Values[i], tracesB[0].Values[i])
+		}
+		return []*types.PerfTrace{ret}, nil
+	}
+
+	// Combine the single trace with each trace of the other argument, which
+	// keep their ids.
+	if len(tracesA) == 1 {
+		single := tracesA[0]
+		for _, tr := range tracesB {
+			for i, v := range tr.Values {
+				tr.Values[i] = apply(single.Values[i], v)
+			}
+		}
+		return tracesB, nil
+	}
+	single := tracesB[0]
+	for _, tr := range tracesA {
+		for i, v := range tr.Values {
+			tr.Values[i] = apply(v, single.Values[i])
+		}
+	}
+	return tracesA, nil
+}
+
+func (f ArithFunc) Describe() string {
+	return f.desc
+}
+
+const arithDescription = `
+
+  One of the arguments must be a single trace, which is combined with each trace of the other argument.`
+
+var addFunc = ArithFunc{
+	name: "add",
+	op:   func(a, b float64) float64 { return a + b },
+	desc: `add(a, b) returns the point by point sum of the traces a and b.` + arithDescription,
+}
+
+var subFunc = ArithFunc{
+	name: "sub",
+	op:   func(a, b float64) float64 { return a - b },
+	desc: `sub(a, b) returns the point by point difference a[i]-b[i] of the traces a and b.` + arithDescription,
+}
+
+var mulFunc = ArithFunc{
+	name: "mul",
+	op:   func(a, b float64) float64 { return a * b },
+	desc: `mul(a, b) returns the point by point product of the traces a and b.` + arithDescription,
+}
+
+var divFunc = ArithFunc{
+	name: "div",
+	op:   func(a, b float64) float64 { return a / b },
+	desc: `div(a, b) returns the point by point quotient a[i]/b[i] of the traces a and b.
+
+  Division by zero results in a missing point.` + arithDescription,
+}
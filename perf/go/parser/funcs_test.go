@@ -0,0 +1,157 @@
+package parser
+
+import (
+	"testing"
+
+	"go.skia.org/infra/perf/go/types"
+)
+
+const e = 1e100
+
+func TestFuncs(t *testing.T) {
+	testCases := []struct {
+		formula string
+		t1      []float64
+		t2      []float64
+		// want maps the id of each returned trace to its values.
+		want map[string][]float64
+	}{
+		{
+			formula: `rolling_mean(filter("config=8888"), 2)`,
+			t1:      []float64{1, 3, e, 5, 7},
+			t2:      []float64{0, 0, 0, 0, 0},
+			want:    map[string][]float64{"!t1": {1, 2, e, 5, 6}},
+		},
+		{
+			formula: `rolling_mean(filter("config=8888"), 3)`,
+			t1:      []float64{3, 6, 9, 12},
+			t2:      []float64{0, 0, 0, 0},
+			want:    map[string][]float64{"!t1": {3, 4.5, 6, 9}},
+		},
+		{
+			formula: `rolling_stddev(filter("config=8888"), 2)`,
+			t1:      []float64{1, 3, 3, e, 1},
+			t2:      []float64{0, 0, 0, 0, 0},
+			want:    map[string][]float64{"!t1": {0, 1, 0, e, 0}},
+		},
+		{
+			formula: `min(filter(""))`,
+			t1:      []float64{1, 5, e, e},
+			t2:      []float64{2, -1, 3, e},
+			want:    map[string][]float64{`@min(filter(""))`: {1, -1, 3, e}},
+		},
+		{
+			formula: `max(filter(""))`,
+			t1:      []float64{1, 5, e, e},
+			t2:      []float64{2, -1, 3, e},
+			want:    map[string][]float64{`@max(filter(""))`: {2, 5, 3, e}},
+		},
+		{
+			formula: `percentile(filter(""), 50)`,
+			t1:      []float64{1, 5, e, e},
+			t2:      []float64{2, -1, 3, e},
+			want:    map[string][]float64{`@percentile(filter(""), 50)`: {1.5, 2, 3, e}},
+		},
+		{
+			formula: `percentile(filter(""), 100)`,
+			t1:      []float64{1, 5, e},
+			t2:      []float64{2, -1, 3},
+			want:    map[string][]float64{`@percentile(filter(""), 100)`: {2, 5, 3}},
+		},
+		{
+			formula: `scale(filter("config=gpu"), 2.5)`,
+			t1:      []float64{1, 1},
+			t2:      []float64{2, e},
+			want:    map[string][]float64{"!t2": {5, e}},
+		},
+		{
+			formula: `shift(filter("config=gpu"), -1)`,
+			t1:      []float64{1, 1},
+			t2:      []float64{2, e},
+			want:    map[string][]float64{"!t2": {1, e}},
+		},
+		{
+			formula: `sub(filter("config=gpu"), filter("config=8888"))`,
+			t1:      []float64{1, 2, e},
+			t2:      []float64{4, 4, 4},
+			want:    map[string][]float64{`@sub(filter("config=gpu"), filter("config=8888"))`: {3, 2, e}},
+		},
+		{
+			formula: `div(filter("config=gpu"), filter("config=8888"))`,
+			t1:      []float64{2, 0, 0},
+			t2:      []float64{4, 4, 0},
+			want:    map[string][]float64{`@div(filter("config=gpu"), filter("config=8888"))`: {2, e, e}},
+		},
+		{
+			formula: `mul(filter(""), ave(filter("config=8888")))`,
+			t1:      []float64{1, 2, e},
+			t2:      []float64{4, 4, 4},
+			want: map[string][]float64{
+				"!t1": {1, 4, e},
+				"!t2": {4, 8, e},
+			},
+		},
+		{
+			formula: `add(max(filter("")), filter(""))`,
+			t1:      []float64{1, 2},
+			t2:      []float64{4, e},
+			want: map[string][]float64{
+				"!t1": {5, 4},
+				"!t2": {8, e},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		ctx := newTestContext()
+		ctx.Tile.Traces["t1"].(*types.PerfTrace).Values = tc.t1
+		ctx.Tile.Traces["t2"].(*types.PerfTrace).Values = tc.t2
+		traces, err := ctx.Eval(tc.formula)
+		if err != nil {
+			t.Errorf("Failed to eval %q: %s", tc.formula, err)
+			continue
+		}
+		if got, want := len(traces), len(tc.want); got != want {
+			t.Errorf("%q returned wrong length: Got %v Want %v", tc.formula, got, want)
+			continue
+		}
+		for _, tr := range traces {
+			id := tr.Params()["id"]
+			want, ok := tc.want[id]
+			if !ok {
+				t.Errorf("%q returned unexpected trace %q", tc.formula, id)
+				continue
+			}
+			for i, w := range want {
+				if got := tr.Values[i]; !near(got, w) {
+					t.Errorf("%q mismatch for %q at %d: Got %v Want %v", tc.formula, id, i, got, w)
+				}
+			}
+		}
+	}
+}
+
+func TestFuncsErrors(t *testing.T) {
+	ctx := newTestContext()
+
+	testCases := []string{
+		`rolling_mean(filter(""))`,
+		`rolling_mean(filter(""), 0)`,
+		`rolling_mean(filter(""), 1.5)`,
+		`rolling_stddev(2, 2)`,
+		`rolling_stddev(filter(""), "2")`,
+		`percentile(filter(""), 101)`,
+		`percentile(filter(""))`,
+		`min(2)`,
+		`max()`,
+		`scale(filter(""))`,
+		`shift(filter(""), "foo")`,
+		`add(filter(""))`,
+		`sub(filter(""), 2)`,
+		`div(filter(""), filter(""))`,
+	}
+	for _, tc := range testCases {
+		if _, err := ctx.Eval(tc); err == nil {
+			t.Errorf("Expected %q to fail.", tc)
+		}
+	}
+}
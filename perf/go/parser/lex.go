@@ -201,11 +201,12 @@ func lexNumber(l *lexer) stateFn {
 	return lexExp
 }
 
-// lexIdentifier parses function names.
+// lexIdentifier parses function names. After the leading letter names may
+// contain letters, digits and underscores, e.g. rolling_mean.
 func lexIdentifier(l *lexer) stateFn {
 	for {
 		r := l.next()
-		if !unicode.IsLetter(rune(r)) && !unicode.IsDigit(rune(r)) {
+		if !unicode.IsLetter(rune(r)) && !unicode.IsDigit(rune(r)) && r != '_' {
 			l.backUp()
 			break
 		}
@@ -58,6 +58,18 @@ func TestLex(t *testing.T) {
 				item{itemEOF, ""},
 			},
 		},
+		{
+			input: "rolling_mean(a2, 3)",
+			items: []item{
+				item{itemIdentifier, "rolling_mean"},
+				item{itemLParen, "("},
+				item{itemIdentifier, "a2"},
+				item{itemComma, ","},
+				item{itemNum, "3"},
+				item{itemRParen, ")"},
+				item{itemEOF, ""},
+			},
+		},
 	}
 	for _, tc := range testCases {
 		l := newLexer(tc.input)
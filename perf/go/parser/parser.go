@@ -67,16 +67,27 @@ func NewContext(tile *tiling.Tile) *Context {
 	return &Context{
 		Tile: tile,
 		Funcs: map[string]Func{
-			"filter": filterFunc,
-			"norm":   normFunc,
-			"fill":   fillFunc,
-			"ave":    aveFunc,
-			"avg":    aveFunc,
-			"count":  countFunc,
-			"ratio":  ratioFunc,
-			"sum":    sumFunc,
-			"geo":    geoFunc,
-			"log":    logFunc,
+			"filter":         filterFunc,
+			"norm":           normFunc,
+			"fill":           fillFunc,
+			"ave":            aveFunc,
+			"avg":            aveFunc,
+			"count":          countFunc,
+			"ratio":          ratioFunc,
+			"sum":            sumFunc,
+			"geo":            geoFunc,
+			"log":            logFunc,
+			"rolling_mean":   rollingMeanFunc,
+			"rolling_stddev": rollingStdDevFunc,
+			"percentile":     percentileFunc,
+			"min":            minFunc,
+			"max":            maxFunc,
+			"scale":          scaleFunc,
+			"shift":          shiftFunc,
+			"add":            addFunc,
+			"sub":            subFunc,
+			"mul":            mulFunc,
+			"div":            divFunc,
 		},
 	}
 }
@@ -38,6 +38,11 @@ func TestFilter(t *testing.T) {
 		{`filter("config=8888")`, 1},
 		{`filter("config=gpu")`, 1},
 		{`filter("config=565")`, 0},
+		{`filter("config=!8888")`, 1},
+		{`filter("config=!8888&config=!gpu")`, 0},
+		{`filter("config=~^8")`, 1},
+		{`filter("config=!~^g")`, 1},
+		{`filter("os=~^Ubuntu")`, 2},
 	}
 	for _, tc := range testCases {
 		traces, err := ctx.Eval(tc.input)
@@ -14,6 +14,7 @@ import (
 	"go.skia.org/infra/go/gitinfo"
 	"go.skia.org/infra/go/human"
 	"go.skia.org/infra/go/query"
+	"go.skia.org/infra/go/util"
 	"go.skia.org/infra/go/vcsinfo"
 	"go.skia.org/infra/perf/go/dataframe"
 	"go.skia.org/infra/perf/go/ptracestore"
@@ -27,11 +28,14 @@ const (
 // Command line flags.
 var (
 	begin          = flag.String("begin", "1w", "Select the commit ids for the range beginning this long ago.")
+	downsampled    = flag.Bool("downsampled", false, "Read the daily summaries from the compacted store instead of the values of each commit.")
 	end            = flag.String("end", "0s", "Select the commit ids for the range ending this long ago.")
+	format         = flag.String("format", "csv", "The format of the 'export' command output, either csv or json.")
 	gitRepoDir     = flag.String("git_repo_dir", "../../../skia", "Directory location for the Skia repo.")
 	gitRepoURL     = flag.String("git_repo_url", "https://skia.googlesource.com/skia", "The URL to pass to git clone for the source repository.")
 	ptraceStoreDir = flag.String("ptrace_store_dir", "/tmp/ptracestore", "The directory where the ptracestore tiles are stored.")
 	queryStr       = flag.String("query", "", "A URL encoded query to filter traces against.")
+	stat           = flag.String("stat", "median", "The daily summary value to read with --downsampled, one of min, median or max.")
 	verbose        = flag.Bool("verbose", false, "Verbose.")
 )
 
@@ -56,6 +60,27 @@ Commands:
 
             	Flags: --begin --end --query
 
+  export    	Write the traces that match a query to stdout as CSV, one row per
+            	trace with the params as columns, or as JSON lines.
+
+            	Flags: --begin --end --query --format
+
+  compact   	Compact the values of each day in the given time range into daily
+            	summaries.
+
+            	Flags: --begin --end
+
+  compacted 	List the days in the given time range and the number of commits
+            	each day was compacted from.
+
+            	Flags: --begin --end
+
+The count, sample, query and export commands read the daily min, median or
+max from the compacted store instead of the values of each commit if
+--downsampled is given.
+
+            	Flags: --downsampled --stat
+
 Examples:
 
   To count all the traces for the first 6 days of the previous week:
@@ -68,33 +93,56 @@ Examples:
 
     ptracequery query --begin=3d --query='test=draw_stroke_bezier&arch=!x86'
 
+  To export the last week of values for the same traces as CSV:
+
+    ptracequery export --begin=1w --query='test=draw_stroke_bezier&arch=!x86' > bezier.csv
+
+  To return the daily maximum over the last 90 days for the same traces:
+
+    ptracequery query --begin=90d --downsampled --stat=max --query='test=draw_stroke_bezier&arch=!x86'
+
 Flags:
 
 `)
 	flag.PrintDefaults()
 }
 
-// _df returns the DataFrame that matches the given query in the range of the
-// --begin and --end command line flags.
-func _df(vcs vcsinfo.VCS, store ptracestore.PTraceStore, q *query.Query) (*dataframe.DataFrame, error) {
+// timeRange returns the time range given by the --begin and --end command
+// line flags.
+func timeRange() (time.Time, time.Time, error) {
 	now := time.Now()
 	b, err := human.ParseDuration(*begin)
 	if err != nil {
-		return nil, fmt.Errorf("Invalid begin value: %s\n", err)
+		return time.Time{}, time.Time{}, fmt.Errorf("Invalid begin value: %s\n", err)
 	}
 	e, err := human.ParseDuration(*end)
 	if err != nil {
-		return nil, fmt.Errorf("Invalid begin value: %s\n", err)
+		return time.Time{}, time.Time{}, fmt.Errorf("Invalid begin value: %s\n", err)
+	}
+	return now.Add(-b), now.Add(-e), nil
+}
+
+// _df returns the DataFrame that matches the given query in the range of the
+// --begin and --end command line flags. It has one column per commit unless
+// --downsampled is given, in which case it has one column per day.
+func _df(vcs vcsinfo.VCS, store ptracestore.DownsampledPTraceStore, q *query.Query) (*dataframe.DataFrame, error) {
+	beginTime, endTime, err := timeRange()
+	if err != nil {
+		return nil, err
 	}
-	beginTime := now.Add(-b)
-	endTime := now.Add(-e)
 	if *verbose {
 		fmt.Printf("Requesting from %s to %s\n", beginTime, endTime)
 	}
-	return dataframe.NewFromQueryAndRange(vcs, store, beginTime, endTime, q)
+	if *downsampled {
+		if !util.In(*stat, ptracestore.AllStats) {
+			return nil, fmt.Errorf("Invalid stat value: %q\n", *stat)
+		}
+		return dataframe.NewDownsampledFromQueryAndRange(vcs, store, beginTime, endTime, q, ptracestore.Stat(*stat))
+	}
+	return dataframe.NewFullResolutionFromQueryAndRange(vcs, store, beginTime, endTime, q)
 }
 
-func count(vcs vcsinfo.VCS, store ptracestore.PTraceStore) {
+func count(vcs vcsinfo.VCS, store ptracestore.DownsampledPTraceStore) {
 	df, err := _df(vcs, store, &query.Query{})
 	if err != nil {
 		fmt.Printf("Failed to load traces: %s", err)
@@ -103,7 +151,7 @@ func count(vcs vcsinfo.VCS, store ptracestore.PTraceStore) {
 	fmt.Printf("Count: %d\n", len(df.TraceSet))
 }
 
-func match(vcs vcsinfo.VCS, store ptracestore.PTraceStore) {
+func match(vcs vcsinfo.VCS, store ptracestore.DownsampledPTraceStore) {
 	u, err := url.ParseQuery(*queryStr)
 	if err != nil {
 		fmt.Printf("Not a valid URL query %q: %s", *queryStr, err)
@@ -122,7 +170,32 @@ func match(vcs vcsinfo.VCS, store ptracestore.PTraceStore) {
 	}
 }
 
-func sample(vcs vcsinfo.VCS, store ptracestore.PTraceStore) {
+func export(vcs vcsinfo.VCS, store ptracestore.DownsampledPTraceStore) {
+	if !util.In(*format, dataframe.AllExportFormats) {
+		fmt.Printf("Invalid format value: %q\n", *format)
+		return
+	}
+	u, err := url.ParseQuery(*queryStr)
+	if err != nil {
+		fmt.Printf("Not a valid URL query %q: %s", *queryStr, err)
+		return
+	}
+	q, err := query.New(u)
+	if err != nil {
+		fmt.Printf("Not a valid query %q: %s", *queryStr, err)
+		return
+	}
+	df, err := _df(vcs, store, q)
+	if err != nil {
+		fmt.Printf("Failed to load traces: %s", err)
+		return
+	}
+	if err := df.Export(os.Stdout, dataframe.ExportFormat(*format)); err != nil {
+		glog.Errorf("Failed to export: %s", err)
+	}
+}
+
+func sample(vcs vcsinfo.VCS, store ptracestore.DownsampledPTraceStore) {
 	df, err := _df(vcs, store, &query.Query{})
 	if err != nil {
 		fmt.Printf("Failed to load traces: %s", err)
@@ -152,6 +225,33 @@ func sample(vcs vcsinfo.VCS, store ptracestore.PTraceStore) {
 	}
 }
 
+func compact(vcs vcsinfo.VCS, store ptracestore.DownsampledPTraceStore) {
+	beginTime, endTime, err := timeRange()
+	if err != nil {
+		fmt.Printf("%s", err)
+		return
+	}
+	if err := ptracestore.CompactRange(vcs, store, beginTime, endTime, true); err != nil {
+		fmt.Printf("Failed to compact: %s", err)
+	}
+}
+
+func compacted(vcs vcsinfo.VCS, store ptracestore.DownsampledPTraceStore) {
+	beginTime, endTime, err := timeRange()
+	if err != nil {
+		fmt.Printf("%s", err)
+		return
+	}
+	for day := ptracestore.NewDayID("master", beginTime); day.Time().Before(endTime); day.Day++ {
+		n, err := store.Compacted(day)
+		if err != nil {
+			fmt.Printf("Failed to read compacted days: %s", err)
+			return
+		}
+		fmt.Printf("%s: %d\n", day.Time().Format("2006-01-02"), n)
+	}
+}
+
 func main() {
 	rand.Seed(time.Now().Unix())
 	flag.Usage = Usage
@@ -178,6 +278,12 @@ func main() {
 		sample(git, ptracestore.Default)
 	case "query":
 		match(git, ptracestore.Default)
+	case "export":
+		export(git, ptracestore.Default)
+	case "compact":
+		compact(git, ptracestore.Default)
+	case "compacted":
+		compacted(git, ptracestore.Default)
 	default:
 		fmt.Printf("Unknown command: %s\n", cmd)
 		Usage()
@@ -0,0 +1,101 @@
+package ptracestore
+
+import (
+	"sort"
+	"time"
+
+	"github.com/skia-dev/glog"
+	"go.skia.org/infra/go/metrics2"
+	"go.skia.org/infra/go/vcsinfo"
+)
+
+const (
+	// COMPACTOR_LOOKBACK is how far back the compactor always recompacts
+	// days, since data for recent commits may still be arriving.
+	COMPACTOR_LOOKBACK = 3 * 24 * time.Hour
+
+	// COMPACTOR_BACKFILL is how far back the compactor looks for days that
+	// have never been compacted when it starts.
+	COMPACTOR_BACKFILL = 365 * 24 * time.Hour
+
+	// COMPACTOR_INTERVAL is how often the compactor runs.
+	COMPACTOR_INTERVAL = time.Hour
+)
+
+// commitsByDay groups the given commits by the day they were made on. The
+// returned DayIDs are in chronological order.
+func commitsByDay(commits []*vcsinfo.IndexCommit) ([]*DayID, map[int][]*CommitID) {
+	days := []*DayID{}
+	byDay := map[int][]*CommitID{}
+	for _, c := range commits {
+		dayID := NewDayID("master", c.Timestamp)
+		if _, ok := byDay[dayID.Day]; !ok {
+			days = append(days, dayID)
+		}
+		byDay[dayID.Day] = append(byDay[dayID.Day], &CommitID{
+			Offset: c.Index,
+			Source: "master",
+		})
+	}
+	sort.Sort(dayIDSlice(days))
+	return days, byDay
+}
+
+// CompactRange compacts all the days with commits in the time range
+// [begin, end). If 'force' is false then days that have already been
+// compacted from the same number of commits are skipped.
+func CompactRange(vcs vcsinfo.VCS, store DownsampledPTraceStore, begin, end time.Time, force bool) error {
+	// Start at the beginning of the day so all the commits of the first day
+	// are included.
+	begin = NewDayID("master", begin).Time()
+	days, byDay := commitsByDay(vcs.Range(begin.Add(-time.Second), end))
+	for _, dayID := range days {
+		commitIDs := byDay[dayID.Day]
+		if !force {
+			n, err := store.Compacted(dayID)
+			if err != nil {
+				return err
+			}
+			if n == len(commitIDs) {
+				continue
+			}
+		}
+		if err := store.Compact(dayID, commitIDs); err != nil {
+			return err
+		}
+		glog.Infof("Compacted %d commits for %s.", len(commitIDs), dayID.Time().Format("2006-01-02"))
+	}
+	return nil
+}
+
+// StartCompactor starts a background process that keeps the daily summaries
+// of the store up to date. Days in the last year that have never been
+// compacted are compacted first, then the most recent days are recompacted
+// every 'interval'.
+func StartCompactor(vcs vcsinfo.VCS, store DownsampledPTraceStore, interval time.Duration) {
+	liveness := metrics2.NewLiveness("perf.ptracestore.compactor")
+	go func() {
+		now := time.Now()
+		if err := CompactRange(vcs, store, now.Add(-COMPACTOR_BACKFILL), now, false); err != nil {
+			glog.Errorf("Failed to backfill downsampled tiles: %s", err)
+		}
+		for _ = range time.Tick(interval) {
+			if err := vcs.Update(true, false); err != nil {
+				glog.Errorf("Failed to update repo: %s", err)
+			}
+			now := time.Now()
+			if err := CompactRange(vcs, store, now.Add(-COMPACTOR_LOOKBACK), now, true); err != nil {
+				glog.Errorf("Failed to compact recent days: %s", err)
+				continue
+			}
+			liveness.Reset()
+		}
+	}()
+}
+
+// dayIDSlice sorts DayIDs chronologically.
+type dayIDSlice []*DayID
+
+func (p dayIDSlice) Len() int           { return len(p) }
+func (p dayIDSlice) Less(i, j int) bool { return p[i].Day < p[j].Day }
+func (p dayIDSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -67,5 +67,31 @@
 
   The largest sourceIndex used is stored at the key 'lastSourceIndex' and is incremented
   when new sourceFullname's are added.
+
+  Downsampling
+  ------------
+
+  Loading long time ranges means opening many tiles, so the values of each
+  day, in UTC, are also summarized by the compactor, see StartCompactor. The
+  summaries for every 50 days are stored in their own BoltDB database in the
+  'downsampled' subdirectory, structured as:
+
+    Bucket     | Key              | Value
+   ------------+------------------+-----------------------
+    summaries  | traceid          | [index, min, median, max]*
+   ------------+------------------+-----------------------
+    compacted  | index            | number of commits
+   ------------+------------------+-----------------------
+
+  Where index is the day within the tile, i.e. in [0, 49], and min, median and
+  max are float32s calculated over the values of the trace at all the commits
+  of that day. Days are compacted again while data may still arrive for them,
+  which replaces their summaries. The 'compacted' bucket records how many
+  commits each day was compacted from, so days that are complete aren't
+  compacted again.
+
+  DataFrames that span more than dataframe.MAX_FULL_RESOLUTION_COMMITS commits
+  are built from the daily medians, see MatchDays, if all the days in the range
+  have been compacted.
 */
 package ptracestore
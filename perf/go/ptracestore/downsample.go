@@ -0,0 +1,326 @@
+package ptracestore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.skia.org/infra/go/query"
+)
+
+const (
+	// DAYS_PER_TILE is the number of days of summaries stored in a single
+	// downsampled tile.
+	DAYS_PER_TILE = 50
+
+	// DOWNSAMPLED_DIR is the subdirectory where downsampled tiles are stored.
+	DOWNSAMPLED_DIR = "downsampled"
+
+	SUMMARIES_BUCKET_NAME = "summaries"
+	COMPACTED_BUCKET_NAME = "compacted"
+
+	secondsPerDay = 24 * 60 * 60
+)
+
+// Stat selects one of the values stored in the summary of a day.
+type Stat string
+
+const (
+	MIN    Stat = "min"
+	MEDIAN Stat = "median"
+	MAX    Stat = "max"
+)
+
+// AllStats is used to validate Stat values.
+var AllStats = []string{string(MIN), string(MEDIAN), string(MAX)}
+
+// DayID identifies a single day, in UTC, of commits from a single source.
+type DayID struct {
+	Day    int    // The number of days since the Unix epoch.
+	Source string // The branch name, e.g. "master".
+}
+
+// NewDayID returns the DayID of the day that contains time 't'.
+func NewDayID(source string, t time.Time) *DayID {
+	return &DayID{
+		Day:    int(t.Unix() / secondsPerDay),
+		Source: source,
+	}
+}
+
+// Time returns the beginning of the day.
+func (d DayID) Time() time.Time {
+	return time.Unix(int64(d.Day)*secondsPerDay, 0).UTC()
+}
+
+// Filename returns a safe filename to be used as part of the underlying
+// BoltDB downsampled tile name.
+func (d DayID) Filename() string {
+	return filepath.Join(DOWNSAMPLED_DIR, fmt.Sprintf("%s-%06d.bdb", safeRe.ReplaceAllLiteralString(d.Source, "_"), d.Day/DAYS_PER_TILE))
+}
+
+// DownsampledPTraceStore is a PTraceStore that can also store and retrieve
+// daily summaries of the traces, which are much faster to load for long time
+// ranges.
+type DownsampledPTraceStore interface {
+	PTraceStore
+
+	// Compact calculates the min, median and max of the values of every trace
+	// at the given commits, which should be all the commits of the given
+	// day, and stores them as the summary of the day. Compacting a day again
+	// replaces its summaries.
+	Compact(dayID *DayID, commitIDs []*CommitID) error
+
+	// Compacted returns the number of commits the summaries of the given day
+	// were calculated from, or 0 if the day hasn't been compacted.
+	Compacted(dayID *DayID) (int, error)
+
+	// MatchDays returns a TraceSet of the summary value 'stat' of the traces
+	// that match the given Query, one point for each DayID.
+	MatchDays(dayIDs []*DayID, q *query.Query, stat Stat) (TraceSet, error)
+}
+
+// summaryValue is used to encode/decode the summary of a trace for a day.
+type summaryValue struct {
+	Index  int64
+	Min    float32
+	Median float32
+	Max    float32
+}
+
+// summaryValueSize is the size of an encoded summaryValue.
+var summaryValueSize = binary.Size(summaryValue{})
+
+// get returns the value selected by 'stat'.
+func (s summaryValue) get(stat Stat) float32 {
+	switch stat {
+	case MIN:
+		return s.Min
+	case MAX:
+		return s.Max
+	default:
+		return s.Median
+	}
+}
+
+// summarize returns the summary of the non-missing values in the trace, and
+// false if all the values are missing.
+func summarize(tr Trace) (summaryValue, bool) {
+	values := make([]float64, 0, len(tr))
+	for _, v := range tr {
+		if v != MISSING_VALUE {
+			values = append(values, float64(v))
+		}
+	}
+	if len(values) == 0 {
+		return summaryValue{}, false
+	}
+	sort.Float64s(values)
+	n := len(values)
+	median := values[n/2]
+	if n%2 == 0 {
+		median = (values[n/2-1] + values[n/2]) / 2
+	}
+	return summaryValue{
+		Min:    float32(values[0]),
+		Median: float32(median),
+		Max:    float32(values[n-1]),
+	}, true
+}
+
+// removeIndex returns a copy of the encoded summaryValues in 'b' without the
+// ones for the given index.
+func removeIndex(b []byte, index int64) []byte {
+	ret := make([]byte, 0, len(b)+summaryValueSize)
+	for ; len(b) >= summaryValueSize; b = b[summaryValueSize:] {
+		if int64(binary.LittleEndian.Uint64(b)) != index {
+			ret = append(ret, b[:summaryValueSize]...)
+		}
+	}
+	return ret
+}
+
+func (b *BoltTraceStore) Compact(dayID *DayID, commitIDs []*CommitID) error {
+	traceSet, err := b.Match(commitIDs, &query.Query{})
+	if err != nil {
+		return fmt.Errorf("Failed to load traces to compact: %s", err)
+	}
+	db, err := b.getBoltDBByName(dayID.Filename())
+	if err != nil {
+		return fmt.Errorf("Unable to open downsampled datastore: %s", err)
+	}
+
+	index := int64(dayID.Day % DAYS_PER_TILE)
+	summaries := map[string][]byte{}
+	for traceID, tr := range traceSet {
+		summary, ok := summarize(tr)
+		if !ok {
+			continue
+		}
+		summary.Index = index
+		valueBytes, err := serialize(summary)
+		if err != nil {
+			return err
+		}
+		summaries[traceID] = valueBytes
+	}
+
+	addSummaries := func(tx *bolt.Tx) error {
+		t, err := tx.CreateBucketIfNotExists([]byte(SUMMARIES_BUCKET_NAME))
+		if err != nil {
+			return fmt.Errorf("Failed to get bucket: %s", err)
+		}
+		// Replace any previous summaries for the day, including those of
+		// traces that no longer have any values on the day. The bucket can't
+		// be modified while iterating over it, so collect the changes first.
+		updates := map[string][]byte{}
+		cur := t.Cursor()
+		for k, v := cur.First(); k != nil; k, v = cur.Next() {
+			if removed := removeIndex(v, index); len(removed) != len(v) {
+				updates[string(k)] = removed
+			}
+		}
+		for traceID, valueBytes := range summaries {
+			old, ok := updates[traceID]
+			if !ok {
+				old = removeIndex(t.Get([]byte(traceID)), index)
+			}
+			updates[traceID] = append(old, valueBytes...)
+		}
+		for traceID, b := range updates {
+			if len(b) == 0 {
+				if err := t.Delete([]byte(traceID)); err != nil {
+					return fmt.Errorf("bucket.Delete() of summary failed: %s", err)
+				}
+				continue
+			}
+			if err := t.Put([]byte(traceID), b); err != nil {
+				return fmt.Errorf("bucket.Put() of summary failed: %s", err)
+			}
+		}
+
+		c, err := tx.CreateBucketIfNotExists([]byte(COMPACTED_BUCKET_NAME))
+		if err != nil {
+			return fmt.Errorf("Failed to get bucket: %s", err)
+		}
+		return c.Put(uint64ToBytes(uint64(index)), uint64ToBytes(uint64(len(commitIDs))))
+	}
+
+	if err := db.Update(addSummaries); err != nil {
+		return fmt.Errorf("Error while writing summaries: %s", err)
+	}
+	return nil
+}
+
+// tileExists returns true if the tile with the given filename, relative to
+// the store directory, exists. Reads check for it since getBoltDBByName
+// creates missing tiles.
+func (b *BoltTraceStore) tileExists(name string) (bool, error) {
+	if _, err := os.Stat(filepath.Join(b.dir, name)); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *BoltTraceStore) Compacted(dayID *DayID) (int, error) {
+	exists, err := b.tileExists(dayID.Filename())
+	if err != nil {
+		return 0, fmt.Errorf("Unable to check for downsampled datastore: %s", err)
+	}
+	if !exists {
+		return 0, nil
+	}
+	db, err := b.getBoltDBByName(dayID.Filename())
+	if err != nil {
+		return 0, fmt.Errorf("Unable to open downsampled datastore: %s", err)
+	}
+	ret := 0
+	get := func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(COMPACTED_BUCKET_NAME))
+		if c == nil {
+			return nil
+		}
+		if v := c.Get(uint64ToBytes(uint64(dayID.Day % DAYS_PER_TILE))); len(v) == 8 {
+			ret = int(binary.LittleEndian.Uint64(v))
+		}
+		return nil
+	}
+	if err := db.View(get); err != nil {
+		return 0, fmt.Errorf("Error while reading compacted days: %s", err)
+	}
+	return ret, nil
+}
+
+func (b *BoltTraceStore) MatchDays(dayIDs []*DayID, q *query.Query, stat Stat) (TraceSet, error) {
+	// Map each downsampled tile to a map from the index of the day in the
+	// tile to the index of the day in the returned Traces, see buildMapper.
+	mapper := map[string]map[int]int{}
+	for targetIndex, dayID := range dayIDs {
+		name := dayID.Filename()
+		if _, ok := mapper[name]; !ok {
+			mapper[name] = map[int]int{}
+		}
+		mapper[name][dayID.Day%DAYS_PER_TILE] = targetIndex
+	}
+
+	ret := TraceSet{}
+	for name, idxmap := range mapper {
+		exists, err := b.tileExists(name)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to check for downsampled datastore: %s", err)
+		}
+		if !exists {
+			// Nothing has been compacted into this tile yet.
+			continue
+		}
+		db, err := b.getBoltDBByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to open downsampled datastore: %s", err)
+		}
+		get := func(tx *bolt.Tx) error {
+			bucket := tx.Bucket([]byte(SUMMARIES_BUCKET_NAME))
+			if bucket == nil {
+				// Nothing has been compacted into this tile yet.
+				return nil
+			}
+			v := bucket.Cursor()
+			value := summaryValue{}
+			for btraceid, rawValues := v.First(); btraceid != nil; btraceid, rawValues = v.Next() {
+				if !q.Matches(string(btraceid)) {
+					continue
+				}
+				traceid := string(dup(btraceid))
+				trace := ret[traceid]
+				if trace == nil {
+					ret[traceid] = NewTrace(len(dayIDs))
+					trace = ret[traceid]
+				}
+				buf := bytes.NewBuffer(rawValues)
+				for {
+					if err := binary.Read(buf, binary.LittleEndian, &value); err != nil {
+						break
+					}
+					if offset, ok := idxmap[int(value.Index)]; ok {
+						trace[offset] = value.get(stat)
+					}
+				}
+			}
+			return nil
+		}
+		if err := db.View(get); err != nil {
+			return nil, fmt.Errorf("Failed to load summaries from %s: %s", name, err)
+		}
+	}
+	return ret, nil
+}
+
+// Ensure that *BoltTraceStore implements DownsampledPTraceStore.
+var _ DownsampledPTraceStore = &BoltTraceStore{}
@@ -0,0 +1,136 @@
+package ptracestore
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.skia.org/infra/go/query"
+	"go.skia.org/infra/go/vcsinfo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDayID(t *testing.T) {
+	d := NewDayID("master", time.Date(2016, 9, 1, 23, 59, 0, 0, time.UTC))
+	assert.Equal(t, 17045, d.Day)
+	assert.Equal(t, time.Date(2016, 9, 1, 0, 0, 0, 0, time.UTC), d.Time())
+	assert.Equal(t, "downsampled/master-000340.bdb", d.Filename())
+
+	d = NewDayID("https://codereview.chromium.org/2251213006", time.Unix(0, 0))
+	assert.Equal(t, 0, d.Day)
+	assert.Equal(t, "downsampled/https___codereview_chromium_org_2251213006-000000.bdb", d.Filename())
+}
+
+func TestSummarize(t *testing.T) {
+	s, ok := summarize(Trace{3, MISSING_VALUE, 1, 2})
+	assert.True(t, ok)
+	assert.Equal(t, summaryValue{Min: 1, Median: 2, Max: 3}, s)
+
+	s, ok = summarize(Trace{4, 1, 2, 3})
+	assert.True(t, ok)
+	assert.Equal(t, summaryValue{Min: 1, Median: 2.5, Max: 4}, s)
+
+	_, ok = summarize(Trace{MISSING_VALUE, MISSING_VALUE})
+	assert.False(t, ok)
+}
+
+func TestCompact(t *testing.T) {
+	setupStoreDir(t)
+	defer cleanup()
+
+	d, err := New(tmpDir)
+	assert.NoError(t, err)
+
+	// Two commits on the first day, one in the next tile, and one commit on
+	// the second day.
+	day1Commits := []*CommitID{
+		&CommitID{Offset: 49, Source: "master"},
+		&CommitID{Offset: 50, Source: "master"},
+	}
+	day2Commits := []*CommitID{
+		&CommitID{Offset: 51, Source: "master"},
+	}
+	assert.NoError(t, d.Add(day1Commits[0], map[string]float32{",config=565,": 1.0, ",config=8888,": 5.0}, "gs://a"))
+	assert.NoError(t, d.Add(day1Commits[1], map[string]float32{",config=565,": 3.0}, "gs://b"))
+	assert.NoError(t, d.Add(day2Commits[0], map[string]float32{",config=565,": 7.0}, "gs://c"))
+
+	day1 := &DayID{Day: 49, Source: "master"}
+	day2 := &DayID{Day: 50, Source: "master"}
+	n, err := d.Compacted(day1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// Reading days that haven't been compacted doesn't create their tile.
+	traces, err := d.MatchDays([]*DayID{day1, day2}, &query.Query{}, MEDIAN)
+	assert.NoError(t, err)
+	assert.Equal(t, TraceSet{}, traces)
+	_, err = os.Stat(filepath.Join(tmpDir, day1.Filename()))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, d.Compact(day1, day1Commits))
+	assert.NoError(t, d.Compact(day2, day2Commits))
+	n, err = d.Compacted(day1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	days := []*DayID{day1, day2}
+	traces, err = d.MatchDays(days, &query.Query{}, MEDIAN)
+	assert.NoError(t, err)
+	assert.Equal(t, TraceSet{
+		",config=565,":  Trace{2.0, 7.0},
+		",config=8888,": Trace{5.0, MISSING_VALUE},
+	}, traces)
+
+	q, err := query.New(url.Values{"config": []string{"565"}})
+	assert.NoError(t, err)
+	traces, err = d.MatchDays(days, q, MIN)
+	assert.NoError(t, err)
+	assert.Equal(t, TraceSet{",config=565,": Trace{1.0, 7.0}}, traces)
+	traces, err = d.MatchDays(days, q, MAX)
+	assert.NoError(t, err)
+	assert.Equal(t, TraceSet{",config=565,": Trace{3.0, 7.0}}, traces)
+
+	// Compacting a day again replaces its summaries.
+	assert.NoError(t, d.Add(day2Commits[0], map[string]float32{",config=565,": 9.0}, "gs://d"))
+	assert.NoError(t, d.Compact(day2, day2Commits))
+	traces, err = d.MatchDays(days, q, MEDIAN)
+	assert.NoError(t, err)
+	assert.Equal(t, TraceSet{",config=565,": Trace{2.0, 9.0}}, traces)
+
+	// The summary of a trace that no longer has values on the day is removed.
+	day2Commits = []*CommitID{
+		&CommitID{Offset: 52, Source: "master"},
+	}
+	assert.NoError(t, d.Add(day2Commits[0], map[string]float32{",config=8888,": 6.0}, "gs://e"))
+	assert.NoError(t, d.Compact(day2, day2Commits))
+	traces, err = d.MatchDays(days, &query.Query{}, MEDIAN)
+	assert.NoError(t, err)
+	assert.Equal(t, TraceSet{
+		",config=565,":  Trace{2.0, MISSING_VALUE},
+		",config=8888,": Trace{5.0, 6.0},
+	}, traces)
+}
+
+func TestCommitsByDay(t *testing.T) {
+	ts := time.Date(2016, 9, 1, 12, 0, 0, 0, time.UTC)
+	commits := []*vcsinfo.IndexCommit{
+		&vcsinfo.IndexCommit{Index: 10, Timestamp: ts},
+		&vcsinfo.IndexCommit{Index: 11, Timestamp: ts.Add(time.Hour)},
+		&vcsinfo.IndexCommit{Index: 12, Timestamp: ts.Add(24 * time.Hour)},
+	}
+	days, byDay := commitsByDay(commits)
+	assert.Equal(t, []*DayID{
+		&DayID{Day: 17045, Source: "master"},
+		&DayID{Day: 17046, Source: "master"},
+	}, days)
+	assert.Equal(t, []*CommitID{
+		&CommitID{Offset: 10, Source: "master"},
+		&CommitID{Offset: 11, Source: "master"},
+	}, byDay[17045])
+	assert.Equal(t, []*CommitID{
+		&CommitID{Offset: 12, Source: "master"},
+	}, byDay[17046])
+}
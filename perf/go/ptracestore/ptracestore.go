@@ -119,7 +119,7 @@ func New(dir string) (*BoltTraceStore, error) {
 	cache := lru.New(MAX_CACHED_TILES)
 	cache.OnEvicted = closer
 
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := os.MkdirAll(filepath.Join(dir, DOWNSAMPLED_DIR), 0755); err != nil {
 		return nil, fmt.Errorf("Failed to create %q for ptracestore: %s", dir, err)
 	}
 
@@ -143,15 +143,20 @@ type sourceValue struct {
 
 // getBoltDB returns a new/existing bolt.DB. Already opened db's are cached.
 func (b *BoltTraceStore) getBoltDB(commitID *CommitID) (*bolt.DB, error) {
+	return b.getBoltDBByName(commitID.Filename())
+}
+
+// getBoltDBByName returns a new/existing bolt.DB for the tile with the given
+// filename, relative to the store directory. Already opened db's are cached.
+func (b *BoltTraceStore) getBoltDBByName(name string) (*bolt.DB, error) {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
-	name := commitID.Filename()
 	if idb, ok := b.cache.Get(name); ok {
 		if db, ok := idb.(*bolt.DB); ok {
 			return db, nil
 		}
 	}
-	filename := filepath.Join(b.dir, commitID.Filename())
+	filename := filepath.Join(b.dir, name)
 	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: 1 * time.Second})
 	if err != nil {
 		return nil, fmt.Errorf("Unable to open %q: %s", filename, err)
@@ -265,4 +265,19 @@ func TestMatch(t *testing.T) {
 	traces, err = d.Match(commits, q)
 	assert.NoError(t, err)
 	assert.Equal(t, 0, len(traces))
+
+	// Negative and regexp matches.
+	q, err = query.New(url.Values{"config": []string{"!565"}, "test": []string{"~^f"}})
+	assert.NoError(t, err)
+	traces, err = d.Match(commits, q)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(traces))
+	assert.Equal(t, Trace{3.21, 5.43, 9.10, MISSING_VALUE}, traces[",config=8888,test=foo,"])
+
+	q, err = query.New(url.Values{"config": []string{"!~^5"}})
+	assert.NoError(t, err)
+	traces, err = d.Match(commits, q)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(traces))
+	assert.Equal(t, Trace{3.21, 5.43, 9.10, MISSING_VALUE}, traces[",config=8888,test=foo,"])
 }
@@ -4,6 +4,7 @@ package quartiles
 import (
 	"net/url"
 
+	"go.skia.org/infra/go/query"
 	"go.skia.org/infra/go/tiling"
 	"go.skia.org/infra/perf/go/kmlabel"
 	"go.skia.org/infra/perf/go/tilestats"
@@ -43,10 +44,13 @@ func FromTile(tile *tiling.Tile, tilestats *tilestats.TileStats, q url.Values) *
 	q3 := map[string]map[string]string{}
 	q4 := map[string]map[string]string{}
 	reg := map[string]map[string]string{}
+	// Invalid queries don't match anything.
+	parsed, err := query.New(q)
+	valid := err == nil
 	tilestats.RLock()
 	defer tilestats.RUnLock()
 	for traceid, tr := range tile.Traces {
-		if tiling.Matches(tr, q) {
+		if valid && parsed.MatchesParams(tr.Params()) {
 			st, ok := tilestats.TraceStats(traceid)
 			if !ok {
 				continue
@@ -0,0 +1,202 @@
+package regression
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/skia-dev/glog"
+	"go.skia.org/infra/go/metrics2"
+	"go.skia.org/infra/go/query"
+	"go.skia.org/infra/go/vcsinfo"
+	"go.skia.org/infra/perf/go/alerting"
+	"go.skia.org/infra/perf/go/clustering"
+	"go.skia.org/infra/perf/go/config"
+	"go.skia.org/infra/perf/go/dataframe"
+	"go.skia.org/infra/perf/go/ptracestore"
+	"go.skia.org/infra/perf/go/vec"
+)
+
+const (
+	// DEFAULT_RADIUS is the number of commits on each side of a commit that
+	// are used to fit a step at that commit.
+	DEFAULT_RADIUS = 5
+)
+
+// Detect looks for steps at each commit of the DataFrame in the traces that
+// match the alert config. For each commit the step fit is calculated over a
+// window of 'radius' commits before and after the commit, with the step
+// fixed at the commit. Commits without a full window are skipped.
+//
+// The traces in the DataFrame are expected to already match cfg.Query.
+//
+// Returns at most one Regression per commit and direction.
+func Detect(df *dataframe.DataFrame, cfg *alerting.Config, radius int) []*Regression {
+	ret := []*Regression{}
+	n := len(df.Header)
+	if radius <= 0 || n < 2*radius {
+		return ret
+	}
+	for i := radius; i+radius <= n; i++ {
+		offset, err := strconv.Atoi(df.Header[i].ID)
+		if err != nil {
+			glog.Errorf("Invalid commit id in DataFrame header %q: %s", df.Header[i].ID, err)
+			continue
+		}
+
+		// Group the traces that step at this commit by direction.
+		windows := map[alerting.Direction]map[string][]float64{}
+		regressions := map[string]float64{}
+		for key, tr := range df.TraceSet {
+			if tr[i] == ptracestore.MISSING_VALUE {
+				continue
+			}
+			w := window(tr, i-radius, i+radius, cfg.StdDevThreshold)
+			sf := clustering.StepFitAt(w, radius)
+			if !cfg.InterestingRegression(sf.Regression) {
+				continue
+			}
+			dir := alerting.DOWN
+			if sf.Regression < 0 {
+				dir = alerting.UP
+			}
+			if windows[dir] == nil {
+				windows[dir] = map[string][]float64{}
+			}
+			windows[dir][key] = w
+			regressions[key] = sf.Regression
+		}
+
+		for _, dir := range []alerting.Direction{alerting.UP, alerting.DOWN} {
+			if len(windows[dir]) == 0 {
+				continue
+			}
+			centroid := make([]float64, 2*radius)
+			keys := make([]string, 0, len(windows[dir]))
+			for key, w := range windows[dir] {
+				for j, x := range w {
+					centroid[j] += x
+				}
+				keys = append(keys, key)
+			}
+			for j := range centroid {
+				centroid[j] /= float64(len(keys))
+			}
+			sort.Sort(&keysByRegression{keys: keys, regressions: regressions})
+			ret = append(ret, &Regression{
+				ID:            -1,
+				Source:        df.Header[i].Source,
+				CommitOffset:  offset,
+				Timestamp:     df.Header[i].Timestamp,
+				AlertConfigID: cfg.ID,
+				Direction:     dir,
+				StepFit:       clustering.StepFitAt(centroid, radius),
+				Centroid:      centroid,
+				Keys:          keys,
+				Status:        UNTRIAGED,
+			})
+		}
+	}
+	return ret
+}
+
+// window returns the normalized values of tr in [begin, end), with missing
+// values filled in.
+func window(tr ptracestore.Trace, begin, end int, minStdDev float64) []float64 {
+	ret := make([]float64, end-begin)
+	for j, x := range tr[begin:end] {
+		if x == ptracestore.MISSING_VALUE {
+			ret[j] = config.MISSING_DATA_SENTINEL
+		} else {
+			ret[j] = float64(x)
+		}
+	}
+	vec.Fill(ret)
+	vec.Norm(ret, minStdDev)
+	return ret
+}
+
+// keysByRegression sorts trace ids by the magnitude of their regression,
+// largest first.
+type keysByRegression struct {
+	keys        []string
+	regressions map[string]float64
+}
+
+func (k *keysByRegression) Len() int { return len(k.keys) }
+func (k *keysByRegression) Less(i, j int) bool {
+	ri, rj := math.Abs(k.regressions[k.keys[i]]), math.Abs(k.regressions[k.keys[j]])
+	if ri == rj {
+		return k.keys[i] < k.keys[j]
+	}
+	return ri > rj
+}
+func (k *keysByRegression) Swap(i, j int) { k.keys[i], k.keys[j] = k.keys[j], k.keys[i] }
+
+// processConfig runs Detect for a single alert config over the given time
+// range and writes the regressions found.
+func processConfig(vcs vcsinfo.VCS, store ptracestore.PTraceStore, cfg *alerting.Config, begin, end time.Time, radius int) (int, error) {
+	values, err := url.ParseQuery(cfg.Query)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid query %q: %s", cfg.Query, err)
+	}
+	q, err := query.New(values)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid query %q: %s", cfg.Query, err)
+	}
+	df, err := dataframe.NewFromQueryAndRange(vcs, store, begin, end, q)
+	if err != nil {
+		return 0, err
+	}
+	found := Detect(df, cfg, radius)
+	for _, r := range found {
+		if err := Write(r); err != nil {
+			return 0, err
+		}
+	}
+	return len(found), nil
+}
+
+// singleStep runs the detection for all alert configs once.
+func singleStep(vcs vcsinfo.VCS, store ptracestore.PTraceStore, radius int) error {
+	configs, err := alerting.ListConfigs()
+	if err != nil {
+		return err
+	}
+	commits := vcs.LastNIndex(dataframe.DEFAULT_NUM_COMMITS)
+	if len(commits) == 0 {
+		return fmt.Errorf("No commits found.")
+	}
+	begin := commits[0].Timestamp
+	end := time.Now()
+	for _, cfg := range configs {
+		n, err := processConfig(vcs, store, cfg, begin, end, radius)
+		if err != nil {
+			glog.Errorf("Regression: Failed to process alert config %d: %s", cfg.ID, err)
+			continue
+		}
+		glog.Infof("Regression: Config %d: Found %d regressions.", cfg.ID, n)
+	}
+	return nil
+}
+
+// Start periodically looks for regressions at each of the most recent
+// commits for every alert config.
+func Start(vcs vcsinfo.VCS, store ptracestore.PTraceStore, radius int, interval time.Duration) {
+	latency := metrics2.NewTimer("perf.regression.latency", nil)
+	liveness := metrics2.NewLiveness("perf.regression.detection")
+	go func() {
+		for _ = range time.Tick(interval) {
+			latency.Start()
+			if err := singleStep(vcs, store, radius); err != nil {
+				glog.Errorf("Regression: Failed to run detection: %s", err)
+			} else {
+				liveness.Reset()
+			}
+			latency.Stop()
+		}
+	}()
+}
@@ -0,0 +1,78 @@
+package regression
+
+import (
+	"fmt"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/perf/go/alerting"
+	"go.skia.org/infra/perf/go/dataframe"
+	"go.skia.org/infra/perf/go/ptracestore"
+)
+
+// newDataFrame returns a DataFrame with the given traces and one column per
+// value, starting at commit offset 100.
+func newDataFrame(traces map[string][]float32) *dataframe.DataFrame {
+	df := &dataframe.DataFrame{
+		TraceSet: ptracestore.TraceSet{},
+		Header:   []*dataframe.ColumnHeader{},
+	}
+	n := 0
+	for key, values := range traces {
+		df.TraceSet[key] = ptracestore.Trace(values)
+		n = len(values)
+	}
+	for i := 0; i < n; i++ {
+		df.Header = append(df.Header, &dataframe.ColumnHeader{
+			Source:    "master",
+			ID:        fmt.Sprintf("%d", 100+i),
+			Timestamp: int64(1000 + i),
+		})
+	}
+	return df
+}
+
+func TestDetect(t *testing.T) {
+	const m = ptracestore.MISSING_VALUE
+	df := newDataFrame(map[string][]float32{
+		",config=8888,":   {1, 1, 1, 1, 1, 2, 2, 2, 2, 2},
+		",config=565,":    {1, 1, m, 1, 1, 3, 3, 3, 3, 3},
+		",config=gpu,":    {5, 5, 5, 5, 5, 4, 4, 4, 4, 4},
+		",config=flat,":   {5, 5, 5, 5, 5, 5, 5, 5, 5, 5},
+		",config=nodata,": {1, 1, 1, 1, 1, m, 2, 2, 2, 2},
+	})
+	cfg := alerting.NewConfig()
+	cfg.ID = 12
+	cfg.Direction = alerting.BOTH
+
+	found := Detect(df, cfg, 3)
+	assert.Len(t, found, 2)
+
+	up := found[0]
+	assert.Equal(t, alerting.UP, up.Direction)
+	assert.Equal(t, int64(-1), up.ID)
+	assert.Equal(t, "master", up.Source)
+	assert.Equal(t, 105, up.CommitOffset)
+	assert.Equal(t, int64(1005), up.Timestamp)
+	assert.Equal(t, int64(12), up.AlertConfigID)
+	assert.Equal(t, UNTRIAGED, up.Status)
+	assert.Equal(t, []string{",config=565,", ",config=8888,"}, up.Keys)
+	assert.Len(t, up.Centroid, 6)
+	assert.Equal(t, 3, up.StepFit.TurningPoint)
+	assert.True(t, up.StepFit.Regression < 0)
+
+	down := found[1]
+	assert.Equal(t, alerting.DOWN, down.Direction)
+	assert.Equal(t, 105, down.CommitOffset)
+	assert.Equal(t, []string{",config=gpu,"}, down.Keys)
+	assert.True(t, down.StepFit.Regression > 0)
+
+	// Only report steps up.
+	cfg.Direction = alerting.UP
+	found = Detect(df, cfg, 3)
+	assert.Len(t, found, 1)
+	assert.Equal(t, alerting.UP, found[0].Direction)
+
+	// The window doesn't fit.
+	assert.Len(t, Detect(df, cfg, 6), 0)
+}
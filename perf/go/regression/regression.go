@@ -0,0 +1,171 @@
+// Package regression finds regressions at individual commits by fitting a
+// step function at every commit to the traces selected by each alert config.
+// Unlike the k-means clustering done in alerting a regression in a small
+// group of traces can't get lost among larger clusters, and every regression
+// is tied to the commit where the step occurs.
+package regression
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/perf/go/alerting"
+	"go.skia.org/infra/perf/go/db"
+	"go.skia.org/infra/perf/go/types"
+)
+
+// Status is the triage status of a Regression.
+type Status string
+
+const (
+	UNTRIAGED Status = "untriaged"
+	POSITIVE  Status = "positive"
+	NEGATIVE  Status = "negative"
+)
+
+// allStatus is used to validate Status values.
+var allStatus = []string{string(UNTRIAGED), string(POSITIVE), string(NEGATIVE)}
+
+// Regression is a step at a single commit found in the traces that match an
+// alert config.
+type Regression struct {
+	// ID is the identifier in the database, -1 if it hasn't been written yet.
+	ID int64 `json:"id"`
+
+	// Source and CommitOffset identify the commit, see ptracestore.CommitID.
+	Source       string `json:"source"`
+	CommitOffset int    `json:"offset"`
+
+	// Timestamp is the time of the commit in seconds from the Unix epoch.
+	Timestamp int64 `json:"timestamp"`
+
+	// AlertConfigID is the id of the alerting.Config that found the regression.
+	AlertConfigID int64 `json:"alert_config_id"`
+
+	// Direction is alerting.UP or alerting.DOWN.
+	Direction alerting.Direction `json:"direction"`
+
+	// StepFit is the step fit of Centroid.
+	StepFit *types.StepFit `json:"step_fit"`
+
+	// Centroid is the average of the normalized windows of all traces in Keys.
+	Centroid []float64 `json:"centroid"`
+
+	// Keys are the trace ids that step at this commit, the ones with the
+	// largest |Regression| first.
+	Keys []string `json:"keys"`
+
+	// Status is the triage status.
+	Status Status `json:"status"`
+
+	// Message is a note about the Status, e.g. a bug link.
+	Message string `json:"message"`
+
+	// TriagedBy is the email address of the user who set the Status.
+	TriagedBy string `json:"triaged_by"`
+}
+
+// body is the part of a Regression that is stored as JSON in the database.
+type body struct {
+	StepFit  *types.StepFit `json:"step_fit"`
+	Centroid []float64      `json:"centroid"`
+	Keys     []string       `json:"keys"`
+}
+
+// Write stores the Regression in the database and sets its ID.
+//
+// If a regression for the same commit, alert config and direction is already
+// stored, then its step fit and traces are updated, but its triage status is
+// kept.
+func Write(r *Regression) error {
+	b, err := json.Marshal(&body{
+		StepFit:  r.StepFit,
+		Centroid: r.Centroid,
+		Keys:     r.Keys,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to encode to JSON: %s", err)
+	}
+	res, err := db.DB.Exec(
+		`INSERT INTO regression (source, commit_offset, ts, alert_config_id, direction, regression, body, status, message, triaged_by)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 ON DUPLICATE KEY UPDATE id=LAST_INSERT_ID(id), ts=VALUES(ts), regression=VALUES(regression), body=VALUES(body)`,
+		r.Source, r.CommitOffset, r.Timestamp, r.AlertConfigID, string(r.Direction), r.StepFit.Regression, string(b), string(UNTRIAGED), "", "")
+	if err != nil {
+		return fmt.Errorf("Failed to write to database: %s", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve id of regression: %s", err)
+	}
+	r.ID = id
+	return nil
+}
+
+// Triage sets the triage status of the regression with the given id.
+func Triage(id int64, status Status, message, user string) error {
+	if !util.In(string(status), allStatus) {
+		return fmt.Errorf("Invalid status %q.", status)
+	}
+	res, err := db.DB.Exec("UPDATE regression SET status=?, message=?, triaged_by=? WHERE id=?", string(status), message, user, id)
+	if err != nil {
+		return fmt.Errorf("Failed to update database: %s", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("Failed to find regression with id: %d", id)
+	}
+	return nil
+}
+
+// ListRange returns all regressions at commits with a timestamp in the
+// range [begin, end), most recent first.
+func ListRange(begin, end int64) ([]*Regression, error) {
+	rows, err := db.DB.Query("SELECT "+columns+" FROM regression WHERE ts>=? AND ts<? ORDER BY ts DESC, id", begin, end)
+	return processRows(rows, err)
+}
+
+// Get returns the regression with the given id.
+func Get(id int64) (*Regression, error) {
+	rows, err := db.DB.Query("SELECT "+columns+" FROM regression WHERE id=?", id)
+	matches, err := processRows(rows, err)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("Failed to find regression with id: %d", id)
+	}
+	return matches[0], nil
+}
+
+// columns are the columns read by processRows.
+const columns = "id, source, commit_offset, ts, alert_config_id, direction, body, status, message, triaged_by"
+
+// processRows reads all the rows from the regression table.
+func processRows(rows *sql.Rows, err error) ([]*Regression, error) {
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from database: %s", err)
+	}
+	defer util.Close(rows)
+
+	ret := []*Regression{}
+	for rows.Next() {
+		r := &Regression{}
+		var direction, status, encoded string
+		if err := rows.Scan(&r.ID, &r.Source, &r.CommitOffset, &r.Timestamp, &r.AlertConfigID, &direction, &encoded, &status, &r.Message, &r.TriagedBy); err != nil {
+			return nil, fmt.Errorf("Failed to read row from database: %s", err)
+		}
+		b := &body{}
+		if err := json.Unmarshal([]byte(encoded), b); err != nil {
+			return nil, fmt.Errorf("Found invalid JSON in regression table: %d %s", r.ID, err)
+		}
+		r.Direction = alerting.Direction(direction)
+		r.Status = Status(status)
+		r.StepFit = b.StepFit
+		r.Centroid = b.Centroid
+		r.Keys = b.Keys
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
@@ -14,6 +14,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	ttemplate "text/template"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -22,6 +23,7 @@ import (
 
 	"go.skia.org/infra/go/auth"
 	"go.skia.org/infra/go/common"
+	"go.skia.org/infra/go/email"
 	"go.skia.org/infra/go/eventbus"
 	"go.skia.org/infra/go/gitinfo"
 	"go.skia.org/infra/go/httputils"
@@ -46,6 +48,7 @@ import (
 	_ "go.skia.org/infra/perf/go/ptraceingest"
 	"go.skia.org/infra/perf/go/ptracestore"
 	"go.skia.org/infra/perf/go/quartiles"
+	"go.skia.org/infra/perf/go/regression"
 	"go.skia.org/infra/perf/go/shortcut"
 	"go.skia.org/infra/perf/go/stats"
 	"go.skia.org/infra/perf/go/tilestats"
@@ -100,19 +103,24 @@ var (
 
 // flags
 var (
-	configFilename = flag.String("config_filename", "default.toml", "Configuration file in TOML format.")
-	gitRepoDir     = flag.String("git_repo_dir", "../../../skia", "Directory location for the Skia repo.")
-	gitRepoURL     = flag.String("git_repo_url", "https://skia.googlesource.com/skia", "The URL to pass to git clone for the source repository.")
-	influxDatabase = flag.String("influxdb_database", influxdb.DEFAULT_DATABASE, "The InfluxDB database.")
-	influxHost     = flag.String("influxdb_host", influxdb.DEFAULT_HOST, "The InfluxDB hostname.")
-	influxPassword = flag.String("influxdb_password", influxdb.DEFAULT_PASSWORD, "The InfluxDB password.")
-	influxUser     = flag.String("influxdb_name", influxdb.DEFAULT_USER, "The InfluxDB username.")
-	local          = flag.Bool("local", false, "Running locally if true. As opposed to in production.")
-	port           = flag.String("port", ":8000", "HTTP service address (e.g., ':8000')")
-	ptraceStoreDir = flag.String("ptrace_store_dir", "/tmp/ptracestore", "The directory where the ptracestore tiles are stored.")
-	resourcesDir   = flag.String("resources_dir", "", "The directory to find templates, JS, and CSS files. If blank the current directory will be used.")
-	tileSize       = flag.Int("tile_size", 100, "The size of Tiles.")
-	traceservice   = flag.String("trace_service", "localhost:9090", "The address of the traceservice endpoint.")
+	bugTemplate       = flag.String("bug_template", "", "A file with the text/template used to file bugs for new clusters. If blank alerting.DEFAULT_BUG_TEMPLATE is used.")
+	configFilename    = flag.String("config_filename", "default.toml", "Configuration file in TOML format.")
+	emailClientId     = flag.String("email_clientid", "", "OAuth Client ID for sending email. If empty no notifications for new clusters are emailed.")
+	emailClientSecret = flag.String("email_clientsecret", "", "OAuth Client Secret for sending email.")
+	emailTokenCache   = flag.String("email_token_cache_file", "/home/perf/gmail_token.data", "Path to the file where to cache the oauth credentials for sending email.")
+	fileBugs          = flag.Bool("file_bugs", false, "File a bug for each new cluster, see --bug_template.")
+	gitRepoDir        = flag.String("git_repo_dir", "../../../skia", "Directory location for the Skia repo.")
+	gitRepoURL        = flag.String("git_repo_url", "https://skia.googlesource.com/skia", "The URL to pass to git clone for the source repository.")
+	influxDatabase    = flag.String("influxdb_database", influxdb.DEFAULT_DATABASE, "The InfluxDB database.")
+	influxHost        = flag.String("influxdb_host", influxdb.DEFAULT_HOST, "The InfluxDB hostname.")
+	influxPassword    = flag.String("influxdb_password", influxdb.DEFAULT_PASSWORD, "The InfluxDB password.")
+	influxUser        = flag.String("influxdb_name", influxdb.DEFAULT_USER, "The InfluxDB username.")
+	local             = flag.Bool("local", false, "Running locally if true. As opposed to in production.")
+	port              = flag.String("port", ":8000", "HTTP service address (e.g., ':8000')")
+	ptraceStoreDir    = flag.String("ptrace_store_dir", "/tmp/ptracestore", "The directory where the ptracestore tiles are stored.")
+	resourcesDir      = flag.String("resources_dir", "", "The directory to find templates, JS, and CSS files. If blank the current directory will be used.")
+	tileSize          = flag.Int("tile_size", 100, "The size of Tiles.")
+	traceservice      = flag.String("trace_service", "localhost:9090", "The address of the traceservice endpoint.")
 )
 
 var (
@@ -284,6 +292,240 @@ func alertResetHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/alerts/", 303)
 }
 
+// alertConfigsHandler returns the alert configs in JSON format.
+//
+// A POST with an alert config in JSON format as the body creates a new
+// config if the id is -1 or missing, otherwise it updates the existing
+// config. If no owner is given the logged in user becomes the owner.
+func alertConfigsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		user := login.LoggedInAs(r)
+		if user == "" {
+			httputils.ReportError(w, r, fmt.Errorf("Not logged in."), "You must be logged in to change an alert config.")
+			return
+		}
+		cfg := alerting.NewConfig()
+		if err := json.NewDecoder(r.Body).Decode(cfg); err != nil {
+			httputils.ReportError(w, r, err, "Failed to decode alert config.")
+			return
+		}
+		if cfg.Owner == "" {
+			cfg.Owner = user
+		}
+		if err := alerting.WriteConfig(cfg); err != nil {
+			httputils.ReportError(w, r, err, "Failed to write alert config.")
+			return
+		}
+	}
+	configs, err := alerting.ListConfigs()
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to retrieve alert configs.")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(configs); err != nil {
+		glog.Errorf("Failed to write or encode output: %s", err)
+	}
+}
+
+// alertConfigDeleteHandler deletes the alert config given by the 'id' form
+// value. Clusters that were found by the config are kept.
+func alertConfigDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if login.LoggedInAs(r) == "" {
+		httputils.ReportError(w, r, fmt.Errorf("Not logged in."), "You must be logged in to delete an alert config.")
+		return
+	}
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed parsing ID.")
+		return
+	}
+	if err := alerting.DeleteConfig(id); err != nil {
+		httputils.ReportError(w, r, err, "Failed to delete alert config.")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]int64{"id": id}); err != nil {
+		glog.Errorf("Failed to write or encode output: %s", err)
+	}
+}
+
+// defaultBegin returns the time of the first of the last
+// dataframe.DEFAULT_NUM_COMMITS commits, in seconds since the Unix epoch.
+func defaultBegin() (int64, error) {
+	commits := git.LastNIndex(dataframe.DEFAULT_NUM_COMMITS)
+	if len(commits) == 0 {
+		return 0, fmt.Errorf("No commits found in the repo.")
+	}
+	return commits[0].Timestamp.Unix(), nil
+}
+
+// regressionsHandler returns the regressions found at commits in the time
+// range given by the 'begin' and 'end' form values, in seconds since the
+// Unix epoch, in JSON format. The range defaults to the last
+// dataframe.DEFAULT_NUM_COMMITS commits.
+func regressionsHandler(w http.ResponseWriter, r *http.Request) {
+	end := time.Now().Unix()
+	var begin int64
+	var err error
+	if s := r.FormValue("begin"); s != "" {
+		if begin, err = strconv.ParseInt(s, 10, 64); err != nil {
+			httputils.ReportError(w, r, err, "Invalid value for begin.")
+			return
+		}
+	} else if begin, err = defaultBegin(); err != nil {
+		httputils.ReportError(w, r, err, "Failed to find the default time range.")
+		return
+	}
+	if s := r.FormValue("end"); s != "" {
+		if end, err = strconv.ParseInt(s, 10, 64); err != nil {
+			httputils.ReportError(w, r, err, "Invalid value for end.")
+			return
+		}
+	}
+	regressions, err := regression.ListRange(begin, end)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to retrieve regressions.")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(regressions); err != nil {
+		glog.Errorf("Failed to write or encode output: %s", err)
+	}
+}
+
+// regressionTriageHandler sets the triage status of the regression given by
+// the 'id' form value to the 'status' form value, one of 'untriaged',
+// 'positive' or 'negative', along with an optional 'message'.
+func regressionTriageHandler(w http.ResponseWriter, r *http.Request) {
+	user := login.LoggedInAs(r)
+	if user == "" {
+		httputils.ReportError(w, r, fmt.Errorf("Not logged in."), "You must be logged in to triage a regression.")
+		return
+	}
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed parsing ID.")
+		return
+	}
+	if err := regression.Triage(id, regression.Status(r.FormValue("status")), r.FormValue("message"), user); err != nil {
+		httputils.ReportError(w, r, err, "Failed to triage regression.")
+		return
+	}
+	reg, err := regression.Get(id)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to retrieve regression.")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(reg); err != nil {
+		glog.Errorf("Failed to write or encode output: %s", err)
+	}
+}
+
+// exportHandler streams the DataFrame of the traces that match the 'q' form
+// value, a URL encoded query, in the time range given by the 'begin' and
+// 'end' form values, in seconds since the Unix epoch. The range defaults to
+// the last dataframe.DEFAULT_NUM_COMMITS commits. The 'format' form value is
+// either 'csv', the default, or 'json' for JSON lines, see
+// dataframe.ExportFormat.
+//
+// The DataFrame has one column per commit, and ranges of more than
+// dataframe.MAX_FULL_RESOLUTION_COMMITS commits are rejected. If the
+// 'resolution' form value is 'day' then it has one column per day instead,
+// containing the daily summary value given by the 'stat' form value, one of
+// 'min', 'median', the default, or 'max', and the range isn't limited.
+//
+// For example:
+//
+//    /_/export/?q=config%3D8888%26arch%3Dx86&begin=1475020800&format=csv
+//    /_/export/?q=config%3D8888&begin=1467331200&resolution=day&stat=max
+//
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+	end := time.Now().Unix()
+	var begin int64
+	var err error
+	if s := r.FormValue("begin"); s != "" {
+		if begin, err = strconv.ParseInt(s, 10, 64); err != nil {
+			httputils.ReportError(w, r, err, "Invalid value for begin.")
+			return
+		}
+	} else if begin, err = defaultBegin(); err != nil {
+		httputils.ReportError(w, r, err, "Failed to find the default time range.")
+		return
+	}
+	if s := r.FormValue("end"); s != "" {
+		if end, err = strconv.ParseInt(s, 10, 64); err != nil {
+			httputils.ReportError(w, r, err, "Invalid value for end.")
+			return
+		}
+	}
+	format := dataframe.CSV
+	if s := r.FormValue("format"); s != "" {
+		if !util.In(s, dataframe.AllExportFormats) {
+			httputils.ReportError(w, r, fmt.Errorf("Unknown format: %q", s), "Invalid value for format.")
+			return
+		}
+		format = dataframe.ExportFormat(s)
+	}
+	resolution := r.FormValue("resolution")
+	if resolution != "" && resolution != "commit" && resolution != "day" {
+		httputils.ReportError(w, r, fmt.Errorf("Unknown resolution: %q", resolution), "Invalid value for resolution.")
+		return
+	}
+	stat := ptracestore.MEDIAN
+	if s := r.FormValue("stat"); s != "" {
+		if !util.In(s, ptracestore.AllStats) {
+			httputils.ReportError(w, r, fmt.Errorf("Unknown stat: %q", s), "Invalid value for stat.")
+			return
+		}
+		stat = ptracestore.Stat(s)
+	}
+	values, err := url.ParseQuery(r.FormValue("q"))
+	if err != nil {
+		httputils.ReportError(w, r, err, "Invalid URL query.")
+		return
+	}
+	q, err := query.New(values)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Invalid query.")
+		return
+	}
+	if resolution != "day" {
+		if n := len(git.Range(time.Unix(begin, 0), time.Unix(end, 0))); n > dataframe.MAX_FULL_RESOLUTION_COMMITS {
+			httputils.ReportError(w, r, fmt.Errorf("Range has %d commits, more than %d.", n, dataframe.MAX_FULL_RESOLUTION_COMMITS), "Range is too large to export one column per commit, use resolution=day.")
+			return
+		}
+	}
+	var df *dataframe.DataFrame
+	if resolution == "day" {
+		df, err = dataframe.NewDownsampledFromQueryAndRange(git, ptracestore.Default, time.Unix(begin, 0), time.Unix(end, 0), q, stat)
+	} else {
+		df, err = dataframe.NewFullResolutionFromQueryAndRange(git, ptracestore.Default, time.Unix(begin, 0), time.Unix(end, 0), q)
+	}
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to load traces.")
+		return
+	}
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=perf-%d-%d.%s", begin, end, format))
+	if err := df.Export(w, format); err != nil {
+		glog.Errorf("Failed to write export: %s", err)
+	}
+}
+
 // clHandler serves the HTML for the /cl/<id> page.
 //
 // These are shortcuts to individual clusters.
@@ -499,10 +741,16 @@ func clusteringHandler(w http.ResponseWriter, r *http.Request) {
 	delete(r.Form, "_stddev")
 	delete(r.Form, "_issue")
 
+	q, err := query.New(r.Form)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Invalid query.")
+		return
+	}
+
 	// Create a filter function for traces that match the query parameters and
 	// optionally tryResults.
 	filter := func(key string, tr *types.PerfTrace) bool {
-		return tiling.Matches(tr, r.Form)
+		return q.MatchesParams(tr.Params())
 	}
 
 	summary, err := clustering.CalculateClusterSummaries(tile, int(k), stddev, filter)
@@ -698,6 +946,11 @@ func queryHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	glog.Infof("tile: %d %d", tileScale, tileNumber)
+	q, err := query.New(r.Form)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Invalid query.")
+		return
+	}
 	tile := masterTileBuilder.GetTile()
 	w.Header().Set("Content-Type", "application/json")
 	ret := &QueryResponse{
@@ -708,7 +961,7 @@ func queryHandler(w http.ResponseWriter, r *http.Request) {
 		// We only want the count.
 		total := 0
 		for _, tr := range tile.Traces {
-			if tiling.Matches(tr, r.Form) {
+			if q.MatchesParams(tr.Params()) {
 				total++
 			}
 		}
@@ -747,7 +1000,7 @@ func queryHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		} else {
 			for key, tr := range tile.Traces {
-				if tiling.Matches(tr, r.Form) {
+				if q.MatchesParams(tr.Params()) {
 					tg := traceGuiFromTrace(tr.(*types.PerfTrace), key, tile)
 					if tg != nil {
 						ret.Traces = append(ret.Traces, tg)
@@ -823,8 +1076,13 @@ func singleHandler(w http.ResponseWriter, r *http.Request) {
 		Traces: []*SingleTrace{},
 		Hash:   tile.Commits[idx].Hash,
 	}
+	q, err := query.New(r.Form)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Invalid query.")
+		return
+	}
 	for _, tr := range tile.Traces {
-		if tiling.Matches(tr, r.Form) {
+		if q.MatchesParams(tr.Params()) {
 			v, err := vec.FillAt(tr.(*types.PerfTrace).Values, idx)
 			if err != nil {
 				httputils.ReportError(w, r, err, "Error while getting value at slice index.")
@@ -1232,7 +1490,27 @@ func main() {
 	}
 
 	stats.Start(masterTileBuilder, git)
-	alerting.Start(masterTileBuilder)
+	// Notify the owners of alert configs about new clusters.
+	var emailer alerting.Emailer = nil
+	if *emailClientId != "" {
+		gmail, err := email.NewGMail(*emailClientId, *emailClientSecret, *emailTokenCache)
+		if err != nil {
+			glog.Fatalf("Failed to create email client: %s", err)
+		}
+		emailer = gmail
+	} else {
+		glog.Warningf("No email credentials provided. Not sending notifications for new clusters.")
+	}
+	var bugs *ttemplate.Template = nil
+	if *fileBugs {
+		var err error
+		if bugs, err = alerting.ParseBugTemplate(*bugTemplate); err != nil {
+			glog.Fatal(err)
+		}
+	}
+	alerting.Start(masterTileBuilder, emailer, bugs)
+	regression.Start(git, ptracestore.Default, regression.DEFAULT_RADIUS, config.RECLUSTER_DURATION)
+	ptracestore.StartCompactor(git, ptracestore.Default, ptracestore.COMPACTOR_INTERVAL)
 
 	var redirectURL = fmt.Sprintf("http://localhost%s/oauth2callback/", *port)
 	if !*local {
@@ -1269,6 +1547,11 @@ func main() {
 	router.HandleFunc("/alerts/", templateHandler("alerting.html"))
 	router.HandleFunc("/alerting/", alertingHandler)
 	router.HandleFunc("/alert_reset/", alertResetHandler)
+	router.HandleFunc("/_/alerts/configs/", alertConfigsHandler)
+	router.HandleFunc("/_/alerts/configs/delete/", alertConfigDeleteHandler)
+	router.HandleFunc("/_/regressions/", regressionsHandler)
+	router.HandleFunc("/_/regressions/triage/", regressionTriageHandler)
+	router.HandleFunc("/_/export/", exportHandler)
 	router.HandleFunc("/annotate/", annotate.Handler)
 	router.HandleFunc("/compare/", templateHandler("compare.html"))
 	router.HandleFunc("/per/", templateHandler("percommit.html"))
@@ -227,6 +227,9 @@ type ClusterSummary struct {
 
 	// Bugs is a list of IDs of bugs in the issue tracker.
 	Bugs []int64
+
+	// AlertConfigID is the id of the alert config that found this cluster.
+	AlertConfigID int64
 }
 
 // ValidStatusValues are the valid values of ClusterSummary.Status when the
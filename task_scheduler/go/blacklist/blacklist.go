@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/skia-dev/glog"
 
@@ -63,23 +65,56 @@ type Blacklist struct {
 }
 
 // Match determines whether the given taskSpec/commit pair matches one of the
-// Rules in the Blacklist.
+// Rules in the Blacklist. Rules which specify Dimensions never match.
 func (b *Blacklist) Match(taskSpec, commit string) bool {
-	return b.MatchRule(taskSpec, commit) != ""
+	rule, _ := b.MatchRule(taskSpec, commit, nil)
+	return rule != ""
 }
 
-// MatchRule determines whether the given taskSpec/commit pair matches one of the
-// Rules in the Blacklist. Returns the name of the matched Rule or the empty
-// string if no Rules match.
-func (b *Blacklist) MatchRule(taskSpec, commit string) string {
+// MatchRule determines whether the given taskSpec/commit pair, whose TaskSpec
+// has the given dimensions, matches one of the currently-active Rules in the
+// Blacklist. Returns the name of the matched Rule and a human-readable
+// explanation of why it matched, or empty strings if no Rules match.
+func (b *Blacklist) MatchRule(taskSpec, commit string, dimensions []string) (string, string) {
 	b.mtx.RLock()
 	defer b.mtx.RUnlock()
+	now := time.Now()
 	for _, rule := range b.Rules {
-		if rule.Match(taskSpec, commit) {
-			return rule.Name
+		if match, reason := rule.MatchTask(taskSpec, commit, dimensions, now); match {
+			return rule.Name, reason
 		}
 	}
-	return ""
+	return "", ""
+}
+
+// RemoveExpiredRules removes all Rules from the Blacklist which have expired as
+// of the given time. Returns the names of the removed Rules.
+func (b *Blacklist) RemoveExpiredRules(now time.Time) ([]string, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	expired := map[string]*Rule{}
+	for name, r := range b.Rules {
+		if r.Expired(now) {
+			expired[name] = r
+		}
+	}
+	if len(expired) == 0 {
+		return []string{}, nil
+	}
+	for name, _ := range expired {
+		delete(b.Rules, name)
+	}
+	if err := b.writeOut(); err != nil {
+		for name, r := range expired {
+			b.Rules[name] = r
+		}
+		return nil, err
+	}
+	rv := make([]string, 0, len(expired))
+	for name, _ := range expired {
+		rv = append(rv, name)
+	}
+	return rv, nil
 }
 
 // ensureDefaults adds the necessary default blacklist rules if necessary.
@@ -237,13 +272,25 @@ func (b *Blacklist) RemoveRule(name string) error {
 // Commits are simply commit hashes for which the rule applies. If the list is
 // empty, the Rule applies for all commits.
 //
-// A Rule should specify TaskSpecPatterns or Commits or both.
+// Dimensions are Swarming bot dimensions in "key:value" form, eg. "os:Android".
+// If any are specified, the Rule only applies to TaskSpecs whose dimensions
+// include all of them.
+//
+// Start and Expires optionally limit the time window during which the Rule is
+// in effect. A zero value indicates no limit. Rules which have expired are
+// removed from the Blacklist by RemoveExpiredRules.
+//
+// A Rule should specify at least one of TaskSpecPatterns, Commits, or
+// Dimensions.
 type Rule struct {
-	AddedBy          string   `json:"added_by"`
-	TaskSpecPatterns []string `json:"task_spec_patterns"`
-	Commits          []string `json:"commits"`
-	Description      string   `json:"description"`
-	Name             string   `json:"name"`
+	AddedBy          string    `json:"added_by"`
+	TaskSpecPatterns []string  `json:"task_spec_patterns"`
+	Commits          []string  `json:"commits"`
+	Description      string    `json:"description"`
+	Dimensions       []string  `json:"dimensions"`
+	Expires          time.Time `json:"expires"`
+	Name             string    `json:"name"`
+	Start            time.Time `json:"start"`
 }
 
 // ValidateRule returns an error if the given Rule is not valid.
@@ -257,14 +304,25 @@ func ValidateRule(r *Rule, repos *gitinfo.RepoMap) error {
 	if r.AddedBy == "" {
 		return fmt.Errorf("Rules must have an AddedBy user.")
 	}
-	if len(r.TaskSpecPatterns) == 0 && len(r.Commits) == 0 {
-		return fmt.Errorf("Rules must include a taskSpec pattern and/or a commit/range.")
+	if len(r.TaskSpecPatterns) == 0 && len(r.Commits) == 0 && len(r.Dimensions) == 0 {
+		return fmt.Errorf("Rules must include a taskSpec pattern, a commit/range, and/or a dimension.")
 	}
 	for _, c := range r.Commits {
 		if err := validateCommit(c, repos); err != nil {
 			return err
 		}
 	}
+	for _, d := range r.Dimensions {
+		if len(strings.SplitN(d, ":", 2)) != 2 {
+			return fmt.Errorf("Dimension %q does not contain a colon!", d)
+		}
+	}
+	if !util.TimeIsZero(r.Start) && !util.TimeIsZero(r.Expires) && !r.Start.Before(r.Expires) {
+		return fmt.Errorf("Rule start time must be before its expiration time.")
+	}
+	if r.Expired(time.Now()) {
+		return fmt.Errorf("Rule has already expired.")
+	}
 	return nil
 }
 
@@ -304,9 +362,63 @@ func (r *Rule) matchCommit(commit string) bool {
 	return false
 }
 
-// Match returns true iff the Rule matches the given taskSpec and commit.
+// matchDimensions determines whether the dimensions portion of the Rule
+// matches.
+func (r *Rule) matchDimensions(dimensions []string) bool {
+	// If no dimensions are specified, then the rule applies for ALL dimensions.
+	// Otherwise, the given dimensions must include all of the Rule's.
+	dims := util.NewStringSet(dimensions)
+	for _, d := range r.Dimensions {
+		if !dims[d] {
+			return false
+		}
+	}
+	return true
+}
+
+// Expired returns true iff the Rule has an expiration time which is not after
+// the given time.
+func (r *Rule) Expired(now time.Time) bool {
+	return !util.TimeIsZero(r.Expires) && !now.Before(r.Expires)
+}
+
+// Active returns true iff the Rule is in effect at the given time.
+func (r *Rule) Active(now time.Time) bool {
+	return !r.Expired(now) && (util.TimeIsZero(r.Start) || !now.Before(r.Start))
+}
+
+// Match returns true iff the Rule is currently active and matches the given
+// taskSpec and commit. Rules which specify Dimensions never match.
 func (r *Rule) Match(taskSpec, commit string) bool {
-	return r.matchTaskSpec(taskSpec) && r.matchCommit(commit)
+	match, _ := r.MatchTask(taskSpec, commit, nil, time.Now())
+	return match
+}
+
+// MatchTask returns true iff the Rule is active at the given time and matches
+// the given taskSpec and commit, whose TaskSpec has the given dimensions. If
+// the Rule matches, also returns a human-readable explanation of why.
+func (r *Rule) MatchTask(taskSpec, commit string, dimensions []string, now time.Time) (bool, string) {
+	if !r.Active(now) || !r.matchTaskSpec(taskSpec) || !r.matchCommit(commit) || !r.matchDimensions(dimensions) {
+		return false, ""
+	}
+	reasons := []string{}
+	if len(r.TaskSpecPatterns) == 0 {
+		reasons = append(reasons, "rule applies to all task specs")
+	} else {
+		reasons = append(reasons, fmt.Sprintf("task spec %q matches one of %v", taskSpec, r.TaskSpecPatterns))
+	}
+	if len(r.Commits) == 0 {
+		reasons = append(reasons, "rule applies to all commits")
+	} else {
+		reasons = append(reasons, fmt.Sprintf("commit %s is blacklisted", commit))
+	}
+	if len(r.Dimensions) > 0 {
+		reasons = append(reasons, fmt.Sprintf("dimensions include %v", r.Dimensions))
+	}
+	if !util.TimeIsZero(r.Expires) {
+		reasons = append(reasons, fmt.Sprintf("rule is in effect until %s", r.Expires.UTC().Format(time.RFC3339)))
+	}
+	return true, strings.Join(reasons, "; ")
 }
 
 // FromFile returns a Blacklist instance based on the given file. If the file
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"path"
 	"testing"
+	"time"
 
 	"go.skia.org/infra/go/gitinfo"
 	"go.skia.org/infra/go/testutils"
@@ -222,9 +223,59 @@ func TestValidation(t *testing.T) {
 				TaskSpecPatterns: []string{},
 				Commits:          []string{},
 			},
-			expect: fmt.Errorf("Rules must include a taskSpec pattern and/or a commit/range."),
+			expect: fmt.Errorf("Rules must include a taskSpec pattern, a commit/range, and/or a dimension."),
 			msg:    "No taskSpecs or commits",
 		},
+		{
+			rule: Rule{
+				AddedBy:    "test@google.com",
+				Name:       "My rule",
+				Dimensions: []string{"os:Android"},
+			},
+			expect: nil,
+			msg:    "Dimensions only",
+		},
+		{
+			rule: Rule{
+				AddedBy:    "test@google.com",
+				Name:       "My rule",
+				Dimensions: []string{"Android"},
+			},
+			expect: fmt.Errorf("Dimension %q does not contain a colon!", "Android"),
+			msg:    "Invalid dimension",
+		},
+		{
+			rule: Rule{
+				AddedBy:          "test@google.com",
+				Name:             "My rule",
+				TaskSpecPatterns: []string{".*"},
+				Start:            time.Now().Add(2 * time.Hour),
+				Expires:          time.Now().Add(time.Hour),
+			},
+			expect: fmt.Errorf("Rule start time must be before its expiration time."),
+			msg:    "Start after Expires",
+		},
+		{
+			rule: Rule{
+				AddedBy:          "test@google.com",
+				Name:             "My rule",
+				TaskSpecPatterns: []string{".*"},
+				Expires:          time.Now().Add(-time.Hour),
+			},
+			expect: fmt.Errorf("Rule has already expired."),
+			msg:    "Already expired",
+		},
+		{
+			rule: Rule{
+				AddedBy:          "test@google.com",
+				Name:             "My rule",
+				TaskSpecPatterns: []string{".*"},
+				Start:            time.Now().Add(time.Hour),
+				Expires:          time.Now().Add(2 * time.Hour),
+			},
+			expect: nil,
+			msg:    "Future time window",
+		},
 		{
 			rule: Rule{
 				AddedBy:          "test@google.com",
@@ -382,3 +433,90 @@ func TestNoTrybots(t *testing.T) {
 
 	assert.Equal(t, "Cannot remove built-in rule \"Trybots\"", b.RemoveRule("Trybots").Error())
 }
+
+func TestRuleDimensionsAndTimeWindow(t *testing.T) {
+	now := time.Now()
+	r := &Rule{
+		AddedBy:          "test@google.com",
+		Name:             "Flaky Android bots",
+		TaskSpecPatterns: []string{"^Test-"},
+		Dimensions:       []string{"os:Android", "device_type:grouper"},
+		Start:            now.Add(-time.Hour),
+		Expires:          now.Add(time.Hour),
+	}
+	dims := []string{"pool:Skia", "os:Android", "device_type:grouper"}
+
+	// All criteria match.
+	match, reason := r.MatchTask("Test-Android", "abc123", dims, now)
+	assert.True(t, match)
+	assert.Contains(t, reason, "task spec \"Test-Android\" matches")
+	assert.Contains(t, reason, "rule applies to all commits")
+	assert.Contains(t, reason, "dimensions include [os:Android device_type:grouper]")
+	assert.Contains(t, reason, "rule is in effect until")
+
+	// Missing one of the dimensions.
+	match, reason = r.MatchTask("Test-Android", "abc123", []string{"pool:Skia", "os:Android"}, now)
+	assert.False(t, match)
+	assert.Equal(t, "", reason)
+
+	// Task spec doesn't match.
+	match, _ = r.MatchTask("Perf-Android", "abc123", dims, now)
+	assert.False(t, match)
+
+	// Outside of the time window.
+	match, _ = r.MatchTask("Test-Android", "abc123", dims, now.Add(-2*time.Hour))
+	assert.False(t, match)
+	match, _ = r.MatchTask("Test-Android", "abc123", dims, now.Add(time.Hour))
+	assert.False(t, match)
+	assert.False(t, r.Expired(now))
+	assert.True(t, r.Expired(now.Add(time.Hour)))
+
+	// Rules with dimensions never match when no dimensions are given.
+	assert.False(t, r.Match("Test-Android", "abc123"))
+}
+
+func TestMatchRuleAndRemoveExpiredRules(t *testing.T) {
+	// Setup.
+	tmp, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, tmp)
+	f := path.Join(tmp, "blacklist.json")
+	b, err := FromFile(f)
+	assert.NoError(t, err)
+
+	// Add two rules, one of which expires shortly.
+	now := time.Now()
+	assert.NoError(t, b.addRule(&Rule{
+		AddedBy:          "test@google.com",
+		Name:             "Temporary",
+		TaskSpecPatterns: []string{"^Temp-"},
+		Expires:          now.Add(time.Hour),
+	}))
+	assert.NoError(t, b.addRule(&Rule{
+		AddedBy:          "test@google.com",
+		Name:             "Permanent",
+		TaskSpecPatterns: []string{"^Perm-"},
+	}))
+	rule, reason := b.MatchRule("Temp-Task", "abc123", nil)
+	assert.Equal(t, "Temporary", rule)
+	assert.NotEqual(t, "", reason)
+	rule, reason = b.MatchRule("Other-Task", "abc123", nil)
+	assert.Equal(t, "", rule)
+	assert.Equal(t, "", reason)
+
+	// Nothing has expired yet.
+	removed, err := b.RemoveExpiredRules(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(removed))
+	assert.Equal(t, len(DEFAULT_RULES)+2, len(b.Rules))
+
+	// Remove the expired rule. Ensure that the change persists.
+	removed, err = b.RemoveExpiredRules(now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Temporary"}, removed)
+	assert.Equal(t, len(DEFAULT_RULES)+1, len(b.Rules))
+	assert.NotNil(t, b.Rules["Permanent"])
+	b2, err := FromFile(f)
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, b, b2)
+}
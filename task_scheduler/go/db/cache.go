@@ -2,6 +2,7 @@ package db
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -41,7 +42,14 @@ type TaskCache interface {
 	//          the previous task's blamelist and into the newer task's blamelist.
 	GetTasksForCommits(string, []string) (map[string]map[string]*Task, error)
 
-	// KnownTaskName returns true iff the given task name has been seen before.
+	// GetTaskForPatch retrieves the most recently created task with the
+	// given name which ran at the given commit with the given patch
+	// applied, or nil if no such task exists. Try job tasks are not
+	// returned by GetTaskForCommit or GetTasksForCommits.
+	GetTaskForPatch(string, string, Patch, string) (*Task, error)
+
+	// KnownTaskName returns true iff the given task name has been seen
+	// before, not including try jobs.
 	KnownTaskName(string, string) bool
 
 	// UnfinishedTasks returns a list of tasks which were not finished at
@@ -52,8 +60,19 @@ type TaskCache interface {
 	Update() error
 }
 
+// patchKey identifies a patch applied on top of a commit in a repo.
+type patchKey struct {
+	repo     string
+	revision string
+	patch    Patch
+}
+
 type taskCache struct {
-	db TaskDB
+	db TaskReader
+	// feed is set if db is a ChangeFeed; in that case, cursor is used instead
+	// of queryId to retrieve modified tasks.
+	cursor string
+	feed   ChangeFeed
 	// map[repo_name][task_spec_name]bool
 	knownTaskNames map[string]map[string]bool
 	mtx            sync.RWMutex
@@ -61,8 +80,10 @@ type taskCache struct {
 	tasks          map[string]*Task
 	// map[repo_name][commit_hash][task_spec_name]*Task
 	tasksByCommit map[string]map[string]map[string]*Task
-	timePeriod    time.Duration
-	unfinished    map[string]*Task
+	// map[patchKey][task_spec_name]*Task
+	tasksByPatch map[patchKey]map[string]*Task
+	timePeriod   time.Duration
+	unfinished   map[string]*Task
 }
 
 // See documentation for TaskCache interface.
@@ -121,6 +142,17 @@ func (c *taskCache) GetTaskForCommit(repo, commit, name string) (*Task, error) {
 	return nil, nil
 }
 
+// See documentation for TaskCache interface.
+func (c *taskCache) GetTaskForPatch(repo, commit string, patch Patch, name string) (*Task, error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	if t, ok := c.tasksByPatch[patchKey{repo, commit, patch}][name]; ok {
+		return t.Copy(), nil
+	}
+	return nil, nil
+}
+
 // See documentation for TaskCache interface.
 func (c *taskCache) UnfinishedTasks() ([]*Task, error) {
 	c.mtx.RLock()
@@ -137,6 +169,10 @@ func (c *taskCache) UnfinishedTasks() ([]*Task, error) {
 // holds a lock.
 func (c *taskCache) update(tasks []*Task) error {
 	for _, t := range tasks {
+		// Changes may be delivered out of order; ignore stale data.
+		if old, ok := c.tasks[t.Id]; ok && old.DbModified.After(t.DbModified) {
+			continue
+		}
 		repo := t.Repo
 		commitMap, ok := c.tasksByCommit[repo]
 		if !ok {
@@ -157,14 +193,6 @@ func (c *taskCache) update(tasks []*Task) error {
 		cpy := t.Copy()
 		c.tasks[t.Id] = cpy
 
-		// Insert the task into tasksByCommits.
-		for _, commit := range t.Commits {
-			if _, ok := commitMap[commit]; !ok {
-				commitMap[commit] = map[string]*Task{}
-			}
-			commitMap[commit][t.Name] = cpy
-		}
-
 		// Unfinished tasks.
 		if _, ok := c.unfinished[t.Id]; ok {
 			delete(c.unfinished, t.Id)
@@ -173,6 +201,28 @@ func (c *taskCache) update(tasks []*Task) error {
 			c.unfinished[t.Id] = cpy
 		}
 
+		// Try jobs are kept separate from the tasks for each commit.
+		if t.IsTryJob() {
+			key := patchKey{repo, t.Revision, t.Patch}
+			byName, ok := c.tasksByPatch[key]
+			if !ok {
+				byName = map[string]*Task{}
+				c.tasksByPatch[key] = byName
+			}
+			if old, ok := byName[t.Name]; !ok || old.Id == t.Id || !old.Created.After(t.Created) {
+				byName[t.Name] = cpy
+			}
+			continue
+		}
+
+		// Insert the task into tasksByCommits.
+		for _, commit := range t.Commits {
+			if _, ok := commitMap[commit]; !ok {
+				commitMap[commit] = map[string]*Task{}
+			}
+			commitMap[commit][t.Name] = cpy
+		}
+
 		// Known task names.
 		if nameMap, ok := c.knownTaskNames[repo]; ok {
 			nameMap[t.Name] = true
@@ -187,23 +237,31 @@ func (c *taskCache) update(tasks []*Task) error {
 func (c *taskCache) reset() error {
 	if c.queryId != "" {
 		c.db.StopTrackingModifiedTasks(c.queryId)
+		c.queryId = ""
 	}
-	queryId, err := c.db.StartTrackingModifiedTasks()
+	cursor, queryId, err := startTracking(c.feed, c.db.StartTrackingModifiedTasks)
 	if err != nil {
 		return err
 	}
+	if cursor == "" {
+		c.feed = nil
+	}
 	now := time.Now()
 	start := now.Add(-c.timePeriod)
 	glog.Infof("Reading Tasks from %s to %s.", start, now)
 	tasks, err := c.db.GetTasksFromDateRange(start, now)
 	if err != nil {
-		c.db.StopTrackingModifiedTasks(queryId)
+		if queryId != "" {
+			c.db.StopTrackingModifiedTasks(queryId)
+		}
 		return err
 	}
+	c.cursor = cursor
 	c.knownTaskNames = map[string]map[string]bool{}
 	c.queryId = queryId
 	c.tasks = map[string]*Task{}
 	c.tasksByCommit = map[string]map[string]map[string]*Task{}
+	c.tasksByPatch = map[patchKey]map[string]*Task{}
 	c.unfinished = map[string]*Task{}
 	if err := c.update(tasks); err != nil {
 		return err
@@ -215,7 +273,20 @@ func (c *taskCache) reset() error {
 func (c *taskCache) Update() error {
 	// TODO(borenet): We need to flush old jobs/commits which are outside
 	// of our timePeriod so that the cache size is not unbounded.
-	newTasks, err := c.db.GetModifiedTasks(c.queryId)
+	var newTasks []*Task
+	var cursor string
+	var err error
+	if c.feed != nil {
+		var changes []*Change
+		changes, cursor, err = c.feed.GetChanges(c.cursor, 0)
+		for _, ch := range changes {
+			if ch.Task != nil {
+				newTasks = append(newTasks, ch.Task)
+			}
+		}
+	} else {
+		newTasks, err = c.db.GetModifiedTasks(c.queryId)
+	}
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 	if IsUnknownId(err) {
@@ -227,6 +298,9 @@ func (c *taskCache) Update() error {
 	} else if err != nil {
 		return err
 	}
+	if c.feed != nil {
+		c.cursor = cursor
+	}
 	if err := c.update(newTasks); err == nil {
 		return nil
 	} else {
@@ -234,13 +308,32 @@ func (c *taskCache) Update() error {
 	}
 }
 
+// startTracking begins tracking modifications. If feed is non-nil, returns a
+// cursor from feed. Otherwise, or if feed returns an error, calls start and
+// returns the query ID.
+func startTracking(feed ChangeFeed, start func() (string, error)) (string, string, error) {
+	if feed != nil {
+		cursor, err := feed.CurrentCursor()
+		if err == nil {
+			return cursor, "", nil
+		}
+		glog.Warningf("Unable to use change feed; falling back to polling for modified entries: %s", err)
+	}
+	queryId, err := start()
+	return "", queryId, err
+}
+
 // NewTaskCache returns a local cache which provides more convenient views of
-// task data than the database can provide.
-func NewTaskCache(db TaskDB, timePeriod time.Duration) (TaskCache, error) {
+// task data than the database can provide. If db is also a ChangeFeed, the
+// cache uses the ChangeFeed to retrieve modified tasks.
+func NewTaskCache(db TaskReader, timePeriod time.Duration) (TaskCache, error) {
 	tc := &taskCache{
 		db:         db,
 		timePeriod: timePeriod,
 	}
+	if feed, ok := db.(ChangeFeed); ok {
+		tc.feed = feed
+	}
 	if err := tc.reset(); err != nil {
 		return nil, err
 	}
@@ -251,8 +344,16 @@ type JobCache interface {
 	// GetJob returns the job with the given ID, or an error if no such job exists.
 	GetJob(string) (*Job, error)
 
+	// GetJobsForCommit returns all jobs, finished or not, for the given
+	// repo/commit, not including try jobs.
+	GetJobsForCommit(string, string) ([]*Job, error)
+
+	// GetJobsForPatch returns all try jobs, finished or not, for the given
+	// repo/commit/patch.
+	GetJobsForPatch(string, string, Patch) ([]*Job, error)
+
 	// ScheduledJobsForCommit indicates whether or not we triggered any jobs
-	// for the given repo/commit.
+	// for the given repo/commit, not including try jobs.
 	ScheduledJobsForCommit(string, string) (bool, error)
 
 	// UnfinishedJobs returns a list of jobs which were not finished at
@@ -264,10 +365,16 @@ type JobCache interface {
 }
 
 type jobCache struct {
-	db                 JobDB
+	db JobReader
+	// feed is set if db is a ChangeFeed; in that case, cursor is used instead
+	// of queryId to retrieve modified jobs.
+	cursor             string
+	feed               ChangeFeed
 	mtx                sync.RWMutex
 	queryId            string
 	jobs               map[string]*Job
+	jobsByCommit       map[string]map[string]map[string]*Job
+	jobsByPatch        map[patchKey]map[string]*Job
 	timePeriod         time.Duration
 	triggeredForCommit map[string]map[string]bool
 	unfinished         map[string]*Job
@@ -284,6 +391,34 @@ func (c *jobCache) GetJob(id string) (*Job, error) {
 	return nil, ErrNotFound
 }
 
+// See documentation for JobCache interface.
+func (c *jobCache) GetJobsForCommit(repo, rev string) ([]*Job, error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	jobs := c.jobsByCommit[repo][rev]
+	rv := make([]*Job, 0, len(jobs))
+	for _, j := range jobs {
+		rv = append(rv, j.Copy())
+	}
+	sort.Sort(JobSlice(rv))
+	return rv, nil
+}
+
+// See documentation for JobCache interface.
+func (c *jobCache) GetJobsForPatch(repo, rev string, patch Patch) ([]*Job, error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	jobs := c.jobsByPatch[patchKey{repo, rev, patch}]
+	rv := make([]*Job, 0, len(jobs))
+	for _, j := range jobs {
+		rv = append(rv, j.Copy())
+	}
+	sort.Sort(JobSlice(rv))
+	return rv, nil
+}
+
 // See documentation for JobCache interface.
 func (c *jobCache) ScheduledJobsForCommit(repo, rev string) (bool, error) {
 	c.mtx.RLock()
@@ -307,22 +442,46 @@ func (c *jobCache) UnfinishedJobs() ([]*Job, error) {
 // holds a lock.
 func (c *jobCache) update(jobs []*Job) error {
 	for _, j := range jobs {
+		// Changes may be delivered out of order; ignore stale data.
+		if old, ok := c.jobs[j.Id]; ok && old.DbModified.After(j.DbModified) {
+			continue
+		}
+
 		// Insert the new job into the main map.
 		cpy := j.Copy()
 		c.jobs[j.Id] = cpy
 
+		// Unfinished jobs.
+		if j.Done() {
+			delete(c.unfinished, j.Id)
+		} else {
+			c.unfinished[j.Id] = cpy
+		}
+
+		// GetJobsForPatch.
+		if j.IsTryJob() {
+			key := patchKey{j.Repo, j.Revision, j.Patch}
+			if _, ok := c.jobsByPatch[key]; !ok {
+				c.jobsByPatch[key] = map[string]*Job{}
+			}
+			c.jobsByPatch[key][j.Id] = cpy
+			continue
+		}
+
 		// ScheduledJobsForCommit.
 		if _, ok := c.triggeredForCommit[j.Repo]; !ok {
 			c.triggeredForCommit[j.Repo] = map[string]bool{}
 		}
 		c.triggeredForCommit[j.Repo][j.Revision] = true
 
-		// Unfinished jobs.
-		if j.Done() {
-			delete(c.unfinished, j.Id)
-		} else {
-			c.unfinished[j.Id] = cpy
+		// GetJobsForCommit.
+		if _, ok := c.jobsByCommit[j.Repo]; !ok {
+			c.jobsByCommit[j.Repo] = map[string]map[string]*Job{}
+		}
+		if _, ok := c.jobsByCommit[j.Repo][j.Revision]; !ok {
+			c.jobsByCommit[j.Repo][j.Revision] = map[string]*Job{}
 		}
+		c.jobsByCommit[j.Repo][j.Revision][j.Id] = cpy
 	}
 	return nil
 }
@@ -331,21 +490,30 @@ func (c *jobCache) update(jobs []*Job) error {
 func (c *jobCache) reset() error {
 	if c.queryId != "" {
 		c.db.StopTrackingModifiedJobs(c.queryId)
+		c.queryId = ""
 	}
-	queryId, err := c.db.StartTrackingModifiedJobs()
+	cursor, queryId, err := startTracking(c.feed, c.db.StartTrackingModifiedJobs)
 	if err != nil {
 		return err
 	}
+	if cursor == "" {
+		c.feed = nil
+	}
 	now := time.Now()
 	start := now.Add(-c.timePeriod)
 	glog.Infof("Reading Jobs from %s to %s.", start, now)
 	jobs, err := c.db.GetJobsFromDateRange(start, now)
 	if err != nil {
-		c.db.StopTrackingModifiedJobs(queryId)
+		if queryId != "" {
+			c.db.StopTrackingModifiedJobs(queryId)
+		}
 		return err
 	}
+	c.cursor = cursor
 	c.queryId = queryId
 	c.jobs = map[string]*Job{}
+	c.jobsByCommit = map[string]map[string]map[string]*Job{}
+	c.jobsByPatch = map[patchKey]map[string]*Job{}
 	c.triggeredForCommit = map[string]map[string]bool{}
 	c.unfinished = map[string]*Job{}
 	if err := c.update(jobs); err != nil {
@@ -358,7 +526,20 @@ func (c *jobCache) reset() error {
 func (c *jobCache) Update() error {
 	// TODO(borenet): We need to flush old jobs/commits which are outside
 	// of our timePeriod so that the cache size is not unbounded.
-	newJobs, err := c.db.GetModifiedJobs(c.queryId)
+	var newJobs []*Job
+	var cursor string
+	var err error
+	if c.feed != nil {
+		var changes []*Change
+		changes, cursor, err = c.feed.GetChanges(c.cursor, 0)
+		for _, ch := range changes {
+			if ch.Job != nil {
+				newJobs = append(newJobs, ch.Job)
+			}
+		}
+	} else {
+		newJobs, err = c.db.GetModifiedJobs(c.queryId)
+	}
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 	if IsUnknownId(err) {
@@ -370,6 +551,9 @@ func (c *jobCache) Update() error {
 	} else if err != nil {
 		return err
 	}
+	if c.feed != nil {
+		c.cursor = cursor
+	}
 	if err := c.update(newJobs); err == nil {
 		return nil
 	} else {
@@ -378,12 +562,16 @@ func (c *jobCache) Update() error {
 }
 
 // NewJobCache returns a local cache which provides more convenient views of
-// job data than the database can provide.
-func NewJobCache(db JobDB, timePeriod time.Duration) (JobCache, error) {
+// job data than the database can provide. If db is also a ChangeFeed, the
+// cache uses the ChangeFeed to retrieve modified jobs.
+func NewJobCache(db JobReader, timePeriod time.Duration) (JobCache, error) {
 	tc := &jobCache{
 		db:         db,
 		timePeriod: timePeriod,
 	}
+	if feed, ok := db.(ChangeFeed); ok {
+		tc.feed = feed
+	}
 	if err := tc.reset(); err != nil {
 		return nil, err
 	}
@@ -125,6 +125,63 @@ func TestTaskCacheMultiRepo(t *testing.T) {
 	}
 }
 
+func TestTaskCacheTryJobs(t *testing.T) {
+	db := NewInMemoryTaskDB()
+	defer testutils.AssertCloses(t, db)
+
+	// Insert a task at a commit, and a try job task with a patch on top of
+	// the same commit.
+	startTime := time.Now().Add(-30 * time.Minute) // Arbitrary starting point.
+	t1 := makeTask(startTime, []string{"a"})
+	t1.Revision = "a"
+	patch := Patch{
+		Server:   "https://codereview.chromium.org",
+		Issue:    "1234",
+		Patchset: "1",
+	}
+	t2 := makeTask(startTime.Add(time.Minute), nil)
+	t2.Revision = "a"
+	t2.Patch = patch
+	assert.NoError(t, db.PutTasks([]*Task{t1, t2}))
+
+	// The try job is not included in the results for the commit.
+	c, err := NewTaskCache(db, time.Hour)
+	assert.NoError(t, err)
+	testGetTasksForCommits(t, c, t1)
+	found, err := c.GetTaskForPatch(DEFAULT_TEST_REPO, "a", patch, t2.Name)
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, t2, found)
+
+	// Try jobs do not count as known task names.
+	t3 := makeTask(startTime.Add(2*time.Minute), nil)
+	t3.Name = "Try-Only-Task"
+	t3.Revision = "a"
+	t3.Patch = patch
+	assert.NoError(t, db.PutTask(t3))
+	assert.NoError(t, c.Update())
+	assert.False(t, c.KnownTaskName(DEFAULT_TEST_REPO, t3.Name))
+	unfinished, err := c.UnfinishedTasks()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(unfinished))
+
+	// A retry of the try job replaces the original.
+	t4 := makeTask(startTime.Add(3*time.Minute), nil)
+	t4.Revision = "a"
+	t4.Patch = patch
+	t4.RetryOf = t2.Id
+	assert.NoError(t, db.PutTask(t4))
+	assert.NoError(t, c.Update())
+	found, err = c.GetTaskForPatch(DEFAULT_TEST_REPO, "a", patch, t2.Name)
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, t4, found)
+
+	// A different patchset has no tasks.
+	patch.Patchset = "2"
+	found, err = c.GetTaskForPatch(DEFAULT_TEST_REPO, "a", patch, t2.Name)
+	assert.NoError(t, err)
+	assert.Nil(t, found)
+}
+
 func TestTaskCacheReset(t *testing.T) {
 	db := NewInMemoryTaskDB()
 	defer testutils.AssertCloses(t, db)
@@ -268,6 +325,84 @@ func TestJobCacheTriggeredForCommit(t *testing.T) {
 	assert.False(t, b)
 }
 
+func TestJobCacheGetJobsForCommit(t *testing.T) {
+	db := NewInMemoryJobDB()
+	defer testutils.AssertCloses(t, db)
+
+	// Insert several jobs at different commits.
+	startTime := time.Now().Add(-30 * time.Minute) // Arbitrary starting point.
+	j1 := makeJob(startTime)
+	j1.Revision = "a"
+	j2 := makeJob(startTime.Add(time.Minute))
+	j2.Revision = "a"
+	j2.Status = JOB_STATUS_CANCELED
+	j3 := makeJob(startTime)
+	j3.Revision = "b"
+	assert.NoError(t, db.PutJobs([]*Job{j1, j2, j3}))
+
+	// Create the cache.
+	cache, err := NewJobCache(db, time.Hour)
+	assert.NoError(t, err)
+	jobs, err := cache.GetJobsForCommit(DEFAULT_TEST_REPO, "a")
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, []*Job{j1, j2}, jobs)
+	jobs, err = cache.GetJobsForCommit(DEFAULT_TEST_REPO, "b")
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, []*Job{j3}, jobs)
+	jobs, err = cache.GetJobsForCommit(DEFAULT_TEST_REPO, "c")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(jobs))
+
+	// Retry a job. Ensure that both are returned.
+	j4 := makeJob(startTime.Add(2 * time.Minute))
+	j4.Revision = "a"
+	j4.RetryOf = j2.Id
+	assert.NoError(t, db.PutJob(j4))
+	assert.NoError(t, cache.Update())
+	jobs, err = cache.GetJobsForCommit(DEFAULT_TEST_REPO, "a")
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, []*Job{j1, j2, j4}, jobs)
+}
+
+func TestJobCacheTryJobs(t *testing.T) {
+	db := NewInMemoryJobDB()
+	defer testutils.AssertCloses(t, db)
+
+	startTime := time.Now().Add(-30 * time.Minute) // Arbitrary starting point.
+	patch := Patch{
+		Server:   "https://codereview.chromium.org",
+		Issue:    "1234",
+		Patchset: "1",
+	}
+	j1 := makeJob(startTime)
+	j1.Revision = "a"
+	j2 := makeJob(startTime.Add(time.Minute))
+	j2.Revision = "a"
+	j2.Patch = patch
+	j3 := makeJob(startTime.Add(2 * time.Minute))
+	j3.Revision = "b"
+	j3.Patch = patch
+	assert.NoError(t, db.PutJobs([]*Job{j1, j2, j3}))
+
+	// Try jobs are not included in the results for the commit.
+	cache, err := NewJobCache(db, time.Hour)
+	assert.NoError(t, err)
+	jobs, err := cache.GetJobsForCommit(DEFAULT_TEST_REPO, "a")
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, []*Job{j1}, jobs)
+	scheduled, err := cache.ScheduledJobsForCommit(DEFAULT_TEST_REPO, "b")
+	assert.NoError(t, err)
+	assert.False(t, scheduled)
+
+	jobs, err = cache.GetJobsForPatch(DEFAULT_TEST_REPO, "a", patch)
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, []*Job{j2}, jobs)
+	jobs, err = cache.GetJobsForPatch(DEFAULT_TEST_REPO, "b", patch)
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, []*Job{j3}, jobs)
+	testGetUnfinished(t, []*Job{j1, j2, j3}, cache)
+}
+
 func testGetUnfinished(t *testing.T, expect []*Job, cache JobCache) {
 	jobs, err := cache.UnfinishedJobs()
 	assert.NoError(t, err)
@@ -347,3 +482,54 @@ func TestJobCacheUnfinished(t *testing.T) {
 	assert.NoError(t, c.Update())
 	testGetUnfinished(t, []*Job{j3}, c)
 }
+
+func TestCacheChangeFeed(t *testing.T) {
+	d := NewChangeFeedDB(NewInMemoryDB())
+	defer testutils.AssertCloses(t, d)
+
+	startTime := time.Now().Add(-30 * time.Minute)
+	t1 := makeTask(startTime, []string{"a", "b"})
+	assert.NoError(t, d.PutTask(t1))
+	j1 := makeJob(startTime)
+	assert.NoError(t, d.PutJob(j1))
+
+	// The caches should use the change feed rather than tracking modified
+	// entries.
+	tc, err := NewTaskCache(d, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, "", tc.(*taskCache).queryId)
+	assert.NotEqual(t, "", tc.(*taskCache).cursor)
+	jc, err := NewJobCache(d, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, "", jc.(*jobCache).queryId)
+	assert.NotEqual(t, "", jc.(*jobCache).cursor)
+	testGetTasksForCommits(t, tc, t1)
+	testGetUnfinished(t, []*Job{j1}, jc)
+
+	// Updates are received through the feed.
+	t1.Commits = []string{"a"}
+	t2 := makeTask(startTime.Add(time.Minute), []string{"b"})
+	assert.NoError(t, d.PutTasks([]*Task{t1, t2}))
+	j1.Status = JOB_STATUS_SUCCESS
+	j2 := makeJob(startTime.Add(time.Minute))
+	assert.NoError(t, d.PutJobs([]*Job{j1, j2}))
+	assert.NoError(t, tc.Update())
+	assert.NoError(t, jc.Update())
+	testGetTasksForCommits(t, tc, t1)
+	testGetTasksForCommits(t, tc, t2)
+	testGetUnfinished(t, []*Job{j2}, jc)
+
+	// An invalid cursor causes the caches to reset.
+	tc.(*taskCache).cursor = "bogus"
+	jc.(*jobCache).cursor = "bogus"
+	t3 := makeTask(startTime.Add(2*time.Minute), []string{"c"})
+	assert.NoError(t, d.PutTask(t3))
+	j3 := makeJob(startTime.Add(2 * time.Minute))
+	assert.NoError(t, d.PutJob(j3))
+	assert.NoError(t, tc.Update())
+	assert.NoError(t, jc.Update())
+	assert.NotEqual(t, "bogus", tc.(*taskCache).cursor)
+	assert.NotEqual(t, "bogus", jc.(*jobCache).cursor)
+	testGetTasksForCommits(t, tc, t3)
+	testGetUnfinished(t, []*Job{j2, j3}, jc)
+}
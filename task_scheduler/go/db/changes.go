@@ -0,0 +1,281 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+const (
+	// Changes are retained by a change feed for at least CHANGE_RETENTION,
+	// unless there are more than MAX_RETAINED_CHANGES changes.
+	CHANGE_RETENTION     = time.Hour
+	MAX_RETAINED_CHANGES = 100000
+)
+
+// Change describes a single modification to a DB. Exactly one of the fields
+// is set.
+type Change struct {
+	// Task is the new value of a Task which was inserted or updated.
+	Task *Task
+	// Job is the new value of a Job which was inserted or updated.
+	Job *Job
+	// Comments contains all of the comments for a repo in which a comment was
+	// added or deleted.
+	Comments *RepoComments
+}
+
+// ChangeFeed provides a resumable stream of changes to a DB. Each change is
+// assigned a position in the stream; a cursor identifies a position. Unlike
+// StartTrackingModifiedTasks, a cursor does not expire because of a lack of
+// polling; it only becomes invalid once the changes following it have been
+// discarded, or if the server restarts.
+type ChangeFeed interface {
+	// CurrentCursor returns a cursor which points to the most recent change.
+	CurrentCursor() (string, error)
+
+	// GetChanges returns all changes following the given cursor, along with a
+	// cursor pointing to the last returned change. If there are no such
+	// changes, waits up to timeout for a change to occur, then returns any
+	// changes and the new cursor. Returns ErrUnknownId if the cursor is
+	// invalid or if changes following the cursor have been discarded.
+	GetChanges(cursor string, timeout time.Duration) ([]*Change, string, error)
+}
+
+// ChangeFeedDB is a DB which also provides a ChangeFeed.
+type ChangeFeedDB interface {
+	DB
+	ChangeFeed
+}
+
+// sequencedChange is a Change along with its position in the changeLog.
+type sequencedChange struct {
+	seq  uint64
+	ts   time.Time
+	data *Change
+}
+
+// changeLog retains recent changes in order and implements ChangeFeed.
+type changeLog struct {
+	// epoch distinguishes cursors issued by different instances, so that
+	// cursors become invalid when the server restarts.
+	epoch string
+	// changes is ordered by seq. Protected by mtx.
+	changes []*sequencedChange
+	// lastSeq is the seq of the most recent change. Protected by mtx.
+	lastSeq uint64
+	// notify is closed and replaced when changes are added. Protected by mtx.
+	notify chan struct{}
+	mtx    sync.Mutex
+}
+
+// newChangeLog returns an empty changeLog.
+func newChangeLog() *changeLog {
+	return &changeLog{
+		epoch:  uuid.NewV4().String(),
+		notify: make(chan struct{}),
+	}
+}
+
+// formatCursor returns a cursor for the given seq.
+func (l *changeLog) formatCursor(seq uint64) string {
+	return fmt.Sprintf("%s_%d", l.epoch, seq)
+}
+
+// parseCursor returns the seq for the given cursor, or ErrUnknownId if the
+// cursor was not issued by this changeLog.
+func (l *changeLog) parseCursor(cursor string) (uint64, error) {
+	split := strings.Split(cursor, "_")
+	if len(split) != 2 || split[0] != l.epoch {
+		return 0, ErrUnknownId
+	}
+	seq, err := strconv.ParseUint(split[1], 10, 64)
+	if err != nil {
+		return 0, ErrUnknownId
+	}
+	return seq, nil
+}
+
+// add appends the given changes to the log and discards old changes.
+func (l *changeLog) add(changes []*Change) {
+	if len(changes) == 0 {
+		return
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	now := time.Now()
+	for _, c := range changes {
+		l.lastSeq++
+		l.changes = append(l.changes, &sequencedChange{
+			seq:  l.lastSeq,
+			ts:   now,
+			data: c,
+		})
+	}
+	expire := 0
+	for expire < len(l.changes) && (len(l.changes)-expire > MAX_RETAINED_CHANGES || now.Sub(l.changes[expire].ts) > CHANGE_RETENTION) {
+		expire++
+	}
+	if expire > 0 {
+		l.changes = append([]*sequencedChange{}, l.changes[expire:]...)
+	}
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// See documentation for ChangeFeed.
+func (l *changeLog) CurrentCursor() (string, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.formatCursor(l.lastSeq), nil
+}
+
+// getChanges returns the changes following seq and a channel which will be
+// closed when more changes are added.
+func (l *changeLog) getChanges(seq uint64) ([]*Change, uint64, <-chan struct{}, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if seq > l.lastSeq {
+		return nil, 0, nil, ErrUnknownId
+	}
+	if seq == l.lastSeq {
+		return []*Change{}, seq, l.notify, nil
+	}
+	// Changes following seq must not have been discarded.
+	if len(l.changes) == 0 || l.changes[0].seq > seq+1 {
+		return nil, 0, nil, ErrUnknownId
+	}
+	start := int(seq + 1 - l.changes[0].seq)
+	rv := make([]*Change, 0, len(l.changes)-start)
+	for _, c := range l.changes[start:] {
+		rv = append(rv, c.data)
+	}
+	return rv, l.lastSeq, l.notify, nil
+}
+
+// See documentation for ChangeFeed.
+func (l *changeLog) GetChanges(cursor string, timeout time.Duration) ([]*Change, string, error) {
+	seq, err := l.parseCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	changes, newSeq, notify, err := l.getChanges(seq)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(changes) == 0 && timeout > 0 {
+		select {
+		case <-notify:
+			changes, newSeq, _, err = l.getChanges(seq)
+			if err != nil {
+				return nil, "", err
+			}
+		case <-time.After(timeout):
+		}
+	}
+	return changes, l.formatCursor(newSeq), nil
+}
+
+// changeFeedDB wraps a DB, recording all changes made through it in a
+// changeLog.
+type changeFeedDB struct {
+	DB
+	*changeLog
+}
+
+// NewChangeFeedDB returns a ChangeFeedDB which records all changes made
+// through it. Changes made directly to d are not recorded.
+func NewChangeFeedDB(d DB) ChangeFeedDB {
+	return &changeFeedDB{
+		DB:        d,
+		changeLog: newChangeLog(),
+	}
+}
+
+// See documentation for TaskDB.
+func (d *changeFeedDB) PutTask(t *Task) error {
+	return d.PutTasks([]*Task{t})
+}
+
+// See documentation for TaskDB.
+func (d *changeFeedDB) PutTasks(tasks []*Task) error {
+	if err := d.DB.PutTasks(tasks); err != nil {
+		return err
+	}
+	changes := make([]*Change, 0, len(tasks))
+	for _, t := range tasks {
+		changes = append(changes, &Change{Task: t.Copy()})
+	}
+	d.add(changes)
+	return nil
+}
+
+// See documentation for JobDB.
+func (d *changeFeedDB) PutJob(j *Job) error {
+	return d.PutJobs([]*Job{j})
+}
+
+// See documentation for JobDB.
+func (d *changeFeedDB) PutJobs(jobs []*Job) error {
+	if err := d.DB.PutJobs(jobs); err != nil {
+		return err
+	}
+	changes := make([]*Change, 0, len(jobs))
+	for _, j := range jobs {
+		changes = append(changes, &Change{Job: j.Copy()})
+	}
+	d.add(changes)
+	return nil
+}
+
+// commentsChanged records the current comments for the given repo as a
+// Change, if err is nil. Returns err.
+func (d *changeFeedDB) commentsChanged(repo string, err error) error {
+	if err != nil {
+		return err
+	}
+	comments, err := d.DB.GetCommentsForRepos([]string{repo}, time.Time{})
+	if err != nil {
+		return err
+	}
+	changes := make([]*Change, 0, len(comments))
+	for _, c := range comments {
+		changes = append(changes, &Change{Comments: c})
+	}
+	d.add(changes)
+	return nil
+}
+
+// See documentation for CommentDB.
+func (d *changeFeedDB) PutTaskComment(c *TaskComment) error {
+	return d.commentsChanged(c.Repo, d.DB.PutTaskComment(c))
+}
+
+// See documentation for CommentDB.
+func (d *changeFeedDB) DeleteTaskComment(c *TaskComment) error {
+	return d.commentsChanged(c.Repo, d.DB.DeleteTaskComment(c))
+}
+
+// See documentation for CommentDB.
+func (d *changeFeedDB) PutTaskSpecComment(c *TaskSpecComment) error {
+	return d.commentsChanged(c.Repo, d.DB.PutTaskSpecComment(c))
+}
+
+// See documentation for CommentDB.
+func (d *changeFeedDB) DeleteTaskSpecComment(c *TaskSpecComment) error {
+	return d.commentsChanged(c.Repo, d.DB.DeleteTaskSpecComment(c))
+}
+
+// See documentation for CommentDB.
+func (d *changeFeedDB) PutCommitComment(c *CommitComment) error {
+	return d.commentsChanged(c.Repo, d.DB.PutCommitComment(c))
+}
+
+// See documentation for CommentDB.
+func (d *changeFeedDB) DeleteCommitComment(c *CommitComment) error {
+	return d.commentsChanged(c.Repo, d.DB.DeleteCommitComment(c))
+}
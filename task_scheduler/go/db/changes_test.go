@@ -0,0 +1,109 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/testutils"
+)
+
+func TestChangeFeed(t *testing.T) {
+	d := NewChangeFeedDB(NewInMemoryDB())
+	defer testutils.AssertCloses(t, d)
+
+	start, err := d.CurrentCursor()
+	assert.NoError(t, err)
+
+	// No changes yet.
+	changes, cursor, err := d.GetChanges(start, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(changes))
+	assert.Equal(t, start, cursor)
+
+	// Insert a task, a job, and a comment.
+	now := time.Now()
+	t1 := makeTask(now, []string{"a", "b"})
+	assert.NoError(t, d.PutTask(t1))
+	j1 := makeJob(now)
+	assert.NoError(t, d.PutJob(j1))
+	c1 := &TaskSpecComment{
+		Repo:      DEFAULT_TEST_REPO,
+		Name:      "spec",
+		Timestamp: now,
+		User:      "me@example.com",
+	}
+	assert.NoError(t, d.PutTaskSpecComment(c1))
+
+	changes, cursor, err = d.GetChanges(start, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(changes))
+	testutils.AssertDeepEqual(t, t1, changes[0].Task)
+	testutils.AssertDeepEqual(t, j1, changes[1].Job)
+	assert.Equal(t, DEFAULT_TEST_REPO, changes[2].Comments.Repo)
+	assert.Equal(t, 1, len(changes[2].Comments.TaskSpecComments["spec"]))
+	current, err := d.CurrentCursor()
+	assert.NoError(t, err)
+	assert.Equal(t, current, cursor)
+
+	// The same cursor can be used again.
+	again, _, err := d.GetChanges(start, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(again))
+
+	// Failed updates are not recorded.
+	stale := t1.Copy()
+	stale.DbModified = time.Time{}
+	assert.True(t, IsConcurrentUpdate(d.PutTask(stale)))
+	changes, cursor2, err := d.GetChanges(cursor, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(changes))
+	assert.Equal(t, cursor, cursor2)
+
+	// GetChanges waits for a change.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		t1.Status = TASK_STATUS_RUNNING
+		assert.NoError(t, d.PutTask(t1))
+	}()
+	changes, _, err = d.GetChanges(cursor, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, TASK_STATUS_RUNNING, changes[0].Task.Status)
+
+	// Invalid cursors.
+	for _, invalid := range []string{"", "bogus", "bogus_0", start + "_0"} {
+		_, _, err := d.GetChanges(invalid, 0)
+		assert.True(t, IsUnknownId(err), invalid)
+	}
+}
+
+func TestChangeLogExpiration(t *testing.T) {
+	l := newChangeLog()
+	start, err := l.CurrentCursor()
+	assert.NoError(t, err)
+	l.add([]*Change{{Task: makeTask(time.Now(), []string{"a"})}})
+	mid, err := l.CurrentCursor()
+	assert.NoError(t, err)
+
+	// Age out the first change.
+	l.changes[0].ts = time.Now().Add(-2 * CHANGE_RETENTION)
+	l.add([]*Change{{Task: makeTask(time.Now(), []string{"b"})}})
+	assert.Equal(t, 1, len(l.changes))
+
+	// The first cursor is no longer valid, but the second is.
+	_, _, err = l.GetChanges(start, 0)
+	assert.True(t, IsUnknownId(err))
+	changes, _, err := l.GetChanges(mid, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, []string{"b"}, changes[0].Task.Commits)
+
+	// Cursors from the future are not valid.
+	_, _, err = l.GetChanges(l.formatCursor(100), 0)
+	assert.True(t, IsUnknownId(err))
+
+	// Cursors from a different changeLog are not valid.
+	_, _, err = newChangeLog().GetChanges(mid, 0)
+	assert.True(t, IsUnknownId(err))
+}
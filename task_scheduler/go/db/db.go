@@ -153,9 +153,11 @@ func UpdateTaskWithRetries(db TaskDB, id string, f func(*Task) error) (*Task, er
 }
 
 // JobReader is a read-only view of a JobDB.
+//
+// Unlike TaskReader, JobReader does not include io.Closer, since the
+// interfaces that combine both, e.g. RemoteDB and DB, would then have a
+// duplicate Close method. Implementations are closed through TaskReader.
 type JobReader interface {
-	io.Closer
-
 	// GetModifiedJobs returns all jobs modified since the last time
 	// GetModifiedJobs was run with the given id.
 	GetModifiedJobs(string) ([]*Job, error)
@@ -257,7 +259,7 @@ func UpdateJobWithRetries(db JobDB, id string, f func(*Job) error) (*Job, error)
 
 // RemoteDB allows retrieving tasks and jobs and full access to comments.
 type RemoteDB interface {
-	//JobReader
+	JobReader
 	TaskReader
 	CommentDB
 }
@@ -267,3 +269,10 @@ type TaskAndCommentDB interface {
 	TaskDB
 	CommentDB
 }
+
+// DB implements TaskDB, JobDB, and CommentDB.
+type DB interface {
+	TaskDB
+	JobDB
+	CommentDB
+}
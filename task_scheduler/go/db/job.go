@@ -41,8 +41,9 @@ var (
 )
 
 // JobStatus represents the current status of a Job. A JobStatus other than
-// JOB_STATUS_IN_PROGRESS is final; we do not retry Jobs, only their component
-// Tasks.
+// JOB_STATUS_IN_PROGRESS is final. A Job which has finished unsuccessfully
+// may be retried by creating a new Job with RetryOf set; the Job itself is
+// never moved back to JOB_STATUS_IN_PROGRESS.
 type JobStatus string
 
 // WorseThan returns true iff this JobStatus is worse than the given JobStatus.
@@ -107,6 +108,10 @@ type Job struct {
 	// should never change for a given Job instance.
 	Name string
 
+	// Patch is the patch to apply on top of Revision, if this is a try
+	// job. This property should never change for a given Job instance.
+	Patch Patch
+
 	// Priority is an indicator of the relative priority of this Job.
 	Priority float64
 
@@ -114,6 +119,10 @@ type Job struct {
 	// property should never change for a given Job instance.
 	Repo string
 
+	// RetryOf is the ID of the Job which this Job retries, if any. This
+	// property should never change for a given Job instance.
+	RetryOf string
+
 	// Revision is the commit at which this Job ran. This property should
 	// never change for a given Job instance.
 	Revision string
@@ -136,8 +145,10 @@ func (j *Job) Copy() *Job {
 		Finished:     j.Finished,
 		Id:           j.Id,
 		Name:         j.Name,
+		Patch:        j.Patch,
 		Priority:     j.Priority,
 		Repo:         j.Repo,
+		RetryOf:      j.RetryOf,
 		Revision:     j.Revision,
 		Status:       j.Status,
 	}
@@ -147,6 +158,17 @@ func (j *Job) Done() bool {
 	return j.Status != JOB_STATUS_IN_PROGRESS
 }
 
+// IsTryJob returns true iff the Job runs with a patch applied.
+func (j *Job) IsTryJob() bool {
+	return !j.Patch.Empty()
+}
+
+// Retryable returns true iff the Job has finished unsuccessfully and may
+// therefore be retried.
+func (j *Job) Retryable() bool {
+	return j.Done() && j.Status != JOB_STATUS_SUCCESS
+}
+
 // JobSlice implements sort.Interface. To sort jobs []*Job, use
 // sort.Sort(JobSlice(jobs)).
 type JobSlice []*Job
@@ -39,6 +39,22 @@ const (
 	//     big endian; v[9:] is the GOB of the Task.
 	BUCKET_TASKS_VERSION = 1
 
+	// BUCKET_JOBS is the name of the Jobs bucket. Key is Job.Id, which is set to
+	// (creation time, sequence number) (see formatId for detail), value is
+	// described in docs for BUCKET_JOBS_VERSION. Jobs will be updated in place.
+	// All repos share the same bucket.
+	BUCKET_JOBS = "jobs"
+	// BUCKET_JOBS_FILL_PERCENT is the value to set for bolt.Bucket.FillPercent
+	// for BUCKET_JOBS. BUCKET_JOBS will be append-mostly, so use a high fill
+	// percent.
+	BUCKET_JOBS_FILL_PERCENT = 0.9
+	// BUCKET_JOBS_VERSION indicates the format of the value of BUCKET_JOBS
+	// written by PutJobs. Retrieving Jobs from the DB must support all previous
+	// versions. For all versions, the first byte is the version number.
+	//   Version 1: v[0] = 1; v[1:9] is the modified time as UnixNano encoded as
+	//     big endian; v[9:] is the GOB of the Job.
+	BUCKET_JOBS_VERSION = 1
+
 	// BUCKET_COMMENTS is the name of the comments bucket. Key is KEY_COMMENT_MAP,
 	// value is the GOB of the map provided by db.CommentBox. The comment map will
 	// be updated in place. All repos share the same bucket.
@@ -46,14 +62,14 @@ const (
 	KEY_COMMENT_MAP = "comment-map"
 
 	// TIMESTAMP_FORMAT is a format string passed to Time.Format and time.Parse to
-	// format/parse the timestamp in the Task ID. It is similar to
-	// util.RFC3339NanoZeroPad, but since Task.Id can not contain colons, we omit
-	// most of the punctuation. This timestamp can only be used to format and
-	// parse times in UTC.
+	// format/parse the timestamp in the Task or Job ID. It is similar to
+	// util.RFC3339NanoZeroPad, but since Task.Id and Job.Id can not contain
+	// colons, we omit most of the punctuation. This timestamp can only be used
+	// to format and parse times in UTC.
 	TIMESTAMP_FORMAT = "20060102T150405.000000000Z"
 	// SEQUENCE_NUMBER_FORMAT is a format string passed to fmt.Sprintf or
-	// fmt.Sscanf to format/parse the sequence number in the Task ID. It is a
-	// 16-digit zero-padded lowercase hexidecimal number.
+	// fmt.Sscanf to format/parse the sequence number in the Task or Job ID. It
+	// is a 16-digit zero-padded lowercase hexidecimal number.
 	SEQUENCE_NUMBER_FORMAT = "%016x"
 
 	// MAX_CREATED_TIME_SKEW is the maximum difference between the timestamp in a
@@ -61,7 +77,7 @@ const (
 	// called before creating the Swarming task so that the Id can be included in
 	// the Swarming task tags. GetTasksFromDateRange accounts for this skew when
 	// retrieving tasks. This value can be increased in the future, but can never
-	// be decreased.
+	// be decreased. The same skew is allowed for Jobs.
 	//
 	// 6 minutes is based on httputils.DIAL_TIMEOUT + httputils.REQUEST_TIMEOUT,
 	// which is assumed to be the approximate maximum duration of a successful
@@ -69,7 +85,8 @@ const (
 	MAX_CREATED_TIME_SKEW = 6 * time.Minute
 )
 
-// formatId returns the timestamp and sequence number formatted for a Task ID.
+// formatId returns the timestamp and sequence number formatted for a Task or
+// Job ID.
 // Format is "<timestamp>_<sequence_num>", where the timestamp is formatted
 // using TIMESTAMP_FORMAT and sequence_num is formatted using
 // SEQUENCE_NUMBER_FORMAT.
@@ -78,7 +95,8 @@ func formatId(t time.Time, seq uint64) string {
 	return fmt.Sprintf("%s_"+SEQUENCE_NUMBER_FORMAT, t.Format(TIMESTAMP_FORMAT), seq)
 }
 
-// parseId returns the timestamp and sequence number stored in a Task ID.
+// parseId returns the timestamp and sequence number stored in a Task or Job
+// ID.
 func parseId(id string) (time.Time, uint64, error) {
 	parts := strings.Split(id, "_")
 	if len(parts) != 2 {
@@ -101,8 +119,9 @@ func parseId(id string) (time.Time, uint64, error) {
 	return t, seq, nil
 }
 
-// packV1 creates a value as described for BUCKET_TASKS_VERSION = 1. t is the
-// modified time and serialized is the GOB of the Task.
+// packV1 creates a value as described for BUCKET_TASKS_VERSION = 1 and
+// BUCKET_JOBS_VERSION = 1. t is the modified time and serialized is the GOB of
+// the Task or Job.
 func packV1(t time.Time, serialized []byte) []byte {
 	rv := make([]byte, len(serialized)+9)
 	rv[0] = 1
@@ -111,8 +130,9 @@ func packV1(t time.Time, serialized []byte) []byte {
 	return rv
 }
 
-// unpackV1 gets the modified time and GOB of the Task from a value as described
-// by BUCKET_TASKS_VERSION = 1. The returned GOB shares structure with value.
+// unpackV1 gets the modified time and GOB of the Task or Job from a value as
+// described by BUCKET_TASKS_VERSION = 1 and BUCKET_JOBS_VERSION = 1. The
+// returned GOB shares structure with value.
 func unpackV1(value []byte) (time.Time, []byte, error) {
 	if len(value) < 9 {
 		return time.Time{}, nil, fmt.Errorf("unpackV1 value is too short (%d bytes)", len(value))
@@ -124,7 +144,8 @@ func unpackV1(value []byte) (time.Time, []byte, error) {
 	return t, value[9:], nil
 }
 
-// localDB accesses a local BoltDB database containing tasks.
+// localDB accesses a local BoltDB database containing tasks, jobs, and
+// comments.
 type localDB struct {
 	// name is used in logging and metrics to identify this DB.
 	name string
@@ -143,6 +164,8 @@ type localDB struct {
 
 	modTasks db.ModifiedTasks
 
+	modJobs db.ModifiedJobs
+
 	// CommentBox is embedded in order to implement db.CommentDB. CommentBox uses
 	// this localDB to persist the comments.
 	*db.CommentBox
@@ -218,8 +241,15 @@ func tasksBucket(tx *bolt.Tx) *bolt.Bucket {
 	return b
 }
 
+// Returns the jobs bucket with FillPercent set.
+func jobsBucket(tx *bolt.Tx) *bolt.Bucket {
+	b := tx.Bucket([]byte(BUCKET_JOBS))
+	b.FillPercent = BUCKET_JOBS_FILL_PERCENT
+	return b
+}
+
 // NewDB returns a local DB instance.
-func NewDB(name, filename string) (db.TaskAndCommentDB, error) {
+func NewDB(name, filename string) (db.DB, error) {
 	boltdb, err := bolt.Open(filename, 0600, nil)
 	if err != nil {
 		return nil, err
@@ -255,6 +285,9 @@ func NewDB(name, filename string) (db.TaskAndCommentDB, error) {
 		if _, err := tx.CreateBucketIfNotExists([]byte(BUCKET_TASKS)); err != nil {
 			return err
 		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(BUCKET_JOBS)); err != nil {
+			return err
+		}
 		commentsBucket, err := tx.CreateBucketIfNotExists([]byte(BUCKET_COMMENTS))
 		if err != nil {
 			return err
@@ -274,7 +307,7 @@ func NewDB(name, filename string) (db.TaskAndCommentDB, error) {
 
 	d.CommentBox = db.NewCommentBoxWithPersistence(comments, d.writeCommentsMap)
 
-	if dbMetric, err := boltutil.NewDbMetric(boltdb, []string{BUCKET_TASKS, BUCKET_COMMENTS}, map[string]string{"database": name}); err != nil {
+	if dbMetric, err := boltutil.NewDbMetric(boltdb, []string{BUCKET_TASKS, BUCKET_JOBS, BUCKET_COMMENTS}, map[string]string{"database": name}); err != nil {
 		return nil, err
 	} else {
 		d.dbMetric = dbMetric
@@ -534,6 +567,214 @@ func (d *localDB) StopTrackingModifiedTasks(id string) {
 	d.modTasks.StopTrackingModifiedTasks(id)
 }
 
+// Sets j.Id based on j.Created. tx must be an update transaction.
+func (d *localDB) assignJobId(tx *bolt.Tx, j *db.Job) error {
+	if j.Id != "" {
+		return fmt.Errorf("Job Id already assigned: %v", j.Id)
+	}
+	seq, err := jobsBucket(tx).NextSequence()
+	if err != nil {
+		return err
+	}
+	j.Id = formatId(j.Created, seq)
+	return nil
+}
+
+// See docs for JobDB interface.
+func (d *localDB) GetJobById(id string) (*db.Job, error) {
+	var rv *db.Job
+	if err := d.view("GetJobById", func(tx *bolt.Tx) error {
+		value := jobsBucket(tx).Get([]byte(id))
+		if value == nil {
+			return nil
+		}
+		// Only BUCKET_JOBS_VERSION = 1 is implemented right now.
+		_, serialized, err := unpackV1(value)
+		if err != nil {
+			return err
+		}
+		var j db.Job
+		if err := gob.NewDecoder(bytes.NewReader(serialized)).Decode(&j); err != nil {
+			return err
+		}
+		rv = &j
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if rv == nil {
+		// Return an error if id is invalid.
+		if _, _, err := parseId(id); err != nil {
+			return nil, err
+		}
+	}
+	return rv, nil
+}
+
+// See docs for JobDB interface.
+func (d *localDB) GetJobsFromDateRange(start, end time.Time) ([]*db.Job, error) {
+	min := []byte(start.Add(-MAX_CREATED_TIME_SKEW).UTC().Format(TIMESTAMP_FORMAT))
+	max := []byte(end.UTC().Format(TIMESTAMP_FORMAT))
+	decoder := db.JobDecoder{}
+	if err := d.view("GetJobsFromDateRange", func(tx *bolt.Tx) error {
+		c := jobsBucket(tx).Cursor()
+		for k, v := c.Seek(min); k != nil && bytes.Compare(k, max) <= 0; k, v = c.Next() {
+			// Only BUCKET_JOBS_VERSION = 1 is implemented right now.
+			_, serialized, err := unpackV1(v)
+			if err != nil {
+				return err
+			}
+			cpy := make([]byte, len(serialized))
+			copy(cpy, serialized)
+			if !decoder.Process(cpy) {
+				return nil
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	result, err := decoder.Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(db.JobSlice(result))
+	// The Jobs retrieved based on Id timestamp may include Jobs with Created
+	// time before/after the desired range.
+	startIdx := 0
+	for startIdx < len(result) && result[startIdx].Created.Before(start) {
+		startIdx++
+	}
+	endIdx := len(result)
+	for endIdx > 0 && !result[endIdx-1].Created.Before(end) {
+		endIdx--
+	}
+	return result[startIdx:endIdx], nil
+}
+
+// See documentation for JobDB interface.
+func (d *localDB) PutJob(j *db.Job) error {
+	return d.PutJobs([]*db.Job{j})
+}
+
+// validateJob returns an error if the job can not be inserted into the DB. Does
+// not modify j.
+func (d *localDB) validateJob(j *db.Job) error {
+	if util.TimeIsZero(j.Created) {
+		return fmt.Errorf("Created not set. Job %s created time is %s. %v", j.Id, j.Created, j)
+	}
+	if j.Id != "" {
+		idTs, _, err := parseId(j.Id)
+		if err != nil {
+			return err
+		}
+		if j.Created.Sub(idTs) > MAX_CREATED_TIME_SKEW {
+			return fmt.Errorf("Created too late. Job %s was assigned Id at %s which is %s before Created time %s, more than MAX_CREATED_TIME_SKEW = %s.", j.Id, idTs, j.Created.Sub(idTs), j.Created, MAX_CREATED_TIME_SKEW)
+		}
+		if j.Created.Before(idTs) {
+			return fmt.Errorf("Created too early. Job %s Created time was changed or set to %s after Id assigned at %s.", j.Id, j.Created, idTs)
+		}
+	}
+	return nil
+}
+
+// See documentation for JobDB interface.
+func (d *localDB) PutJobs(jobs []*db.Job) error {
+	// If there is an error during the transaction, we should leave the jobs
+	// unchanged. Save the old Ids and DbModified times since we set them below.
+	type savedData struct {
+		Id         string
+		DbModified time.Time
+	}
+	oldData := make([]savedData, 0, len(jobs))
+	// Validate and save current data.
+	for _, j := range jobs {
+		if err := d.validateJob(j); err != nil {
+			return err
+		}
+		oldData = append(oldData, savedData{
+			Id:         j.Id,
+			DbModified: j.DbModified,
+		})
+	}
+	revertChanges := func() {
+		for i, data := range oldData {
+			jobs[i].Id = data.Id
+			jobs[i].DbModified = data.DbModified
+		}
+	}
+	gobs := make(map[string][]byte, len(jobs))
+	err := d.update("PutJobs", func(tx *bolt.Tx) error {
+		bucket := jobsBucket(tx)
+		// Assign Ids and encode.
+		e := db.JobEncoder{}
+		now := time.Now().UTC()
+		for _, j := range jobs {
+			if j.Id == "" {
+				if err := d.assignJobId(tx, j); err != nil {
+					return err
+				}
+			} else {
+				if value := bucket.Get([]byte(j.Id)); value != nil {
+					modTs, serialized, err := unpackV1(value)
+					if err != nil {
+						return err
+					}
+					if !modTs.Equal(j.DbModified) {
+						var existing db.Job
+						if err := gob.NewDecoder(bytes.NewReader(serialized)).Decode(&existing); err != nil {
+							return err
+						}
+						glog.Warningf("Cached Job has been modified in the DB. Current:\n%#v\nCached:\n%#v", existing, j)
+						return db.ErrConcurrentUpdate
+					}
+				}
+			}
+			j.DbModified = now
+			e.Process(j)
+		}
+		// Insert/update.
+		for {
+			j, serialized, err := e.Next()
+			if err != nil {
+				return err
+			}
+			if j == nil {
+				break
+			}
+			gobs[j.Id] = serialized
+			// BUCKET_JOBS_VERSION = 1
+			value := packV1(now, serialized)
+			if err := bucket.Put([]byte(j.Id), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		revertChanges()
+		return err
+	} else {
+		d.modJobs.TrackModifiedJobsGOB(gobs)
+	}
+	return nil
+}
+
+// See docs for JobDB interface.
+func (d *localDB) GetModifiedJobs(id string) ([]*db.Job, error) {
+	return d.modJobs.GetModifiedJobs(id)
+}
+
+// See docs for JobDB interface.
+func (d *localDB) StartTrackingModifiedJobs() (string, error) {
+	return d.modJobs.StartTrackingModifiedJobs()
+}
+
+// See docs for JobDB interface.
+func (d *localDB) StopTrackingModifiedJobs(id string) {
+	d.modJobs.StopTrackingModifiedJobs(id)
+}
+
 // writeCommentsMap is passed to db.NewCommentBoxWithPersistence to persist
 // comments after every change. Updates the value stored in BUCKET_COMMENTS.
 func (d *localDB) writeCommentsMap(comments map[string]*db.RepoComments) error {
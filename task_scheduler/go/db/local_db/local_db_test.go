@@ -136,7 +136,7 @@ func TestPackUnpackV1(t *testing.T) {
 
 // Create a localDB for testing. Call defer util.RemoveAll() on the second
 // return value.
-func makeDB(t *testing.T, name string) (db.TaskAndCommentDB, string) {
+func makeDB(t *testing.T, name string) (db.DB, string) {
 	//testutils.SkipIfShort(t)
 	tmpdir, err := ioutil.TempDir("", name)
 	assert.NoError(t, err)
@@ -446,3 +446,49 @@ func TestLocalDBCommentDB(t *testing.T) {
 	defer util.RemoveAll(tmpdir)
 	db.TestCommentDB(t, d)
 }
+
+func TestLocalDBJobDB(t *testing.T) {
+	d, tmpdir := makeDB(t, "TestLocalDBJobDB")
+	defer util.RemoveAll(tmpdir)
+	db.TestJobDB(t, d)
+}
+
+func TestLocalDBJobDBTooManyUsers(t *testing.T) {
+	d, tmpdir := makeDB(t, "TestLocalDBJobDBTooManyUsers")
+	defer util.RemoveAll(tmpdir)
+	db.TestJobDBTooManyUsers(t, d)
+}
+
+func TestLocalDBJobDBConcurrentUpdate(t *testing.T) {
+	d, tmpdir := makeDB(t, "TestLocalDBJobDBConcurrentUpdate")
+	defer util.RemoveAll(tmpdir)
+	db.TestJobDBConcurrentUpdate(t, d)
+}
+
+func TestLocalDBUpdateJobsWithRetries(t *testing.T) {
+	d, tmpdir := makeDB(t, "TestLocalDBUpdateJobsWithRetries")
+	defer util.RemoveAll(tmpdir)
+	db.TestUpdateJobsWithRetries(t, d)
+}
+
+// Test that Jobs persist after the DB is closed and reopened.
+func TestLocalDBJobsPersist(t *testing.T) {
+	d, tmpdir := makeDB(t, "TestLocalDBJobsPersist")
+	defer util.RemoveAll(tmpdir)
+
+	j := &db.Job{
+		Created: time.Now(),
+		Name:    "Test-Job",
+		Repo:    "repo",
+	}
+	assert.NoError(t, d.PutJob(j))
+	assert.NoError(t, d.Close())
+
+	d, err := NewDB("TestLocalDBJobsPersist", filepath.Join(tmpdir, "task.db"))
+	assert.NoError(t, err)
+	defer testutils.AssertCloses(t, d)
+
+	jobCopy, err := d.GetJobById(j.Id)
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, j, jobCopy)
+}
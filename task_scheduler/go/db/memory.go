@@ -134,7 +134,7 @@ func (db *inMemoryJobDB) assignId(j *Job) error {
 	return nil
 }
 
-// See docs for JobDB interface.
+// Close implements io.Closer.
 func (db *inMemoryJobDB) Close() error {
 	return nil
 }
@@ -225,3 +225,26 @@ func NewInMemoryJobDB() JobDB {
 	}
 	return db
 }
+
+// inMemoryDB combines an inMemoryTaskDB and an inMemoryJobDB.
+type inMemoryDB struct {
+	*inMemoryTaskDB
+	*inMemoryJobDB
+}
+
+// See docs for DB interface.
+func (db *inMemoryDB) Close() error {
+	if err := db.inMemoryTaskDB.Close(); err != nil {
+		return err
+	}
+	return db.inMemoryJobDB.Close()
+}
+
+// NewInMemoryDB returns an extremely simple, inefficient, in-memory DB
+// implementation.
+func NewInMemoryDB() DB {
+	return &inMemoryDB{
+		inMemoryTaskDB: NewInMemoryTaskDB().(*inMemoryTaskDB),
+		inMemoryJobDB:  NewInMemoryJobDB().(*inMemoryJobDB),
+	}
+}
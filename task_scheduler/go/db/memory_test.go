@@ -33,3 +33,9 @@ func TestInMemoryJobDBConcurrentUpdate(t *testing.T) {
 func TestInMemoryJobDBUpdateJobsWithRetries(t *testing.T) {
 	TestUpdateJobsWithRetries(t, NewInMemoryJobDB())
 }
+
+func TestInMemoryDB(t *testing.T) {
+	TestTaskDB(t, NewInMemoryDB())
+	TestJobDB(t, NewInMemoryDB())
+	TestCommentDB(t, NewInMemoryDB())
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Patch identifies a patchset uploaded to a code review server, which is
+// applied on top of a commit in order to run try jobs. The zero value
+// indicates that no patch is applied.
+//
+// Patch is stored as part of Task and Job GOBs, so changes must maintain
+// backwards compatibility.
+type Patch struct {
+	// Server is the URL of the code review server, eg.
+	// https://codereview.chromium.org or
+	// https://skia-review.googlesource.com.
+	Server string
+
+	// Issue is the issue or change number on the code review server.
+	Issue string
+
+	// Patchset is the patchset number within the issue.
+	Patchset string
+}
+
+// Empty returns true iff no patch is specified.
+func (p Patch) Empty() bool {
+	return p == Patch{}
+}
+
+// Validate returns an error if the Patch is not fully specified.
+func (p Patch) Validate() error {
+	if p.Server == "" || p.Issue == "" || p.Patchset == "" {
+		return fmt.Errorf("Patch must have a server, issue, and patchset; got %+v", p)
+	}
+	if _, err := strconv.ParseInt(p.Issue, 10, 64); err != nil {
+		return fmt.Errorf("Invalid issue %q: %s", p.Issue, err)
+	}
+	if _, err := strconv.ParseInt(p.Patchset, 10, 64); err != nil {
+		return fmt.Errorf("Invalid patchset %q: %s", p.Patchset, err)
+	}
+	return nil
+}
+
+// String returns a human-readable representation of the Patch.
+func (p Patch) String() string {
+	return fmt.Sprintf("%s/%s#ps%s", p.Server, p.Issue, p.Patchset)
+}
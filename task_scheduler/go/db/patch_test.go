@@ -0,0 +1,29 @@
+package db
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestPatchValidate(t *testing.T) {
+	p := Patch{}
+	assert.True(t, p.Empty())
+	assert.Error(t, p.Validate())
+
+	p = Patch{
+		Server:   "https://codereview.chromium.org",
+		Issue:    "1234",
+		Patchset: "5",
+	}
+	assert.False(t, p.Empty())
+	assert.NoError(t, p.Validate())
+
+	p.Patchset = ""
+	assert.Error(t, p.Validate())
+	p.Patchset = "abc"
+	assert.Error(t, p.Validate())
+	p.Patchset = "5"
+	p.Issue = "12.34"
+	assert.Error(t, p.Validate())
+}
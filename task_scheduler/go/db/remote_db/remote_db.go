@@ -25,10 +25,22 @@ const (
 	// Server handles requests on these paths. See registerHandlers for detail.
 	MODIFIED_TASKS_PATH     = "modified-tasks"
 	TASKS_PATH              = "tasks"
+	MODIFIED_JOBS_PATH      = "modified-jobs"
+	JOBS_PATH               = "jobs"
 	COMMENTS_PATH           = "comments"
 	TASK_COMMENTS_PATH      = "comments/task-comments"
 	TASK_SPEC_COMMENTS_PATH = "comments/task-spec-comments"
 	COMMIT_COMMENTS_PATH    = "comments/commit-comments"
+	CHANGES_PATH            = "changes"
+
+	// STREAM_HEARTBEAT_PERIOD is the default interval at which
+	// GetChangesHandler sends an empty batch in streaming mode when there are
+	// no changes, so that the client can detect a broken connection.
+	STREAM_HEARTBEAT_PERIOD = 30 * time.Second
+	// STREAM_POLL_PERIOD is the maximum time GetChangesHandler waits for
+	// changes in streaming mode before checking whether the client has gone
+	// away.
+	STREAM_POLL_PERIOD = time.Second
 
 	// HTTP error codes used for defined DB errors. See reportDBError and
 	// interpretStatusCode for detail.
@@ -38,6 +50,7 @@ const (
 	ERR_NOT_FOUND_CODE         = http.StatusNotFound
 	ERR_TOO_MANY_USERS_CODE    = http.StatusTooManyRequests
 	ERR_UNKNOWN_ID_CODE        = http.StatusGone
+	ERR_NOT_IMPLEMENTED_CODE   = http.StatusNotImplemented
 )
 
 // server translates HTTP requests to method calls on d.
@@ -64,6 +77,10 @@ func (s *server) registerHandlers(r *mux.Router) {
 	r.HandleFunc("/"+MODIFIED_TASKS_PATH, s.DeleteModifiedTasksHandler).Methods(http.MethodDelete)
 	r.HandleFunc("/"+MODIFIED_TASKS_PATH, s.GetModifiedTasksHandler).Methods(http.MethodGet)
 	r.HandleFunc("/"+TASKS_PATH, s.GetTasksHandler).Methods(http.MethodGet)
+	r.HandleFunc("/"+MODIFIED_JOBS_PATH, s.PostModifiedJobsHandler).Methods(http.MethodPost)
+	r.HandleFunc("/"+MODIFIED_JOBS_PATH, s.DeleteModifiedJobsHandler).Methods(http.MethodDelete)
+	r.HandleFunc("/"+MODIFIED_JOBS_PATH, s.GetModifiedJobsHandler).Methods(http.MethodGet)
+	r.HandleFunc("/"+JOBS_PATH, s.GetJobsHandler).Methods(http.MethodGet)
 	r.HandleFunc("/"+COMMENTS_PATH, s.GetCommentsHandler).Methods(http.MethodGet)
 	r.HandleFunc("/"+TASK_COMMENTS_PATH, s.PostTaskCommentsHandler).Methods(http.MethodPost)
 	r.HandleFunc("/"+TASK_COMMENTS_PATH, s.DeleteTaskCommentsHandler).Methods(http.MethodDelete)
@@ -71,20 +88,44 @@ func (s *server) registerHandlers(r *mux.Router) {
 	r.HandleFunc("/"+TASK_SPEC_COMMENTS_PATH, s.DeleteTaskSpecCommentsHandler).Methods(http.MethodDelete)
 	r.HandleFunc("/"+COMMIT_COMMENTS_PATH, s.PostCommitCommentsHandler).Methods(http.MethodPost)
 	r.HandleFunc("/"+COMMIT_COMMENTS_PATH, s.DeleteCommitCommentsHandler).Methods(http.MethodDelete)
+	r.HandleFunc("/"+CHANGES_PATH, s.PostChangesHandler).Methods(http.MethodPost)
+	r.HandleFunc("/"+CHANGES_PATH, s.GetChangesHandler).Methods(http.MethodGet)
+}
+
+// Client is a db.RemoteDB which also provides the db.ChangeFeed of the DB
+// provided to NewServer. If that DB is not a db.ChangeFeed, the
+// db.ChangeFeed methods return an error.
+type Client interface {
+	db.RemoteDB
+	db.ChangeFeed
+
+	// StreamChanges calls fn with each batch of changes following cursor, as
+	// they occur, along with a cursor pointing to the last change in the
+	// batch. If there are no changes, fn is called with an empty batch every
+	// heartbeat. Returns when fn returns an error or the connection is lost.
+	// Returns db.ErrUnknownId if cursor is invalid.
+	StreamChanges(cursor string, heartbeat time.Duration, fn func([]*db.Change, string) error) error
 }
 
 // client translates db.RemoteDB method calls to HTTP requests.
 type client struct {
 	serverRoot string
 	client     *http.Client
+	// streamClient has no request timeout, for use with StreamChanges.
+	streamClient *http.Client
 }
 
-// NewClient returns a db.RemoteDB that connects to the server created by
+// NewClient returns a Client that connects to the server created by
 // NewServer. serverRoot should end with a slash.
-func NewClient(serverRoot string) (db.RemoteDB, error) {
+func NewClient(serverRoot string) (Client, error) {
 	return &client{
 		serverRoot: serverRoot,
 		client:     httputils.NewTimeoutClient(),
+		streamClient: &http.Client{
+			Transport: &http.Transport{
+				Dial: httputils.DialTimeout,
+			},
+		},
 	}, nil
 }
 
@@ -141,6 +182,8 @@ func interpretStatusCode(r *http.Response) error {
 		return db.ErrTooManyUsers
 	case ERR_UNKNOWN_ID_CODE:
 		return db.ErrUnknownId
+	case ERR_NOT_IMPLEMENTED_CODE:
+		return fmt.Errorf("Not implemented by server: %s", r.Status)
 	default:
 		return fmt.Errorf("Received status code %d: %s", r.StatusCode, r.Status)
 	}
@@ -410,6 +453,269 @@ func (c *client) GetTasksFromDateRange(from time.Time, to time.Time) ([]*db.Task
 	return processTaskList(r.Body)
 }
 
+// PostModifiedJobsHandler translates a POST request with empty body to
+// StartTrackingModifiedJobs.
+//   - format: must be "gob"; default "gob"
+// Response is GOB of string id.
+func (s *server) PostModifiedJobsHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "gob" {
+		httputils.ReportError(w, r, nil, fmt.Sprintf("Unsupported format %q", format))
+		return
+	}
+	id, err := s.d.StartTrackingModifiedJobs()
+	if err != nil {
+		reportDBError(w, r, err, "Unable to start tracking jobs")
+		return
+	}
+	w.Header().Set("Content-Type", "application/gob")
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(id); err != nil {
+		s.d.StopTrackingModifiedJobs(id)
+		httputils.ReportError(w, r, err, "Unable to encode start id")
+		return
+	}
+}
+
+// See documentation for db.JobReader.
+func (c *client) StartTrackingModifiedJobs() (string, error) {
+	req, err := http.NewRequest(http.MethodPost, c.serverRoot+MODIFIED_JOBS_PATH+"?format=gob", nil)
+	if err != nil {
+		return "", err
+	}
+	r, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer r.Body.Close()
+	if err := interpretStatusCode(r); err != nil {
+		return "", err
+	}
+	dec := gob.NewDecoder(r.Body)
+	var id string
+	if err := dec.Decode(&id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// DeleteModifiedJobsHandler translates a DELETE request with empty body to
+// StopTrackingModifiedJobs.
+//   - id: id returned from PostModifiedJobsHandler
+// No response body.
+func (s *server) DeleteModifiedJobsHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		httputils.ReportError(w, r, nil, "Missing id param")
+		return
+	}
+	s.d.StopTrackingModifiedJobs(id)
+	w.WriteHeader(http.StatusOK)
+}
+
+// See documentation for db.JobReader.
+func (c *client) StopTrackingModifiedJobs(id string) {
+	params := url.Values{}
+	params.Set("id", id)
+	req, err := http.NewRequest(http.MethodDelete, c.serverRoot+MODIFIED_JOBS_PATH+"?"+params.Encode(), nil)
+	if err != nil {
+		glog.Error(err)
+		return
+	}
+	r, err := c.client.Do(req)
+	if err != nil {
+		glog.Error(err)
+		return
+	}
+	defer r.Body.Close()
+	if err := interpretStatusCode(r); err != nil {
+		glog.Error(err)
+		return
+	}
+}
+
+// GetModifiedJobsHandler translates a GET request to GetModifiedJobs.
+//   - format: must be "gob"; default "gob"
+//   - id: id returned from PostModifiedJobsHandler
+// Response is GOB stream; first object is the number of jobs, the remaining
+// objects are db.Jobs.
+// Warning: not RESTful: the same URI will return different results each time.
+func (s *server) GetModifiedJobsHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "gob" {
+		httputils.ReportError(w, r, nil, fmt.Sprintf("Unsupported format %q", format))
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		httputils.ReportError(w, r, nil, "Missing id param")
+		return
+	}
+	jobs, err := s.d.GetModifiedJobs(id)
+	if err != nil {
+		reportDBError(w, r, err, "Unable to retrieve jobs")
+		return
+	}
+	w.Header().Set("Content-Type", "application/gob")
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(len(jobs)); err != nil {
+		s.d.StopTrackingModifiedJobs(id)
+		httputils.ReportError(w, r, err, "Unable to encode job count")
+		return
+	}
+	for _, j := range jobs {
+		if err := enc.Encode(j); err != nil {
+			s.d.StopTrackingModifiedJobs(id)
+			httputils.ReportError(w, r, err, "Unable to encode job")
+			return
+		}
+		flush(w)
+	}
+}
+
+// processJobList decodes a list of db.Job from r. r must be a GOB stream
+// where the first object is the count of jobs and the remaining objects are
+// db.Jobs.
+func processJobList(r io.Reader) ([]*db.Job, error) {
+	dec := gob.NewDecoder(r)
+	var count int
+	if err := dec.Decode(&count); err != nil {
+		return nil, err
+	}
+	rv := make([]*db.Job, count)
+	for i, _ := range rv {
+		var j db.Job
+		if err := dec.Decode(&j); err != nil {
+			return nil, err
+		}
+		rv[i] = &j
+	}
+	return rv, nil
+}
+
+// See documentation for db.JobReader.
+func (c *client) GetModifiedJobs(id string) ([]*db.Job, error) {
+	params := url.Values{}
+	params.Set("format", "gob")
+	params.Set("id", id)
+	r, err := c.client.Get(c.serverRoot + MODIFIED_JOBS_PATH + "?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	if err := interpretStatusCode(r); err != nil {
+		return nil, err
+	}
+	return processJobList(r.Body)
+}
+
+// GetJobsHandler translates a GET request to GetJobsFromDateRange or
+// GetJobById.
+//   - format: must be "gob"; default "gob"
+//   - id: Job.Id; may not be repeated
+//   - from, to: nanoseconds since the Unix epoch. (base-10 string)
+// Response is GOB stream; first object is the number of jobs, the remaining
+// objects are db.Jobs.
+func (s *server) GetJobsHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "gob" {
+		httputils.ReportError(w, r, nil, fmt.Sprintf("Unsupported format %q", format))
+		return
+	}
+	id := r.URL.Query().Get("id")
+	fromStr := r.URL.Query().Get("from")
+	toStr := r.URL.Query().Get("to")
+	if id == "" && (fromStr == "" || toStr == "") {
+		httputils.ReportError(w, r, nil, "Only lookup by id or date range is implemented. Missing id/from/to params")
+		return
+	}
+	var jobs []*db.Job
+	if id != "" {
+		job, err := s.d.GetJobById(id)
+		if err != nil {
+			reportDBError(w, r, err, "Unable to retrieve job")
+			return
+		}
+		if job == nil {
+			jobs = []*db.Job{}
+		} else {
+			jobs = []*db.Job{job}
+		}
+	} else {
+		fromInt, err := strconv.ParseInt(fromStr, 10, 64)
+		if err != nil {
+			httputils.ReportError(w, r, err, fmt.Sprintf("Invalid from param %q", fromStr))
+			return
+		}
+		toInt, err := strconv.ParseInt(toStr, 10, 64)
+		if err != nil {
+			httputils.ReportError(w, r, err, fmt.Sprintf("Invalid to param %q", toStr))
+			return
+		}
+		jobs, err = s.d.GetJobsFromDateRange(time.Unix(0, fromInt), time.Unix(0, toInt))
+		if err != nil {
+			reportDBError(w, r, err, "Unable to retrieve jobs")
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/gob")
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(len(jobs)); err != nil {
+		httputils.ReportError(w, r, err, "Unable to encode job count")
+		return
+	}
+	for _, j := range jobs {
+		if err := enc.Encode(j); err != nil {
+			httputils.ReportError(w, r, err, "Unable to encode job")
+			return
+		}
+		flush(w)
+	}
+}
+
+// See documentation for db.JobReader.
+func (c *client) GetJobById(id string) (*db.Job, error) {
+	params := url.Values{}
+	params.Set("format", "gob")
+	params.Set("id", id)
+	r, err := c.client.Get(c.serverRoot + JOBS_PATH + "?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	if err := interpretStatusCode(r); err != nil {
+		return nil, err
+	}
+	jobs, err := processJobList(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+	if len(jobs) > 1 {
+		return nil, fmt.Errorf("Unexpected multiple jobs for id query. %q %v", id, jobs)
+	}
+	return jobs[0], nil
+}
+
+// See documentation for db.JobReader.
+func (c *client) GetJobsFromDateRange(from time.Time, to time.Time) ([]*db.Job, error) {
+	params := url.Values{}
+	params.Set("format", "gob")
+	params.Set("from", strconv.FormatInt(from.UnixNano(), 10))
+	params.Set("to", strconv.FormatInt(to.UnixNano(), 10))
+	r, err := c.client.Get(c.serverRoot + JOBS_PATH + "?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	if err := interpretStatusCode(r); err != nil {
+		return nil, err
+	}
+	return processJobList(r.Body)
+}
+
 // GetCommentsHandler translates a GET request to GetCommentsForRepos
 //   - format: must be "gob"; default "gob"
 //   - repo: repo for which to return comments (may be repeated)
@@ -693,5 +999,224 @@ func (c *client) DeleteCommitComment(cc *db.CommitComment) error {
 	return c.doCommentRequest(http.MethodDelete, COMMIT_COMMENTS_PATH, &buf)
 }
 
-// Compile-time assert that client is a db.RemoteDB.
-var _ db.RemoteDB = &client{}
+// changeBatch is the unit of the GOB stream returned by GetChangesHandler.
+type changeBatch struct {
+	// Cursor points to the last change in Changes.
+	Cursor  string
+	Changes []*db.Change
+}
+
+// changeFeed returns the db.ChangeFeed of the DB provided to NewServer, or
+// writes an error to w and returns nil if it is not a db.ChangeFeed.
+func (s *server) changeFeed(w http.ResponseWriter) db.ChangeFeed {
+	feed, ok := s.d.(db.ChangeFeed)
+	if !ok {
+		http.Error(w, "DB does not provide a change feed", ERR_NOT_IMPLEMENTED_CODE)
+		return nil
+	}
+	return feed
+}
+
+// PostChangesHandler translates a POST request with empty body to
+// CurrentCursor.
+//   - format: must be "gob"; default "gob"
+// Response is GOB of string cursor.
+func (s *server) PostChangesHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "gob" {
+		httputils.ReportError(w, r, nil, fmt.Sprintf("Unsupported format %q", format))
+		return
+	}
+	feed := s.changeFeed(w)
+	if feed == nil {
+		return
+	}
+	cursor, err := feed.CurrentCursor()
+	if err != nil {
+		reportDBError(w, r, err, "Unable to retrieve cursor")
+		return
+	}
+	w.Header().Set("Content-Type", "application/gob")
+	if err := gob.NewEncoder(w).Encode(cursor); err != nil {
+		httputils.ReportError(w, r, err, "Unable to encode cursor")
+		return
+	}
+}
+
+// See documentation for db.ChangeFeed.
+func (c *client) CurrentCursor() (string, error) {
+	req, err := http.NewRequest(http.MethodPost, c.serverRoot+CHANGES_PATH+"?format=gob", nil)
+	if err != nil {
+		return "", err
+	}
+	r, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer r.Body.Close()
+	if err := interpretStatusCode(r); err != nil {
+		return "", err
+	}
+	var cursor string
+	if err := gob.NewDecoder(r.Body).Decode(&cursor); err != nil {
+		return "", err
+	}
+	return cursor, nil
+}
+
+// GetChangesHandler translates a GET request to GetChanges.
+//   - format: must be "gob"; default "gob"
+//   - cursor: cursor returned from PostChangesHandler or a previous request
+//   - timeout (optional): nanoseconds to wait for changes. (base-10 string)
+//     Must be positive if stream is "true".
+//   - stream (optional): if "true", the first batch is written immediately,
+//     and the response is not completed after the first batch; instead, each
+//     subsequent batch of changes is written as it occurs, and an empty batch
+//     is written after each timeout (default STREAM_HEARTBEAT_PERIOD) without
+//     changes.
+// Response is GOB stream of changeBatch; there is exactly one batch unless
+// stream is "true".
+// Warning: the response may be blocked by middleware which buffers the
+// response; in streaming mode, the server should not be wrapped in such
+// middleware.
+func (s *server) GetChangesHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "gob" {
+		httputils.ReportError(w, r, nil, fmt.Sprintf("Unsupported format %q", format))
+		return
+	}
+	cursor := r.URL.Query().Get("cursor")
+	if cursor == "" {
+		httputils.ReportError(w, r, nil, "Missing cursor param")
+		return
+	}
+	stream := r.URL.Query().Get("stream") == "true"
+	timeout := time.Duration(0)
+	if stream {
+		timeout = STREAM_HEARTBEAT_PERIOD
+	}
+	if timeoutStr := r.URL.Query().Get("timeout"); timeoutStr != "" {
+		timeoutInt, err := strconv.ParseInt(timeoutStr, 10, 64)
+		if err != nil {
+			httputils.ReportError(w, r, err, fmt.Sprintf("Invalid timeout param %q", timeoutStr))
+			return
+		}
+		timeout = time.Duration(timeoutInt)
+	}
+	// A non-positive timeout would make the stream send empty batches
+	// without pause.
+	if stream && timeout <= 0 {
+		httputils.ReportError(w, r, nil, fmt.Sprintf("Invalid timeout %d; must be positive when streaming", timeout))
+		return
+	}
+	feed := s.changeFeed(w)
+	if feed == nil {
+		return
+	}
+	// In streaming mode, send the first batch immediately so that the client
+	// knows that the stream has started.
+	firstTimeout := timeout
+	if stream {
+		firstTimeout = 0
+	}
+	changes, cursor, err := feed.GetChanges(cursor, firstTimeout)
+	if err != nil {
+		reportDBError(w, r, err, "Unable to retrieve changes")
+		return
+	}
+	w.Header().Set("Content-Type", "application/gob")
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(&changeBatch{Cursor: cursor, Changes: changes}); err != nil {
+		httputils.ReportError(w, r, err, "Unable to encode changes")
+		return
+	}
+	flush(w)
+	if !stream {
+		return
+	}
+	var closed <-chan bool
+	if cn, ok := w.(http.CloseNotifier); ok {
+		closed = cn.CloseNotify()
+	}
+	poll := STREAM_POLL_PERIOD
+	if timeout < poll {
+		poll = timeout
+	}
+	lastSent := time.Now()
+	for {
+		select {
+		case <-closed:
+			return
+		default:
+		}
+		changes, newCursor, err := feed.GetChanges(cursor, poll)
+		if err != nil {
+			// We can't change the status code at this point. Ending the
+			// response causes the client to retry with its latest cursor,
+			// which will result in the appropriate error.
+			glog.Errorf("Unable to retrieve changes; ending stream: %s", err)
+			return
+		}
+		if len(changes) == 0 && time.Now().Sub(lastSent) < timeout {
+			continue
+		}
+		cursor = newCursor
+		if err := enc.Encode(&changeBatch{Cursor: cursor, Changes: changes}); err != nil {
+			glog.Infof("Unable to encode changes; ending stream: %s", err)
+			return
+		}
+		flush(w)
+		lastSent = time.Now()
+	}
+}
+
+// See documentation for db.ChangeFeed.
+func (c *client) GetChanges(cursor string, timeout time.Duration) ([]*db.Change, string, error) {
+	params := url.Values{}
+	params.Set("format", "gob")
+	params.Set("cursor", cursor)
+	params.Set("timeout", strconv.FormatInt(int64(timeout), 10))
+	r, err := c.client.Get(c.serverRoot + CHANGES_PATH + "?" + params.Encode())
+	if err != nil {
+		return nil, "", err
+	}
+	defer r.Body.Close()
+	if err := interpretStatusCode(r); err != nil {
+		return nil, "", err
+	}
+	var batch changeBatch
+	if err := gob.NewDecoder(r.Body).Decode(&batch); err != nil {
+		return nil, "", err
+	}
+	return batch.Changes, batch.Cursor, nil
+}
+
+// See documentation for Client.
+func (c *client) StreamChanges(cursor string, heartbeat time.Duration, fn func([]*db.Change, string) error) error {
+	params := url.Values{}
+	params.Set("format", "gob")
+	params.Set("cursor", cursor)
+	params.Set("stream", "true")
+	params.Set("timeout", strconv.FormatInt(int64(heartbeat), 10))
+	r, err := c.streamClient.Get(c.serverRoot + CHANGES_PATH + "?" + params.Encode())
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if err := interpretStatusCode(r); err != nil {
+		return err
+	}
+	dec := gob.NewDecoder(r.Body)
+	for {
+		var batch changeBatch
+		if err := dec.Decode(&batch); err != nil {
+			return err
+		}
+		if err := fn(batch.Changes, batch.Cursor); err != nil {
+			return err
+		}
+	}
+}
+
+// Compile-time assert that client is a Client.
+var _ Client = &client{}
@@ -1,17 +1,20 @@
 package remote_db
 
 import (
+	"fmt"
 	"io"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
+	"go.skia.org/infra/go/testutils"
 	"go.skia.org/infra/task_scheduler/go/db"
 )
 
 // clientWithBackdoor allows us to test the client/server pair as a
-// db.TaskAndCommentDB, using the generic DB test utils. All method calls
+// db.DB, using the generic DB test utils. All method calls
 // supported by RemoteDB use the client/server implementation; other methods
 // have "backdoor" access to the underlying DB to allow the tests to modify the
 // DB.
@@ -19,7 +22,7 @@ type clientWithBackdoor struct {
 	// *client; implements the methods being tested.
 	db.RemoteDB
 	// The DB passed to NewServer.
-	backdoor db.TaskDB
+	backdoor db.DB
 	// *server; keep a reference so that we can call Close().
 	dbserver io.Closer
 	// The test HTTP server listening on the loopback address.
@@ -49,10 +52,16 @@ func (b *clientWithBackdoor) PutTask(t *db.Task) error {
 func (b *clientWithBackdoor) PutTasks(t []*db.Task) error {
 	return b.backdoor.PutTasks(t)
 }
+func (b *clientWithBackdoor) PutJob(j *db.Job) error {
+	return b.backdoor.PutJob(j)
+}
+func (b *clientWithBackdoor) PutJobs(j []*db.Job) error {
+	return b.backdoor.PutJobs(j)
+}
 
 // makeDB sets up a client/server pair wrapped in a clientWithBackdoor.
-func makeDB(t *testing.T) db.TaskAndCommentDB {
-	baseDB := db.NewInMemoryTaskDB()
+func makeDB(t *testing.T) db.DB {
+	baseDB := db.NewInMemoryDB()
 	r := mux.NewRouter()
 	dbserver, err := NewServer(baseDB, r.PathPrefix("/db").Subrouter())
 	assert.NoError(t, err)
@@ -91,3 +100,135 @@ func TestRemoteDBCommentDB(t *testing.T) {
 	d := makeDB(t)
 	db.TestCommentDB(t, d)
 }
+
+func TestRemoteDBJobDB(t *testing.T) {
+	d := makeDB(t)
+	db.TestJobDB(t, d)
+}
+
+func TestRemoteDBJobDBTooManyUsers(t *testing.T) {
+	d := makeDB(t)
+	db.TestJobDBTooManyUsers(t, d)
+}
+
+func TestRemoteDBJobDBConcurrentUpdate(t *testing.T) {
+	d := makeDB(t)
+	db.TestJobDBConcurrentUpdate(t, d)
+}
+
+func TestRemoteDBUpdateJobsWithRetries(t *testing.T) {
+	d := makeDB(t)
+	db.TestUpdateJobsWithRetries(t, d)
+}
+
+// makeChangeFeedDB sets up a client/server pair where the server's DB is a
+// db.ChangeFeedDB. Returns the client, the server's DB, and a function to
+// clean up.
+func makeChangeFeedDB(t *testing.T) (Client, db.ChangeFeedDB, func()) {
+	baseDB := db.NewChangeFeedDB(db.NewInMemoryDB())
+	r := mux.NewRouter()
+	dbserver, err := NewServer(baseDB, r.PathPrefix("/db").Subrouter())
+	assert.NoError(t, err)
+	ts := httptest.NewServer(r)
+	dbclient, err := NewClient(ts.URL + "/db/")
+	assert.NoError(t, err)
+	return dbclient, baseDB, func() {
+		testutils.AssertCloses(t, dbclient)
+		ts.Close()
+		testutils.AssertCloses(t, dbserver)
+		testutils.AssertCloses(t, baseDB)
+	}
+}
+
+func TestRemoteDBChangeFeed(t *testing.T) {
+	c, d, cleanup := makeChangeFeedDB(t)
+	defer cleanup()
+
+	start, err := c.CurrentCursor()
+	assert.NoError(t, err)
+
+	// Make changes via the server's DB and via the client.
+	t1 := &db.Task{Created: time.Now(), Name: "task", Repo: "repo"}
+	assert.NoError(t, d.PutTask(t1))
+	j1 := &db.Job{Created: time.Now(), Name: "job", Repo: "repo"}
+	assert.NoError(t, d.PutJob(j1))
+	assert.NoError(t, c.PutCommitComment(&db.CommitComment{
+		Repo:      "repo",
+		Revision:  "abc",
+		Timestamp: time.Now(),
+		User:      "me@example.com",
+	}))
+
+	changes, cursor, err := c.GetChanges(start, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(changes))
+	assert.Equal(t, t1.Id, changes[0].Task.Id)
+	assert.Equal(t, j1.Id, changes[1].Job.Id)
+	assert.Equal(t, 1, len(changes[2].Comments.CommitComments["abc"]))
+
+	// Invalid cursor.
+	_, _, err = c.GetChanges("bogus", 0)
+	assert.True(t, db.IsUnknownId(err))
+	assert.True(t, db.IsUnknownId(c.StreamChanges("bogus", time.Second, func([]*db.Change, string) error {
+		return nil
+	})))
+
+	// Streaming requires a positive heartbeat period.
+	for _, heartbeat := range []time.Duration{0, -time.Second} {
+		assert.Error(t, c.StreamChanges(cursor, heartbeat, func([]*db.Change, string) error {
+			assert.Fail(t, "Unexpected batch.")
+			return nil
+		}))
+	}
+
+	// Stream changes. The first batch is empty, since nothing has changed
+	// since cursor.
+	stop := fmt.Errorf("stop")
+	batches := [][]*db.Change{}
+	err = c.StreamChanges(cursor, time.Minute, func(changes []*db.Change, newCursor string) error {
+		batches = append(batches, changes)
+		cursor = newCursor
+		if len(changes) > 0 {
+			return stop
+		}
+		// Make a change once the stream has started.
+		go func() {
+			t1.Status = db.TASK_STATUS_SUCCESS
+			assert.NoError(t, d.PutTask(t1))
+		}()
+		return nil
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 2, len(batches))
+	assert.Equal(t, 0, len(batches[0]))
+	assert.Equal(t, 1, len(batches[1]))
+	assert.Equal(t, db.TASK_STATUS_SUCCESS, batches[1][0].Task.Status)
+
+	// A TaskCache using the client consumes the change feed.
+	cache, err := db.NewTaskCache(c, time.Hour)
+	assert.NoError(t, err)
+	t2 := &db.Task{Created: time.Now(), Name: "task2", Repo: "repo", Commits: []string{"abc"}}
+	assert.NoError(t, d.PutTask(t2))
+	assert.NoError(t, cache.Update())
+	found, err := cache.GetTaskForCommit("repo", "abc", "task2")
+	assert.NoError(t, err)
+	assert.Equal(t, t2.Id, found.Id)
+}
+
+func TestRemoteDBNoChangeFeed(t *testing.T) {
+	d := makeDB(t)
+	defer testutils.AssertCloses(t, d)
+	c := d.(*clientWithBackdoor).RemoteDB.(Client)
+	_, err := c.CurrentCursor()
+	assert.Error(t, err)
+
+	// A TaskCache falls back to tracking modified tasks.
+	cache, err := db.NewTaskCache(c, time.Hour)
+	assert.NoError(t, err)
+	t1 := &db.Task{Created: time.Now(), Name: "task", Repo: "repo", Commits: []string{"abc"}}
+	assert.NoError(t, d.PutTask(t1))
+	assert.NoError(t, cache.Update())
+	found, err := cache.GetTaskForCommit("repo", "abc", "task")
+	assert.NoError(t, err)
+	assert.Equal(t, t1.Id, found.Id)
+}
@@ -0,0 +1,490 @@
+package sql_db
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/skia-dev/glog"
+	"go.skia.org/infra/go/database"
+	"go.skia.org/infra/go/metrics2"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/task_scheduler/go/db"
+)
+
+const (
+	// Default database parameters.
+	PROD_DB_HOST = "localhost"
+	PROD_DB_PORT = 3306
+	PROD_DB_NAME = "task_scheduler"
+
+	// TABLE_TASKS and TABLE_JOBS are the names of the tables which store Tasks
+	// and Jobs. Each row contains the Id, the Created time as UnixNano, the
+	// DbModified time as UnixNano, and the GOB of the Task or Job.
+	TABLE_TASKS = "tasks"
+	TABLE_JOBS  = "jobs"
+
+	// KEY_COMMENT_MAP is the key in the comments table whose value is the GOB
+	// of the map provided by db.CommentBox.
+	KEY_COMMENT_MAP = "comment-map"
+
+	// TIMESTAMP_FORMAT and SEQUENCE_NUMBER_FORMAT are used to format Task and
+	// Job IDs. They match the format used by local_db, so that IDs look the same
+	// regardless of the backend. The timestamp is informational only; unlike
+	// local_db, lookups by date range use the created column.
+	TIMESTAMP_FORMAT       = "20060102T150405.000000000Z"
+	SEQUENCE_NUMBER_FORMAT = "%016x"
+)
+
+// MigrationSteps returns the migration (up and down) for the database.
+func MigrationSteps() []database.MigrationStep {
+	return migrationSteps
+}
+
+// migrationSteps define the steps it takes to migrate the db between versions.
+// Note: Only add to this list, once a step has landed in version control it
+// must not be changed.
+var migrationSteps = []database.MigrationStep{
+	// version 1
+	{
+		MySQLUp: []string{
+			`CREATE TABLE tasks (
+				id        VARCHAR(64)  NOT NULL PRIMARY KEY,
+				created   BIGINT       NOT NULL,
+				modified  BIGINT       NOT NULL,
+				gob       MEDIUMBLOB   NOT NULL,
+				INDEX created_idx(created)
+			)`,
+			`CREATE TABLE jobs (
+				id        VARCHAR(64)  NOT NULL PRIMARY KEY,
+				created   BIGINT       NOT NULL,
+				modified  BIGINT       NOT NULL,
+				gob       MEDIUMBLOB   NOT NULL,
+				INDEX created_idx(created)
+			)`,
+			`CREATE TABLE comments (
+				id        VARCHAR(64)  NOT NULL PRIMARY KEY,
+				gob       MEDIUMBLOB   NOT NULL
+			)`,
+			`CREATE TABLE id_sequences (
+				name      VARCHAR(64)  NOT NULL PRIMARY KEY,
+				seq       BIGINT       NOT NULL
+			)`,
+			`INSERT INTO id_sequences (name, seq) VALUES ('tasks', 0), ('jobs', 0)`,
+		},
+		MySQLDown: []string{
+			`DROP TABLE IF EXISTS tasks`,
+			`DROP TABLE IF EXISTS jobs`,
+			`DROP TABLE IF EXISTS comments`,
+			`DROP TABLE IF EXISTS id_sequences`,
+		},
+	},
+}
+
+// formatId returns the timestamp and sequence number formatted for a Task or
+// Job ID.
+func formatId(t time.Time, seq uint64) string {
+	t = t.UTC()
+	return fmt.Sprintf("%s_"+SEQUENCE_NUMBER_FORMAT, t.Format(TIMESTAMP_FORMAT), seq)
+}
+
+// sqlDB stores tasks, jobs, and comments in a SQL database.
+type sqlDB struct {
+	// name is used in metrics to identify this DB.
+	name string
+
+	// vdb is the underlying database.
+	vdb *database.VersionedDB
+
+	modTasks db.ModifiedTasks
+
+	modJobs db.ModifiedJobs
+
+	// CommentBox is embedded in order to implement db.CommentDB. CommentBox uses
+	// this sqlDB to persist the comments.
+	*db.CommentBox
+}
+
+// NewDB returns a db.DB backed by the given SQL database, which must already
+// be migrated to the latest version of MigrationSteps().
+func NewDB(name string, vdb *database.VersionedDB) (db.DB, error) {
+	if !vdb.IsLatestVersion() {
+		return nil, fmt.Errorf("Wrong DB version. Please update to the latest version.")
+	}
+	d := &sqlDB{
+		name: name,
+		vdb:  vdb,
+	}
+	comments := map[string]*db.RepoComments{}
+	var serializedCommentMap []byte
+	err := vdb.DB.QueryRow("SELECT gob FROM comments WHERE id = ?", KEY_COMMENT_MAP).Scan(&serializedCommentMap)
+	if err == nil {
+		if err := gob.NewDecoder(bytes.NewReader(serializedCommentMap)).Decode(&comments); err != nil {
+			return nil, err
+		}
+	} else if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("Failed to read comments: %s", err)
+	}
+	d.CommentBox = db.NewCommentBoxWithPersistence(comments, d.writeCommentsMap)
+	return d, nil
+}
+
+// tx runs fn inside a SQL transaction, which is committed if fn returns nil
+// and rolled back otherwise. The error returned by fn is passed through
+// unchanged so that callers can check for db.ErrConcurrentUpdate.
+func (d *sqlDB) tx(name string, fn func(*sql.Tx) error) error {
+	defer metrics2.NewTimer("db-tx-duration", map[string]string{
+		"database":    d.name,
+		"transaction": name,
+	}).Stop()
+	tx, err := d.vdb.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("Unable to start transaction: %s", err)
+	}
+	if err := fn(tx); err != nil {
+		if err2 := tx.Rollback(); err2 != nil {
+			glog.Errorf("Failed to roll back transaction %s: %s", name, err2)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// See docs for DB interface.
+func (d *sqlDB) Close() error {
+	return d.vdb.Close()
+}
+
+// nextId increments the named sequence and returns an ID based on ts and the
+// new sequence number.
+func nextId(tx *sql.Tx, sequence string, ts time.Time) (string, error) {
+	res, err := tx.Exec("UPDATE id_sequences SET seq = LAST_INSERT_ID(seq + 1) WHERE name = ?", sequence)
+	if err != nil {
+		return "", fmt.Errorf("Failed to increment sequence %q: %s", sequence, err)
+	}
+	seq, err := res.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	return formatId(ts, uint64(seq)), nil
+}
+
+// checkModified locks the row for id in the given table and returns
+// db.ErrConcurrentUpdate if it exists and its modified time differs from the
+// given time. The row is locked until the end of the transaction.
+func checkModified(tx *sql.Tx, table, id string, modified time.Time) error {
+	var ts int64
+	err := tx.QueryRow(fmt.Sprintf("SELECT modified FROM %s WHERE id = ? FOR UPDATE", table), id).Scan(&ts)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if ts != modified.UnixNano() {
+		glog.Warningf("Cached entry %s in %s has been modified in the DB; expected modified time %d but got %d.", id, table, modified.UnixNano(), ts)
+		return db.ErrConcurrentUpdate
+	}
+	return nil
+}
+
+// putGOB inserts or updates the row for id in the given table.
+func putGOB(tx *sql.Tx, table, id string, created, modified time.Time, serialized []byte) error {
+	stmt := fmt.Sprintf("INSERT INTO %s (id, created, modified, gob) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE created = VALUES(created), modified = VALUES(modified), gob = VALUES(gob)", table)
+	if _, err := tx.Exec(stmt, id, created.UnixNano(), modified.UnixNano(), serialized); err != nil {
+		return fmt.Errorf("Failed to write %s to %s: %s", id, table, err)
+	}
+	return nil
+}
+
+// getGOB returns the GOB stored for id in the given table, or nil if there is
+// no such row.
+func (d *sqlDB) getGOB(table, id string) ([]byte, error) {
+	var serialized []byte
+	err := d.vdb.DB.QueryRow(fmt.Sprintf("SELECT gob FROM %s WHERE id = ?", table), id).Scan(&serialized)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to read %s from %s: %s", id, table, err)
+	}
+	return serialized, nil
+}
+
+// getGOBsFromDateRange calls process for the GOB of each row in the given
+// table whose created time is within [start, end), stopping early if process
+// returns false.
+func (d *sqlDB) getGOBsFromDateRange(table string, start, end time.Time, process func([]byte) bool) error {
+	rows, err := d.vdb.DB.Query(fmt.Sprintf("SELECT gob FROM %s WHERE created >= ? AND created < ?", table), start.UnixNano(), end.UnixNano())
+	if err != nil {
+		return fmt.Errorf("Failed to query %s: %s", table, err)
+	}
+	defer util.Close(rows)
+	for rows.Next() {
+		var serialized []byte
+		if err := rows.Scan(&serialized); err != nil {
+			return err
+		}
+		if !process(serialized) {
+			return nil
+		}
+	}
+	return rows.Err()
+}
+
+// See docs for DB interface.
+func (d *sqlDB) AssignId(t *db.Task) error {
+	if t.Id != "" {
+		return fmt.Errorf("Task Id already assigned: %v", t.Id)
+	}
+	ts := time.Now()
+	if !util.TimeIsZero(t.Created) {
+		ts = t.Created
+	}
+	return d.tx("AssignId", func(tx *sql.Tx) error {
+		id, err := nextId(tx, TABLE_TASKS, ts)
+		if err != nil {
+			return err
+		}
+		t.Id = id
+		return nil
+	})
+}
+
+// See docs for DB interface.
+func (d *sqlDB) GetTaskById(id string) (*db.Task, error) {
+	serialized, err := d.getGOB(TABLE_TASKS, id)
+	if err != nil || serialized == nil {
+		return nil, err
+	}
+	var t db.Task
+	if err := gob.NewDecoder(bytes.NewReader(serialized)).Decode(&t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// See docs for DB interface.
+func (d *sqlDB) GetTasksFromDateRange(start, end time.Time) ([]*db.Task, error) {
+	decoder := db.TaskDecoder{}
+	if err := d.getGOBsFromDateRange(TABLE_TASKS, start, end, decoder.Process); err != nil {
+		return nil, err
+	}
+	result, err := decoder.Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(db.TaskSlice(result))
+	return result, nil
+}
+
+// See documentation for DB interface.
+func (d *sqlDB) PutTask(t *db.Task) error {
+	return d.PutTasks([]*db.Task{t})
+}
+
+// See documentation for DB interface.
+func (d *sqlDB) PutTasks(tasks []*db.Task) error {
+	// If there is an error during the transaction, we should leave the tasks
+	// unchanged. Save the old Ids and DbModified times since we set them below.
+	type savedData struct {
+		Id         string
+		DbModified time.Time
+	}
+	oldData := make([]savedData, 0, len(tasks))
+	for _, t := range tasks {
+		if util.TimeIsZero(t.Created) {
+			return fmt.Errorf("Created not set. Task %s created time is %s. %v", t.Id, t.Created, t)
+		}
+		oldData = append(oldData, savedData{
+			Id:         t.Id,
+			DbModified: t.DbModified,
+		})
+	}
+	revertChanges := func() {
+		for i, data := range oldData {
+			tasks[i].Id = data.Id
+			tasks[i].DbModified = data.DbModified
+		}
+	}
+	gobs := make(map[string][]byte, len(tasks))
+	err := d.tx("PutTasks", func(tx *sql.Tx) error {
+		// Assign Ids, check for concurrent updates, and encode.
+		e := db.TaskEncoder{}
+		now := time.Now().UTC()
+		for _, t := range tasks {
+			if t.Id == "" {
+				id, err := nextId(tx, TABLE_TASKS, t.Created)
+				if err != nil {
+					return err
+				}
+				t.Id = id
+			} else if err := checkModified(tx, TABLE_TASKS, t.Id, t.DbModified); err != nil {
+				return err
+			}
+			t.DbModified = now
+			e.Process(t)
+		}
+		// Insert/update.
+		for {
+			t, serialized, err := e.Next()
+			if err != nil {
+				return err
+			}
+			if t == nil {
+				break
+			}
+			gobs[t.Id] = serialized
+			if err := putGOB(tx, TABLE_TASKS, t.Id, t.Created, now, serialized); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		revertChanges()
+		return err
+	}
+	d.modTasks.TrackModifiedTasksGOB(gobs)
+	return nil
+}
+
+// See docs for DB interface.
+func (d *sqlDB) GetModifiedTasks(id string) ([]*db.Task, error) {
+	return d.modTasks.GetModifiedTasks(id)
+}
+
+// See docs for DB interface.
+func (d *sqlDB) StartTrackingModifiedTasks() (string, error) {
+	return d.modTasks.StartTrackingModifiedTasks()
+}
+
+// See docs for DB interface.
+func (d *sqlDB) StopTrackingModifiedTasks(id string) {
+	d.modTasks.StopTrackingModifiedTasks(id)
+}
+
+// See docs for JobDB interface.
+func (d *sqlDB) GetJobById(id string) (*db.Job, error) {
+	serialized, err := d.getGOB(TABLE_JOBS, id)
+	if err != nil || serialized == nil {
+		return nil, err
+	}
+	var j db.Job
+	if err := gob.NewDecoder(bytes.NewReader(serialized)).Decode(&j); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// See docs for JobDB interface.
+func (d *sqlDB) GetJobsFromDateRange(start, end time.Time) ([]*db.Job, error) {
+	decoder := db.JobDecoder{}
+	if err := d.getGOBsFromDateRange(TABLE_JOBS, start, end, decoder.Process); err != nil {
+		return nil, err
+	}
+	result, err := decoder.Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(db.JobSlice(result))
+	return result, nil
+}
+
+// See documentation for JobDB interface.
+func (d *sqlDB) PutJob(j *db.Job) error {
+	return d.PutJobs([]*db.Job{j})
+}
+
+// See documentation for JobDB interface.
+func (d *sqlDB) PutJobs(jobs []*db.Job) error {
+	// If there is an error during the transaction, we should leave the jobs
+	// unchanged. Save the old Ids and DbModified times since we set them below.
+	type savedData struct {
+		Id         string
+		DbModified time.Time
+	}
+	oldData := make([]savedData, 0, len(jobs))
+	for _, j := range jobs {
+		if util.TimeIsZero(j.Created) {
+			return fmt.Errorf("Created not set. Job %s created time is %s. %v", j.Id, j.Created, j)
+		}
+		oldData = append(oldData, savedData{
+			Id:         j.Id,
+			DbModified: j.DbModified,
+		})
+	}
+	revertChanges := func() {
+		for i, data := range oldData {
+			jobs[i].Id = data.Id
+			jobs[i].DbModified = data.DbModified
+		}
+	}
+	gobs := make(map[string][]byte, len(jobs))
+	err := d.tx("PutJobs", func(tx *sql.Tx) error {
+		// Assign Ids, check for concurrent updates, and encode.
+		e := db.JobEncoder{}
+		now := time.Now().UTC()
+		for _, j := range jobs {
+			if j.Id == "" {
+				id, err := nextId(tx, TABLE_JOBS, j.Created)
+				if err != nil {
+					return err
+				}
+				j.Id = id
+			} else if err := checkModified(tx, TABLE_JOBS, j.Id, j.DbModified); err != nil {
+				return err
+			}
+			j.DbModified = now
+			e.Process(j)
+		}
+		// Insert/update.
+		for {
+			j, serialized, err := e.Next()
+			if err != nil {
+				return err
+			}
+			if j == nil {
+				break
+			}
+			gobs[j.Id] = serialized
+			if err := putGOB(tx, TABLE_JOBS, j.Id, j.Created, now, serialized); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		revertChanges()
+		return err
+	}
+	d.modJobs.TrackModifiedJobsGOB(gobs)
+	return nil
+}
+
+// See docs for JobDB interface.
+func (d *sqlDB) GetModifiedJobs(id string) ([]*db.Job, error) {
+	return d.modJobs.GetModifiedJobs(id)
+}
+
+// See docs for JobDB interface.
+func (d *sqlDB) StartTrackingModifiedJobs() (string, error) {
+	return d.modJobs.StartTrackingModifiedJobs()
+}
+
+// See docs for JobDB interface.
+func (d *sqlDB) StopTrackingModifiedJobs(id string) {
+	d.modJobs.StopTrackingModifiedJobs(id)
+}
+
+// writeCommentsMap is passed to db.NewCommentBoxWithPersistence to persist
+// comments after every change. Updates the row for KEY_COMMENT_MAP.
+func (d *sqlDB) writeCommentsMap(comments map[string]*db.RepoComments) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(comments); err != nil {
+		return err
+	}
+	_, err := d.vdb.DB.Exec("INSERT INTO comments (id, gob) VALUES (?, ?) ON DUPLICATE KEY UPDATE gob = VALUES(gob)", KEY_COMMENT_MAP, buf.Bytes())
+	return err
+}
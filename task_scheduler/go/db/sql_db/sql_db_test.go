@@ -0,0 +1,132 @@
+package sql_db
+
+import (
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/database/testutil"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/task_scheduler/go/db"
+)
+
+// Set up a clean test database and run the given test function on a sqlDB.
+// This also locks the test database until the test is finished, causing
+// similar tests to wait.
+func runTest(t *testing.T, name string, test func(*testing.T, db.DB)) {
+	testutils.SkipIfShort(t)
+	mysqlDB := testutil.SetupMySQLTestDatabase(t, migrationSteps)
+	defer mysqlDB.Close(t)
+
+	vdb, err := testutil.LocalTestDatabaseConfig(migrationSteps).NewVersionedDB()
+	assert.NoError(t, err)
+	d, err := NewDB(name, vdb)
+	assert.NoError(t, err)
+	test(t, d)
+}
+
+func TestMySQLVersioning(t *testing.T) {
+	testutils.SkipIfShort(t)
+	testutil.MySQLVersioningTests(t, "task_scheduler", migrationSteps)
+}
+
+func TestSQLDB(t *testing.T) {
+	runTest(t, "TestSQLDB", func(t *testing.T, d db.DB) {
+		db.TestTaskDB(t, d)
+	})
+}
+
+func TestSQLDBTooManyUsers(t *testing.T) {
+	runTest(t, "TestSQLDBTooManyUsers", func(t *testing.T, d db.DB) {
+		db.TestTaskDBTooManyUsers(t, d)
+	})
+}
+
+func TestSQLDBConcurrentUpdate(t *testing.T) {
+	runTest(t, "TestSQLDBConcurrentUpdate", func(t *testing.T, d db.DB) {
+		db.TestTaskDBConcurrentUpdate(t, d)
+	})
+}
+
+func TestSQLDBUpdateTasksWithRetries(t *testing.T) {
+	runTest(t, "TestSQLDBUpdateTasksWithRetries", func(t *testing.T, d db.DB) {
+		db.TestUpdateTasksWithRetries(t, d)
+	})
+}
+
+func TestSQLDBCommentDB(t *testing.T) {
+	runTest(t, "TestSQLDBCommentDB", func(t *testing.T, d db.DB) {
+		db.TestCommentDB(t, d)
+	})
+}
+
+func TestSQLDBJobDB(t *testing.T) {
+	runTest(t, "TestSQLDBJobDB", func(t *testing.T, d db.DB) {
+		db.TestJobDB(t, d)
+	})
+}
+
+func TestSQLDBJobDBTooManyUsers(t *testing.T) {
+	runTest(t, "TestSQLDBJobDBTooManyUsers", func(t *testing.T, d db.DB) {
+		db.TestJobDBTooManyUsers(t, d)
+	})
+}
+
+func TestSQLDBJobDBConcurrentUpdate(t *testing.T) {
+	runTest(t, "TestSQLDBJobDBConcurrentUpdate", func(t *testing.T, d db.DB) {
+		db.TestJobDBConcurrentUpdate(t, d)
+	})
+}
+
+func TestSQLDBUpdateJobsWithRetries(t *testing.T) {
+	runTest(t, "TestSQLDBUpdateJobsWithRetries", func(t *testing.T, d db.DB) {
+		db.TestUpdateJobsWithRetries(t, d)
+	})
+}
+
+// Test that PutTasks assigns unique, increasing Ids.
+func TestSQLDBAssignIds(t *testing.T) {
+	runTest(t, "TestSQLDBAssignIds", func(t *testing.T, d db.DB) {
+		defer testutils.AssertCloses(t, d)
+		now := time.Now()
+		t1 := &db.Task{Created: now}
+		t2 := &db.Task{Created: now}
+		assert.NoError(t, d.PutTasks([]*db.Task{t1, t2}))
+		assert.NotEqual(t, t1.Id, t2.Id)
+		assert.True(t, t1.Id < t2.Id)
+
+		// AssignId fails if the Id is already set.
+		assert.Error(t, d.AssignId(t1))
+	})
+}
+
+// Test that comments persist after the DB is closed and reopened.
+func TestSQLDBCommentsPersist(t *testing.T) {
+	testutils.SkipIfShort(t)
+	mysqlDB := testutil.SetupMySQLTestDatabase(t, migrationSteps)
+	defer mysqlDB.Close(t)
+
+	vdb, err := testutil.LocalTestDatabaseConfig(migrationSteps).NewVersionedDB()
+	assert.NoError(t, err)
+	d, err := NewDB("TestSQLDBCommentsPersist", vdb)
+	assert.NoError(t, err)
+	c := &db.TaskSpecComment{
+		Repo:      "r",
+		Name:      "n",
+		Timestamp: time.Unix(0, 1000).UTC(),
+		User:      "me@example.com",
+		Message:   "hello",
+	}
+	assert.NoError(t, d.PutTaskSpecComment(c))
+	testutils.AssertCloses(t, d)
+
+	vdb, err = testutil.LocalTestDatabaseConfig(migrationSteps).NewVersionedDB()
+	assert.NoError(t, err)
+	d, err = NewDB("TestSQLDBCommentsPersist", vdb)
+	assert.NoError(t, err)
+	defer testutils.AssertCloses(t, d)
+	comments, err := d.GetCommentsForRepos([]string{"r"}, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(comments))
+	testutils.AssertDeepEqual(t, []*db.TaskSpecComment{c}, comments[0].TaskSpecComments["n"])
+}
@@ -30,8 +30,11 @@ const (
 	// Swarming tags added by Build Scheduler.
 	SWARMING_TAG_ALLOW_MILO     = "allow_milo"
 	SWARMING_TAG_ID             = "sk_id"
+	SWARMING_TAG_ISSUE          = "sk_issue"
+	SWARMING_TAG_ISSUE_SERVER   = "sk_issue_server"
 	SWARMING_TAG_NAME           = "sk_name"
 	SWARMING_TAG_PARENT_TASK_ID = "sk_parent_task_id"
+	SWARMING_TAG_PATCHSET       = "sk_patchset"
 	SWARMING_TAG_PRIORITY       = "sk_priority"
 	SWARMING_TAG_REPO           = "sk_repo"
 	SWARMING_TAG_RETRY_OF       = "sk_retry_of"
@@ -68,8 +71,13 @@ const (
 //     reused.
 //   - Add any new fields to the Copy() method.
 type Task struct {
+	// Attempt is the attempt number of this task at its Revision, starting
+	// with zero. Each retry increments the attempt number of the task it
+	// retries.
+	Attempt int
+
 	// Commits are the commits which were tested in this Task. The list may
-	// change due to backfilling/bisecting.
+	// change due to backfilling/bisecting. Empty for try jobs.
 	Commits []string
 
 	// Created is the creation timestamp.
@@ -102,6 +110,10 @@ type Task struct {
 	// ParentTaskIds are IDs of tasks which satisfied this task's dependencies.
 	ParentTaskIds []string
 
+	// Patch is the patch which was applied on top of Revision, if this
+	// Task is part of a try job.
+	Patch Patch
+
 	// Repo is the repository of the commit at which this task ran.
 	Repo string
 
@@ -133,9 +145,10 @@ type Task struct {
 //
 // If empty, sets t.Id, t.Name, t.Repo, and t.Revision from s's tags named
 // SWARMING_TAG_ID, SWARMING_TAG_NAME, SWARMING_TAG_REPO, and
-// SWARMING_TAG_REVISION, sets t.Created from s.CreatedTs, and sets
-// t.SwarmingTaskId from s.TaskId. If these fields are non-empty, returns an
-// error if they do not match.
+// SWARMING_TAG_REVISION, sets t.Patch from the SWARMING_TAG_ISSUE_SERVER,
+// SWARMING_TAG_ISSUE, and SWARMING_TAG_PATCHSET tags, sets t.Created from
+// s.CreatedTs, and sets t.SwarmingTaskId from s.TaskId. If these fields are
+// non-empty, returns an error if they do not match.
 //
 // Always sets t.Status, t.Started, t.Finished, and t.IsolatedOutput based on s.
 func (orig *Task) UpdateFromSwarming(s *swarming_api.SwarmingRpcsTaskResult) (bool, error) {
@@ -178,6 +191,15 @@ func (orig *Task) UpdateFromSwarming(s *swarming_api.SwarmingRpcsTaskResult) (bo
 	if err := checkOrSetFromTag(SWARMING_TAG_REVISION, &copy.Revision, "Revision"); err != nil {
 		return false, err
 	}
+	if err := checkOrSetFromTag(SWARMING_TAG_ISSUE_SERVER, &copy.Patch.Server, "Patch.Server"); err != nil {
+		return false, err
+	}
+	if err := checkOrSetFromTag(SWARMING_TAG_ISSUE, &copy.Patch.Issue, "Patch.Issue"); err != nil {
+		return false, err
+	}
+	if err := checkOrSetFromTag(SWARMING_TAG_PATCHSET, &copy.Patch.Patchset, "Patch.Patchset"); err != nil {
+		return false, err
+	}
 
 	// Set ParentTaskIds.
 	var parentTaskIds []string
@@ -309,6 +331,11 @@ func (t *Task) Success() bool {
 	return t.Status == TASK_STATUS_SUCCESS
 }
 
+// IsTryJob returns true iff the Task ran with a patch applied.
+func (t *Task) IsTryJob() bool {
+	return !t.Patch.Empty()
+}
+
 func (t *Task) Copy() *Task {
 	var commits []string
 	if t.Commits != nil {
@@ -321,6 +348,7 @@ func (t *Task) Copy() *Task {
 		copy(parentTaskIds, t.ParentTaskIds)
 	}
 	return &Task{
+		Attempt:        t.Attempt,
 		Commits:        commits,
 		Created:        t.Created,
 		DbModified:     t.DbModified,
@@ -329,6 +357,7 @@ func (t *Task) Copy() *Task {
 		IsolatedOutput: t.IsolatedOutput,
 		Name:           t.Name,
 		ParentTaskIds:  parentTaskIds,
+		Patch:          t.Patch,
 		Repo:           t.Repo,
 		RetryOf:        t.RetryOf,
 		Revision:       t.Revision,
@@ -500,8 +529,9 @@ func (d *TaskDecoder) Result() ([]*Task, error) {
 	}
 }
 
-// TagsForTask returns the tags which should be set for a Task.
-func TagsForTask(name, id string, priority float64, repo, retryOf, revision string, dimensions map[string]string, parentTaskIds []string) []string {
+// TagsForTask returns the tags which should be set for a Task. The patch tags
+// are only included if patch is not empty.
+func TagsForTask(name, id string, priority float64, repo, retryOf, revision string, patch Patch, dimensions map[string]string, parentTaskIds []string) []string {
 	tags := map[string]string{
 		SWARMING_TAG_ALLOW_MILO: "1",
 		SWARMING_TAG_NAME:       name,
@@ -511,6 +541,11 @@ func TagsForTask(name, id string, priority float64, repo, retryOf, revision stri
 		SWARMING_TAG_RETRY_OF:   retryOf,
 		SWARMING_TAG_REVISION:   revision,
 	}
+	if !patch.Empty() {
+		tags[SWARMING_TAG_ISSUE_SERVER] = patch.Server
+		tags[SWARMING_TAG_ISSUE] = patch.Issue
+		tags[SWARMING_TAG_PATCHSET] = patch.Patchset
+	}
 
 	for k, v := range dimensions {
 		key := fmt.Sprintf("sk_dim_%s", k)
@@ -197,6 +197,41 @@ func TestUpdateFromSwarmingInit(t *testing.T) {
 	})
 }
 
+// Test that Task.UpdateFromSwarming sets and checks the Patch.
+func TestUpdateFromSwarmingPatch(t *testing.T) {
+	now := time.Now().UTC().Round(time.Microsecond)
+	task := &Task{}
+	s := &swarming_api.SwarmingRpcsTaskResult{
+		TaskId:    "E",
+		CreatedTs: now.Format(swarming.TIMESTAMP_FORMAT),
+		State:     SWARMING_STATE_PENDING,
+		Tags: []string{
+			fmt.Sprintf("%s:A", SWARMING_TAG_ID),
+			fmt.Sprintf("%s:B", SWARMING_TAG_NAME),
+			fmt.Sprintf("%s:C", SWARMING_TAG_REPO),
+			fmt.Sprintf("%s:D", SWARMING_TAG_REVISION),
+			fmt.Sprintf("%s:https://codereview.chromium.org", SWARMING_TAG_ISSUE_SERVER),
+			fmt.Sprintf("%s:1234", SWARMING_TAG_ISSUE),
+			fmt.Sprintf("%s:5", SWARMING_TAG_PATCHSET),
+		},
+	}
+	changed, err := task.UpdateFromSwarming(s)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.True(t, task.IsTryJob())
+	testutils.AssertDeepEqual(t, Patch{
+		Server:   "https://codereview.chromium.org",
+		Issue:    "1234",
+		Patchset: "5",
+	}, task.Patch)
+
+	s.Tags[6] = fmt.Sprintf("%s:6", SWARMING_TAG_PATCHSET)
+	changed, err = task.UpdateFromSwarming(s)
+	assert.False(t, changed)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Patch.Patchset does not match")
+}
+
 // Test that Task.UpdateFromSwarming updates the expected fields in an existing
 // Task.
 func TestUpdateFromSwarmingUpdate(t *testing.T) {
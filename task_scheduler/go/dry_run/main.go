@@ -0,0 +1,185 @@
+package main
+
+/*
+	Program which shows what the Task Scheduler would schedule, given a repo,
+	a set of previously-run tasks, and a set of free bots. Nothing is
+	triggered and no database is modified. Useful for previewing the effects
+	of changes to scoring or to the tasks cfg file.
+
+	Example:
+
+	dry_run --repo=https://skia.googlesource.com/skia.git \
+		--tasks=recorded_tasks.json --bots=free_bots.json \
+		--tasks_cfg=path/to/modified/tasks.json
+*/
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	swarming_api "github.com/luci/luci-go/common/api/swarming/swarming/v1"
+	"github.com/skia-dev/glog"
+	"go.skia.org/infra/go/common"
+	"go.skia.org/infra/go/human"
+	"go.skia.org/infra/go/isolate"
+	"go.skia.org/infra/go/swarming"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/task_scheduler/go/db"
+	"go.skia.org/infra/task_scheduler/go/scheduling"
+)
+
+var (
+	botsFile       = flag.String("bots", "", "JSON file containing a list of free bots, eg. [{\"id\": \"bot1\", \"dimensions\": [\"pool:Skia\", \"os:Ubuntu\"]}]. If not specified, no bots are free and nothing is scheduled.")
+	jsonOutput     = flag.Bool("json", false, "Print the queue as JSON instead of human-readable text.")
+	repo           = flag.String("repo", "", "Repository to use. May be a URL or a local path.")
+	scoreDecay24Hr = flag.Float64("scoreDecay24Hr", 0.9, "Task candidate scores are penalized using linear time decay. This is the desired value after 24 hours. Setting it to 1.0 causes commits not to be prioritized according to commit time.")
+	tasksCfgFile   = flag.String("tasks_cfg", "", "If specified, use this tasks cfg file for every commit instead of the one checked in to the repo.")
+	tasksFile      = flag.String("tasks", "", "JSON file containing a list of previously-run db.Tasks. If not specified, assumes no tasks have run.")
+	timePeriod     = flag.String("timePeriod", "4d", "Time period to use.")
+	workdir        = flag.String("workdir", "", "Working directory to use. If not specified, a temporary directory is used.")
+)
+
+// bot is a simplified representation of a Swarming bot.
+type bot struct {
+	Id         string   `json:"id"`
+	Dimensions []string `json:"dimensions"`
+}
+
+// readJson decodes the given JSON file into dst.
+func readJson(file string, dst interface{}) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer util.Close(f)
+	return json.NewDecoder(f).Decode(dst)
+}
+
+// readBots reads the list of free bots from the given file.
+func readBots(file string) ([]*swarming_api.SwarmingRpcsBotInfo, error) {
+	bots := []*bot{}
+	if err := readJson(file, &bots); err != nil {
+		return nil, err
+	}
+	rv := make([]*swarming_api.SwarmingRpcsBotInfo, 0, len(bots))
+	for _, b := range bots {
+		dims := map[string][]string{}
+		for _, d := range b.Dimensions {
+			split := strings.SplitN(d, ":", 2)
+			if len(split) != 2 {
+				return nil, fmt.Errorf("Dimension %q for bot %q does not contain a colon!", d, b.Id)
+			}
+			dims[split[0]] = append(dims[split[0]], split[1])
+		}
+		dimensions := make([]*swarming_api.SwarmingRpcsStringListPair, 0, len(dims))
+		for k, v := range dims {
+			dimensions = append(dimensions, &swarming_api.SwarmingRpcsStringListPair{
+				Key:   k,
+				Value: v,
+			})
+		}
+		rv = append(rv, &swarming_api.SwarmingRpcsBotInfo{
+			BotId:      b.Id,
+			Dimensions: dimensions,
+		})
+	}
+	return rv, nil
+}
+
+func main() {
+	common.Init()
+	defer common.LogPanic()
+
+	if *repo == "" {
+		glog.Fatal("--repo is required.")
+	}
+	period, err := human.ParseDuration(*timePeriod)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	wd := *workdir
+	if wd == "" {
+		wd, err = ioutil.TempDir("", "dry_run")
+		if err != nil {
+			glog.Fatal(err)
+		}
+		defer util.RemoveAll(wd)
+	}
+
+	// Load the recorded tasks into an in-memory DB.
+	d := db.NewInMemoryDB()
+	if *tasksFile != "" {
+		tasks := []*db.Task{}
+		if err := readJson(*tasksFile, &tasks); err != nil {
+			glog.Fatal(err)
+		}
+		if err := d.PutTasks(tasks); err != nil {
+			glog.Fatal(err)
+		}
+		glog.Infof("Loaded %d tasks.", len(tasks))
+	}
+	cache, err := db.NewTaskCache(d, period)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	bots := []*swarming_api.SwarmingRpcsBotInfo{}
+	if *botsFile != "" {
+		bots, err = readBots(*botsFile)
+		if err != nil {
+			glog.Fatal(err)
+		}
+	}
+
+	// Create the TaskScheduler. The Isolate and Swarming clients are fakes,
+	// since we never trigger any tasks.
+	isolateClient, err := isolate.NewClient(wd)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	isolateClient.ServerUrl = isolate.FAKE_SERVER_URL
+	swarmingClient := swarming.NewTestClient()
+	swarmingClient.MockBots(bots)
+	s, err := scheduling.NewTaskScheduler(d, cache, period, wd, []string{*repo}, isolateClient, swarmingClient, *scoreDecay24Hr)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if *tasksCfgFile != "" {
+		b, err := ioutil.ReadFile(*tasksCfgFile)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		cfg, err := scheduling.ParseTasksCfg(string(b))
+		if err != nil {
+			glog.Fatal(err)
+		}
+		s.SetTasksCfgOverride(cfg)
+	}
+
+	// Run the scheduler and print the results.
+	queue, err := s.DryRun(bots)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if *jsonOutput {
+		b, err := json.MarshalIndent(queue, "", "  ")
+		if err != nil {
+			glog.Fatal(err)
+		}
+		fmt.Println(string(b))
+		return
+	}
+	scheduled := 0
+	for i, c := range queue {
+		fmt.Printf("%4d. %s\n", i+1, c)
+		if c.Bot != "" {
+			scheduled++
+		}
+	}
+	fmt.Printf("%d candidates in queue; %d would be scheduled on %d free bots.\n", len(queue), scheduled, len(bots))
+}
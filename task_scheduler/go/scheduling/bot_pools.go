@@ -0,0 +1,157 @@
+package scheduling
+
+import (
+	"fmt"
+	"time"
+
+	swarming_api "github.com/luci/luci-go/common/api/swarming/swarming/v1"
+	"go.skia.org/infra/go/metrics2"
+	"go.skia.org/infra/go/util"
+)
+
+const (
+	MEASUREMENT_STARVATION = "task-spec-starvation-s"
+)
+
+// botPoolState tracks the usage of a BotPool during a single round of
+// scheduling.
+type botPoolState struct {
+	*BotPool
+
+	// bots is the set of IDs of the usable bots in the pool.
+	bots util.StringSet
+
+	// free is the number of bots in the pool which are not running tasks.
+	free int
+
+	// running is the number of bots in the pool which are running tasks for
+	// each TaskSpec, keyed by crossRepoId.
+	running map[string]int
+}
+
+// poolLimiter applies the limits of a set of BotPools during a single round
+// of scheduling. A nil poolLimiter imposes no limits.
+type poolLimiter struct {
+	pools []*botPoolState
+}
+
+// botHasDimensions returns true iff the bot has all of the given dimensions.
+func botHasDimensions(b *swarming_api.SwarmingRpcsBotInfo, dims []string) bool {
+	botDims := util.StringSet{}
+	for _, dim := range b.Dimensions {
+		for _, val := range dim.Value {
+			botDims[fmt.Sprintf("%s:%s", dim.Key, val)] = true
+		}
+	}
+	for _, d := range dims {
+		if !botDims[d] {
+			return false
+		}
+	}
+	return true
+}
+
+// newPoolLimiter returns a poolLimiter for the given BotPools. specsByBot
+// maps the IDs of the busy bots to the crossRepoId of the TaskSpec whose task
+// they are running, if known.
+func newPoolLimiter(pools []*BotPool, free, busy []*swarming_api.SwarmingRpcsBotInfo, specsByBot map[string]string) *poolLimiter {
+	rv := &poolLimiter{
+		pools: make([]*botPoolState, 0, len(pools)),
+	}
+	for _, p := range pools {
+		state := &botPoolState{
+			BotPool: p,
+			bots:    util.StringSet{},
+			running: map[string]int{},
+		}
+		for _, b := range free {
+			if botHasDimensions(b, p.Dimensions) {
+				state.bots[b.BotId] = true
+				state.free++
+			}
+		}
+		for _, b := range busy {
+			if botHasDimensions(b, p.Dimensions) {
+				state.bots[b.BotId] = true
+				if spec, ok := specsByBot[b.BotId]; ok {
+					state.running[spec]++
+				}
+			}
+		}
+		rv.pools = append(rv.pools, state)
+	}
+	return rv
+}
+
+// allowed returns true iff the given candidate may run on the given free bot
+// without exceeding the limits of any pool which contains the bot.
+func (l *poolLimiter) allowed(c *taskCandidate, bot string) bool {
+	if l == nil {
+		return true
+	}
+	spec := crossRepoId(c.Repo, c.Name)
+	for _, p := range l.pools {
+		if !p.bots[bot] {
+			continue
+		}
+		if p.MaxConcurrentPerTaskSpec > 0 && p.running[spec] >= p.MaxConcurrentPerTaskSpec {
+			return false
+		}
+		if c.TaskSpec.Priority < p.ReservedMinPriority && p.free <= p.Reserved {
+			return false
+		}
+	}
+	return true
+}
+
+// assign records that the given candidate will run on the given free bot.
+func (l *poolLimiter) assign(c *taskCandidate, bot string) {
+	if l == nil {
+		return
+	}
+	spec := crossRepoId(c.Repo, c.Name)
+	for _, p := range l.pools {
+		if p.bots[bot] {
+			p.free--
+			p.running[spec]++
+		}
+	}
+}
+
+// starvedTaskSpec records when a TaskSpec began to starve, ie. when its
+// candidates began to be passed over for free bots because of BotPool limits.
+type starvedTaskSpec struct {
+	repo  string
+	name  string
+	since time.Time
+}
+
+// updateStarvation updates the starvation metrics given the candidates which
+// starved during the most recent round of scheduling. Assumes the caller holds
+// a lock on queueMtx.
+func (s *TaskScheduler) updateStarvation(starved []*taskCandidate, now time.Time) {
+	current := make(map[string]bool, len(starved))
+	for _, c := range starved {
+		id := crossRepoId(c.Repo, c.Name)
+		current[id] = true
+		if _, ok := s.starved[id]; !ok {
+			s.starved[id] = &starvedTaskSpec{
+				repo:  c.Repo,
+				name:  c.Name,
+				since: now,
+			}
+		}
+	}
+	for id, st := range s.starved {
+		m := metrics2.GetInt64Metric(MEASUREMENT_STARVATION, map[string]string{
+			"repo":      st.repo,
+			"task_spec": st.name,
+		})
+		if current[id] {
+			m.Update(int64(now.Sub(st.since).Seconds()))
+		} else {
+			m.Update(0)
+			delete(s.starved, id)
+		}
+	}
+}
@@ -40,6 +40,11 @@ func (c *cacheWrapper) GetTaskForCommit(repo, commit, name string) (*db.Task, er
 	return c.c.GetTaskForCommit(repo, commit, name)
 }
 
+// See documentation for TaskCache interface.
+func (c *cacheWrapper) GetTaskForPatch(repo, commit string, patch db.Patch, name string) (*db.Task, error) {
+	return c.c.GetTaskForPatch(repo, commit, patch, name)
+}
+
 // See documentation for TaskCache interface.
 func (c *cacheWrapper) GetTasksForCommits(string, []string) (map[string]map[string]*db.Task, error) {
 	return nil, fmt.Errorf("cacheWrapper.GetTasksForCommits not implemented.")
@@ -0,0 +1,127 @@
+package scheduling
+
+import (
+	"fmt"
+	"strings"
+
+	swarming_api "github.com/luci/luci-go/common/api/swarming/swarming/v1"
+)
+
+// DryRunCandidate describes a task candidate in the scheduling queue, along
+// with the breakdown of its score, as computed by DryRun.
+type DryRunCandidate struct {
+	// Identifying information about the candidate.
+	Name     string `json:"name"`
+	Repo     string `json:"repo"`
+	Revision string `json:"revision"`
+
+	// Patch describes the patch applied for try job candidates.
+	Patch string `json:"patch,omitempty"`
+
+	// The score is the product of the testedness increase and the time
+	// decay.
+	Score              float64 `json:"score"`
+	TestednessIncrease float64 `json:"testedness_increase"`
+	TimeDecay          float64 `json:"time_decay"`
+
+	// Inputs to the testedness increase.
+	BlamelistLength  int    `json:"blamelist_length"`
+	StealingFromId   string `json:"stealing_from_id,omitempty"`
+	StoleFromCommits int    `json:"stole_from_commits"`
+
+	// Priority of the TaskSpec. Not currently used for scoring.
+	Priority float64 `json:"priority"`
+
+	// Bot is the ID of the bot on which the candidate would run, or the
+	// empty string if it would not be scheduled.
+	Bot string `json:"bot,omitempty"`
+
+	// Starved indicates that the candidate matched a free bot but would not
+	// be scheduled because of the limits of a BotPool.
+	Starved bool `json:"starved,omitempty"`
+}
+
+// String returns a one-line human-readable summary of the DryRunCandidate.
+func (c *DryRunCandidate) String() string {
+	bot := "-"
+	if c.Bot != "" {
+		bot = c.Bot
+	} else if c.Starved {
+		bot = "- (starved)"
+	}
+	stealing := ""
+	if c.StealingFromId != "" {
+		stealing = fmt.Sprintf(" (stealing %d commits from %s)", c.StoleFromCommits, c.StealingFromId)
+	}
+	revision := c.Revision
+	if c.Patch != "" {
+		revision = fmt.Sprintf("%s + %s", c.Revision, c.Patch)
+	}
+	return fmt.Sprintf("%.4f = %.4f testedness x %.4f decay; %d commits%s; %s @ %s; bot: %s", c.Score, c.TestednessIncrease, c.TimeDecay, c.BlamelistLength, stealing, c.Name, revision, bot)
+}
+
+// SetTasksCfgOverride causes the TaskScheduler to use the given TasksCfg for
+// every commit, instead of the tasks cfg file checked in to the repo. This is
+// intended for use with DryRun, to preview the effects of a change to the
+// tasks cfg file. A nil TasksCfg removes the override.
+func (s *TaskScheduler) SetTasksCfgOverride(cfg *TasksCfg) {
+	s.taskCfgCache.setOverride(cfg)
+}
+
+// DryRun regenerates the task queue and matches it against the given free
+// bots, without triggering any tasks or modifying the TaskDB. Returns the
+// entire queue, ranked in decreasing order by score, with the Bot field set
+// for the candidates which would be scheduled. BotPool limits are applied as
+// if no other bots were busy.
+func (s *TaskScheduler) DryRun(bots []*swarming_api.SwarmingRpcsBotInfo) ([]*DryRunCandidate, error) {
+	if err := s.regenerateTaskQueue(); err != nil {
+		return nil, err
+	}
+
+	// getCandidatesToSchedule modifies the candidates' dimensions, so we
+	// give it copies.
+	s.queueMtx.RLock()
+	queue := make([]*taskCandidate, 0, len(s.queue))
+	for _, c := range s.queue {
+		queue = append(queue, c.Copy())
+	}
+	limiter := newPoolLimiter(s.botPools, bots, nil, nil)
+	s.queueMtx.RUnlock()
+	schedule, starved := getCandidatesToSchedule(bots, queue, limiter)
+	botsByCandidate := map[string]string{}
+	for _, c := range schedule {
+		for _, d := range c.TaskSpec.Dimensions {
+			if strings.HasPrefix(d, "id:") {
+				botsByCandidate[c.MakeId()] = strings.TrimPrefix(d, "id:")
+			}
+		}
+	}
+	starvedCandidates := make(map[string]bool, len(starved))
+	for _, c := range starved {
+		starvedCandidates[c.MakeId()] = true
+	}
+
+	rv := make([]*DryRunCandidate, 0, len(queue))
+	for _, c := range queue {
+		patch := ""
+		if c.IsTryJob() {
+			patch = c.Patch.String()
+		}
+		rv = append(rv, &DryRunCandidate{
+			Name:               c.Name,
+			Repo:               c.Repo,
+			Revision:           c.Revision,
+			Patch:              patch,
+			Score:              c.Score,
+			TestednessIncrease: c.TestednessIncrease,
+			TimeDecay:          c.TimeDecay,
+			BlamelistLength:    len(c.Commits),
+			StealingFromId:     c.StealingFromId,
+			StoleFromCommits:   c.StoleFromCommits,
+			Priority:           c.TaskSpec.Priority,
+			Bot:                botsByCandidate[c.MakeId()],
+			Starved:            starvedCandidates[c.MakeId()],
+		})
+	}
+	return rv, nil
+}
@@ -0,0 +1,84 @@
+package scheduling
+
+import (
+	"testing"
+	"time"
+
+	swarming_api "github.com/luci/luci-go/common/api/swarming/swarming/v1"
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/task_scheduler/go/db"
+)
+
+func TestDryRun(t *testing.T) {
+	tr, d, cache, _, _, swarmingClient, s := setup(t)
+	defer tr.Cleanup()
+
+	// No tasks have run, so the queue should contain the two Build tasks.
+	// Only one bot is free.
+	bot1 := makeBot("bot1", map[string]string{"pool": "Skia", "os": "Ubuntu"})
+	queue, err := s.DryRun([]*swarming_api.SwarmingRpcsBotInfo{bot1})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(queue))
+	scheduled := 0
+	for _, c := range queue {
+		assert.Equal(t, buildTask, c.Name)
+		assert.Equal(t, repoName, c.Repo)
+		assert.Equal(t, 1, c.BlamelistLength)
+		assert.Equal(t, "", c.StealingFromId)
+		assert.Equal(t, 2.0, c.TestednessIncrease)
+		assert.Equal(t, 1.0, c.TimeDecay)
+		assert.Equal(t, c.TestednessIncrease*c.TimeDecay, c.Score)
+		if c.Bot != "" {
+			assert.Equal(t, "bot1", c.Bot)
+			scheduled++
+		}
+	}
+	assert.Equal(t, 1, scheduled)
+
+	// Ensure that nothing was triggered or inserted into the DB.
+	assert.NoError(t, cache.Update())
+	tasks, err := d.GetTasksFromDateRange(time.Time{}, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(tasks))
+	triggered, err := swarmingClient.ListTasks(time.Time{}, time.Now(), []string{}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(triggered))
+
+	// Record a finished Build task at c1 and ensure that the queue reflects
+	// it.
+	t1 := makeTask(buildTask, repoName, c1)
+	t1.Status = db.TASK_STATUS_SUCCESS
+	t1.IsolatedOutput = "fake isolated hash"
+	assert.NoError(t, d.PutTask(t1))
+	assert.NoError(t, cache.Update())
+	queue, err = s.DryRun([]*swarming_api.SwarmingRpcsBotInfo{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(queue))
+	names := map[string]string{}
+	for _, c := range queue {
+		names[c.Name] = c.Revision
+		assert.Equal(t, "", c.Bot)
+	}
+	assert.Equal(t, map[string]string{buildTask: c2, testTask: c1}, names)
+
+	// Override the tasks cfg. Only the Build task remains, and it no longer
+	// has the Ubuntu dimension.
+	s.SetTasksCfgOverride(&TasksCfg{
+		Tasks: map[string]*TaskSpec{
+			buildTask: &TaskSpec{
+				CipdPackages: []*CipdPackage{},
+				Dependencies: []string{},
+				Dimensions:   []string{"pool:Skia"},
+				Isolate:      "compile_skia.isolate",
+				Priority:     0.5,
+			},
+		},
+	})
+	queue, err = s.DryRun([]*swarming_api.SwarmingRpcsBotInfo{bot1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(queue))
+	assert.Equal(t, buildTask, queue[0].Name)
+	assert.Equal(t, c2, queue[0].Revision)
+	assert.Equal(t, 0.5, queue[0].Priority)
+	assert.Equal(t, "bot1", queue[0].Bot)
+}
@@ -10,10 +10,18 @@ import (
 	"github.com/skia-dev/glog"
 
 	"go.skia.org/infra/go/gitinfo"
+	"go.skia.org/infra/go/swarming"
 )
 
 const (
 	TASKS_CFG_FILE = "infra/bots/tasks.json"
+
+	// DEFAULT_TASK_SPEC_MAX_ATTEMPTS is the number of attempts used for a
+	// TaskSpec which does not specify MaxAttempts, ie. one retry.
+	DEFAULT_TASK_SPEC_MAX_ATTEMPTS = 2
+
+	// MAX_TASK_SPEC_MAX_ATTEMPTS is the upper bound on TaskSpec.MaxAttempts.
+	MAX_TASK_SPEC_MAX_ATTEMPTS = 5
 )
 
 // ParseTasksCfg parses the given task cfg file contents and returns the config.
@@ -29,6 +37,12 @@ func ParseTasksCfg(contents string) (*TasksCfg, error) {
 		}
 	}
 
+	for _, p := range rv.BotPools {
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := findCycles(rv.Tasks); err != nil {
 		return nil, err
 	}
@@ -51,6 +65,57 @@ type TasksCfg struct {
 	// Tasks is a map whose keys are TaskSpec names and values are TaskSpecs
 	// detailing the Swarming tasks to run at each commit.
 	Tasks map[string]*TaskSpec `json:"tasks"`
+
+	// BotPools place limits on the use of the Swarming bots matching
+	// particular sets of dimensions. See BotPool for details.
+	BotPools []*BotPool `json:"bot_pools,omitempty"`
+}
+
+// BotPool is a struct which describes limits on the use of the set of
+// Swarming bots which have all of the given dimensions. The limits apply in
+// addition to any other pools containing the same bots.
+type BotPool struct {
+	// Dimensions identify the bots in the pool; a bot belongs to the pool
+	// if it has all of these dimensions.
+	Dimensions []string `json:"dimensions"`
+
+	// MaxConcurrentPerTaskSpec is the maximum number of bots in the pool
+	// which may be running tasks for any single TaskSpec at once. If zero,
+	// there is no limit.
+	MaxConcurrentPerTaskSpec int `json:"max_concurrent_per_task_spec,omitempty"`
+
+	// Reserved is the number of free bots in the pool which are held back
+	// for TaskSpecs whose Priority is at least ReservedMinPriority. Tasks
+	// of lower priority only run on the pool's bots if more than Reserved
+	// of them are free.
+	Reserved int `json:"reserved,omitempty"`
+
+	// ReservedMinPriority is the minimum TaskSpec Priority which may use
+	// the reserved bots.
+	ReservedMinPriority float64 `json:"reserved_min_priority,omitempty"`
+}
+
+// Validate ensures that the BotPool is defined properly.
+func (p *BotPool) Validate() error {
+	if len(p.Dimensions) == 0 {
+		return fmt.Errorf("Bot pools must have at least one dimension.")
+	}
+	for _, d := range p.Dimensions {
+		split := strings.SplitN(d, ":", 2)
+		if len(split) != 2 {
+			return fmt.Errorf("Dimension %q does not contain a colon!", d)
+		}
+	}
+	if p.MaxConcurrentPerTaskSpec < 0 || p.Reserved < 0 {
+		return fmt.Errorf("Bot pool limits may not be negative.")
+	}
+	if p.ReservedMinPriority < 0 || p.ReservedMinPriority > 1 {
+		return fmt.Errorf("ReservedMinPriority must be between 0 and 1; got %f.", p.ReservedMinPriority)
+	}
+	if p.Reserved > 0 && p.ReservedMinPriority == 0 {
+		return fmt.Errorf("ReservedMinPriority is required for bot pools with reserved bots.")
+	}
+	return nil
 }
 
 // TaskSpec is a struct which describes a Swarming task to run.
@@ -59,8 +124,12 @@ type TaskSpec struct {
 	// CipdPackages are CIPD packages which should be installed for the task.
 	CipdPackages []*CipdPackage `json:"cipd_packages"`
 
-	// Dependencies are names of other TaskSpecs for tasks which need to run
-	// before this task.
+	// CrossRepoDependencies are TaskSpecs in other repos whose tasks need to
+	// run before this task. See CrossRepoDependency for details.
+	CrossRepoDependencies []*CrossRepoDependency `json:"cross_repo_dependencies,omitempty"`
+
+	// Dependencies are names of other TaskSpecs in the same repo for tasks
+	// which need to run before this task.
 	Dependencies []string `json:"dependencies"`
 
 	// Dimensions are Swarming bot dimensions which describe the type of bot
@@ -70,12 +139,29 @@ type TaskSpec struct {
 	// Environment is a set of environment variables needed by the task.
 	Environment map[string]string `json:"environment"`
 
+	// ExecutionTimeout is the maximum amount of time the task is allowed to
+	// run. If zero, swarming.RECOMMENDED_HARD_TIMEOUT is used.
+	ExecutionTimeout time.Duration `json:"execution_timeout_ns,omitempty"`
+
+	// Expiration is how long the task may wait in the Swarming queue before
+	// it expires. If zero, swarming.RECOMMENDED_EXPIRATION is used.
+	Expiration time.Duration `json:"expiration_ns,omitempty"`
+
 	// ExtraArgs are extra command-line arguments to pass to the task.
 	ExtraArgs []string `json:"extra_args"`
 
+	// IoTimeout is the maximum amount of time the task may run without
+	// producing output. If zero, swarming.RECOMMENDED_IO_TIMEOUT is used.
+	IoTimeout time.Duration `json:"io_timeout_ns,omitempty"`
+
 	// Isolate is the name of the isolate file used by this task.
 	Isolate string `json:"isolate"`
 
+	// MaxAttempts is the maximum number of times a task for this TaskSpec
+	// may run at a given commit, including the first attempt. If zero,
+	// DEFAULT_TASK_SPEC_MAX_ATTEMPTS is used.
+	MaxAttempts int `json:"max_attempts,omitempty"`
+
 	// Priority indicates the relative priority of the task, with 0 < p <= 1
 	Priority float64 `json:"priority"`
 }
@@ -89,6 +175,13 @@ func (t *TaskSpec) Validate(cfg *TasksCfg) error {
 		}
 	}
 
+	// Ensure that cross-repo dependencies are specified properly.
+	for _, d := range t.CrossRepoDependencies {
+		if d.Repo == "" || d.Name == "" {
+			return fmt.Errorf("Cross-repo dependencies must have a repo and a name.")
+		}
+	}
+
 	// Ensure that the dimensions are specified properly.
 	for _, d := range t.Dimensions {
 		split := strings.SplitN(d, ":", 2)
@@ -102,9 +195,62 @@ func (t *TaskSpec) Validate(cfg *TasksCfg) error {
 		return fmt.Errorf("Isolate file is required.")
 	}
 
+	// Ensure that timeouts and attempts are within range.
+	if t.ExecutionTimeout < 0 || t.Expiration < 0 || t.IoTimeout < 0 {
+		return fmt.Errorf("Timeouts and expiration may not be negative.")
+	}
+	// The default I/O timeout is clamped to the execution timeout, so only
+	// check an explicitly set one.
+	if t.IoTimeout != 0 && t.IoTimeout > t.GetExecutionTimeout() {
+		return fmt.Errorf("I/O timeout (%s) may not exceed execution timeout (%s).", t.IoTimeout, t.GetExecutionTimeout())
+	}
+	if t.MaxAttempts < 0 || t.MaxAttempts > MAX_TASK_SPEC_MAX_ATTEMPTS {
+		return fmt.Errorf("MaxAttempts must be between 0 and %d; got %d.", MAX_TASK_SPEC_MAX_ATTEMPTS, t.MaxAttempts)
+	}
+
 	return nil
 }
 
+// GetExecutionTimeout returns the execution timeout for tasks of this
+// TaskSpec, using the default if none is specified.
+func (t *TaskSpec) GetExecutionTimeout() time.Duration {
+	if t.ExecutionTimeout == 0 {
+		return swarming.RECOMMENDED_HARD_TIMEOUT
+	}
+	return t.ExecutionTimeout
+}
+
+// GetExpiration returns the expiration for tasks of this TaskSpec, using the
+// default if none is specified.
+func (t *TaskSpec) GetExpiration() time.Duration {
+	if t.Expiration == 0 {
+		return swarming.RECOMMENDED_EXPIRATION
+	}
+	return t.Expiration
+}
+
+// GetIoTimeout returns the I/O timeout for tasks of this TaskSpec, using the
+// default if none is specified. The default never exceeds the execution
+// timeout.
+func (t *TaskSpec) GetIoTimeout() time.Duration {
+	if t.IoTimeout == 0 {
+		if exec := t.GetExecutionTimeout(); exec < swarming.RECOMMENDED_IO_TIMEOUT {
+			return exec
+		}
+		return swarming.RECOMMENDED_IO_TIMEOUT
+	}
+	return t.IoTimeout
+}
+
+// GetMaxAttempts returns the maximum number of attempts for tasks of this
+// TaskSpec at a given commit, using the default if none is specified.
+func (t *TaskSpec) GetMaxAttempts() int {
+	if t.MaxAttempts == 0 {
+		return DEFAULT_TASK_SPEC_MAX_ATTEMPTS
+	}
+	return t.MaxAttempts
+}
+
 // Copy returns a copy of the TaskSpec.
 func (t *TaskSpec) Copy() *TaskSpec {
 	cipdPackages := make([]*CipdPackage, 0, len(t.CipdPackages))
@@ -113,6 +259,13 @@ func (t *TaskSpec) Copy() *TaskSpec {
 		pkgs[i] = *p
 		cipdPackages = append(cipdPackages, &pkgs[i])
 	}
+	var crossRepoDeps []*CrossRepoDependency
+	if t.CrossRepoDependencies != nil {
+		crossRepoDeps = make([]*CrossRepoDependency, 0, len(t.CrossRepoDependencies))
+		for _, d := range t.CrossRepoDependencies {
+			crossRepoDeps = append(crossRepoDeps, d.Copy())
+		}
+	}
 	deps := make([]string, len(t.Dependencies))
 	copy(deps, t.Dependencies)
 	dims := make([]string, len(t.Dimensions))
@@ -124,16 +277,45 @@ func (t *TaskSpec) Copy() *TaskSpec {
 	extraArgs := make([]string, len(t.ExtraArgs))
 	copy(extraArgs, t.ExtraArgs)
 	return &TaskSpec{
-		CipdPackages: cipdPackages,
-		Dependencies: deps,
-		Dimensions:   dims,
-		Environment:  environment,
-		ExtraArgs:    extraArgs,
-		Isolate:      t.Isolate,
-		Priority:     t.Priority,
+		CipdPackages:          cipdPackages,
+		CrossRepoDependencies: crossRepoDeps,
+		Dependencies:          deps,
+		Dimensions:            dims,
+		Environment:           environment,
+		ExecutionTimeout:      t.ExecutionTimeout,
+		Expiration:            t.Expiration,
+		ExtraArgs:             extraArgs,
+		IoTimeout:             t.IoTimeout,
+		Isolate:               t.Isolate,
+		MaxAttempts:           t.MaxAttempts,
+		Priority:              t.Priority,
+	}
+}
+
+// CrossRepoDependency is a struct representing a dependency on a TaskSpec in
+// another repo. Since the repos' histories are unrelated, the dependency is
+// satisfied by the successful task for the given TaskSpec at the most recent
+// commit in the other repo which is not newer than the commit of the
+// dependent task. Blamelists are still computed within each repo.
+type CrossRepoDependency struct {
+	Repo string `json:"repo"`
+	Name string `json:"name"`
+}
+
+// Copy returns a copy of the CrossRepoDependency.
+func (d *CrossRepoDependency) Copy() *CrossRepoDependency {
+	return &CrossRepoDependency{
+		Repo: d.Repo,
+		Name: d.Name,
 	}
 }
 
+// crossRepoId returns a string which identifies the given TaskSpec across
+// repos.
+func crossRepoId(repo, name string) string {
+	return fmt.Sprintf("%s|%s", repo, name)
+}
+
 // CipdPackage is a struct representing a CIPD package which needs to be
 // installed on a bot for a particular task.
 type CipdPackage struct {
@@ -143,11 +325,13 @@ type CipdPackage struct {
 }
 
 // taskCfgCache is a struct used for caching tasks cfg files. The user should
-// periodically call Cleanup() to remove old entries.
+// periodically call Cleanup() to remove old entries. If override is set, it is
+// used in place of the tasks cfg file at every commit.
 type taskCfgCache struct {
-	cache map[string]map[string]*TasksCfg
-	mtx   sync.Mutex
-	repos *gitinfo.RepoMap
+	cache    map[string]map[string]*TasksCfg
+	mtx      sync.Mutex
+	override *TasksCfg
+	repos    *gitinfo.RepoMap
 }
 
 // newTaskCfgCache returns a taskCfgCache instance.
@@ -163,6 +347,9 @@ func newTaskCfgCache(repos *gitinfo.RepoMap) *taskCfgCache {
 // Stores a cache of already-read task cfg files. Syncs the repo and reads the
 // file if needed. Assumes the caller holds a lock.
 func (c *taskCfgCache) readTasksCfg(repo, commit string) (*TasksCfg, error) {
+	if c.override != nil {
+		return c.override, nil
+	}
 	r, err := c.repos.Repo(repo)
 	if err != nil {
 		return nil, fmt.Errorf("Could not read task cfg; failed to check out repo: %s", err)
@@ -194,6 +381,8 @@ func (c *taskCfgCache) readTasksCfg(repo, commit string) (*TasksCfg, error) {
 //
 // map[repo_name][commit_hash][task_name]*TaskSpec
 //
+// Returns an error if the TaskSpecs at the most recent of the given commits in
+// each repo contain a cycle of dependencies across repos.
 func (c *taskCfgCache) GetTaskSpecsForCommits(commitsByRepo map[string][]string) (map[string]map[string]map[string]*TaskSpec, error) {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
@@ -217,9 +406,61 @@ func (c *taskCfgCache) GetTaskSpecsForCommits(commitsByRepo map[string][]string)
 		}
 		rv[repo] = tasksByCommit
 	}
+
+	// Check for cycles across repos, using the most recent commit in each.
+	newest := make(map[string]map[string]*TaskSpec, len(rv))
+	for repo, tasksByCommit := range rv {
+		r, err := c.repos.Repo(repo)
+		if err != nil {
+			return nil, err
+		}
+		var newestTs time.Time
+		for commit, tasks := range tasksByCommit {
+			if ts := r.Timestamp(commit); newest[repo] == nil || ts.After(newestTs) {
+				newest[repo] = tasks
+				newestTs = ts
+			}
+		}
+	}
+	if err := findCrossRepoCycles(newest); err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// GetBotPools returns the BotPools defined in the tasks cfg files at the most
+// recent of the given commits in each repo. Assumes that the commits for each
+// repo are in chronological order.
+func (c *taskCfgCache) GetBotPools(commitsByRepo map[string][]string) ([]*BotPool, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	rv := []*BotPool{}
+	for repo, commits := range commitsByRepo {
+		if len(commits) == 0 {
+			continue
+		}
+		cfg, err := c.readTasksCfg(repo, commits[len(commits)-1])
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, cfg.BotPools...)
+		// The override applies to every repo; don't count it twice.
+		if c.override != nil {
+			break
+		}
+	}
 	return rv, nil
 }
 
+// setOverride causes the given TasksCfg to be used for every commit, instead of
+// the tasks cfg file checked in to the repo. A nil TasksCfg removes the
+// override.
+func (c *taskCfgCache) setOverride(cfg *TasksCfg) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.override = cfg
+}
+
 // Cleanup removes cache entries which are outside of our scheduling window.
 func (c *taskCfgCache) Cleanup(period time.Duration) error {
 	c.mtx.Lock()
@@ -250,19 +491,54 @@ func (c *taskCfgCache) Cleanup(period time.Duration) error {
 // findCycles searches for cyclical dependencies in the task specs and returns
 // an error if any are found.
 func findCycles(tasks map[string]*TaskSpec) error {
+	graph := make(map[string][]string, len(tasks))
+	for name, t := range tasks {
+		graph[name] = t.Dependencies
+	}
+	return findCyclesInGraph(graph, true)
+}
+
+// findCrossRepoCycles searches for cyclical dependencies, including
+// cross-repo dependencies, in the given task specs, which are keyed by repo
+// and TaskSpec name, and returns an error if any are found. Cross-repo
+// dependencies on unknown repos or TaskSpecs are ignored, since they may refer
+// to repos which are not being scheduled.
+func findCrossRepoCycles(tasks map[string]map[string]*TaskSpec) error {
+	graph := map[string][]string{}
+	for repo, specs := range tasks {
+		for name, t := range specs {
+			deps := make([]string, 0, len(t.Dependencies)+len(t.CrossRepoDependencies))
+			for _, d := range t.Dependencies {
+				deps = append(deps, crossRepoId(repo, d))
+			}
+			for _, d := range t.CrossRepoDependencies {
+				deps = append(deps, crossRepoId(d.Repo, d.Name))
+			}
+			graph[crossRepoId(repo, name)] = deps
+		}
+	}
+	return findCyclesInGraph(graph, false)
+}
+
+// findCyclesInGraph searches for cycles in the given dependency graph, whose
+// keys are vertex names and values are the names of the vertices on which
+// they depend, and returns an error if any are found. If strict is true,
+// dependencies on vertices which are not in the graph are also errors;
+// otherwise they are ignored.
+func findCyclesInGraph(graph map[string][]string, strict bool) error {
 	// Create vertex objects with metadata for the depth-first search.
 	type vertex struct {
 		active  bool
+		deps    []string
 		name    string
-		ts      *TaskSpec
 		visited bool
 	}
-	vertices := make(map[string]*vertex, len(tasks))
-	for name, t := range tasks {
+	vertices := make(map[string]*vertex, len(graph))
+	for name, deps := range graph {
 		vertices[name] = &vertex{
 			active:  false,
+			deps:    deps,
 			name:    name,
-			ts:      t,
 			visited: false,
 		}
 	}
@@ -272,9 +548,12 @@ func findCycles(tasks map[string]*TaskSpec) error {
 	visit = func(v *vertex) error {
 		v.active = true
 		v.visited = true
-		for _, dep := range v.ts.Dependencies {
+		for _, dep := range v.deps {
 			e := vertices[dep]
 			if e == nil {
+				if !strict {
+					continue
+				}
 				return fmt.Errorf("Task %q has unknown task %q as a dependency.", v.name, dep)
 			}
 			if !e.visited {
@@ -8,6 +8,7 @@ import (
 
 	assert "github.com/stretchr/testify/require"
 	"go.skia.org/infra/go/gitinfo"
+	"go.skia.org/infra/go/swarming"
 	"go.skia.org/infra/go/testutils"
 	"go.skia.org/infra/go/util"
 )
@@ -138,3 +139,215 @@ func TestTasksCircularDependency(t *testing.T) {
 	}))
 	assert.NoError(t, err)
 }
+
+func TestTaskSpecValidateTimeoutsAndAttempts(t *testing.T) {
+	cfg := &TasksCfg{}
+	makeSpec := func() *TaskSpec {
+		return &TaskSpec{
+			CipdPackages: []*CipdPackage{},
+			Dependencies: []string{},
+			Dimensions:   []string{"os:Ubuntu"},
+			Environment:  map[string]string{},
+			ExtraArgs:    []string{},
+			Isolate:      "abc123",
+		}
+	}
+
+	// Defaults.
+	ts := makeSpec()
+	assert.NoError(t, ts.Validate(cfg))
+	assert.Equal(t, swarming.RECOMMENDED_HARD_TIMEOUT, ts.GetExecutionTimeout())
+	assert.Equal(t, swarming.RECOMMENDED_EXPIRATION, ts.GetExpiration())
+	assert.Equal(t, swarming.RECOMMENDED_IO_TIMEOUT, ts.GetIoTimeout())
+	assert.Equal(t, DEFAULT_TASK_SPEC_MAX_ATTEMPTS, ts.GetMaxAttempts())
+
+	// Overrides.
+	ts.ExecutionTimeout = 2 * time.Hour
+	ts.Expiration = 10 * time.Minute
+	ts.IoTimeout = 30 * time.Minute
+	ts.MaxAttempts = 3
+	assert.NoError(t, ts.Validate(cfg))
+	assert.Equal(t, 2*time.Hour, ts.GetExecutionTimeout())
+	assert.Equal(t, 10*time.Minute, ts.GetExpiration())
+	assert.Equal(t, 30*time.Minute, ts.GetIoTimeout())
+	assert.Equal(t, 3, ts.GetMaxAttempts())
+	testutils.AssertDeepEqual(t, ts, ts.Copy())
+
+	// Invalid values.
+	ts = makeSpec()
+	ts.ExecutionTimeout = -time.Second
+	assert.EqualError(t, ts.Validate(cfg), "Timeouts and expiration may not be negative.")
+
+	ts = makeSpec()
+	ts.ExecutionTimeout = time.Minute
+	ts.IoTimeout = 2 * time.Minute
+	assert.EqualError(t, ts.Validate(cfg), "I/O timeout (2m0s) may not exceed execution timeout (1m0s).")
+
+	// A short execution timeout doesn't require an explicit I/O timeout, the
+	// default is clamped instead.
+	ts = makeSpec()
+	ts.ExecutionTimeout = 5 * time.Minute
+	assert.NoError(t, ts.Validate(cfg))
+	assert.Equal(t, 5*time.Minute, ts.GetIoTimeout())
+
+	ts = makeSpec()
+	ts.MaxAttempts = MAX_TASK_SPEC_MAX_ATTEMPTS + 1
+	assert.Error(t, ts.Validate(cfg))
+	ts.MaxAttempts = -1
+	assert.Error(t, ts.Validate(cfg))
+}
+
+func TestParseTasksCfgTimeouts(t *testing.T) {
+	cfg, err := ParseTasksCfg(`{
+  "tasks": {
+    "a": {
+      "isolate": "abc123",
+      "execution_timeout_ns": 7200000000000,
+      "io_timeout_ns": 600000000000,
+      "max_attempts": 4
+    }
+  }
+}`)
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Hour, cfg.Tasks["a"].ExecutionTimeout)
+	assert.Equal(t, 10*time.Minute, cfg.Tasks["a"].IoTimeout)
+	assert.Equal(t, time.Duration(0), cfg.Tasks["a"].Expiration)
+	assert.Equal(t, 4, cfg.Tasks["a"].MaxAttempts)
+
+	_, err = ParseTasksCfg(`{"tasks": {"a": {"isolate": "abc123", "max_attempts": 100}}}`)
+	assert.Error(t, err)
+}
+
+func TestParseTasksCfgBotPools(t *testing.T) {
+	cfg, err := ParseTasksCfg(`{
+  "tasks": {
+    "a": {"isolate": "abc123", "priority": 0.5}
+  },
+  "bot_pools": [
+    {
+      "dimensions": ["pool:Skia", "os:Android"],
+      "max_concurrent_per_task_spec": 3,
+      "reserved": 2,
+      "reserved_min_priority": 0.8
+    }
+  ]
+}`)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(cfg.BotPools))
+	testutils.AssertDeepEqual(t, &BotPool{
+		Dimensions:               []string{"pool:Skia", "os:Android"},
+		MaxConcurrentPerTaskSpec: 3,
+		Reserved:                 2,
+		ReservedMinPriority:      0.8,
+	}, cfg.BotPools[0])
+
+	// Invalid pools.
+	for _, pool := range []string{
+		`{}`,
+		`{"dimensions": ["pool"]}`,
+		`{"dimensions": ["pool:Skia"], "max_concurrent_per_task_spec": -1}`,
+		`{"dimensions": ["pool:Skia"], "reserved": 1}`,
+		`{"dimensions": ["pool:Skia"], "reserved": 1, "reserved_min_priority": 2}`,
+	} {
+		_, err := ParseTasksCfg(`{"tasks": {}, "bot_pools": [` + pool + `]}`)
+		assert.Error(t, err, pool)
+	}
+}
+
+func TestCrossRepoDependencies(t *testing.T) {
+	// Validation.
+	cfg := &TasksCfg{}
+	ts := &TaskSpec{
+		CipdPackages: []*CipdPackage{},
+		CrossRepoDependencies: []*CrossRepoDependency{
+			&CrossRepoDependency{
+				Repo: "chromium.git",
+				Name: "Build-Linux",
+			},
+		},
+		Dependencies: []string{},
+		Dimensions:   []string{"os:Ubuntu"},
+		Environment:  map[string]string{},
+		ExtraArgs:    []string{},
+		Isolate:      "abc123",
+	}
+	assert.NoError(t, ts.Validate(cfg))
+	testutils.AssertDeepEqual(t, ts, ts.Copy())
+	ts.CrossRepoDependencies[0].Repo = ""
+	assert.EqualError(t, ts.Validate(cfg), "Cross-repo dependencies must have a repo and a name.")
+
+	// Cross-repo dependencies are not considered when parsing a single
+	// repo's tasks cfg.
+	_, err := ParseTasksCfg(`{
+  "tasks": {
+    "Perf": {
+      "cross_repo_dependencies": [{"repo": "chromium.git", "name": "Build-Linux"}],
+      "dependencies": [],
+      "isolate": "perf.isolate"
+    }
+  }
+}`)
+	assert.NoError(t, err)
+
+	makeSpec := func(deps []string, crossRepoDeps ...string) *TaskSpec {
+		rv := &TaskSpec{
+			Dependencies: deps,
+		}
+		for i := 0; i < len(crossRepoDeps); i += 2 {
+			rv.CrossRepoDependencies = append(rv.CrossRepoDependencies, &CrossRepoDependency{
+				Repo: crossRepoDeps[i],
+				Name: crossRepoDeps[i+1],
+			})
+		}
+		return rv
+	}
+
+	// No cycles.
+	assert.NoError(t, findCrossRepoCycles(map[string]map[string]*TaskSpec{
+		"a.git": map[string]*TaskSpec{
+			"build": makeSpec([]string{}),
+			"test":  makeSpec([]string{"build"}, "b.git", "build"),
+		},
+		"b.git": map[string]*TaskSpec{
+			"build": makeSpec([]string{}, "a.git", "build"),
+		},
+	}))
+
+	// Dependencies on unknown repos and TaskSpecs are ignored.
+	assert.NoError(t, findCrossRepoCycles(map[string]map[string]*TaskSpec{
+		"a.git": map[string]*TaskSpec{
+			"test": makeSpec([]string{}, "b.git", "build", "c.git", "build"),
+		},
+		"b.git": map[string]*TaskSpec{},
+	}))
+
+	// Cycle across repos.
+	err = findCrossRepoCycles(map[string]map[string]*TaskSpec{
+		"a.git": map[string]*TaskSpec{
+			"build": makeSpec([]string{}),
+			"test":  makeSpec([]string{"build"}, "b.git", "test"),
+		},
+		"b.git": map[string]*TaskSpec{
+			"build": makeSpec([]string{}),
+			"test":  makeSpec([]string{"build"}, "a.git", "test"),
+		},
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Found a circular dependency")
+
+	// Longer cycle, passing through same-repo dependencies.
+	err = findCrossRepoCycles(map[string]map[string]*TaskSpec{
+		"a.git": map[string]*TaskSpec{
+			"build": makeSpec([]string{}, "c.git", "test"),
+			"test":  makeSpec([]string{"build"}),
+		},
+		"b.git": map[string]*TaskSpec{
+			"build": makeSpec([]string{}, "a.git", "test"),
+		},
+		"c.git": map[string]*TaskSpec{
+			"build": makeSpec([]string{}, "b.git", "build"),
+			"test":  makeSpec([]string{"build"}),
+		},
+	})
+	assert.Error(t, err)
+}
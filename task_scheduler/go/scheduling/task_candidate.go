@@ -7,31 +7,41 @@ import (
 
 	swarming_api "github.com/luci/luci-go/common/api/swarming/swarming/v1"
 	"go.skia.org/infra/go/isolate"
-	"go.skia.org/infra/go/swarming"
 	"go.skia.org/infra/task_scheduler/go/db"
 )
 
 const (
 	VARIABLE_SYNTAX = "<(%s)"
 
-	VARIABLE_REPO      = "REPO"
-	VARIABLE_REVISION  = "REVISION"
-	VARIABLE_TASK_NAME = "TASK_NAME"
+	VARIABLE_ISSUE        = "ISSUE"
+	VARIABLE_ISSUE_SERVER = "ISSUE_SERVER"
+	VARIABLE_PATCHSET     = "PATCHSET"
+	VARIABLE_REPO         = "REPO"
+	VARIABLE_REVISION     = "REVISION"
+	VARIABLE_TASK_NAME    = "TASK_NAME"
 )
 
 // taskCandidate is a struct used for determining which tasks to schedule.
+// StoleFromCommits, TestednessIncrease, and TimeDecay record the components
+// of Score, for diagnostic purposes. If Patch is set, the candidate is part of
+// a try job and has no blamelist.
 type taskCandidate struct {
-	Commits        []string
-	IsolatedInput  string
-	IsolatedHashes []string
-	Name           string
-	ParentTaskIds  []string
-	Repo           string
-	RetryOf        string
-	Revision       string
-	Score          float64
-	StealingFromId string
-	TaskSpec       *TaskSpec
+	Attempt            int
+	Commits            []string
+	IsolatedInput      string
+	IsolatedHashes     []string
+	Name               string
+	ParentTaskIds      []string
+	Patch              db.Patch
+	Repo               string
+	RetryOf            string
+	Revision           string
+	Score              float64
+	StealingFromId     string
+	StoleFromCommits   int
+	TaskSpec           *TaskSpec
+	TestednessIncrease float64
+	TimeDecay          float64
 }
 
 // Copy returns a copy of the taskCandidate.
@@ -43,26 +53,41 @@ func (c *taskCandidate) Copy() *taskCandidate {
 	parentTaskIds := make([]string, len(c.ParentTaskIds))
 	copy(parentTaskIds, c.ParentTaskIds)
 	return &taskCandidate{
-		Commits:        commits,
-		IsolatedInput:  c.IsolatedInput,
-		IsolatedHashes: isolatedHashes,
-		Name:           c.Name,
-		ParentTaskIds:  parentTaskIds,
-		Repo:           c.Repo,
-		RetryOf:        c.RetryOf,
-		Revision:       c.Revision,
-		Score:          c.Score,
-		StealingFromId: c.StealingFromId,
-		TaskSpec:       c.TaskSpec.Copy(),
+		Attempt:            c.Attempt,
+		Commits:            commits,
+		IsolatedInput:      c.IsolatedInput,
+		IsolatedHashes:     isolatedHashes,
+		Name:               c.Name,
+		ParentTaskIds:      parentTaskIds,
+		Patch:              c.Patch,
+		Repo:               c.Repo,
+		RetryOf:            c.RetryOf,
+		Revision:           c.Revision,
+		Score:              c.Score,
+		StealingFromId:     c.StealingFromId,
+		StoleFromCommits:   c.StoleFromCommits,
+		TaskSpec:           c.TaskSpec.Copy(),
+		TestednessIncrease: c.TestednessIncrease,
+		TimeDecay:          c.TimeDecay,
 	}
 }
 
+// IsTryJob returns true iff the taskCandidate is part of a try job.
+func (c *taskCandidate) IsTryJob() bool {
+	return !c.Patch.Empty()
+}
+
 // MakeId generates a string ID for the taskCandidate.
 func (c *taskCandidate) MakeId() string {
+	if c.IsTryJob() {
+		return fmt.Sprintf("taskCandidate|%s|%s|%s|%s|%s|%s", c.Repo, c.Name, c.Revision, c.Patch.Server, c.Patch.Issue, c.Patch.Patchset)
+	}
 	return fmt.Sprintf("taskCandidate|%s|%s|%s", c.Repo, c.Name, c.Revision)
 }
 
-// ParseId generates taskCandidate information from the ID.
+// ParseId generates taskCandidate information from the ID. Only IDs of
+// candidates which are not try jobs may be parsed, since only those may be
+// referenced by StealingFromId.
 func parseId(id string) (string, string, string, error) {
 	split := strings.Split(id, "|")
 	if len(split) != 4 {
@@ -86,10 +111,12 @@ func (c *taskCandidate) MakeTask() *db.Task {
 	parentTaskIds := make([]string, len(c.ParentTaskIds))
 	copy(parentTaskIds, c.ParentTaskIds)
 	return &db.Task{
+		Attempt:       c.Attempt,
 		Commits:       commits,
 		Id:            "", // Filled in when the task is inserted into the DB.
 		Name:          c.Name,
 		ParentTaskIds: parentTaskIds,
+		Patch:         c.Patch,
 		Repo:          c.Repo,
 		RetryOf:       c.RetryOf,
 		Revision:      c.Revision,
@@ -110,9 +137,12 @@ func (c *taskCandidate) MakeIsolateTask(infraBotsDir, baseDir string) *isolate.T
 // replaceVars replaces variable names with their values in a given string.
 func replaceVars(c *taskCandidate, s string) string {
 	replacements := map[string]string{
-		VARIABLE_REPO:      c.Repo,
-		VARIABLE_REVISION:  c.Revision,
-		VARIABLE_TASK_NAME: c.Name,
+		VARIABLE_ISSUE:        c.Patch.Issue,
+		VARIABLE_ISSUE_SERVER: c.Patch.Server,
+		VARIABLE_PATCHSET:     c.Patch.Patchset,
+		VARIABLE_REPO:         c.Repo,
+		VARIABLE_REVISION:     c.Revision,
+		VARIABLE_TASK_NAME:    c.Name,
 	}
 	for k, v := range replacements {
 		s = strings.Replace(s, fmt.Sprintf(VARIABLE_SYNTAX, k), v, -1)
@@ -166,34 +196,41 @@ func (c *taskCandidate) MakeTaskRequest(id string) *swarming_api.SwarmingRpcsNew
 	}
 
 	return &swarming_api.SwarmingRpcsNewTaskRequest{
-		ExpirationSecs: int64(swarming.RECOMMENDED_EXPIRATION.Seconds()),
+		ExpirationSecs: int64(c.TaskSpec.GetExpiration().Seconds()),
 		Name:           c.Name,
 		Priority:       int64(100.0 * c.TaskSpec.Priority),
 		Properties: &swarming_api.SwarmingRpcsTaskProperties{
 			CipdInput:            cipdInput,
 			Dimensions:           dims,
 			Env:                  env,
-			ExecutionTimeoutSecs: int64(swarming.RECOMMENDED_HARD_TIMEOUT.Seconds()),
+			ExecutionTimeoutSecs: int64(c.TaskSpec.GetExecutionTimeout().Seconds()),
 			ExtraArgs:            extraArgs,
 			InputsRef: &swarming_api.SwarmingRpcsFilesRef{
 				Isolated:       c.IsolatedInput,
 				Isolatedserver: isolate.ISOLATE_SERVER_URL,
 				Namespace:      isolate.DEFAULT_NAMESPACE,
 			},
-			IoTimeoutSecs: int64(swarming.RECOMMENDED_IO_TIMEOUT.Seconds()),
+			IoTimeoutSecs: int64(c.TaskSpec.GetIoTimeout().Seconds()),
 		},
-		Tags: db.TagsForTask(c.Name, id, c.TaskSpec.Priority, c.Repo, c.RetryOf, c.Revision, dimsMap, c.ParentTaskIds),
+		Tags: db.TagsForTask(c.Name, id, c.TaskSpec.Priority, c.Repo, c.RetryOf, c.Revision, c.Patch, dimsMap, c.ParentTaskIds),
 		User: "skia-task-scheduler",
 	}
 }
 
 // allDepsMet determines whether all dependencies for the given task candidate
 // have been satisfied, and if so, returns a map of whose keys are task IDs and
-// values are their isolated outputs.
+// values are their isolated outputs. The dependencies of a try job must be
+// satisfied by tasks with the same patch.
 func (c *taskCandidate) allDepsMet(cache db.TaskCache) (bool, map[string]string, error) {
 	rv := make(map[string]string, len(c.TaskSpec.Dependencies))
 	for _, depName := range c.TaskSpec.Dependencies {
-		d, err := cache.GetTaskForCommit(c.Repo, c.Revision, depName)
+		var d *db.Task
+		var err error
+		if c.IsTryJob() {
+			d, err = cache.GetTaskForPatch(c.Repo, c.Revision, c.Patch, depName)
+		} else {
+			d, err = cache.GetTaskForCommit(c.Repo, c.Revision, depName)
+		}
 		if err != nil {
 			return false, nil, err
 		}
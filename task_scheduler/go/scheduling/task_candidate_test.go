@@ -2,8 +2,11 @@ package scheduling
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"go.skia.org/infra/go/swarming"
+	"go.skia.org/infra/task_scheduler/go/db"
 )
 
 func TestTaskCandidateId(t *testing.T) {
@@ -17,6 +20,15 @@ func TestTaskCandidateId(t *testing.T) {
 	assert.Equal(t, t1.Name, name1)
 	assert.Equal(t, t1.Revision, rev1)
 
+	// Try job candidates have distinct IDs.
+	t2 := t1.Copy()
+	t2.Patch = db.Patch{
+		Server:   "https://codereview.chromium.org",
+		Issue:    "123",
+		Patchset: "1",
+	}
+	assert.NotEqual(t, id1, t2.MakeId())
+
 	badIds := []string{
 		"",
 		"taskCandidate|a|b|",
@@ -39,4 +51,30 @@ func TestReplaceVar(t *testing.T) {
 	assert.Equal(t, "abc123", replaceVars(c, "<(REVISION)"))
 	assert.Equal(t, "<(REVISION", replaceVars(c, "<(REVISION"))
 	assert.Equal(t, "my-repo_my-task_abc123", replaceVars(c, "<(REPO)_<(TASK_NAME)_<(REVISION)"))
+	assert.Equal(t, "", replaceVars(c, "<(ISSUE)"))
+	c.Patch = db.Patch{
+		Server:   "https://codereview.chromium.org",
+		Issue:    "123",
+		Patchset: "4",
+	}
+	assert.Equal(t, "https://codereview.chromium.org/123/4", replaceVars(c, "<(ISSUE_SERVER)/<(ISSUE)/<(PATCHSET)"))
+}
+
+func TestMakeTaskRequestTimeouts(t *testing.T) {
+	c := makeTaskCandidate("c", []string{"k:v"})
+
+	// Use the Swarming defaults if the TaskSpec doesn't specify timeouts.
+	req := c.MakeTaskRequest("id")
+	assert.Equal(t, int64(swarming.RECOMMENDED_EXPIRATION.Seconds()), req.ExpirationSecs)
+	assert.Equal(t, int64(swarming.RECOMMENDED_HARD_TIMEOUT.Seconds()), req.Properties.ExecutionTimeoutSecs)
+	assert.Equal(t, int64(swarming.RECOMMENDED_IO_TIMEOUT.Seconds()), req.Properties.IoTimeoutSecs)
+
+	// Use the TaskSpec values if specified.
+	c.TaskSpec.ExecutionTimeout = 3 * time.Hour
+	c.TaskSpec.Expiration = 20 * time.Minute
+	c.TaskSpec.IoTimeout = 40 * time.Minute
+	req = c.MakeTaskRequest("id")
+	assert.Equal(t, int64(20*60), req.ExpirationSecs)
+	assert.Equal(t, int64(3*60*60), req.Properties.ExecutionTimeoutSecs)
+	assert.Equal(t, int64(40*60), req.Properties.IoTimeoutSecs)
 }
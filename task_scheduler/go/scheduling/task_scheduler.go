@@ -39,7 +39,10 @@ var (
 // TaskScheduler is a struct used for scheduling tasks on bots.
 type TaskScheduler struct {
 	bl               *blacklist.Blacklist
+	botPools         []*BotPool // protected by queueMtx.
 	isolate          *isolate.Client
+	jCache           db.JobCache
+	jobDB            db.JobDB
 	lastScheduled    time.Time // protected by queueMtx.
 	period           time.Duration
 	queue            []*taskCandidate // protected by queueMtx.
@@ -49,6 +52,7 @@ type TaskScheduler struct {
 	recentTaskSpecs  []string // protected by recentMtx.
 	repoMap          *gitinfo.RepoMap
 	repos            map[string]*gitrepo.Repo
+	starved          map[string]*starvedTaskSpec // protected by queueMtx.
 	swarming         swarming.ApiClient
 	taskCfgCache     *taskCfgCache
 	taskDB           db.TaskDB
@@ -57,12 +61,17 @@ type TaskScheduler struct {
 	workdir          string
 }
 
-func NewTaskScheduler(taskDB db.TaskDB, tCache db.TaskCache, period time.Duration, workdir string, repoNames []string, isolateClient *isolate.Client, swarmingClient swarming.ApiClient, timeDecayAmt24Hr float64) (*TaskScheduler, error) {
+func NewTaskScheduler(d db.DB, tCache db.TaskCache, period time.Duration, workdir string, repoNames []string, isolateClient *isolate.Client, swarmingClient swarming.ApiClient, timeDecayAmt24Hr float64) (*TaskScheduler, error) {
 	bl, err := blacklist.FromFile(path.Join(workdir, "blacklist.json"))
 	if err != nil {
 		return nil, err
 	}
 
+	jCache, err := db.NewJobCache(d, period)
+	if err != nil {
+		return nil, err
+	}
+
 	repos := make(map[string]*gitrepo.Repo, len(repoNames))
 	rm := gitinfo.NewRepoMap(workdir)
 	for _, r := range repoNames {
@@ -78,14 +87,17 @@ func NewTaskScheduler(taskDB db.TaskDB, tCache db.TaskCache, period time.Duratio
 	s := &TaskScheduler{
 		bl:               bl,
 		isolate:          isolateClient,
+		jCache:           jCache,
+		jobDB:            d,
 		period:           period,
 		queue:            []*taskCandidate{},
 		queueMtx:         sync.RWMutex{},
 		repoMap:          rm,
 		repos:            repos,
+		starved:          map[string]*starvedTaskSpec{},
 		swarming:         swarmingClient,
 		taskCfgCache:     newTaskCfgCache(rm),
-		taskDB:           taskDB,
+		taskDB:           d,
 		tCache:           tCache,
 		timeDecayAmt24Hr: timeDecayAmt24Hr,
 		workdir:          workdir,
@@ -149,6 +161,149 @@ func (s *TaskScheduler) Trigger(repo, commit, taskSpec string) error {
 	return fmt.Errorf("TaskScheduler.Trigger not implemented.")
 }
 
+// CancelJob marks the given Job as canceled, which prevents the TaskScheduler
+// from scheduling any of its remaining tasks, and cancels any of its pending
+// or running Swarming tasks which are not needed by other unfinished Jobs.
+func (s *TaskScheduler) CancelJob(id string) (*db.Job, error) {
+	job, err := db.UpdateJobWithRetries(s.jobDB, id, func(j *db.Job) error {
+		if j.Done() {
+			return fmt.Errorf("Job %s is already finished with status %q.", j.Id, j.Status)
+		}
+		j.Status = db.JOB_STATUS_CANCELED
+		j.Finished = time.Now()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.jCache.Update(); err != nil {
+		return nil, err
+	}
+
+	// Find the tasks which are no longer needed.
+	specs, err := s.taskCfgCache.GetTaskSpecsForCommits(map[string][]string{job.Repo: []string{job.Revision}})
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*db.Job
+	if job.IsTryJob() {
+		jobs, err = s.jCache.GetJobsForPatch(job.Repo, job.Revision, job.Patch)
+	} else {
+		jobs, err = s.jCache.GetJobsForCommit(job.Repo, job.Revision)
+	}
+	if err != nil {
+		return nil, err
+	}
+	canceled, _ := jobRequirements(jobs, specs[job.Repo][job.Revision])
+
+	// Cancel the corresponding Swarming tasks. The tasks themselves will be
+	// updated by updateUnfinishedTasks.
+	for name, _ := range canceled {
+		var task *db.Task
+		if job.IsTryJob() {
+			task, err = s.tCache.GetTaskForPatch(job.Repo, job.Revision, job.Patch, name)
+		} else {
+			task, err = s.tCache.GetTaskForCommit(job.Repo, job.Revision, name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if task == nil || task.Revision != job.Revision || task.Done() || task.SwarmingTaskId == "" {
+			continue
+		}
+		glog.Infof("Canceling task %s (%s @ %s) for canceled job %s", task.Id, task.Name, task.Revision, job.Id)
+		if err := s.swarming.CancelTask(task.SwarmingTaskId); err != nil {
+			return nil, fmt.Errorf("Canceled job %s but failed to cancel Swarming task %s: %s", job.Id, task.SwarmingTaskId, err)
+		}
+	}
+	return job, nil
+}
+
+// RetryJob creates a new Job which re-runs the given unsuccessfully-finished
+// Job. The TaskScheduler will re-run any of the new Job's tasks which did not
+// succeed before the new Job was created, regardless of their retry limits.
+func (s *TaskScheduler) RetryJob(id string) (*db.Job, error) {
+	old, err := s.jobDB.GetJobById(id)
+	if err != nil {
+		return nil, err
+	}
+	if old == nil {
+		return nil, db.ErrNotFound
+	}
+	if !old.Retryable() {
+		return nil, fmt.Errorf("Job %s has status %q and cannot be retried.", old.Id, old.Status)
+	}
+	deps := make([]string, len(old.Dependencies))
+	copy(deps, old.Dependencies)
+	job := &db.Job{
+		Created:      time.Now(),
+		Dependencies: deps,
+		Name:         old.Name,
+		Patch:        old.Patch,
+		Priority:     old.Priority,
+		Repo:         old.Repo,
+		RetryOf:      old.Id,
+		Revision:     old.Revision,
+	}
+	if err := s.jobDB.PutJob(job); err != nil {
+		return nil, err
+	}
+	if err := s.jCache.Update(); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// taskSpecClosure returns the set containing the given TaskSpec names and all
+// of their transitive dependencies.
+func taskSpecClosure(names []string, specs map[string]*TaskSpec) util.StringSet {
+	rv := util.StringSet{}
+	var visit func(string)
+	visit = func(name string) {
+		if rv[name] {
+			return
+		}
+		rv[name] = true
+		if spec, ok := specs[name]; ok {
+			for _, d := range spec.Dependencies {
+				visit(d)
+			}
+		}
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	return rv
+}
+
+// jobRequirements examines the given Jobs, which must all be at the same
+// commit, whose TaskSpecs are given. Returns the set of TaskSpec names which
+// are needed only by canceled Jobs and should therefore not be scheduled, and
+// a map of TaskSpec name to the creation time of the most recent unfinished
+// retry Job which needs it. Tasks which did not succeed and were created
+// before that time should be re-run.
+func jobRequirements(jobs []*db.Job, specs map[string]*TaskSpec) (util.StringSet, map[string]time.Time) {
+	canceled := util.StringSet{}
+	needed := util.StringSet{}
+	retries := map[string]time.Time{}
+	for _, j := range jobs {
+		deps := taskSpecClosure(j.Dependencies, specs)
+		if j.Status == db.JOB_STATUS_CANCELED {
+			canceled = canceled.Union(deps)
+		} else if !j.Done() {
+			needed = needed.Union(deps)
+			if j.RetryOf != "" {
+				for d, _ := range deps {
+					if j.Created.After(retries[d]) {
+						retries[d] = j.Created
+					}
+				}
+			}
+		}
+	}
+	return canceled.Complement(needed), retries
+}
+
 // ComputeBlamelist computes the blamelist for a new task, specified by name,
 // repo, and revision. Returns the list of commits covered by the task, and any
 // previous task which part or all of the blamelist was "stolen" from (see
@@ -283,10 +438,19 @@ func (s *TaskScheduler) findTaskCandidates(commitsByRepo map[string][]string) (m
 	for repo, commits := range specs {
 		for commit, tasks := range commits {
 			recentCommits = append(recentCommits, commit)
+			jobs, err := s.jCache.GetJobsForCommit(repo, commit)
+			if err != nil {
+				return nil, err
+			}
+			canceled, retries := jobRequirements(jobs, tasks)
 			for name, task := range tasks {
 				recentTaskSpecsMap[name] = true
-				if rule := s.bl.MatchRule(name, commit); rule != "" {
-					glog.Warningf("Skipping blacklisted task candidate: %s @ %s due to rule %q", name, commit, rule)
+				if rule, reason := s.bl.MatchRule(name, commit, task.Dimensions); rule != "" {
+					glog.Warningf("Skipping blacklisted task candidate: %s @ %s due to rule %q: %s", name, commit, rule, reason)
+					continue
+				}
+				if canceled[name] {
+					glog.Infof("Skipping task candidate: %s @ %s; all jobs which need it have been canceled.", name, commit)
 					continue
 				}
 				c := &taskCandidate{
@@ -297,43 +461,17 @@ func (s *TaskScheduler) findTaskCandidates(commitsByRepo map[string][]string) (m
 					Score:          0.0,
 					TaskSpec:       task,
 				}
-				// We shouldn't duplicate pending, in-progress,
-				// or successfully completed tasks.
 				previous, err := s.tCache.GetTaskForCommit(c.Repo, c.Revision, c.Name)
 				if err != nil {
 					return nil, err
 				}
-				if previous != nil && previous.Revision == c.Revision {
-					if previous.Status == db.TASK_STATUS_PENDING || previous.Status == db.TASK_STATUS_RUNNING {
-						continue
-					}
-					if previous.Success() {
-						continue
-					}
-					// Only retry a task once.
-					if previous.RetryOf != "" {
-						continue
-					}
-					c.RetryOf = previous.Id
-				}
-
-				// Don't consider candidates whose dependencies are not met.
-				depsMet, idsToHashes, err := c.allDepsMet(s.tCache)
+				ok, err := s.filterCandidate(c, previous, retries[name], commitsByRepo)
 				if err != nil {
 					return nil, err
 				}
-				if !depsMet {
+				if !ok {
 					continue
 				}
-				hashes := make([]string, 0, len(idsToHashes))
-				parentTaskIds := make([]string, 0, len(idsToHashes))
-				for id, hash := range idsToHashes {
-					hashes = append(hashes, hash)
-					parentTaskIds = append(parentTaskIds, id)
-				}
-				c.IsolatedHashes = hashes
-				sort.Strings(parentTaskIds)
-				c.ParentTaskIds = parentTaskIds
 
 				key := fmt.Sprintf("%s|%s", c.Repo, c.Name)
 				candidates, ok := bySpec[key]
@@ -357,6 +495,141 @@ func (s *TaskScheduler) findTaskCandidates(commitsByRepo map[string][]string) (m
 	return bySpec, nil
 }
 
+// filterCandidate determines whether the given candidate should run, given
+// the most recent previous task for the same TaskSpec at the same commit (and
+// patch, if any), and the creation time of the most recent retry Job which
+// needs the candidate. If the candidate should run, sets its retry and
+// dependency information and returns true.
+func (s *TaskScheduler) filterCandidate(c *taskCandidate, previous *db.Task, retryAfter time.Time, commitsByRepo map[string][]string) (bool, error) {
+	// We shouldn't duplicate pending, in-progress,
+	// or successfully completed tasks.
+	if previous != nil && previous.Revision == c.Revision {
+		if previous.Status == db.TASK_STATUS_PENDING || previous.Status == db.TASK_STATUS_RUNNING {
+			return false, nil
+		}
+		if previous.Success() {
+			return false, nil
+		}
+		// Retry Jobs force a re-run of tasks which
+		// did not succeed before the Job was created.
+		forced := previous.Created.Before(retryAfter)
+		if !forced && !shouldRetry(previous, c.TaskSpec) {
+			return false, nil
+		}
+		c.Attempt = previous.Attempt + 1
+		c.RetryOf = previous.Id
+	}
+
+	// Don't consider candidates whose dependencies are not met.
+	depsMet, idsToHashes, err := c.allDepsMet(s.tCache)
+	if err != nil {
+		return false, err
+	}
+	if !depsMet {
+		return false, nil
+	}
+	crossRepoDepsMet, crossRepoIdsToHashes, err := s.crossRepoDepsMet(c, commitsByRepo)
+	if err != nil {
+		return false, err
+	}
+	if !crossRepoDepsMet {
+		return false, nil
+	}
+	for id, hash := range crossRepoIdsToHashes {
+		idsToHashes[id] = hash
+	}
+	hashes := make([]string, 0, len(idsToHashes))
+	parentTaskIds := make([]string, 0, len(idsToHashes))
+	for id, hash := range idsToHashes {
+		hashes = append(hashes, hash)
+		parentTaskIds = append(parentTaskIds, id)
+	}
+	c.IsolatedHashes = hashes
+	sort.Strings(parentTaskIds)
+	c.ParentTaskIds = parentTaskIds
+	return true, nil
+}
+
+// crossRepoDepsMet determines whether all cross-repo dependencies for the given
+// task candidate have been satisfied, and if so, returns a map whose keys are
+// task IDs and values are their isolated outputs. Each dependency is satisfied
+// by the successful task for the upstream TaskSpec at the most recent commit
+// in the upstream repo which is not newer than the candidate's commit, within
+// the given commits-by-repo.
+func (s *TaskScheduler) crossRepoDepsMet(c *taskCandidate, commitsByRepo map[string][]string) (bool, map[string]string, error) {
+	rv := make(map[string]string, len(c.TaskSpec.CrossRepoDependencies))
+	if len(c.TaskSpec.CrossRepoDependencies) == 0 {
+		return true, rv, nil
+	}
+	repo, err := s.repoMap.Repo(c.Repo)
+	if err != nil {
+		return false, nil, err
+	}
+	ts := repo.Timestamp(c.Revision)
+	for _, dep := range c.TaskSpec.CrossRepoDependencies {
+		commits, ok := commitsByRepo[dep.Repo]
+		if !ok {
+			glog.Warningf("Task %s in %s has a dependency on %s in unknown repo %s", c.Name, c.Repo, dep.Name, dep.Repo)
+			return false, nil, nil
+		}
+		upstream, err := s.repoMap.Repo(dep.Repo)
+		if err != nil {
+			return false, nil, err
+		}
+		// Sort the upstream commits, newest first.
+		sorted := make([]string, len(commits))
+		copy(sorted, commits)
+		sort.Sort(sort.Reverse(commitsByTimestamp{sorted, upstream}))
+		var found *db.Task
+		for _, commit := range sorted {
+			if upstream.Timestamp(commit).After(ts) {
+				continue
+			}
+			t, err := s.tCache.GetTaskForCommit(dep.Repo, commit, dep.Name)
+			if err != nil {
+				return false, nil, err
+			}
+			if t != nil && t.Revision == commit && t.Done() && t.Success() && t.IsolatedOutput != "" {
+				found = t
+				break
+			}
+		}
+		if found == nil {
+			return false, nil, nil
+		}
+		rv[found.Id] = found.IsolatedOutput
+	}
+	return true, rv, nil
+}
+
+// commitsByTimestamp is a struct used for sorting commit hashes by timestamp.
+type commitsByTimestamp struct {
+	commits []string
+	repo    *gitinfo.GitInfo
+}
+
+func (s commitsByTimestamp) Len() int { return len(s.commits) }
+func (s commitsByTimestamp) Swap(i, j int) {
+	s.commits[i], s.commits[j] = s.commits[j], s.commits[i]
+}
+func (s commitsByTimestamp) Less(i, j int) bool {
+	return s.repo.Timestamp(s.commits[i]).Before(s.repo.Timestamp(s.commits[j]))
+}
+
+// shouldRetry determines whether the given finished, unsuccessful task should
+// be retried at the same commit. Tasks which failed are retried only once, to
+// detect flakiness. Tasks which experienced a mishap, eg. a bot died or the
+// task expired, are retried until the TaskSpec's MaxAttempts is exhausted.
+func shouldRetry(previous *db.Task, spec *TaskSpec) bool {
+	if previous.Attempt+1 >= spec.GetMaxAttempts() {
+		return false
+	}
+	if previous.Status == db.TASK_STATUS_MISHAP {
+		return true
+	}
+	return previous.RetryOf == ""
+}
+
 // processTaskCandidate computes the remaining information about the task
 // candidate, eg. blamelists and scoring.
 func (s *TaskScheduler) processTaskCandidate(c *taskCandidate, now time.Time, cache *cacheWrapper, commitsBuf []*gitrepo.Commit) error {
@@ -382,6 +655,8 @@ func (s *TaskScheduler) processTaskCandidate(c *taskCandidate, now time.Time, ca
 		stoleFromCommits = len(stealingFrom.Commits)
 	}
 	score := testednessIncrease(len(c.Commits), stoleFromCommits)
+	c.StoleFromCommits = stoleFromCommits
+	c.TestednessIncrease = score
 
 	// Scale the score by other factors, eg. time decay.
 	decay, err := s.timeDecayForCommit(now, c.Repo, c.Revision)
@@ -389,6 +664,7 @@ func (s *TaskScheduler) processTaskCandidate(c *taskCandidate, now time.Time, ca
 		return err
 	}
 	score *= decay
+	c.TimeDecay = decay
 
 	c.Score = score
 	return nil
@@ -424,6 +700,10 @@ func (s *TaskScheduler) regenerateTaskQueue() error {
 		}
 		commits[repoName] = repo.From(from)
 	}
+	pools, err := s.taskCfgCache.GetBotPools(commits)
+	if err != nil {
+		return err
+	}
 
 	// Find and process task candidates.
 	candidates, err := s.findTaskCandidates(commits)
@@ -513,19 +793,29 @@ func (s *TaskScheduler) regenerateTaskQueue() error {
 	if len(rvErrs) != 0 {
 		return rvErrs[0]
 	}
+
+	// Try job candidates have no blamelists, so they don't need processing.
+	tryCandidates, err := s.findTryJobCandidates(now, commits)
+	if err != nil {
+		return err
+	}
+	rvCandidates = append(rvCandidates, tryCandidates...)
 	sort.Sort(taskCandidateSlice(rvCandidates))
 
 	s.queueMtx.Lock()
 	defer s.queueMtx.Unlock()
 	s.lastScheduled = time.Now()
 	s.queue = rvCandidates
+	s.botPools = pools
 	return nil
 }
 
 // getCandidatesToSchedule matches the list of free Swarming bots to task
-// candidates in the queue and returns the candidates which should be run.
+// candidates in the queue and returns the candidates which should be run,
+// along with the candidates which starved, ie. which matched free bots but
+// were not scheduled because of the limits imposed by the given poolLimiter.
 // Assumes that the tasks are sorted in decreasing order by score.
-func getCandidatesToSchedule(bots []*swarming_api.SwarmingRpcsBotInfo, tasks []*taskCandidate) []*taskCandidate {
+func getCandidatesToSchedule(bots []*swarming_api.SwarmingRpcsBotInfo, tasks []*taskCandidate, limiter *poolLimiter) ([]*taskCandidate, []*taskCandidate) {
 	defer timer.New("scheduling.getCandidatesToSchedule").Stop()
 	// Create a bots-by-swarming-dimension mapping.
 	botsByDim := map[string]util.StringSet{}
@@ -546,6 +836,7 @@ func getCandidatesToSchedule(bots []*swarming_api.SwarmingRpcsBotInfo, tasks []*
 	// match so that less-specialized tasks don't "steal" more-specialized
 	// bots which they don't actually need.
 	rv := make([]*taskCandidate, 0, len(bots))
+	starved := []*taskCandidate{}
 	for _, c := range tasks {
 		// For each dimension of the task, find the set of bots which matches.
 		matches := util.StringSet{}
@@ -557,14 +848,25 @@ func getCandidatesToSchedule(bots []*swarming_api.SwarmingRpcsBotInfo, tasks []*
 			}
 		}
 		if len(matches) > 0 {
-			// We're going to run this task. Choose a bot. Sort the
+			// Choose a bot which the task is allowed to use. Sort the
 			// bots by ID so that the choice is deterministic.
 			choices := make([]string, 0, len(matches))
 			for botId, _ := range matches {
 				choices = append(choices, botId)
 			}
 			sort.Strings(choices)
-			bot := choices[0]
+			bot := ""
+			for _, choice := range choices {
+				if limiter.allowed(c, choice) {
+					bot = choice
+					break
+				}
+			}
+			if bot == "" {
+				starved = append(starved, c)
+				continue
+			}
+			limiter.assign(c, bot)
 
 			// Remove the bot from consideration.
 			for dim, subset := range botsByDim {
@@ -587,7 +889,7 @@ func getCandidatesToSchedule(bots []*swarming_api.SwarmingRpcsBotInfo, tasks []*
 		}
 	}
 	sort.Sort(taskCandidateSlice(rv))
-	return rv
+	return rv, starved
 }
 
 // tempGitRepo creates a git repository in a temporary directory and returns its
@@ -625,22 +927,29 @@ func (s *TaskScheduler) tempGitRepo(repoUrl, subdir string) (string, error) {
 func (s *TaskScheduler) scheduleTasks() error {
 	defer timer.New("TaskScheduler.scheduleTasks").Stop()
 	// Find free bots, match them with tasks.
-	bots, err := getFreeSwarmingBots(s.swarming)
+	bots, busy, err := getSwarmingBots(s.swarming)
+	if err != nil {
+		return err
+	}
+	specsByBot, err := getTaskSpecsByBot(s.tCache, busy)
 	if err != nil {
 		return err
 	}
 	s.queueMtx.Lock()
 	defer s.queueMtx.Unlock()
-	schedule := getCandidatesToSchedule(bots, s.queue)
+	limiter := newPoolLimiter(s.botPools, bots, busy, specsByBot)
+	schedule, starved := getCandidatesToSchedule(bots, s.queue, limiter)
+	s.updateStarvation(starved, time.Now())
 
-	// First, group by commit hash since we have to isolate the code at
-	// a particular revision for each task.
-	byRepoCommit := map[string]map[string][]*taskCandidate{}
+	// First, group by commit hash and patch since we have to isolate the
+	// code at a particular revision, with any patch applied, for each task.
+	byRepoCommit := map[string]map[tryJobKey][]*taskCandidate{}
 	for _, c := range schedule {
+		k := tryJobKey{c.Repo, c.Revision, c.Patch}
 		if mRepo, ok := byRepoCommit[c.Repo]; !ok {
-			byRepoCommit[c.Repo] = map[string][]*taskCandidate{c.Revision: []*taskCandidate{c}}
+			byRepoCommit[c.Repo] = map[tryJobKey][]*taskCandidate{k: []*taskCandidate{c}}
 		} else {
-			mRepo[c.Revision] = append(mRepo[c.Revision], c)
+			mRepo[k] = append(mRepo[k], c)
 		}
 	}
 
@@ -655,10 +964,24 @@ func (s *TaskScheduler) scheduleTasks() error {
 		}
 		defer util.RemoveAll(repoDir)
 		infraBotsDir := path.Join(repoDir, "infra", "bots")
-		for commit, candidates := range commits {
-			if _, err := exec.RunCwd(repoDir, "git", "checkout", commit); err != nil {
+		for k, candidates := range commits {
+			if _, err := exec.RunCwd(repoDir, "git", "checkout", k.revision); err != nil {
 				return err
 			}
+			if !k.patch.Empty() {
+				// A patch which fails to apply only affects its own
+				// try jobs; their candidates are not triggered.
+				if err := applyPatch(repoDir, repoName, k.patch); err != nil {
+					glog.Errorf("Failed to apply patch %s to %s @ %s: %s", k.patch, repoName, k.revision, err)
+					if err := resetCheckout(repoDir); err != nil {
+						return err
+					}
+					if err := s.failTryJobs(k); err != nil {
+						return err
+					}
+					continue
+				}
+			}
 			tasks := make([]*isolate.Task, 0, len(candidates))
 			for _, c := range candidates {
 				tasks = append(tasks, c.MakeIsolateTask(infraBotsDir, s.workdir))
@@ -673,9 +996,23 @@ func (s *TaskScheduler) scheduleTasks() error {
 			for i, c := range candidates {
 				c.IsolatedInput = hashes[i]
 			}
+			if !k.patch.Empty() {
+				if err := resetCheckout(repoDir); err != nil {
+					return err
+				}
+			}
 		}
 	}
 
+	// Drop any candidates which could not be isolated.
+	isolated := make([]*taskCandidate, 0, len(schedule))
+	for _, c := range schedule {
+		if c.IsolatedInput != "" {
+			isolated = append(isolated, c)
+		}
+	}
+	schedule = isolated
+
 	// Trigger tasks.
 	byCandidateId := make(map[string]*db.Task, len(schedule))
 	tasksToInsert := make(map[string]*db.Task, len(schedule)*2)
@@ -803,10 +1140,20 @@ func (s *TaskScheduler) MainLoop() error {
 		return e2
 	}
 
-	// Update the task cache.
+	// Update the task and job caches.
 	if err := s.tCache.Update(); err != nil {
 		return err
 	}
+	if err := s.jCache.Update(); err != nil {
+		return err
+	}
+
+	// Remove any expired blacklist rules.
+	if removed, err := s.bl.RemoveExpiredRules(time.Now()); err != nil {
+		glog.Errorf("Failed to remove expired blacklist rules: %s", err)
+	} else if len(removed) > 0 {
+		glog.Infof("Removed expired blacklist rules: %v", removed)
+	}
 
 	// Regenerate the queue, schedule tasks.
 	// TODO(borenet): Query for free Swarming bots while we're regenerating
@@ -950,13 +1297,15 @@ func testednessIncrease(blamelistLength, stoleFromBlamelistLength int) float64 {
 	}
 }
 
-// getFreeSwarmingBots returns a slice of free swarming bots.
-func getFreeSwarmingBots(s swarming.ApiClient) ([]*swarming_api.SwarmingRpcsBotInfo, error) {
+// getSwarmingBots returns slices of the free and busy swarming bots. Dead
+// and quarantined bots are not included.
+func getSwarmingBots(s swarming.ApiClient) ([]*swarming_api.SwarmingRpcsBotInfo, []*swarming_api.SwarmingRpcsBotInfo, error) {
 	bots, err := s.ListSkiaBots()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	rv := make([]*swarming_api.SwarmingRpcsBotInfo, 0, len(bots))
+	free := make([]*swarming_api.SwarmingRpcsBotInfo, 0, len(bots))
+	busy := make([]*swarming_api.SwarmingRpcsBotInfo, 0, len(bots))
 	for _, bot := range bots {
 		if bot.IsDead {
 			continue
@@ -965,9 +1314,44 @@ func getFreeSwarmingBots(s swarming.ApiClient) ([]*swarming_api.SwarmingRpcsBotI
 			continue
 		}
 		if bot.TaskId != "" {
-			continue
+			busy = append(busy, bot)
+		} else {
+			free = append(free, bot)
+		}
+	}
+	return free, busy, nil
+}
+
+// swarmingRequestId returns the Swarming task request ID for the given ID,
+// which may be either a request ID or a run ID. Swarming run IDs are the
+// request ID with the last digit, which is always 0, replaced by the number
+// of the try.
+func swarmingRequestId(id string) string {
+	if id == "" {
+		return id
+	}
+	return id[:len(id)-1] + "0"
+}
+
+// getTaskSpecsByBot returns a map of the IDs of the given busy bots to the
+// crossRepoId of the TaskSpec whose task they are running. Bots which are
+// running tasks not found in the cache are not included.
+func getTaskSpecsByBot(cache db.TaskCache, busy []*swarming_api.SwarmingRpcsBotInfo) (map[string]string, error) {
+	tasks, err := cache.UnfinishedTasks()
+	if err != nil {
+		return nil, err
+	}
+	// Tasks store the request ID of the Swarming task, while bots report the
+	// run ID of the task they are running.
+	bySwarmingId := make(map[string]*db.Task, len(tasks))
+	for _, t := range tasks {
+		bySwarmingId[swarmingRequestId(t.SwarmingTaskId)] = t
+	}
+	rv := make(map[string]string, len(busy))
+	for _, b := range busy {
+		if t, ok := bySwarmingId[swarmingRequestId(b.TaskId)]; ok {
+			rv[b.BotId] = crossRepoId(t.Repo, t.Name)
 		}
-		rv = append(rv, bot)
 	}
 	return rv, nil
 }
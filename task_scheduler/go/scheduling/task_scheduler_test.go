@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"path/filepath"
@@ -21,6 +23,7 @@ import (
 	"go.skia.org/infra/go/gitinfo"
 	"go.skia.org/infra/go/gitrepo"
 	"go.skia.org/infra/go/isolate"
+	"go.skia.org/infra/go/metrics2"
 	"go.skia.org/infra/go/swarming"
 	"go.skia.org/infra/go/testutils"
 	"go.skia.org/infra/go/util"
@@ -93,6 +96,12 @@ func makeSwarmingRpcsTaskRequestMetadata(t *testing.T, task *db.Task) *swarming_
 	for _, p := range task.ParentTaskIds {
 		tags = append(tags, tag(db.SWARMING_TAG_PARENT_TASK_ID, p))
 	}
+	if task.IsTryJob() {
+		tags = append(tags,
+			tag(db.SWARMING_TAG_ISSUE_SERVER, task.Patch.Server),
+			tag(db.SWARMING_TAG_ISSUE, task.Patch.Issue),
+			tag(db.SWARMING_TAG_PATCHSET, task.Patch.Patchset))
+	}
 
 	return &swarming_api.SwarmingRpcsTaskRequestMetadata{
 		Request: &swarming_api.SwarmingRpcsTaskRequest{
@@ -116,10 +125,10 @@ func makeSwarmingRpcsTaskRequestMetadata(t *testing.T, task *db.Task) *swarming_
 }
 
 // Common setup for TaskScheduler tests.
-func setup(t *testing.T) (*util.TempRepo, db.TaskDB, db.TaskCache, *gitinfo.RepoMap, *gitinfo.GitInfo, *swarming.TestClient, *TaskScheduler) {
+func setup(t *testing.T) (*util.TempRepo, db.DB, db.TaskCache, *gitinfo.RepoMap, *gitinfo.GitInfo, *swarming.TestClient, *TaskScheduler) {
 	testutils.SkipIfShort(t)
 	tr := util.NewTempRepo()
-	taskDB := db.NewInMemoryTaskDB()
+	taskDB := db.NewInMemoryDB()
 	tCache, err := db.NewTaskCache(taskDB, time.Hour)
 	assert.NoError(t, err)
 	repos := gitinfo.NewRepoMap(tr.Dir)
@@ -721,44 +730,44 @@ func makeSwarmingBot(id string, dims []string) *swarming_api.SwarmingRpcsBotInfo
 
 func TestGetCandidatesToSchedule(t *testing.T) {
 	// Empty lists.
-	rv := getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{}, []*taskCandidate{})
+	rv, _ := getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{}, []*taskCandidate{}, nil)
 	assert.Equal(t, 0, len(rv))
 
 	t1 := makeTaskCandidate("task1", []string{"k:v"})
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{}, []*taskCandidate{t1})
+	rv, _ = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{}, []*taskCandidate{t1}, nil)
 	assert.Equal(t, 0, len(rv))
 
 	b1 := makeSwarmingBot("bot1", []string{"k:v"})
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{})
+	rv, _ = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{}, nil)
 	assert.Equal(t, 0, len(rv))
 
 	// Single match.
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1})
+	rv, _ = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t1}, rv)
 
 	// No match.
 	t1.TaskSpec.Dimensions[0] = "k:v2"
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1})
+	rv, _ = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1}, nil)
 	assert.Equal(t, 0, len(rv))
 
 	// Add a task candidate to match b1.
 	t1 = makeTaskCandidate("task1", []string{"k:v2"})
 	t2 := makeTaskCandidate("task2", []string{"k:v"})
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1, t2})
+	rv, _ = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1, t2}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t2}, rv)
 
 	// Switch the task order.
 	t1 = makeTaskCandidate("task1", []string{"k:v2"})
 	t2 = makeTaskCandidate("task2", []string{"k:v"})
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t2, t1})
+	rv, _ = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t2, t1}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t2}, rv)
 
 	// Make both tasks match the bot, ensure that we pick the first one.
 	t1 = makeTaskCandidate("task1", []string{"k:v"})
 	t2 = makeTaskCandidate("task2", []string{"k:v"})
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1, t2})
+	rv, _ = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1, t2}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t1}, rv)
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t2, t1})
+	rv, _ = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t2, t1}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t2}, rv)
 
 	// Multiple dimensions. Ensure that different permutations of the bots
@@ -773,21 +782,21 @@ func TestGetCandidatesToSchedule(t *testing.T) {
 	// is first in sorted order. The second task does not get scheduled
 	// because there is no bot available which can run it.
 	// TODO(borenet): Use a more optimal solution to avoid this case.
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2}, []*taskCandidate{t1, t2})
+	rv, _ = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2}, []*taskCandidate{t1, t2}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t1}, rv)
 	t1 = makeTaskCandidate("task1", []string{"k:v"})
 	t2 = makeTaskCandidate("task2", dims)
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b2, b1}, []*taskCandidate{t1, t2})
+	rv, _ = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b2, b1}, []*taskCandidate{t1, t2}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t1}, rv)
 	// In these two cases, the task with more dimensions has the higher
 	// priority. Both tasks get scheduled.
 	t1 = makeTaskCandidate("task1", []string{"k:v"})
 	t2 = makeTaskCandidate("task2", dims)
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2}, []*taskCandidate{t2, t1})
+	rv, _ = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2}, []*taskCandidate{t2, t1}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t2, t1}, rv)
 	t1 = makeTaskCandidate("task1", []string{"k:v"})
 	t2 = makeTaskCandidate("task2", dims)
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b2, b1}, []*taskCandidate{t2, t1})
+	rv, _ = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b2, b1}, []*taskCandidate{t2, t1}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t2, t1}, rv)
 
 	// Matching dimensions. More bots than tasks.
@@ -796,17 +805,80 @@ func TestGetCandidatesToSchedule(t *testing.T) {
 	t1 = makeTaskCandidate("task1", dims)
 	t2 = makeTaskCandidate("task2", dims)
 	t3 := makeTaskCandidate("task3", dims)
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2, b3}, []*taskCandidate{t1, t2})
+	rv, _ = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2, b3}, []*taskCandidate{t1, t2}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t1, t2}, rv)
 
 	// More tasks than bots.
 	t1 = makeTaskCandidate("task1", dims)
 	t2 = makeTaskCandidate("task2", dims)
 	t3 = makeTaskCandidate("task3", dims)
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2}, []*taskCandidate{t1, t2, t3})
+	rv, _ = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2}, []*taskCandidate{t1, t2, t3}, nil)
 	testutils.AssertDeepEqual(t, []*taskCandidate{t1, t2}, rv)
 }
 
+func TestGetCandidatesToScheduleBotPools(t *testing.T) {
+	dims := []string{"pool:Skia", "os:Ubuntu"}
+	b1 := makeSwarmingBot("bot1", dims)
+	b2 := makeSwarmingBot("bot2", dims)
+	b3 := makeSwarmingBot("bot3", dims)
+	busy := makeSwarmingBot("bot4", dims)
+	free := []*swarming_api.SwarmingRpcsBotInfo{b1, b2, b3}
+	specsByBot := map[string]string{"bot4": crossRepoId("", "task1")}
+
+	// At most two bots per TaskSpec. One is already busy with task1, so
+	// only one more task1 candidate may run.
+	pools := []*BotPool{
+		&BotPool{
+			Dimensions:               []string{"pool:Skia"},
+			MaxConcurrentPerTaskSpec: 2,
+		},
+	}
+	t1 := makeTaskCandidate("task1", dims)
+	t2 := makeTaskCandidate("task1", dims)
+	t3 := makeTaskCandidate("task2", dims)
+	limiter := newPoolLimiter(pools, free, []*swarming_api.SwarmingRpcsBotInfo{busy}, specsByBot)
+	rv, starved := getCandidatesToSchedule(free, []*taskCandidate{t1, t2, t3}, limiter)
+	testutils.AssertDeepEqual(t, []*taskCandidate{t1, t3}, rv)
+	testutils.AssertDeepEqual(t, []*taskCandidate{t2}, starved)
+
+	// Pools only apply to the bots which have all of their dimensions.
+	pools[0].Dimensions = []string{"pool:Skia", "os:Mac"}
+	t1 = makeTaskCandidate("task1", dims)
+	t2 = makeTaskCandidate("task1", dims)
+	t3 = makeTaskCandidate("task2", dims)
+	limiter = newPoolLimiter(pools, free, []*swarming_api.SwarmingRpcsBotInfo{busy}, specsByBot)
+	rv, starved = getCandidatesToSchedule(free, []*taskCandidate{t1, t2, t3}, limiter)
+	testutils.AssertDeepEqual(t, []*taskCandidate{t1, t2, t3}, rv)
+	assert.Equal(t, 0, len(starved))
+
+	// Reserve two bots for high-priority tasks. Only one bot is available
+	// to the low-priority task, but the high-priority task may use any.
+	pools = []*BotPool{
+		&BotPool{
+			Dimensions:          []string{"pool:Skia"},
+			Reserved:            2,
+			ReservedMinPriority: 0.8,
+		},
+	}
+	low1 := makeTaskCandidate("low1", dims)
+	low1.TaskSpec.Priority = 0.5
+	low2 := makeTaskCandidate("low2", dims)
+	low2.TaskSpec.Priority = 0.5
+	high := makeTaskCandidate("high", dims)
+	high.TaskSpec.Priority = 0.9
+	limiter = newPoolLimiter(pools, free, nil, nil)
+	rv, starved = getCandidatesToSchedule(free, []*taskCandidate{low1, low2, high}, limiter)
+	testutils.AssertDeepEqual(t, []*taskCandidate{low1, high}, rv)
+	testutils.AssertDeepEqual(t, []*taskCandidate{low2}, starved)
+
+	// A nil limiter imposes no limits.
+	low1 = makeTaskCandidate("low1", dims)
+	low2 = makeTaskCandidate("low2", dims)
+	rv, starved = getCandidatesToSchedule(free, []*taskCandidate{low1, low2}, nil)
+	testutils.AssertDeepEqual(t, []*taskCandidate{low1, low2}, rv)
+	assert.Equal(t, 0, len(starved))
+}
+
 func makeBot(id string, dims map[string]string) *swarming_api.SwarmingRpcsBotInfo {
 	dimensions := make([]*swarming_api.SwarmingRpcsStringListPair, 0, len(dims))
 	for k, v := range dims {
@@ -1214,7 +1286,7 @@ func TestMultipleCandidatesBackfillingEachOther(t *testing.T) {
 	repos := gitinfo.NewRepoMap(workdir)
 	repo, err := repos.Repo(repoName)
 	assert.NoError(t, err)
-	taskDB := db.NewInMemoryTaskDB()
+	taskDB := db.NewInMemoryDB()
 	tCache, err := db.NewTaskCache(taskDB, time.Hour)
 	assert.NoError(t, err)
 	isolateClient, err := isolate.NewClient(workdir)
@@ -1364,6 +1436,7 @@ func TestSchedulingRetry(t *testing.T) {
 	t3 := tasks[0]
 	assert.NotNil(t, t3)
 	assert.Equal(t, t1.Id, t3.RetryOf)
+	assert.Equal(t, 1, t3.Attempt)
 
 	// The retry failed. Ensure that we don't schedule another.
 	t3.Status = db.TASK_STATUS_FAILURE
@@ -1376,6 +1449,263 @@ func TestSchedulingRetry(t *testing.T) {
 	assert.Equal(t, 0, len(tasks))
 }
 
+func TestShouldRetry(t *testing.T) {
+	spec := &TaskSpec{}
+	failed := &db.Task{
+		Id:     "failed",
+		Status: db.TASK_STATUS_FAILURE,
+	}
+	mishap := &db.Task{
+		Id:     "mishap",
+		Status: db.TASK_STATUS_MISHAP,
+	}
+
+	// By default, both failures and mishaps are retried once.
+	assert.True(t, shouldRetry(failed, spec))
+	assert.True(t, shouldRetry(mishap, spec))
+	failed.Attempt = 1
+	failed.RetryOf = "a"
+	mishap.Attempt = 1
+	mishap.RetryOf = "b"
+	assert.False(t, shouldRetry(failed, spec))
+	assert.False(t, shouldRetry(mishap, spec))
+
+	// With more attempts allowed, mishaps are retried until the attempts
+	// are exhausted, but failures are still only retried once.
+	spec.MaxAttempts = 3
+	assert.False(t, shouldRetry(failed, spec))
+	assert.True(t, shouldRetry(mishap, spec))
+	mishap.Attempt = 2
+	assert.False(t, shouldRetry(mishap, spec))
+
+	// A failed retry of a mishap is not retried.
+	failed.Attempt = 1
+	failed.RetryOf = "mishap"
+	assert.False(t, shouldRetry(failed, spec))
+
+	// MaxAttempts of one disables retries.
+	spec.MaxAttempts = 1
+	assert.False(t, shouldRetry(&db.Task{Status: db.TASK_STATUS_MISHAP}, spec))
+	assert.False(t, shouldRetry(&db.Task{Status: db.TASK_STATUS_FAILURE}, spec))
+}
+
+func TestCrossRepoDepsMet(t *testing.T) {
+	tr, d, cache, _, _, _, s := setup(t)
+	defer tr.Cleanup()
+
+	// The "upstream" repo is the same as the downstream repo, which is
+	// sufficient for testing commit ordering.
+	commitsByRepo := map[string][]string{repoName: []string{c1, c2}}
+	makeCandidate := func(revision string, deps ...*CrossRepoDependency) *taskCandidate {
+		return &taskCandidate{
+			Name:     perfTask,
+			Repo:     repoName,
+			Revision: revision,
+			TaskSpec: &TaskSpec{
+				CrossRepoDependencies: deps,
+			},
+		}
+	}
+	dep := &CrossRepoDependency{
+		Repo: repoName,
+		Name: buildTask,
+	}
+
+	// No cross-repo dependencies.
+	met, hashes, err := s.crossRepoDepsMet(makeCandidate(c2), commitsByRepo)
+	assert.NoError(t, err)
+	assert.True(t, met)
+	assert.Equal(t, 0, len(hashes))
+
+	// No upstream tasks.
+	met, _, err = s.crossRepoDepsMet(makeCandidate(c2, dep), commitsByRepo)
+	assert.NoError(t, err)
+	assert.False(t, met)
+
+	// Unknown repo.
+	met, _, err = s.crossRepoDepsMet(makeCandidate(c2, &CrossRepoDependency{
+		Repo: "bogus.git",
+		Name: buildTask,
+	}), commitsByRepo)
+	assert.NoError(t, err)
+	assert.False(t, met)
+
+	// Upstream task at c1 is still running.
+	t1 := makeTask(buildTask, repoName, c1)
+	t1.Status = db.TASK_STATUS_RUNNING
+	assert.NoError(t, d.PutTask(t1))
+	assert.NoError(t, cache.Update())
+	met, _, err = s.crossRepoDepsMet(makeCandidate(c2, dep), commitsByRepo)
+	assert.NoError(t, err)
+	assert.False(t, met)
+
+	// Upstream task at c1 succeeded.
+	t1.Status = db.TASK_STATUS_SUCCESS
+	t1.IsolatedOutput = "hash1"
+	assert.NoError(t, d.PutTask(t1))
+	assert.NoError(t, cache.Update())
+	met, hashes, err = s.crossRepoDepsMet(makeCandidate(c2, dep), commitsByRepo)
+	assert.NoError(t, err)
+	assert.True(t, met)
+	assert.Equal(t, map[string]string{t1.Id: "hash1"}, hashes)
+
+	// Upstream task at c2 succeeded. It's used for candidates at c2, but
+	// not for candidates at c1, since c2 is newer.
+	t2 := makeTask(buildTask, repoName, c2)
+	t2.Status = db.TASK_STATUS_SUCCESS
+	t2.IsolatedOutput = "hash2"
+	assert.NoError(t, d.PutTask(t2))
+	assert.NoError(t, cache.Update())
+	met, hashes, err = s.crossRepoDepsMet(makeCandidate(c2, dep), commitsByRepo)
+	assert.NoError(t, err)
+	assert.True(t, met)
+	assert.Equal(t, map[string]string{t2.Id: "hash2"}, hashes)
+	met, hashes, err = s.crossRepoDepsMet(makeCandidate(c1, dep), commitsByRepo)
+	assert.NoError(t, err)
+	assert.True(t, met)
+	assert.Equal(t, map[string]string{t1.Id: "hash1"}, hashes)
+}
+
+func TestJobRequirements(t *testing.T) {
+	specs := map[string]*TaskSpec{
+		buildTask: &TaskSpec{},
+		testTask:  &TaskSpec{Dependencies: []string{buildTask}},
+		perfTask:  &TaskSpec{Dependencies: []string{buildTask}},
+	}
+	now := time.Now()
+	testJob := &db.Job{
+		Created:      now,
+		Dependencies: []string{testTask},
+		Status:       db.JOB_STATUS_CANCELED,
+	}
+	perfJob := &db.Job{
+		Created:      now,
+		Dependencies: []string{perfTask},
+	}
+
+	// Only the test job, canceled. Both the test task and its dependency
+	// should not be scheduled.
+	canceled, retries := jobRequirements([]*db.Job{testJob}, specs)
+	testutils.AssertDeepEqual(t, util.NewStringSet([]string{buildTask, testTask}), canceled)
+	assert.Equal(t, 0, len(retries))
+
+	// The perf job also needs the build task.
+	canceled, retries = jobRequirements([]*db.Job{testJob, perfJob}, specs)
+	testutils.AssertDeepEqual(t, util.NewStringSet([]string{testTask}), canceled)
+	assert.Equal(t, 0, len(retries))
+
+	// A retry of the test job forces a re-run of all of its tasks.
+	retryJob := &db.Job{
+		Created:      now.Add(time.Minute),
+		Dependencies: []string{testTask},
+		RetryOf:      "abc123",
+	}
+	canceled, retries = jobRequirements([]*db.Job{testJob, perfJob, retryJob}, specs)
+	assert.Equal(t, 0, len(canceled))
+	assert.Equal(t, map[string]time.Time{
+		buildTask: retryJob.Created,
+		testTask:  retryJob.Created,
+	}, retries)
+
+	// Finished retry jobs force nothing.
+	retryJob.Status = db.JOB_STATUS_SUCCESS
+	canceled, retries = jobRequirements([]*db.Job{testJob, perfJob, retryJob}, specs)
+	testutils.AssertDeepEqual(t, util.NewStringSet([]string{testTask}), canceled)
+	assert.Equal(t, 0, len(retries))
+}
+
+func TestCancelAndRetryJob(t *testing.T) {
+	tr, d, cache, _, _, _, s := setup(t)
+	defer tr.Cleanup()
+
+	findCandidate := func(name, revision string) *taskCandidate {
+		for _, c := range s.queue {
+			if c.Name == name && c.Revision == revision {
+				return c
+			}
+		}
+		return nil
+	}
+
+	// Insert a failed Build task at c1 which has already been retried, and
+	// a job at c2 which needs the Perf task.
+	t1 := makeTask(buildTask, repoName, c1)
+	t1.Attempt = 1
+	t1.RetryOf = "some-other-task"
+	t1.Status = db.TASK_STATUS_FAILURE
+	t1.Finished = time.Now()
+	assert.NoError(t, d.PutTask(t1))
+	assert.NoError(t, cache.Update())
+	j1 := &db.Job{
+		Created:      time.Now(),
+		Dependencies: []string{perfTask},
+		Name:         "perf-job",
+		Repo:         repoName,
+		Revision:     c2,
+	}
+	assert.NoError(t, d.PutJob(j1))
+	assert.NoError(t, s.jCache.Update())
+
+	// The failed Build task is not retried.
+	assert.NoError(t, s.regenerateTaskQueue())
+	assert.Equal(t, 1, len(s.queue))
+	assert.NotNil(t, findCandidate(buildTask, c2))
+
+	// Unfinished jobs can't be retried.
+	_, err := s.RetryJob(j1.Id)
+	assert.Error(t, err)
+	_, err = s.RetryJob("bogus-id")
+	assert.Equal(t, db.ErrNotFound, err)
+
+	// Cancel the job. Its tasks should no longer be scheduled.
+	canceled, err := s.CancelJob(j1.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, db.JOB_STATUS_CANCELED, canceled.Status)
+	assert.False(t, util.TimeIsZero(canceled.Finished))
+	stored, err := d.GetJobById(j1.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, db.JOB_STATUS_CANCELED, stored.Status)
+	assert.NoError(t, s.regenerateTaskQueue())
+	assert.Equal(t, 0, len(s.queue))
+
+	// Can't cancel a job twice.
+	_, err = s.CancelJob(j1.Id)
+	assert.Error(t, err)
+
+	// Retry the job. Its tasks are scheduled again.
+	j2, err := s.RetryJob(j1.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, j1.Id, j2.RetryOf)
+	assert.Equal(t, db.JOB_STATUS_IN_PROGRESS, j2.Status)
+	assert.NotEqual(t, j1.Id, j2.Id)
+	assert.NoError(t, s.regenerateTaskQueue())
+	assert.Equal(t, 1, len(s.queue))
+	assert.NotNil(t, findCandidate(buildTask, c2))
+
+	// Add a job at c1 which needs the Test task, cancel it, and retry it.
+	// The failed Build task should be retried, despite having exhausted
+	// its attempts.
+	j3 := &db.Job{
+		Created:      time.Now(),
+		Dependencies: []string{testTask},
+		Name:         "test-job",
+		Repo:         repoName,
+		Revision:     c1,
+	}
+	assert.NoError(t, d.PutJob(j3))
+	_, err = s.CancelJob(j3.Id)
+	assert.NoError(t, err)
+	assert.NoError(t, s.regenerateTaskQueue())
+	assert.Nil(t, findCandidate(buildTask, c1))
+	_, err = s.RetryJob(j3.Id)
+	assert.NoError(t, err)
+	assert.NoError(t, s.regenerateTaskQueue())
+	c := findCandidate(buildTask, c1)
+	assert.NotNil(t, c)
+	assert.Equal(t, t1.Id, c.RetryOf)
+	assert.Equal(t, 2, c.Attempt)
+}
+
 func TestParentTaskId(t *testing.T) {
 	tr, d, cache, _, _, swarmingClient, s := setup(t)
 	defer tr.Cleanup()
@@ -1456,3 +1786,276 @@ func TestBlacklist(t *testing.T) {
 	assert.Equal(t, 1, len(tasks))
 	assert.NotEqual(t, c1, tasks[0].Revision)
 }
+
+func TestSchedulingBotPools(t *testing.T) {
+	tr, _, cache, _, _, swarmingClient, s := setup(t)
+	defer tr.Cleanup()
+
+	// Only allow one Build task to run at a time.
+	cfg := &TasksCfg{
+		Tasks: map[string]*TaskSpec{
+			buildTask: &TaskSpec{
+				CipdPackages: []*CipdPackage{},
+				Dependencies: []string{},
+				Dimensions:   []string{"pool:Skia", "os:Ubuntu"},
+				Isolate:      "compile_skia.isolate",
+				Priority:     0.5,
+			},
+		},
+		BotPools: []*BotPool{
+			&BotPool{
+				Dimensions:               []string{"pool:Skia"},
+				MaxConcurrentPerTaskSpec: 1,
+			},
+		},
+	}
+	s.SetTasksCfgOverride(cfg)
+	starvation := metrics2.GetInt64Metric(MEASUREMENT_STARVATION, map[string]string{
+		"repo":      repoName,
+		"task_spec": buildTask,
+	})
+	starvedId := crossRepoId(repoName, buildTask)
+
+	// Two bots are free, but only one Build task is triggered. The other
+	// starves.
+	bot1 := makeBot("bot1", map[string]string{"pool": "Skia", "os": "Ubuntu"})
+	bot2 := makeBot("bot2", map[string]string{"pool": "Skia", "os": "Ubuntu"})
+	swarmingClient.MockBots([]*swarming_api.SwarmingRpcsBotInfo{bot1, bot2})
+	assert.NoError(t, s.MainLoop())
+	assert.NoError(t, cache.Update())
+	tasks, err := cache.UnfinishedTasks()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(tasks))
+	assert.Equal(t, 1, len(s.queue))
+	assert.NotNil(t, s.starved[starvedId])
+	assert.Equal(t, int64(0), starvation.Get())
+
+	// The task is running on bot1. The remaining candidate still may not
+	// use bot2. Bots report the run ID of their task, which differs from
+	// the request ID stored in the Task.
+	id := tasks[0].SwarmingTaskId
+	bot1.TaskId = id[:len(id)-1] + "1"
+	assert.NoError(t, s.MainLoop())
+	assert.NoError(t, cache.Update())
+	tasks, err = cache.UnfinishedTasks()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(tasks))
+	assert.NotNil(t, s.starved[starvedId])
+
+	// Quarantined bots are not considered, so the candidate no longer
+	// starves; there are simply no bots for it.
+	bot2.Quarantined = true
+	assert.NoError(t, s.MainLoop())
+	assert.Equal(t, 0, len(s.starved))
+	assert.Equal(t, int64(0), starvation.Get())
+
+	// Reserve the last free bot for high-priority tasks.
+	bot2.Quarantined = false
+	cfg.BotPools[0] = &BotPool{
+		Dimensions:          []string{"pool:Skia"},
+		Reserved:            1,
+		ReservedMinPriority: 0.8,
+	}
+	assert.NoError(t, s.MainLoop())
+	assert.NoError(t, cache.Update())
+	tasks, err = cache.UnfinishedTasks()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(tasks))
+	assert.NotNil(t, s.starved[starvedId])
+
+	// Raise the priority of the Build task. It now runs on bot2.
+	cfg.Tasks[buildTask].Priority = 0.9
+	assert.NoError(t, s.MainLoop())
+	assert.NoError(t, cache.Update())
+	tasks, err = cache.UnfinishedTasks()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(tasks))
+	assert.Equal(t, 0, len(s.starved))
+	assert.Equal(t, int64(0), starvation.Get())
+}
+
+func TestSwarmingRequestId(t *testing.T) {
+	assert.Equal(t, "", swarmingRequestId(""))
+	assert.Equal(t, "2f4ac1b0d05ea210", swarmingRequestId("2f4ac1b0d05ea210"))
+	assert.Equal(t, "2f4ac1b0d05ea210", swarmingRequestId("2f4ac1b0d05ea211"))
+	assert.Equal(t, "2f4ac1b0d05ea210", swarmingRequestId("2f4ac1b0d05ea212"))
+}
+
+func TestTryJobs(t *testing.T) {
+	tr, d, cache, _, _, swarmingClient, s := setup(t)
+	defer tr.Cleanup()
+
+	// Serve patches from a fake Rietveld server. Patchset 1 applies
+	// cleanly; other patchsets don't exist.
+	diff := `diff --git a/try_job_file b/try_job_file
+new file mode 100644
+--- /dev/null
++++ b/try_job_file
+@@ -0,0 +1 @@
++hello
+`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/download/issue123_1.diff" {
+			http.NotFound(w, r)
+			return
+		}
+		_, err := w.Write([]byte(diff))
+		assert.NoError(t, err)
+	}))
+	defer srv.Close()
+	patch := db.Patch{
+		Server:   srv.URL,
+		Issue:    "123",
+		Patchset: "1",
+	}
+
+	// Invalid try jobs.
+	_, err := s.TriggerTryJob("try", repoName, c1, db.Patch{}, []string{buildTask})
+	assert.Error(t, err)
+	_, err = s.TriggerTryJob("try", "bogus.git", c1, patch, []string{buildTask})
+	assert.Error(t, err)
+	_, err = s.TriggerTryJob("try", repoName, c1, patch, []string{perfTask})
+	assert.Error(t, err)
+	_, err = s.TriggerTryJob("try", repoName, c1, patch, []string{})
+	assert.Error(t, err)
+
+	// Request a try job for the Test task. The Build task for the patch
+	// is the highest-scoring candidate.
+	j1, err := s.TriggerTryJob("try", repoName, c1, patch, []string{testTask})
+	assert.NoError(t, err)
+	assert.True(t, j1.IsTryJob())
+	assert.NoError(t, s.regenerateTaskQueue())
+	assert.Equal(t, 3, len(s.queue))
+	assert.Equal(t, buildTask, s.queue[0].Name)
+	assert.Equal(t, patch, s.queue[0].Patch)
+	assert.True(t, s.queue[0].Score >= CANDIDATE_SCORE_TRY_JOB)
+	assert.Equal(t, 0, len(s.queue[0].Commits))
+
+	// Schedule the try job's Build task.
+	bot1 := makeBot("bot1", map[string]string{"pool": "Skia", "os": "Ubuntu"})
+	swarmingClient.MockBots([]*swarming_api.SwarmingRpcsBotInfo{bot1})
+	assert.NoError(t, s.MainLoop())
+	assert.NoError(t, cache.Update())
+	tasks, err := cache.UnfinishedTasks()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(tasks))
+	t1 := tasks[0]
+	assert.Equal(t, patch, t1.Patch)
+	assert.Equal(t, 0, len(t1.Commits))
+
+	// The try job task is not associated with any commit.
+	byCommit, err := cache.GetTasksForCommits(repoName, []string{c1, c2})
+	assert.NoError(t, err)
+	testutils.AssertDeepEqual(t, map[string]map[string]*db.Task{
+		c1: map[string]*db.Task{},
+		c2: map[string]*db.Task{},
+	}, byCommit)
+	found, err := cache.GetTaskForCommit(repoName, c1, buildTask)
+	assert.NoError(t, err)
+	assert.Nil(t, found)
+	found, err = cache.GetTaskForPatch(repoName, c1, patch, buildTask)
+	assert.NoError(t, err)
+	assert.Equal(t, t1.Id, found.Id)
+
+	// The Build task is not duplicated for the same patch.
+	swarmingClient.MockBots([]*swarming_api.SwarmingRpcsBotInfo{})
+	assert.NoError(t, s.regenerateTaskQueue())
+	for _, c := range s.queue {
+		assert.False(t, c.IsTryJob())
+	}
+
+	// Once the Build task finishes, the Test task for the patch uses its
+	// output.
+	t1.Status = db.TASK_STATUS_SUCCESS
+	t1.Finished = time.Now()
+	t1.IsolatedOutput = "abc123"
+	assert.NoError(t, d.PutTask(t1))
+	assert.NoError(t, cache.Update())
+	assert.NoError(t, s.regenerateTaskQueue())
+	assert.Equal(t, testTask, s.queue[0].Name)
+	assert.Equal(t, patch, s.queue[0].Patch)
+	assert.Equal(t, []string{t1.Id}, s.queue[0].ParentTaskIds)
+
+	// Canceling the try job prevents its remaining tasks from running.
+	_, err = s.CancelJob(j1.Id)
+	assert.NoError(t, err)
+	assert.NoError(t, s.regenerateTaskQueue())
+	for _, c := range s.queue {
+		assert.False(t, c.IsTryJob())
+	}
+
+	// A patch which can't be downloaded causes the try job to fail
+	// without triggering any tasks.
+	badPatch := db.Patch{
+		Server:   srv.URL,
+		Issue:    "123",
+		Patchset: "2",
+	}
+	j2, err := s.TriggerTryJob("try", repoName, c1, badPatch, []string{buildTask})
+	assert.NoError(t, err)
+	swarmingClient.MockBots([]*swarming_api.SwarmingRpcsBotInfo{bot1})
+	assert.NoError(t, s.MainLoop())
+	assert.NoError(t, cache.Update())
+	found, err = cache.GetTaskForPatch(repoName, c1, badPatch, buildTask)
+	assert.NoError(t, err)
+	assert.Nil(t, found)
+	j2, err = d.GetJobById(j2.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, db.JOB_STATUS_MISHAP, j2.Status)
+}
+
+func TestTryJobsFinish(t *testing.T) {
+	tr, d, cache, _, _, _, s := setup(t)
+	defer tr.Cleanup()
+
+	patch1 := db.Patch{Server: "https://codereview.chromium.org", Issue: "123", Patchset: "1"}
+	patch2 := db.Patch{Server: "https://codereview.chromium.org", Issue: "124", Patchset: "1"}
+	j1, err := s.TriggerTryJob("try", repoName, c1, patch1, []string{buildTask})
+	assert.NoError(t, err)
+	j2, err := s.TriggerTryJob("try", repoName, c1, patch2, []string{testTask})
+	assert.NoError(t, err)
+
+	// The jobs stay unfinished while their tasks run.
+	t1 := makeTask(buildTask, repoName, c1)
+	t1.Commits = nil
+	t1.Patch = patch1
+	t1.Status = db.TASK_STATUS_RUNNING
+	t2 := makeTask(buildTask, repoName, c1)
+	t2.Commits = nil
+	t2.Patch = patch2
+	t2.Status = db.TASK_STATUS_RUNNING
+	assert.NoError(t, d.PutTasks([]*db.Task{t1, t2}))
+	assert.NoError(t, cache.Update())
+	assert.NoError(t, s.regenerateTaskQueue())
+	jobs, err := s.jCache.UnfinishedJobs()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(jobs))
+
+	// A job finishes once all of its tasks are done.
+	t1.Status = db.TASK_STATUS_SUCCESS
+	t1.Finished = time.Now()
+	// The Build task for patch2 fails and won't be retried, so the Test
+	// task can never run.
+	t2.Status = db.TASK_STATUS_FAILURE
+	t2.Finished = time.Now()
+	t2.RetryOf = "some-task"
+	t2.Attempt = 1
+	assert.NoError(t, d.PutTasks([]*db.Task{t1, t2}))
+	assert.NoError(t, cache.Update())
+	assert.NoError(t, s.regenerateTaskQueue())
+
+	j1, err = d.GetJobById(j1.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, db.JOB_STATUS_SUCCESS, j1.Status)
+	assert.False(t, util.TimeIsZero(j1.Finished))
+	j2, err = d.GetJobById(j2.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, db.JOB_STATUS_FAILURE, j2.Status)
+	assert.False(t, util.TimeIsZero(j2.Finished))
+	jobs, err = s.jCache.UnfinishedJobs()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(jobs))
+	for _, c := range s.queue {
+		assert.False(t, c.IsTryJob())
+	}
+}
@@ -0,0 +1,344 @@
+package scheduling
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skia-dev/glog"
+	"go.skia.org/infra/go/exec"
+	"go.skia.org/infra/go/httputils"
+	"go.skia.org/infra/go/timer"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/task_scheduler/go/db"
+)
+
+const (
+	// CANDIDATE_SCORE_TRY_JOB is the base score for try job candidates. It
+	// is larger than the testedness increase of all but the longest
+	// blamelists, since someone is actively waiting on a try job. Try job
+	// candidates gain an additional point for each hour they have waited.
+	CANDIDATE_SCORE_TRY_JOB = 10.0
+
+	// RIETVELD_DIFF_URL_TMPL is used to download the diff for a patchset
+	// from a Rietveld server.
+	RIETVELD_DIFF_URL_TMPL = "%s/download/issue%s_%s.diff"
+)
+
+// tryJobKey identifies the set of try jobs which run at the same
+// repo/revision/patch.
+type tryJobKey struct {
+	repo     string
+	revision string
+	patch    db.Patch
+}
+
+// isGerrit returns true iff the given code review server is a Gerrit server,
+// as opposed to a Rietveld server.
+func isGerrit(server string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(server, "/"), "-review.googlesource.com")
+}
+
+// applyPatch applies the given patch on top of the current checkout in
+// repoDir, leaving the changes uncommitted. Gerrit changes are fetched from
+// repoUrl.
+func applyPatch(repoDir, repoUrl string, patch db.Patch) error {
+	if isGerrit(patch.Server) {
+		issue, err := strconv.ParseInt(patch.Issue, 10, 64)
+		if err != nil {
+			return err
+		}
+		ref := fmt.Sprintf("refs/changes/%02d/%s/%s", issue%100, patch.Issue, patch.Patchset)
+		if _, err := exec.RunCwd(repoDir, "git", "fetch", repoUrl, ref); err != nil {
+			return err
+		}
+		_, err = exec.RunCwd(repoDir, "git", "cherry-pick", "--no-commit", "FETCH_HEAD")
+		return err
+	}
+
+	url := fmt.Sprintf(RIETVELD_DIFF_URL_TMPL, strings.TrimSuffix(patch.Server, "/"), patch.Issue, patch.Patchset)
+	resp, err := httputils.NewTimeoutClient().Get(url)
+	if err != nil {
+		return err
+	}
+	defer util.Close(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to download %s; got status %d", url, resp.StatusCode)
+	}
+	f, err := ioutil.TempFile("", "patch")
+	if err != nil {
+		return err
+	}
+	defer util.Remove(f.Name())
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		util.Close(f)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	_, err = exec.RunCwd(repoDir, "git", "apply", "--index", f.Name())
+	return err
+}
+
+// resetCheckout discards any uncommitted changes in repoDir, eg. those made
+// by applyPatch.
+func resetCheckout(repoDir string) error {
+	if _, err := exec.RunCwd(repoDir, "git", "reset", "--hard", "HEAD"); err != nil {
+		return err
+	}
+	_, err := exec.RunCwd(repoDir, "git", "clean", "-d", "-f")
+	return err
+}
+
+// TriggerTryJob creates a new Job which runs the given TaskSpecs, and their
+// dependencies, at the given commit with the given patch applied.
+func (s *TaskScheduler) TriggerTryJob(name, repo, revision string, patch db.Patch, taskSpecs []string) (*db.Job, error) {
+	if err := patch.Validate(); err != nil {
+		return nil, err
+	}
+	if _, ok := s.repos[repo]; !ok {
+		return nil, fmt.Errorf("Unknown repo %q", repo)
+	}
+	if len(taskSpecs) == 0 {
+		return nil, fmt.Errorf("Try jobs must specify at least one TaskSpec.")
+	}
+	specs, err := s.taskCfgCache.GetTaskSpecsForCommits(map[string][]string{repo: []string{revision}})
+	if err != nil {
+		return nil, err
+	}
+	for _, spec := range taskSpecs {
+		if _, ok := specs[repo][revision][spec]; !ok {
+			return nil, fmt.Errorf("No such TaskSpec %q in %s @ %s", spec, repo, revision)
+		}
+	}
+	deps := make([]string, len(taskSpecs))
+	copy(deps, taskSpecs)
+	job := &db.Job{
+		Created:      time.Now(),
+		Dependencies: deps,
+		Name:         name,
+		Patch:        patch,
+		Repo:         repo,
+		Revision:     revision,
+	}
+	if err := s.jobDB.PutJob(job); err != nil {
+		return nil, err
+	}
+	if err := s.jCache.Update(); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// findTryJobCandidates returns task candidates for the unfinished try jobs.
+// Try job candidates have no blamelist; they are scored according to how long
+// the try jobs have been waiting. Try jobs whose tasks are all done are marked
+// as finished, see tryJobStatus.
+func (s *TaskScheduler) findTryJobCandidates(now time.Time, commitsByRepo map[string][]string) ([]*taskCandidate, error) {
+	defer timer.New("TaskScheduler.findTryJobCandidates").Stop()
+	unfinished, err := s.jCache.UnfinishedJobs()
+	if err != nil {
+		return nil, err
+	}
+	byPatch := map[tryJobKey][]*db.Job{}
+	for _, j := range unfinished {
+		if j.IsTryJob() {
+			k := tryJobKey{j.Repo, j.Revision, j.Patch}
+			byPatch[k] = append(byPatch[k], j)
+		}
+	}
+	rv := []*taskCandidate{}
+	finished := false
+	for k, jobs := range byPatch {
+		specs, err := s.taskCfgCache.GetTaskSpecsForCommits(map[string][]string{k.repo: []string{k.revision}})
+		if err != nil {
+			// Don't let one bad try job prevent scheduling of
+			// everything else.
+			glog.Errorf("Failed to obtain TaskSpecs for try jobs at %s @ %s: %s", k.repo, k.revision, err)
+			continue
+		}
+		tasks := specs[k.repo][k.revision]
+		_, retries := jobRequirements(jobs, tasks)
+
+		// Finish the try jobs which have nothing left to run.
+		running := make([]*db.Job, 0, len(jobs))
+		for _, j := range jobs {
+			status, err := s.tryJobStatus(j, tasks, retries)
+			if err != nil {
+				return nil, err
+			}
+			if status == db.JOB_STATUS_IN_PROGRESS {
+				running = append(running, j)
+				continue
+			}
+			if err := s.finishJob(j.Id, status); err != nil {
+				return nil, err
+			}
+			finished = true
+		}
+		jobs = running
+		if len(jobs) == 0 {
+			continue
+		}
+
+		needed := []string{}
+		oldest := now
+		for _, j := range jobs {
+			needed = append(needed, j.Dependencies...)
+			if j.Created.Before(oldest) {
+				oldest = j.Created
+			}
+		}
+		score := CANDIDATE_SCORE_TRY_JOB + now.Sub(oldest).Hours()
+		for name, _ := range taskSpecClosure(needed, tasks) {
+			task, ok := tasks[name]
+			if !ok {
+				glog.Warningf("Try job at %s @ %s (%s) needs unknown TaskSpec %q", k.repo, k.revision, k.patch, name)
+				continue
+			}
+			if rule, reason := s.bl.MatchRule(name, k.revision, task.Dimensions); rule != "" {
+				glog.Warningf("Skipping blacklisted try job candidate: %s @ %s (%s) due to rule %q: %s", name, k.revision, k.patch, rule, reason)
+				continue
+			}
+			c := &taskCandidate{
+				Name:      name,
+				Patch:     k.patch,
+				Repo:      k.repo,
+				Revision:  k.revision,
+				Score:     score,
+				TaskSpec:  task,
+				TimeDecay: 1.0,
+			}
+			previous, err := s.tCache.GetTaskForPatch(c.Repo, c.Revision, c.Patch, c.Name)
+			if err != nil {
+				return nil, err
+			}
+			ok, err = s.filterCandidate(c, previous, retries[name], commitsByRepo)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				rv = append(rv, c)
+			}
+		}
+	}
+	if finished {
+		if err := s.jCache.Update(); err != nil {
+			return nil, err
+		}
+	}
+	glog.Infof("Found %d try job candidates for %d patches", len(rv), len(byPatch))
+	return rv, nil
+}
+
+// tryJobStatus returns the status of the given try job, whose TaskSpecs are
+// given, based on the tasks for its patch. It returns JOB_STATUS_IN_PROGRESS
+// while any of the job's tasks may still run. Otherwise the job succeeded if
+// all of its tasks succeeded, or it failed with the status of the first task
+// that failed and won't be retried. Tasks which depend on a failed task can
+// never run, so they don't keep the job in progress.
+func (s *TaskScheduler) tryJobStatus(j *db.Job, specs map[string]*TaskSpec, retries map[string]time.Time) (db.JobStatus, error) {
+	// state maps TaskSpec name to the status of its task, or to
+	// JOB_STATUS_IN_PROGRESS if the task may still run.
+	state := map[string]db.JobStatus{}
+	var visit func(string) (db.JobStatus, error)
+	visit = func(name string) (db.JobStatus, error) {
+		if st, ok := state[name]; ok {
+			return st, nil
+		}
+		spec, ok := specs[name]
+		if !ok {
+			// Unknown TaskSpecs can never run.
+			state[name] = db.JOB_STATUS_MISHAP
+			return db.JOB_STATUS_MISHAP, nil
+		}
+		task, err := s.tCache.GetTaskForPatch(j.Repo, j.Revision, j.Patch, name)
+		if err != nil {
+			return db.JOB_STATUS_IN_PROGRESS, err
+		}
+		st := db.JOB_STATUS_IN_PROGRESS
+		if task != nil {
+			// Failed tasks which will be retried may still succeed.
+			retry := !task.Success() && (task.Created.Before(retries[name]) || shouldRetry(task, spec))
+			if task.Done() && !retry {
+				st = db.JobStatusFromTaskStatus(task.Status)
+			}
+		} else {
+			// The task hasn't run yet. It never will if one of
+			// its dependencies failed.
+			for _, d := range spec.Dependencies {
+				depState, err := visit(d)
+				if err != nil {
+					return db.JOB_STATUS_IN_PROGRESS, err
+				}
+				if depState != db.JOB_STATUS_IN_PROGRESS && depState != db.JOB_STATUS_SUCCESS {
+					st = depState
+					break
+				}
+			}
+		}
+		state[name] = st
+		return st, nil
+	}
+
+	names := taskSpecClosure(j.Dependencies, specs).Keys()
+	sort.Strings(names)
+	rv := db.JOB_STATUS_SUCCESS
+	for _, name := range names {
+		st, err := visit(name)
+		if err != nil {
+			return db.JOB_STATUS_IN_PROGRESS, err
+		}
+		if st == db.JOB_STATUS_IN_PROGRESS {
+			return db.JOB_STATUS_IN_PROGRESS, nil
+		}
+		if st != db.JOB_STATUS_SUCCESS && rv == db.JOB_STATUS_SUCCESS {
+			rv = st
+		}
+	}
+	return rv, nil
+}
+
+// finishJob sets the status of the given job, unless it is already done.
+func (s *TaskScheduler) finishJob(id string, status db.JobStatus) error {
+	_, err := db.UpdateJobWithRetries(s.jobDB, id, func(j *db.Job) error {
+		if j.Done() {
+			return nil
+		}
+		j.Status = status
+		j.Finished = time.Now()
+		return nil
+	})
+	return err
+}
+
+// failTryJobs marks the unfinished try jobs for the given repo/revision/patch
+// as failed with a mishap, eg. because the patch could not be applied.
+func (s *TaskScheduler) failTryJobs(k tryJobKey) error {
+	jobs, err := s.jCache.GetJobsForPatch(k.repo, k.revision, k.patch)
+	if err != nil {
+		return err
+	}
+	for _, j := range jobs {
+		if j.Done() {
+			continue
+		}
+		if _, err := db.UpdateJobWithRetries(s.jobDB, j.Id, func(j *db.Job) error {
+			if j.Done() {
+				return nil
+			}
+			j.Status = db.JOB_STATUS_MISHAP
+			j.Finished = time.Now()
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return s.jCache.Update()
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/skia-dev/glog"
 	"go.skia.org/infra/go/auth"
 	"go.skia.org/infra/go/common"
+	"go.skia.org/infra/go/database"
 	"go.skia.org/infra/go/gitinfo"
 	"go.skia.org/infra/go/httputils"
 	"go.skia.org/infra/go/human"
@@ -27,6 +28,7 @@ import (
 	"go.skia.org/infra/task_scheduler/go/db"
 	"go.skia.org/infra/task_scheduler/go/db/local_db"
 	"go.skia.org/infra/task_scheduler/go/db/remote_db"
+	"go.skia.org/infra/task_scheduler/go/db/sql_db"
 	"go.skia.org/infra/task_scheduler/go/scheduling"
 )
 
@@ -64,6 +66,7 @@ var (
 	// Flags.
 	host           = flag.String("host", "localhost", "HTTP service host")
 	port           = flag.String("port", ":8000", "HTTP service port for the web server (e.g., ':8000')")
+	dbBackend      = flag.String("db_backend", "bolt", "Which database backend to use; either \"bolt\" (local file in workdir) or \"sql\" (see the --task_db_* flags).")
 	dbPort         = flag.String("db_port", ":8008", "HTTP service port for the database RPC server (e.g., ':8008')")
 	local          = flag.Bool("local", false, "Whether we're running on a dev machine vs in production.")
 	resourcesDir   = flag.String("resources_dir", "", "The directory to find templates, JS, and CSS files. If blank, assumes you're running inside a checkout and will attempt to find the resources relative to this source file.")
@@ -206,6 +209,9 @@ func jsonBlacklistHandler(w http.ResponseWriter, r *http.Request) {
 				httputils.ReportError(w, r, err, fmt.Sprintf("Failed to create commit range rule: %s", err))
 				return
 			}
+			rangeRule.Dimensions = rule.Dimensions
+			rangeRule.Expires = rule.Expires
+			rangeRule.Start = rule.Start
 			rule = *rangeRule
 		}
 		if err := ts.GetBlacklist().AddRule(&rule, repos); err != nil {
@@ -219,6 +225,101 @@ func jsonBlacklistHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// jsonBlacklistMatchHandler reports whether the given task spec, commit, and
+// dimensions match any rule in the blacklist, and if so, why.
+func jsonBlacklistMatchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	taskSpec := r.FormValue("task_spec")
+	commit := r.FormValue("commit")
+	dimensions := r.URL.Query()["dimension"]
+	rule, reason := ts.GetBlacklist().MatchRule(taskSpec, commit, dimensions)
+	if err := json.NewEncoder(w).Encode(&struct {
+		Rule   string `json:"rule"`
+		Reason string `json:"reason"`
+	}{
+		Rule:   rule,
+		Reason: reason,
+	}); err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to encode response: %s", err))
+		return
+	}
+}
+
+// jsonJobHandler cancels or retries the Job given in the URL, and writes the
+// resulting Job as JSON. In the case of a retry, this is the new Job.
+func jsonJobHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !login.IsGoogler(r) {
+		errStr := "Cannot modify jobs; user is not a logged-in Googler."
+		httputils.ReportError(w, r, fmt.Errorf(errStr), errStr)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	action := mux.Vars(r)["action"]
+	var job *db.Job
+	var err error
+	switch action {
+	case "cancel":
+		job, err = ts.CancelJob(id)
+	case "retry":
+		job, err = ts.RetryJob(id)
+	default:
+		err = fmt.Errorf("Unknown action %q", action)
+	}
+	if err == db.ErrNotFound {
+		http.Error(w, fmt.Sprintf("No such job %q", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to %s job: %s", action, err))
+		return
+	}
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to encode response: %s", err))
+		return
+	}
+}
+
+// jsonTryJobHandler creates a try job which runs the requested TaskSpecs with
+// the given patch applied, and writes the new Job as JSON.
+func jsonTryJobHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !login.IsGoogler(r) {
+		errStr := "Cannot trigger try jobs; user is not a logged-in Googler."
+		httputils.ReportError(w, r, fmt.Errorf(errStr), errStr)
+		return
+	}
+
+	var msg struct {
+		Name      string   `json:"name"`
+		Repo      string   `json:"repo"`
+		Revision  string   `json:"revision"`
+		Server    string   `json:"server"`
+		Issue     string   `json:"issue"`
+		Patchset  string   `json:"patchset"`
+		TaskSpecs []string `json:"task_specs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to decode request body: %s", err))
+		return
+	}
+	defer util.Close(r.Body)
+	patch := db.Patch{
+		Server:   msg.Server,
+		Issue:    msg.Issue,
+		Patchset: msg.Patchset,
+	}
+	job, err := ts.TriggerTryJob(msg.Name, msg.Repo, msg.Revision, patch, msg.TaskSpecs)
+	if err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to trigger try job: %s", err))
+		return
+	}
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to encode response: %s", err))
+		return
+	}
+}
+
 func jsonTriggerHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if !login.IsGoogler(r) {
@@ -251,7 +352,10 @@ func runServer(serverURL string) {
 	r.HandleFunc("/blacklist", blacklistHandler)
 	r.HandleFunc("/trigger", triggerHandler)
 	r.HandleFunc("/json/blacklist", jsonBlacklistHandler).Methods(http.MethodPost, http.MethodDelete)
+	r.HandleFunc("/json/blacklist/match", jsonBlacklistMatchHandler).Methods(http.MethodGet)
+	r.HandleFunc("/json/job/{id}/{action:cancel|retry}", jsonJobHandler).Methods(http.MethodPost)
 	r.HandleFunc("/json/trigger", jsonTriggerHandler).Methods(http.MethodPost)
+	r.HandleFunc("/json/tryjob", jsonTryJobHandler).Methods(http.MethodPost)
 	r.HandleFunc("/json/version", skiaversion.JsonHandler)
 	r.PathPrefix("/res/").HandlerFunc(httputils.MakeResourceHandler(*resourcesDir))
 
@@ -273,12 +377,20 @@ func runDbServer(taskDb db.RemoteDB) {
 		glog.Fatal(err)
 	}
 	defer util.Close(dbserver)
-	glog.Fatal(http.ListenAndServe(*dbPort, httputils.LoggingGzipRequestResponse(r)))
+	// The change feed streams its response, so it must not be wrapped in
+	// middleware which buffers the response.
+	h := http.NewServeMux()
+	h.Handle("/db/"+remote_db.CHANGES_PATH, r)
+	h.Handle("/", httputils.LoggingGzipRequestResponse(r))
+	glog.Fatal(http.ListenAndServe(*dbPort, h))
 }
 
 func main() {
 	defer common.LogPanic()
 
+	// Setup DB flags. These are only used when --db_backend=sql.
+	dbConf := database.ConfigFromPrefixedFlags(sql_db.PROD_DB_HOST, sql_db.PROD_DB_PORT, database.USER_RW, sql_db.PROD_DB_NAME, sql_db.MigrationSteps(), "task_")
+
 	// Global init.
 	common.InitWithMetrics2(APP_NAME, influxHost, influxUser, influxPassword, influxDatabase, local)
 
@@ -314,10 +426,30 @@ func main() {
 
 	// Initialize the database.
 	// TODO(benjaminwagner): Create a signal handler which closes the DB.
-	d, err := local_db.NewDB(DB_NAME, path.Join(*workdir, DB_FILENAME))
+	var d db.DB
+	switch *dbBackend {
+	case "bolt":
+		d, err = local_db.NewDB(DB_NAME, path.Join(*workdir, DB_FILENAME))
+	case "sql":
+		if !*local {
+			if err := dbConf.GetPasswordFromMetadata(); err != nil {
+				glog.Fatal(err)
+			}
+		}
+		var vdb *database.VersionedDB
+		vdb, err = dbConf.NewVersionedDB()
+		if err != nil {
+			glog.Fatal(err)
+		}
+		d, err = sql_db.NewDB(DB_NAME, vdb)
+	default:
+		glog.Fatalf("Unknown --db_backend %q", *dbBackend)
+	}
 	if err != nil {
 		glog.Fatal(err)
 	}
+	// Record changes so that remote_db clients can stream them.
+	d = db.NewChangeFeedDB(d)
 	defer util.Close(d)
 
 	// ... and database cache.
@@ -0,0 +1,49 @@
+package main
+
+// Executes database migrations for the SQL task scheduler DB to the latest
+// target version. In production this requires the root password for MySQL. The
+// user will be prompted for that so it is not entered via the command line.
+
+import (
+	"github.com/skia-dev/glog"
+	"go.skia.org/infra/go/common"
+	"go.skia.org/infra/go/database"
+	"go.skia.org/infra/task_scheduler/go/db/sql_db"
+)
+
+func main() {
+	defer common.LogPanic()
+	// Set up flags.
+	dbConf := database.ConfigFromFlags(sql_db.PROD_DB_HOST, sql_db.PROD_DB_PORT, database.USER_ROOT, sql_db.PROD_DB_NAME, sql_db.MigrationSteps())
+
+	// Global init to initialize glog and parse arguments.
+	common.Init()
+
+	if err := dbConf.PromptForPassword(); err != nil {
+		glog.Fatal(err)
+	}
+	vdb, err := dbConf.NewVersionedDB()
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	// Get the current database version
+	maxDBVersion := vdb.MaxDBVersion()
+	glog.Infof("Latest database version: %d", maxDBVersion)
+
+	dbVersion, err := vdb.DBVersion()
+	if err != nil {
+		glog.Fatalf("Unable to retrieve database version. Error: %s", err)
+	}
+	glog.Infof("Current database version: %d", dbVersion)
+
+	if dbVersion < maxDBVersion {
+		glog.Infof("Migrating to version: %d", maxDBVersion)
+		err = vdb.Migrate(maxDBVersion)
+		if err != nil {
+			glog.Fatalf("Unable to migrate database. Error: %s", err)
+		}
+	}
+
+	glog.Infoln("Database migration finished.")
+}